@@ -0,0 +1,224 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eiam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	"github.com/replit/ephemeral-iam/internal/approvals"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+	"github.com/replit/ephemeral-iam/pkg/options"
+)
+
+var (
+	listAllApprovals bool
+	approvalComment  string
+)
+
+// approvalReminder is how often the user is reminded that eiam is still
+// waiting for approval.
+const approvalReminder = time.Minute
+
+func newCmdApprovals() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "approvals",
+		Short: "Review requests for approval of privileged sessions",
+		Long: dedent.Dedent(`
+			Privileged sessions for the service accounts in the approvals.serviceaccounts setting
+			must be approved by a second engineer. The command that starts the session sends a
+			request to the approval server in the approvals.url setting and waits until it is
+			approved, denied or times out. The approver is added to the reason in the audit logs.
+
+			The "approvals" subcommands let approvers review the pending requests.`),
+	}
+	cmd.AddCommand(newCmdApprovalsList())
+	cmd.AddCommand(newCmdApprovalsDecide("approve", "Approve a request for a privileged session"))
+	cmd.AddCommand(newCmdApprovalsDecide("deny", "Deny a request for a privileged session"))
+	return cmd
+}
+
+func newCmdApprovalsList() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the requests that are waiting for approval",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := approvals.NewClient()
+			if err != nil {
+				return err
+			}
+			status := approvals.Pending
+			if listAllApprovals {
+				status = ""
+			}
+			reqs, err := client.List(cmd.Context(), status)
+			if err != nil {
+				return errorsutil.New("Failed to list approval requests", err)
+			}
+			if len(reqs) == 0 {
+				util.Logger.Info("There are no approval requests")
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+			fmt.Fprintln(w, "\nID\tSTATUS\tREQUESTER\tSERVICE ACCOUNT\tREASON")
+			for _, req := range reqs {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", req.ID, req.Status, req.Requester, req.ServiceAccount, req.Reason)
+			}
+			fmt.Fprintln(w)
+			return w.Flush()
+		},
+	}
+	cmd.Flags().BoolVarP(&listAllApprovals, "all", "a", false, "List the requests that have been decided as well")
+	return cmd
+}
+
+func newCmdApprovalsDecide(action, short string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   action + " ID",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := approvals.NewClient()
+			if err != nil {
+				return err
+			}
+			approver, err := appconfig.Identity()
+			if err != nil {
+				return err
+			}
+			req, err := client.Get(cmd.Context(), args[0])
+			if err != nil {
+				return errorsutil.New("Failed to get approval request", err)
+			}
+			if req.Status != approvals.Pending {
+				return fmt.Errorf("the request %s is already %s", req.ID, req.Status)
+			}
+			if strings.EqualFold(req.Requester, approver) {
+				return errors.New("you cannot decide on your own request")
+			}
+
+			if !options.YesOption {
+				util.Confirm(map[string]string{
+					"Action":          action,
+					"Requester":       req.Requester,
+					"Project":         req.Project,
+					"Service Account": req.ServiceAccount,
+					"Reason":          req.Reason,
+				})
+			}
+			decision := approvals.Decision{Approver: approver, Comment: approvalComment}
+			decide := client.Approve
+			if action == "deny" {
+				decide = client.Deny
+			}
+			if req, err = decide(cmd.Context(), req.ID, decision); err != nil {
+				return errorsutil.New(fmt.Sprintf("Failed to %s request", action), err)
+			}
+			util.Logger.Infof("The request %s is %s", req.ID, req.Status)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&approvalComment, "comment", "c", "", "A comment for the requester")
+	return cmd
+}
+
+// awaitApproval asks for approval of a privileged session if the service
+// account requires it and waits for a decision. The approver is added to the
+// reason, which has already been formatted.
+func awaitApproval(cfg *options.CmdConfig) error {
+	if !approvals.Required(cfg.ServiceAccountEmail) {
+		return nil
+	}
+	client, err := approvals.NewClient()
+	if err != nil {
+		return errorsutil.New("Failed to request approval", err)
+	}
+	requester, err := appconfig.Identity()
+	if err != nil {
+		return err
+	}
+
+	timeout := viper.GetDuration(appconfig.ApprovalsTimeout)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req := approvals.Request{
+		Requester:      requester,
+		Project:        cfg.Project,
+		ServiceAccount: cfg.ServiceAccountEmail,
+		Reason:         cfg.Reason,
+	}
+	if cfg.TokenDuration > 0 {
+		req.Duration = cfg.TokenDuration.String()
+	}
+	created, err := client.Create(ctx, req)
+	if err != nil {
+		return errorsutil.New("Failed to request approval", err)
+	}
+	util.Logger.Infof("%s requires approval. Waiting up to %v for an approver to run:\n\n\teiam approvals approve %s\n",
+		cfg.ServiceAccountEmail, timeout, created.ID)
+
+	lastReminder := time.Now()
+	decided, err := client.Wait(ctx, created.ID, viper.GetDuration(appconfig.ApprovalsPollInterval), func(r *approvals.Request) {
+		if r.Status == approvals.Pending && time.Since(lastReminder) >= approvalReminder {
+			lastReminder = time.Now()
+			util.Logger.Infof("Still waiting for approval of request %s", r.ID)
+		}
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			return errorsutil.New("Failed to check the approval request", err)
+		}
+		// Withdraw the request so that it is not approved after we give up.
+		cancelCtx, cancelDone := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelDone()
+		if err := client.Cancel(cancelCtx, created.ID); err != nil {
+			util.Logger.WithError(err).Warnf("Failed to cancel approval request %s", created.ID)
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("the approval request %s was not approved within %v", created.ID, timeout)
+		}
+		return fmt.Errorf("cancelled the approval request %s", created.ID)
+	}
+
+	switch decided.Status {
+	case approvals.Approved:
+		util.Logger.Infof("%s approved the request", decided.Approver)
+		cfg.Reason = fmt.Sprintf("%s (approved by %s)", cfg.Reason, decided.Approver)
+		return nil
+	case approvals.Denied:
+		msg := fmt.Sprintf("%s denied the approval request %s", decided.Approver, decided.ID)
+		if decided.Comment != "" {
+			msg += ": " + decided.Comment
+		}
+		return errors.New(msg)
+	}
+	return fmt.Errorf("the approval request %s is %s", decided.ID, decided.Status)
+}
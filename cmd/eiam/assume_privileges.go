@@ -15,12 +15,17 @@
 package eiam
 
 import (
+	"time"
+
 	"github.com/lithammer/dedent"
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
 
+	"github.com/replit/ephemeral-iam/internal/access"
 	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
 	"github.com/replit/ephemeral-iam/internal/gcpclient"
+	"github.com/replit/ephemeral-iam/internal/hooks"
 	"github.com/replit/ephemeral-iam/internal/proxy"
 	"github.com/replit/ephemeral-iam/pkg/options"
 )
@@ -54,6 +59,18 @@ func newCmdAssumePrivileges() *cobra.Command {
 				return err
 			}
 
+			if err := options.CheckServiceAccount(apCmdConfig.ServiceAccountEmail); err != nil {
+				return err
+			}
+
+			if err := options.CheckAccess(access.CommandAssumePrivileges, &apCmdConfig); err != nil {
+				return err
+			}
+
+			if err := options.ResolveReason(&apCmdConfig); err != nil {
+				return err
+			}
+
 			if err := util.FormatReason(&apCmdConfig.Reason); err != nil {
 				return err
 			}
@@ -65,7 +82,7 @@ func newCmdAssumePrivileges() *cobra.Command {
 					"Reason":          apCmdConfig.Reason,
 				})
 			}
-			return nil
+			return awaitApproval(&apCmdConfig)
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return startPrivilegedSession()
@@ -74,6 +91,7 @@ func newCmdAssumePrivileges() *cobra.Command {
 
 	options.AddServiceAccountEmailFlag(cmd.Flags(), &apCmdConfig.ServiceAccountEmail, true)
 	options.AddReasonFlag(cmd.Flags(), &apCmdConfig.Reason, true)
+	options.AddReasonTemplateFlags(cmd.Flags(), &apCmdConfig.ReasonTemplate, &apCmdConfig.Ticket)
 	options.AddProjectFlag(cmd.Flags(), &apCmdConfig.Project, false)
 	options.AddTokenDurationFlag(cmd.Flags(), &apCmdConfig.TokenDuration, false)
 
@@ -88,15 +106,26 @@ func startPrivilegedSession() error {
 		util.Logger.Fatalln("You do not have access to impersonate this service account")
 	}
 
+	// Extensions of the session cannot make it last longer than a single
+	// token may.
+	maxLifetime, err := options.MaxTokenDuration(&apCmdConfig)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+
 	util.Logger.Info("Fetching short-lived access token for ", apCmdConfig.ServiceAccountEmail)
-	accessToken, err := gcpclient.GenerateTemporaryAccessToken(
-		apCmdConfig.ServiceAccountEmail,
-		apCmdConfig.Reason,
-		apCmdConfig.TokenDuration)
+	accessToken, err := mintToken("assume-privileges", &apCmdConfig)
 	if err != nil {
 		return err
 	}
 
+	started := newEvent(hooks.SessionStarted, "assume-privileges", &apCmdConfig)
+	started.ExpiresAt = accessToken.GetExpireTime().AsTime()
+	if err := hooks.Emit(started); err != nil {
+		return errorsutil.New("Refusing to start privileged session", err)
+	}
+
 	util.Logger.Info("Configuring gcloud to use auth proxy")
 	if gcpclient.ConfigureGcloudProxy(apCmdConfig.Project) != nil {
 		return err
@@ -139,14 +168,44 @@ func startPrivilegedSession() error {
 			}
 		}
 	}
-	token := accessToken.GetAccessToken()
-	expirationDate := accessToken.GetExpireTime().AsTime()
-	return proxy.StartProxyServer(
-		token,
-		apCmdConfig.Reason,
-		apCmdConfig.ServiceAccountEmail,
-		apCmdConfig.Project,
-		expirationDate,
-		defaultCluster,
-	)
+	return proxy.StartProxyServer(&proxy.Session{
+		AccessToken:    accessToken.GetAccessToken(),
+		Reason:         apCmdConfig.Reason,
+		ServiceAccount: apCmdConfig.ServiceAccountEmail,
+		Project:        apCmdConfig.Project,
+		Expiry:         accessToken.GetExpireTime().AsTime(),
+		DefaultCluster: defaultCluster,
+		Extend:         extendPrivilegedSession,
+		Start:          start,
+		MaxLifetime:    maxLifetime,
+		OnEnd: func() {
+			if err := hooks.Emit(newEvent(hooks.SessionEnded, "assume-privileges", &apCmdConfig)); err != nil {
+				util.Logger.WithError(err).Error("Hooks failed after the privileged session ended")
+			}
+			hooks.Wait()
+		},
+	})
+}
+
+// extendPrivilegedSession generates the token that extends the privileged
+// session for "eiam session extend". The duration is checked like the one of
+// a new session, and the proxy refuses extensions that would make the session
+// last longer than the maximum token duration in total.
+func extendPrivilegedSession(d time.Duration) (string, time.Time, error) {
+	cfg := apCmdConfig
+	cfg.TokenDuration = d
+	if err := options.CheckTokenDuration(d); err != nil {
+		return "", time.Time{}, err
+	}
+	if err := options.CheckAccess(access.CommandAssumePrivileges, &cfg); err != nil {
+		return "", time.Time{}, err
+	}
+	if err := awaitApproval(&cfg); err != nil {
+		return "", time.Time{}, err
+	}
+	accessToken, err := mintToken("assume-privileges", &cfg)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return accessToken.GetAccessToken(), accessToken.GetExpireTime().AsTime(), nil
 }
@@ -0,0 +1,181 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eiam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	"github.com/replit/ephemeral-iam/internal/audit"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	"github.com/replit/ephemeral-iam/internal/gcpclient"
+	"github.com/replit/ephemeral-iam/internal/history"
+)
+
+// auditDefaultWindow is how far back eiam audit searches if neither --since
+// nor the history says when the session started. Data access logs are kept
+// for 30 days by default.
+const auditDefaultWindow = 30 * 24 * time.Hour
+
+var (
+	auditProjects []string
+	auditSince    string
+	auditUntil    string
+	auditLimit    int
+	auditOutput   string
+)
+
+func newCmdAudit() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit SESSION_ID",
+		Short: "Summarize the API calls made during a session from Cloud Audit Logs",
+		Long: dedent.Dedent(`
+			eiam adds a random session ID to the reason of every API call it makes for you, such as
+			"ephemeral-iam 3f2a1b0c9d8e7f6a: Debugging (JIRA-1234)". Cloud Audit Logs record the reason
+			in protoPayload.requestMetadata.requestAttributes.reason, and the "audit" command searches
+			them for a session ID and lists every API call that was made with it.
+
+			The projects and start time of the session are taken from the local history when it has
+			the session, and otherwise default to your project and the last 30 days. Only calls that
+			audit logs are enabled for can be found.`),
+		Example: dedent.Dedent(`
+			eiam audit 3f2a1b0c9d8e7f6a
+			eiam audit 3f2a1b0c9d8e7f6a -p my-prod --since 2021-06-01 --output json`),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := audit.CheckSessionID(args[0]); err != nil {
+				return argsError(err)
+			}
+			q := audit.Query{SessionID: args[0], Projects: auditProjects, Limit: auditLimit}
+			var err error
+			if q.Since, err = parseHistoryTime(auditSince); err != nil {
+				return argsError(fmt.Errorf("invalid --since: %v", err))
+			}
+			if q.Until, err = parseHistoryTime(auditUntil); err != nil {
+				return argsError(fmt.Errorf("invalid --until: %v", err))
+			}
+			if auditOutput != historyTable && auditOutput != historyJSON {
+				return argsError(fmt.Errorf("--output must be %s or %s", historyTable, historyJSON))
+			}
+			if err := fillAuditQuery(&q); err != nil {
+				return err
+			}
+
+			var opts []option.ClientOption
+			if endpoint := viper.GetString(appconfig.AuditEndpoint); endpoint != "" {
+				opts = append(opts, option.WithEndpoint(endpoint))
+			}
+			client, err := audit.NewClient(context.Background(), opts...)
+			if err != nil {
+				return err
+			}
+			util.Logger.Infof("Searching the audit logs of %v since %s", q.Projects, q.Since.Format(time.RFC1123))
+			calls, err := client.Search(cmd.Context(), q)
+			if err != nil {
+				return err
+			}
+
+			summary := audit.Summarize(q, calls)
+			if auditOutput == historyJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(summary)
+			}
+			return printAuditSummary(summary)
+		},
+	}
+	cmd.Flags().StringSliceVarP(&auditProjects, "project", "p", nil, "The projects to search. Defaults to the projects of the session in the history")
+	cmd.Flags().StringVar(&auditSince, "since", "", "Only search entries from this time on. Defaults to the start of the session")
+	cmd.Flags().StringVar(&auditUntil, "until", "", "Only search entries before this time")
+	cmd.Flags().IntVar(&auditLimit, "limit", 0, "The most API calls to list. All of them if 0")
+	cmd.Flags().StringVarP(&auditOutput, "output", "o", historyTable, "The output format: table or json")
+	return cmd
+}
+
+// fillAuditQuery sets the projects and start time of q that were not given
+// as flags from the history of the session, or from the defaults.
+func fillAuditQuery(q *audit.Query) error {
+	entries, err := history.Default().Read()
+	if err != nil {
+		util.Logger.WithError(err).Warn("Failed to read the history")
+	}
+	projects := map[string]bool{}
+	var started time.Time
+	for _, e := range (history.Filter{Reason: "ephemeral-iam " + q.SessionID}).Apply(entries) {
+		if e.Project != "" && !projects[e.Project] {
+			projects[e.Project] = true
+			if len(auditProjects) == 0 {
+				q.Projects = append(q.Projects, e.Project)
+			}
+		}
+		if started.IsZero() || e.Time.Before(started) {
+			started = e.Time
+		}
+	}
+
+	if len(q.Projects) == 0 {
+		project := viper.GetString(appconfig.DefaultProject)
+		if project == "" {
+			if project, err = gcpclient.GetCurrentProject(); err != nil {
+				return err
+			}
+		}
+		if project == "" {
+			return argsError(fmt.Errorf("the session is not in the history, set the project to search with --project"))
+		}
+		q.Projects = []string{project}
+	}
+	if q.Since.IsZero() {
+		if started.IsZero() {
+			q.Since = time.Now().Add(-auditDefaultWindow)
+		} else {
+			// Allow for clock skew between this machine and GCP.
+			q.Since = started.Add(-5 * time.Minute)
+		}
+	}
+	return nil
+}
+
+func printAuditSummary(s *audit.Summary) error {
+	if len(s.Calls) == 0 {
+		util.Logger.Warnf("No API calls of session %s were found in %v", s.SessionID, s.Projects)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tPRINCIPAL\tMETHOD\tRESOURCE\tSTATUS")
+	for _, c := range s.Calls {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			c.Time.Local().Format("2006-01-02 15:04:05"), c.Principal, c.Method, c.Resource, c.Status)
+	}
+	fmt.Fprintf(w, "\nMETHOD\tCALLS\tFAILED\n")
+	for _, m := range s.Methods {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", m.Method, m.Calls, m.Failed)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\n%d API calls, %d failed\n", len(s.Calls), s.Failed)
+	return nil
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/replit/ephemeral-iam/internal/access"
 	util "github.com/replit/ephemeral-iam/internal/eiamutil"
 	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
 	"github.com/replit/ephemeral-iam/internal/gcpclient"
@@ -67,6 +68,19 @@ func newCmdCloudSQLProxy() *cobra.Command {
 			}
 
 			cloudSQLProxyCmdArgs = util.ExtractUnknownArgs(cmd.Flags(), os.Args)
+
+			if err := options.CheckServiceAccount(cspCmdConfig.ServiceAccountEmail); err != nil {
+				return err
+			}
+
+			if err := options.CheckAccess(access.CommandCloudSQLProxy, &cspCmdConfig); err != nil {
+				return err
+			}
+
+			if err := options.ResolveReason(&cspCmdConfig); err != nil {
+				return err
+			}
+
 			if err := util.FormatReason(&cspCmdConfig.Reason); err != nil {
 				return err
 			}
@@ -79,7 +93,7 @@ func newCmdCloudSQLProxy() *cobra.Command {
 					"Command":         fmt.Sprintf("cloud_sql_proxy %s", strings.Join(cloudSQLProxyCmdArgs, " ")),
 				})
 			}
-			return nil
+			return awaitApproval(&cspCmdConfig)
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runCloudSQLProxyCommand()
@@ -88,6 +102,7 @@ func newCmdCloudSQLProxy() *cobra.Command {
 
 	options.AddServiceAccountEmailFlag(cmd.Flags(), &cspCmdConfig.ServiceAccountEmail, true)
 	options.AddReasonFlag(cmd.Flags(), &cspCmdConfig.Reason, true)
+	options.AddReasonTemplateFlags(cmd.Flags(), &cspCmdConfig.ReasonTemplate, &cspCmdConfig.Ticket)
 	options.AddProjectFlag(cmd.Flags(), &cspCmdConfig.Project, false)
 	options.AddTokenDurationFlag(cmd.Flags(), &cspCmdConfig.TokenDuration, false)
 
@@ -103,10 +118,7 @@ func runCloudSQLProxyCommand() error {
 	}
 
 	util.Logger.Infof("Fetching access token for %s", cspCmdConfig.ServiceAccountEmail)
-	accessToken, err := gcpclient.GenerateTemporaryAccessToken(
-		cspCmdConfig.ServiceAccountEmail,
-		cspCmdConfig.Reason,
-		cspCmdConfig.TokenDuration)
+	accessToken, err := mintToken("cloud_sql_proxy", &cspCmdConfig)
 	if err != nil {
 		return err
 	}
@@ -118,7 +130,7 @@ func runCloudSQLProxyCommand() error {
 	c.Stderr = os.Stderr
 	c.Stdin = os.Stdin
 
-	if err := c.Run(); err != nil {
+	if err := runWithHooks(c, "cloud_sql_proxy", cloudSQLProxyCmdArgs, &cspCmdConfig); err != nil {
 		fullCmd := fmt.Sprintf("cloud_sql_proxy %s", strings.Join(cloudSQLProxyCmdArgs, " "))
 		return errorsutil.New(fmt.Sprintf("Failed to run command [%s]", fullCmd), err)
 	}
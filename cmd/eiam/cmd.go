@@ -17,8 +17,13 @@ package eiam
 import (
 	"github.com/lithammer/dedent"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
 	eiam "github.com/replit/ephemeral-iam/internal"
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	"github.com/replit/ephemeral-iam/internal/history"
+	"github.com/replit/ephemeral-iam/internal/hooks"
+	"github.com/replit/ephemeral-iam/internal/notify"
 	"github.com/replit/ephemeral-iam/pkg/options"
 )
 
@@ -70,19 +75,28 @@ func NewEphemeralIamCommand() (*eiam.RootCommand, error) {
 
 	cmds.ResetFlags()
 
+	cmds.AddCommand(newCmdApprovals())
 	cmds.AddCommand(newCmdAssumePrivileges())
+	cmds.AddCommand(newCmdAudit())
 	cmds.AddCommand(newCmdCloudSQLProxy())
 	cmds.AddCommand(newCmdConfig())
 	cmds.AddCommand(newCmdDefaultServiceAccounts())
 	cmds.AddCommand(newCmdGcloud())
+	cmds.AddCommand(newCmdGrant())
+	cmds.AddCommand(newCmdHistory())
 	cmds.AddCommand(newCmdKubectl())
 	cmds.AddCommand(newCmdListServiceAccounts())
 	cmds.AddCommand(newCmdPlugins())
+	cmds.AddCommand(newCmdPolicy())
 	cmds.AddCommand(newCmdQueryPermissions())
+	cmds.AddCommand(newCmdSession())
 	cmds.AddCommand(newCmdVersion())
-	if err := cmds.LoadPlugins(); err != nil {
-		return nil, err
+	hooks.RegisterConfigured()
+	notify.RegisterConfigured()
+	if viper.GetBool(appconfig.HistoryEnabled) {
+		hooks.Register(history.Default().Hook())
 	}
+	cmds.LoadPlugins()
 	options.AddPersistentFlags(cmds.PersistentFlags())
 
 	RootCommand = cmds
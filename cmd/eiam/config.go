@@ -15,88 +15,27 @@
 package eiam
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
-	"strconv"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/lithammer/dedent"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 
 	"github.com/replit/ephemeral-iam/internal/appconfig"
 	util "github.com/replit/ephemeral-iam/internal/eiamutil"
 	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+	"github.com/replit/ephemeral-iam/internal/plugins"
+	"github.com/replit/ephemeral-iam/internal/secrets"
+	"github.com/replit/ephemeral-iam/pkg/options"
 )
 
-var (
-	loggingLevels    = []string{"trace", "debug", "info", "warn", "error", "fatal", "panic"}
-	loggingFormats   = []string{"text", "json", "debug"}
-	boolConfigFields = []string{
-		appconfig.AuthProxyVerbose,
-		appconfig.GithubAuth,
-		appconfig.LoggingLevelTruncation,
-		appconfig.LoggingPadLevelText,
-	}
-)
-
-var configInfo = dedent.Dedent(`
-		┏━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━┳━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━┓
-		┃ Key                            ┃ Description                                 ┃
-		┡━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━╇━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━┩
-		│ authproxy.certfile             │ The path to the auth proxy's TLS            │
-		│                                │ certificate                                 │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ authproxy.keyfile              │ The path to the auth proxy's x509 key       │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ authproxy.logdir               │ The directory that auth proxy logs will be  │
-		│                                │ written to                                  │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ authproxy.proxyaddress         │ The address that the auth proxy is hosted   │
-		│                                │ on                                          │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ authproxy.proxyport            │ The port that the auth proxy runs on        │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ authproxy.verbose              │ When set to 'true', verbose output for      │
-		│                                │ proxy logs will be enabled                  │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ binarypaths.cloudsqlproxy      │ The path to the cloud_sql_proxy binary on   │
-		│                                │ your filesystem                             │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ binarypaths.gcloud             │ The path to the gcloud binary on your       │
-		│                                │ filesystem                                  │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ binarypaths.kubectl            │ The path to the kubectl binary on your      │
-		│                                │ filesystem                                  │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ github.auth                    │ When set to 'true', the "plugins install"   │
-		│                                │ command will use a configured personal      │
-		│                                │ access token to authenticate to the Github  │
-		│                                │ API.                                        │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ github.tokens                  │ The configured Github personal access       │
-		│                                │ tokens                                      │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ logging.format                 │ The format for which to write console logs  │
-		│                                │ Can be 'json', 'text', or 'debug'           │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ logging.level                  │ The logging level to write to the console   │
-		│                                │ Can be one of 'trace', 'debug', 'info',     │
-		│                                │ 'warn', 'error', 'fatal', or 'panic'        │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ logging.disableleveltruncation │ When set to 'true', the level indicator for │
-		│                                │ logs will not be trucated                   │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ logging.padleveltext           │ When set to 'true', output logs will align  │
-		│                                │ evenly with their output level indicator    │
-		├────────────────────────────────┼─────────────────────────────────────────────┤
-		│ serviceaccounts                │ The default service accounts set via the    │
-		│                                │ 'default-service-accounts' command          │
-		└────────────────────────────────┴─────────────────────────────────────────────┘
-`)
-
 func newCmdConfig() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "config",
@@ -104,60 +43,294 @@ func newCmdConfig() *cobra.Command {
 	}
 
 	cmd.AddCommand(newCmdConfigPrint())
+	cmd.AddCommand(newCmdConfigProfiles())
 	cmd.AddCommand(newCmdConfigView())
 	cmd.AddCommand(newCmdConfigSet())
+	cmd.AddCommand(newCmdConfigUnset())
+	cmd.AddCommand(newCmdConfigReset())
+	cmd.AddCommand(newCmdConfigValidate())
 	cmd.AddCommand(newCmdConfigInfo())
 
 	return cmd
 }
 
 func newCmdConfigPrint() *cobra.Command {
+	var fileOnly bool
 	cmd := &cobra.Command{
 		Use:   "print",
 		Short: "Print the current configuration",
+		Long: dedent.Dedent(`
+			The "config print" command prints the configuration that eiam uses. Each value is
+			followed by where it came from: the default, a default or enforced setting in the
+			organization's policy file, the configuration file, or the active profile. The
+			--file flag prints the configuration file with its profiles instead.
+
+			Github access tokens and plugin settings marked as secret are masked. Plugin
+			settings are also masked if the plugin is not loaded or does not describe the
+			setting, since eiam cannot tell whether they are secret.`),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if !fileOnly {
+				out, err := effectiveConfig()
+				if err != nil {
+					return errorsutil.New("Failed to format configuration", err)
+				}
+				fmt.Printf("\n%s\n", out)
+				return nil
+			}
+
 			configFile := viper.ConfigFileUsed()
 			data, err := os.ReadFile(configFile)
 			if err != nil {
 				return errorsutil.New("Failed to read configuration file", err)
 			}
-			lines := strings.Split(string(data), "\n")
-			inTokens := false
-			fmt.Printf("\n")
-			for i := 0; i < len(lines); i++ {
-				if lines[i] == "  tokens:" {
-					inTokens = true
-				} else if inTokens {
-					// Mask Github personal access tokens.
-					tokenConfig := viper.GetStringMapString(appconfig.GithubTokens)
-					for tokenName := range tokenConfig {
-						fmt.Printf("    %s: **********\n", tokenName)
-						i++
-					}
-					i--
-					inTokens = false
-					continue
-				}
-				fmt.Println(lines[i])
+			masked, err := maskSecrets(data)
+			if err != nil {
+				return errorsutil.New("Failed to parse configuration file", err)
 			}
-			fmt.Printf("\n")
+			fmt.Printf("\n%s\n", masked)
 			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&fileOnly, "file", false, "Print the configuration file instead of the configuration in use")
 	return cmd
 }
 
+// effectiveConfig returns the settings that eiam uses as YAML with the source
+// of each value in a comment.
+func effectiveConfig() ([]byte, error) {
+	settings := viper.AllSettings()
+	delete(settings, appconfig.Profiles)
+
+	var node yaml.Node
+	if err := node.Encode(settings); err != nil {
+		return nil, err
+	}
+	annotateNode(&node, nil)
+	maskNode(&node, nil)
+	return encodeNode(&node)
+}
+
+// annotateNode adds the source of each value to the node as a comment.
+func annotateNode(node *yaml.Node, path []string) {
+	if node.Kind != yaml.MappingNode {
+		node.LineComment = appconfig.Source(strings.Join(path, "."))
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyPath := append(path[:len(path):len(path)], strings.ToLower(node.Content[i].Value))
+		value := node.Content[i+1]
+		// The comment of a block sequence is written after its last item, so
+		// it is attached to the key instead.
+		if value.Kind == yaml.SequenceNode && len(value.Content) > 0 {
+			node.Content[i].LineComment = appconfig.Source(strings.Join(keyPath, "."))
+			continue
+		}
+		annotateNode(value, keyPath)
+	}
+}
+
+// maskSecrets replaces the secret values of a YAML config file with asterisks
+// while preserving the order and comments of the file.
+func maskSecrets(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return data, nil
+	}
+	maskNode(doc.Content[0], nil)
+	return encodeNode(&doc)
+}
+
+func encodeNode(node *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func maskNode(node *yaml.Node, path []string) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := strings.ToLower(node.Content[i].Value)
+			maskNode(node.Content[i+1], append(path[:len(path):len(path)], key))
+		}
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			maskNode(child, path)
+		}
+	case yaml.ScalarNode:
+		if isSecretKey(strings.Join(path, ".")) {
+			node.Value = "**********"
+			node.Tag = "!!str"
+			node.Style = 0
+		}
+	}
+}
+
+// isSecretKey reports whether the value of a config key should be masked.
+// Settings in profiles are treated like the settings they override.
+func isSecretKey(key string) bool {
+	if parts := strings.SplitN(key, ".", 3); len(parts) == 3 && parts[0] == appconfig.Profiles {
+		key = parts[2]
+	}
+	if f, ok := appconfig.FindField(key); ok && f.Secret {
+		return true
+	}
+	// Github tokens saved in the configuration file before they were moved to
+	// the secrets file.
+	if strings.HasPrefix(key, appconfig.GithubTokens+".") && strings.Count(key, ".") == 2 {
+		return true
+	}
+	pluginName, field, ok := plugins.SplitConfigKey(key)
+	if !ok {
+		return false
+	}
+	schema, loaded := pluginSchema(pluginName)
+	if !loaded {
+		return true
+	}
+	f, ok := plugins.FindField(schema, field)
+	return !ok || f.Secret
+}
+
+// pluginSchema returns the configuration fields of a loaded plugin.
+func pluginSchema(name string) ([]plugins.ConfigField, bool) {
+	if RootCommand == nil {
+		return nil, false
+	}
+	for _, p := range RootCommand.Plugins {
+		if strings.EqualFold(p.Name, name) {
+			return p.Config, true
+		}
+	}
+	return nil, false
+}
+
 func newCmdConfigInfo() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "info",
 		Short: "Print information about config fields",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println(configInfo)
+			fmt.Println(formatConfigInfo(appconfig.Schema()))
+			printPluginConfigInfo()
 		},
 	}
 	return cmd
 }
 
+// formatConfigInfo draws a table of the configuration settings.
+func formatConfigInfo(fields []appconfig.Field) string {
+	const descWidth = 43
+	keyWidth, typeWidth := len("Key"), len("Type")
+	for _, f := range fields {
+		keyWidth = max(keyWidth, len(f.Key))
+		typeWidth = max(typeWidth, len(f.Type))
+	}
+	line := func(left, fill, mid, right string) string {
+		return left + strings.Repeat(fill, keyWidth+2) + mid + strings.Repeat(fill, typeWidth+2) +
+			mid + strings.Repeat(fill, descWidth+2) + right + "\n"
+	}
+	row := func(sep, key, fieldType, desc string) string {
+		return fmt.Sprintf("%s %-*s %s %-*s %s %-*s %s\n", sep, keyWidth, key, sep, typeWidth, fieldType, sep, descWidth, desc, sep)
+	}
+
+	var b strings.Builder
+	b.WriteString("\n")
+	b.WriteString(line("┏", "━", "┳", "┓"))
+	b.WriteString(row("┃", "Key", "Type", "Description"))
+	b.WriteString(line("┡", "━", "╇", "┩"))
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteString(line("├", "─", "┼", "┤"))
+		}
+		desc := f.Description
+		if len(f.Allowed) > 0 {
+			desc += ". One of " + strings.Join(f.Allowed, ", ")
+		}
+		if def := fmt.Sprint(f.Default); f.Default != nil && def != "" && def != "[]" {
+			desc += ". Default: " + def
+		}
+		for j, text := range wrapText(desc, descWidth) {
+			if j == 0 {
+				b.WriteString(row("│", f.Key, string(f.Type), text))
+			} else {
+				b.WriteString(row("│", "", "", text))
+			}
+		}
+	}
+	b.WriteString(line("└", "─", "┴", "┘"))
+	return b.String()
+}
+
+// wrapText splits text into lines of at most width characters. Words longer
+// than a line are split.
+func wrapText(text string, width int) []string {
+	var lines []string
+	current := ""
+	for _, word := range strings.Fields(text) {
+		for len(word) > width {
+			if current != "" {
+				lines = append(lines, current)
+				current = ""
+			}
+			lines = append(lines, word[:width])
+			word = word[width:]
+		}
+		switch {
+		case current == "":
+			current = word
+		case len(current)+1+len(word) <= width:
+			current += " " + word
+		default:
+			lines = append(lines, current)
+			current = word
+		}
+	}
+	if current != "" || len(lines) == 0 {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+// printPluginConfigInfo lists the configuration fields of the loaded plugins.
+func printPluginConfigInfo() {
+	if RootCommand == nil {
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+	printed := false
+	for _, p := range RootCommand.Plugins {
+		for _, f := range p.Config {
+			if !printed {
+				fmt.Fprintln(w, "KEY\tTYPE\tDEFAULT\tDESCRIPTION")
+				printed = true
+			}
+			fieldType := f.Type
+			if fieldType == "" {
+				fieldType = plugins.ConfigString
+			}
+			desc := f.Description
+			if len(f.Allowed) > 0 {
+				desc = fmt.Sprintf("%s (one of %s)", desc, strings.Join(f.Allowed, ", "))
+			}
+			if f.Secret {
+				desc += " [secret]"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", plugins.ConfigKey(p.Name, f.Name), fieldType, f.Default, desc)
+		}
+	}
+	if printed {
+		fmt.Fprintln(w)
+		w.Flush()
+	}
+}
+
 func newCmdConfigView() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:       "view",
@@ -180,19 +353,22 @@ func newCmdConfigSet() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			oldVal := viper.Get(args[0])
 
-			if oldVal == args[1] {
+			if fmt.Sprint(oldVal) == args[1] {
 				util.Logger.Warn("New value is the same as the current one")
 				return nil
 			}
-			if util.Contains(boolConfigFields, args[0]) {
-				newValue, err := strconv.ParseBool(args[1])
-				if err != nil {
-					return argsError(fmt.Errorf("the %s value must be either true or false", args[0]))
-				}
-				viper.Set(args[0], newValue)
-			} else {
-				viper.Set(args[0], args[1])
+			if pluginName, field, ok := plugins.SplitConfigKey(args[0]); ok {
+				return setPluginConfig(pluginName, field, args[1])
+			}
+			f, _ := appconfig.FindField(args[0])
+			newValue, err := f.Parse(args[1])
+			if err != nil {
+				return argsError(err)
 			}
+			if f.Secret {
+				return setSecret(strings.ToLower(args[0]), args[1])
+			}
+			appconfig.SetProfile(args[0], newValue)
 			// Update the logger (for testing).
 			switch args[0] {
 			case appconfig.LoggingLevel:
@@ -212,7 +388,7 @@ func newCmdConfigSet() *cobra.Command {
 					util.Logger.Formatter = util.NewTextFormatter()
 				}
 			}
-			if err := viper.WriteConfig(); err != nil {
+			if err := appconfig.WriteConfig(); err != nil {
 				return errorsutil.New("Failed to write updated configuration", err)
 			}
 			util.Logger.Infof("Updated %s from %v to %s", args[0], oldVal, args[1])
@@ -222,39 +398,246 @@ func newCmdConfigSet() *cobra.Command {
 	return cmd
 }
 
-func checkSetArgs(cmd *cobra.Command, args []string) error {
-	if len(args) != 2 {
-		return argsError(errors.New("requires both a config key and a new value"))
+func newCmdConfigUnset() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "unset KEY",
+		Short: "Remove a config item from the active profile",
+		Long: dedent.Dedent(`
+			The "config unset" command removes a setting from the active profile so that the
+			value of the base configuration is used instead. In the default profile, the
+			setting goes back to its default value.`),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key := strings.ToLower(args[0])
+			if err := checkEditableKey(key); err != nil {
+				return err
+			}
+			removedSecret, err := unsetSecret(key)
+			if err != nil {
+				return errorsutil.New("Failed to remove secret from the secrets file", err)
+			}
+			if !appconfig.UnsetProfile(key) {
+				if removedSecret {
+					util.Logger.Infof("Removed %s from the secrets file", key)
+				} else {
+					util.Logger.Warnf("%s is not set in the %s profile", key, appconfig.Profile())
+				}
+				return nil
+			}
+			if err := appconfig.WriteConfig(); err != nil {
+				return errorsutil.New("Failed to write updated configuration", err)
+			}
+			util.Logger.Infof("Unset %s, its value is now %v", key, viper.Get(key))
+			return nil
+		},
 	}
+	return cmd
+}
 
-	switch args[0] {
-	case appconfig.LoggingLevel:
-		if !util.Contains(loggingLevels, args[1]) {
-			return argsError(fmt.Errorf("logging level must be one of %v", loggingLevels))
+func newCmdConfigReset() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reset [KEY]",
+		Short: "Reset config items to their default values",
+		Long: dedent.Dedent(`
+			The "config reset" command sets a config item in the active profile back to its
+			default value. Without a key, every setting that has a default is reset, except
+			the ones enforced by the organization's policy.`),
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				key := strings.ToLower(args[0])
+				if err := checkEditableKey(key); err != nil {
+					return err
+				}
+				f, _ := appconfig.FindField(key)
+				if f.Default == nil {
+					return argsError(fmt.Errorf("%s does not have a default value, use 'config unset' instead", key))
+				}
+				appconfig.SetProfile(key, f.Default)
+				if err := appconfig.WriteConfig(); err != nil {
+					return errorsutil.New("Failed to write updated configuration", err)
+				}
+				util.Logger.Infof("Reset %s to %v", key, f.Default)
+				return nil
+			}
+
+			if !options.YesOption {
+				util.Confirm(map[string]string{"Reset all settings of profile": appconfig.Profile()})
+			}
+			for _, f := range appconfig.Schema() {
+				if _, locked := appconfig.Locked(f.Key); f.Default == nil || f.Managed != "" || locked {
+					continue
+				}
+				appconfig.SetProfile(f.Key, f.Default)
+			}
+			if err := appconfig.WriteConfig(); err != nil {
+				return errorsutil.New("Failed to write updated configuration", err)
+			}
+			util.Logger.Infof("Reset the settings of the %s profile to their defaults", appconfig.Profile())
+			return nil
+		},
+	}
+	return cmd
+}
+
+// unsetSecret removes a secret setting from the secret store. It reports
+// whether the setting was in the store.
+func unsetSecret(key string) (bool, error) {
+	if pluginName, field, ok := plugins.SplitConfigKey(key); ok {
+		schema, _ := pluginSchema(pluginName)
+		if f, ok := plugins.FindField(schema, field); !ok || !f.Secret {
+			return false, nil
 		}
+		key = plugins.ConfigKey(pluginName, field)
+	} else if f, ok := appconfig.FindField(key); !ok || !f.Secret {
+		return false, nil
+	}
+	err := appconfig.SecretStore().Delete(key)
+	if errors.Is(err, secrets.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkEditableKey ensures that a setting can be changed by 'config unset' and
+// 'config reset'.
+func checkEditableKey(key string) error {
+	if locked, ok := appconfig.Locked(key); ok {
+		return fmt.Errorf("%s is enforced by the policy in %s and cannot be changed", locked, appconfig.PolicyFile(locked))
+	}
+	if _, _, ok := plugins.SplitConfigKey(key); ok {
 		return nil
-	case appconfig.LoggingFormat:
-		if !util.Contains(loggingFormats, args[1]) {
-			return argsError(fmt.Errorf("logging format must be one of %v", loggingFormats))
-		}
+	}
+	f, ok := appconfig.FindField(key)
+	if !ok {
+		return argsError(fmt.Errorf("invalid config key %s", key))
+	}
+	if f.Managed != "" {
+		return errors.New(f.Managed)
+	}
+	return nil
+}
+
+func newCmdConfigValidate() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the configuration file for invalid settings",
+		Long: dedent.Dedent(`
+			The "config validate" command checks every setting in the configuration file and
+			its profiles against the types and allowed values listed by "config info". The
+			settings of plugins are checked if the plugin is loaded.`),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			errs := appconfig.Validate(checkPluginSetting)
+			for _, err := range errs {
+				util.Logger.Error(err)
+			}
+			if len(errs) > 0 {
+				return fmt.Errorf("found %d invalid settings in %s", len(errs), viper.ConfigFileUsed())
+			}
+			util.Logger.Infof("%s is valid", viper.ConfigFileUsed())
+			return nil
+		},
+	}
+	return cmd
+}
+
+// checkPluginSetting validates a plugin setting in the configuration file
+// against the schema reported by the plugin. The settings of plugins that
+// are not loaded are not checked.
+func checkPluginSetting(key string, value interface{}) error {
+	pluginName, field, _ := plugins.SplitConfigKey(key)
+	schema, loaded := pluginSchema(pluginName)
+	if !loaded {
 		return nil
-	case appconfig.GithubTokens:
-		return errors.New("please use the 'plugins auth' commands to edit configured Github access tokens")
-	case appconfig.DefaultServiceAccounts:
-		return errors.New("please use the 'default-service-accounts' commands to edit configured default service accounts")
+	}
+	f, ok := plugins.FindField(schema, field)
+	if !ok {
+		return errors.New("unknown plugin setting")
+	}
+	_, err := f.ParseValue(fmt.Sprint(value))
+	return err
+}
+
+// setPluginConfig stores a setting in the plugins.<name> section of the config,
+// or in the secret store if the setting is secret. The value has already been
+// validated by checkSetArgs.
+func setPluginConfig(pluginName, field, value string) error {
+	schema, _ := pluginSchema(pluginName)
+	f, _ := plugins.FindField(schema, field)
+	newVal, err := f.ParseValue(value)
+	if err != nil {
+		return argsError(err)
+	}
+	key := plugins.ConfigKey(pluginName, f.Name)
+	if f.Secret {
+		return setSecret(key, value)
 	}
 
-	if util.Contains(boolConfigFields, args[0]) {
-		if _, err := strconv.ParseBool(args[1]); err != nil {
-			return argsError(fmt.Errorf("the %s value must be either true or false", args[0]))
+	oldVal := viper.Get(key)
+	appconfig.SetProfile(key, newVal)
+	if err := appconfig.WriteConfig(); err != nil {
+		return errorsutil.New("Failed to write updated configuration", err)
+	}
+	util.Logger.Infof("Updated %s from %v to %s", key, oldVal, value)
+	return nil
+}
+
+// setSecret saves a secret setting in the secret store.
+func setSecret(key, value string) error {
+	if err := appconfig.SecretStore().Set(key, value); err != nil {
+		return errorsutil.New("Failed to write secret to the secrets file", err)
+	}
+	// Remove a plaintext value from before the setting was secret.
+	if appconfig.UnsetProfile(key) {
+		if err := appconfig.WriteConfig(); err != nil {
+			return errorsutil.New("Failed to write updated configuration", err)
 		}
-		return nil
 	}
+	util.Logger.Infof("Updated %s", key)
+	return nil
+}
 
-	if !util.Contains(viper.AllKeys(), args[0]) {
-		return argsError(fmt.Errorf("invalid config key %s", args[0]))
+// checkPluginSetArgs validates a plugin setting against the schema reported
+// by the plugin.
+func checkPluginSetArgs(pluginName, field, value string) error {
+	schema, loaded := pluginSchema(pluginName)
+	if !loaded {
+		return argsError(fmt.Errorf("no plugin named %s is loaded", pluginName))
+	}
+	f, ok := plugins.FindField(schema, field)
+	if !ok {
+		return argsError(fmt.Errorf("invalid config key %s", plugins.ConfigKey(pluginName, field)))
+	}
+	if _, err := f.ParseValue(value); err != nil {
+		return argsError(err)
 	}
+	return nil
+}
 
+func checkSetArgs(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return argsError(errors.New("requires both a config key and a new value"))
+	}
+
+	if locked, ok := appconfig.Locked(args[0]); ok {
+		return fmt.Errorf("%s is enforced by the policy in %s and cannot be changed", locked, appconfig.PolicyFile(locked))
+	}
+
+	if pluginName, field, ok := plugins.SplitConfigKey(args[0]); ok {
+		return checkPluginSetArgs(pluginName, field, args[1])
+	}
+
+	f, ok := appconfig.FindField(args[0])
+	if !ok || f.Type == appconfig.TypePlugin {
+		return argsError(fmt.Errorf("invalid config key %s", args[0]))
+	}
+	if f.Managed != "" {
+		return errors.New(f.Managed)
+	}
+	if _, err := f.Parse(args[1]); err != nil {
+		return argsError(err)
+	}
 	return nil
 }
 
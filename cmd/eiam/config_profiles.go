@@ -0,0 +1,133 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eiam
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+	"github.com/replit/ephemeral-iam/pkg/options"
+)
+
+func newCmdConfigProfiles() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profiles",
+		Short: "Manage configuration profiles",
+		Long: dedent.Dedent(`
+			Profiles are named sets of settings in the configuration file that override the
+			base configuration, such as the proxy port, default project, and default service
+			accounts of an environment. The base configuration is the "default" profile.
+
+			The profile used by a command is selected by the --profile flag, the EIAM_PROFILE
+			environment variable, or the profile saved with 'config profiles use', in that
+			order. While a profile is active, 'config set' and 'default-service-accounts set'
+			change the profile's settings.`),
+		Example: dedent.Dedent(`
+			eiam config profiles create prod
+			eiam --profile prod config set authproxy.proxyport 8085
+			eiam config profiles use prod`),
+	}
+	cmd.AddCommand(newCmdConfigProfilesList())
+	cmd.AddCommand(newCmdConfigProfilesCreate())
+	cmd.AddCommand(newCmdConfigProfilesUse())
+	cmd.AddCommand(newCmdConfigProfilesDelete())
+	return cmd
+}
+
+func newCmdConfigProfilesList() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the configuration profiles",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+			fmt.Fprintln(w, "\nPROFILE\tACTIVE\tSAVED")
+			for _, name := range appconfig.ProfileNames() {
+				active, saved := "", ""
+				if name == appconfig.Profile() {
+					active = "*"
+				}
+				if name == appconfig.SavedProfile() {
+					saved = "*"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", name, active, saved)
+			}
+			fmt.Fprintln(w)
+			w.Flush()
+		},
+	}
+	return cmd
+}
+
+func newCmdConfigProfilesCreate() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create [name]",
+		Short: "Create an empty configuration profile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := appconfig.CreateProfile(args[0]); err != nil {
+				return errorsutil.New("Failed to create profile", err)
+			}
+			util.Logger.Infof("Created profile %s. Use 'eiam --profile %s config set' to change its settings", args[0], args[0])
+			return nil
+		},
+	}
+	return cmd
+}
+
+func newCmdConfigProfilesUse() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "use [name]",
+		Short: "Save the profile to use when --profile and EIAM_PROFILE are not set",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := appconfig.UseProfile(args[0]); err != nil {
+				return errorsutil.New("Failed to change the active profile", err)
+			}
+			util.Logger.Infof("Switched to profile %s", args[0])
+			if env := os.Getenv(appconfig.ProfileEnv); env != "" && env != args[0] {
+				util.Logger.Warnf("%s is set, so the %s profile is used until it is unset", appconfig.ProfileEnv, env)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+func newCmdConfigProfilesDelete() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete [name]",
+		Short: "Delete a configuration profile and its settings",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !options.YesOption {
+				util.Confirm(map[string]string{"Delete profile": args[0]})
+			}
+			if err := appconfig.DeleteProfile(args[0]); err != nil {
+				return errorsutil.New("Failed to delete profile", err)
+			}
+			util.Logger.Infof("Deleted profile %s", args[0])
+			return nil
+		},
+	}
+	return cmd
+}
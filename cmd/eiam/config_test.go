@@ -75,10 +75,15 @@ func TestConfigNoSubCommand(t *testing.T) {
           config [command]
 
         Available Commands:
+          completion  Generate the autocompletion script for the specified shell
           help        Help about any command
           info        Print information about config fields
           print       Print the current configuration
+          profiles    Manage configuration profiles
+          reset       Reset config items to their default values
           set         Set the value of a provided config item
+          unset       Remove a config item from the active profile
+          validate    Check the configuration file for invalid settings
           view        View the value of a provided config item
 
         Flags:
@@ -255,3 +260,86 @@ func TestConfigSetCommandSetLoggingFormat(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
+
+func TestMaskSecrets(t *testing.T) {
+	input := dedent.Dedent(`
+		github:
+		  auth: true
+		  tokens:
+		    personal: ghp_secret
+		logging:
+		  level: info
+		plugins:
+		  not-loaded:
+		    apikey: secret
+	`)
+	masked, err := maskSecrets([]byte(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	output := string(masked)
+	for _, secret := range []string{"ghp_secret", "apikey: secret"} {
+		if strings.Contains(output, secret) {
+			t.Errorf("expected %q to be masked\nOUTPUT:\n%s", secret, output)
+		}
+	}
+	for _, expected := range []string{"personal: '**********'", "level: info", "auth: true"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("unexpected output:\nEXPECTED TO FIND: %s\nACTUAL: %s", expected, output)
+		}
+	}
+}
+
+func TestConfigUnsetAndReset(t *testing.T) {
+	initialPort := viper.Get(appconfig.AuthProxyPort)
+	defer func() {
+		appconfig.SetProfile(appconfig.AuthProxyPort, initialPort)
+		if err := appconfig.WriteConfig(); err != nil {
+			t.Errorf("failed to restore the proxy port: %v", err)
+		}
+	}()
+
+	if _, err := executeCommand(newCmdConfigSet(), appconfig.AuthProxyPort, "9000"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	output, err := executeCommand(newCmdConfigReset(), appconfig.AuthProxyPort)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if port := viper.GetInt(appconfig.AuthProxyPort); port != 8084 {
+		t.Errorf("expected the default proxy port after reset, got %d\nOUTPUT:\n%s", port, output)
+	}
+
+	if _, err := executeCommand(newCmdConfigUnset(), appconfig.AuthProxyPort); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if port := viper.GetInt(appconfig.AuthProxyPort); port != 8084 {
+		t.Errorf("expected the default proxy port after unset, got %d", port)
+	}
+
+	output, err = executeCommand(newCmdConfigUnset(), appconfig.GithubTokens+".personal")
+	if err == nil {
+		t.Errorf("expected an error unsetting a token\nOUTPUT:\n%s", output)
+	}
+}
+
+func TestFormatConfigInfo(t *testing.T) {
+	table := formatConfigInfo([]appconfig.Field{{
+		Key:         appconfig.LoggingFormat,
+		Type:        appconfig.TypeString,
+		Allowed:     []string{"text", "json"},
+		Default:     "text",
+		Description: "The format for which to write console logs",
+	}})
+	expected := dedent.Dedent(`
+		┏━━━━━━━━━━━━━━━━┳━━━━━━━━┳━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━┓
+		┃ Key            ┃ Type   ┃ Description                                 ┃
+		┡━━━━━━━━━━━━━━━━╇━━━━━━━━╇━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━┩
+		│ logging.format │ string │ The format for which to write console logs. │
+		│                │        │ One of text, json. Default: text            │
+		└────────────────┴────────┴─────────────────────────────────────────────┘
+	`)
+	if strings.TrimSpace(table) != strings.TrimSpace(expected) {
+		t.Errorf("unexpected output:\n%s", diff.LineDiff(expected, table))
+	}
+}
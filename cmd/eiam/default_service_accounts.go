@@ -35,6 +35,11 @@ func newCmdSetDefaultServiceAccount() *cobra.Command {
 		Use:   "set",
 		Short: "Set a default privileged service account to impersonate for a given GCP project",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			key := appconfig.DefaultServiceAccounts + "." + project
+			if locked, ok := appconfig.Locked(key); ok {
+				return fmt.Errorf("%s is enforced by the policy in %s and cannot be changed", locked, appconfig.PolicyFile(locked))
+			}
+
 			availableSAs, err := gcpclient.FetchAvailableServiceAccounts(project)
 			if err != nil {
 				return err
@@ -49,10 +54,9 @@ func newCmdSetDefaultServiceAccount() *cobra.Command {
 				return errorsutil.New("Failed to get selected service account: %v", err)
 			}
 
-			defaultSAs := viper.GetStringMapString(appconfig.DefaultServiceAccounts)
-			defaultSAs[project] = selected
-			viper.Set(appconfig.DefaultServiceAccounts, defaultSAs)
-			if err := viper.WriteConfig(); err != nil {
+			// Default service accounts are stored in the active profile.
+			appconfig.SetProfile(key, selected)
+			if err := appconfig.WriteConfig(); err != nil {
 				return errorsutil.New("Failed to write updated configuration", err)
 			}
 
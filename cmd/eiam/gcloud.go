@@ -24,6 +24,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/replit/ephemeral-iam/internal/access"
 	util "github.com/replit/ephemeral-iam/internal/eiamutil"
 	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
 	"github.com/replit/ephemeral-iam/internal/gcpclient"
@@ -58,6 +59,19 @@ func newCmdGcloud() *cobra.Command {
 			}
 
 			gcloudCmdArgs = util.ExtractUnknownArgs(cmd.Flags(), os.Args)
+
+			if err := options.CheckServiceAccount(gcloudCmdConfig.ServiceAccountEmail); err != nil {
+				return err
+			}
+
+			if err := options.CheckAccess(access.CommandGcloud, &gcloudCmdConfig); err != nil {
+				return err
+			}
+
+			if err := options.ResolveReason(&gcloudCmdConfig); err != nil {
+				return err
+			}
+
 			if err := util.FormatReason(&gcloudCmdConfig.Reason); err != nil {
 				return err
 			}
@@ -70,7 +84,7 @@ func newCmdGcloud() *cobra.Command {
 					"Command":         fmt.Sprintf("gcloud %s", strings.Join(gcloudCmdArgs, " ")),
 				})
 			}
-			return nil
+			return awaitApproval(&gcloudCmdConfig)
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runGcloudCommand()
@@ -79,6 +93,7 @@ func newCmdGcloud() *cobra.Command {
 
 	options.AddServiceAccountEmailFlag(cmd.Flags(), &gcloudCmdConfig.ServiceAccountEmail, true)
 	options.AddReasonFlag(cmd.Flags(), &gcloudCmdConfig.Reason, true)
+	options.AddReasonTemplateFlags(cmd.Flags(), &gcloudCmdConfig.ReasonTemplate, &gcloudCmdConfig.Ticket)
 	options.AddProjectFlag(cmd.Flags(), &gcloudCmdConfig.Project, false)
 
 	return cmd
@@ -110,7 +125,20 @@ func runGcloudCommand() error {
 	}
 
 	cmdArgs := append([]string(nil), gcloudOpts...)
-	cmdArgs = append(cmdArgs, "--impersonate-service-account", gcloudCmdConfig.ServiceAccountEmail, "--verbosity=error")
+	env := append(os.Environ(), reasonHeader)
+	if gcloudCmdConfig.ReadOnly || gcloudCmdConfig.TokenDuration > 0 {
+		// gcloud cannot limit the scopes or the lifetime of an impersonated
+		// token, so a token is generated and passed to it in a file instead.
+		tokenFile, err := writeAccessToken()
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tokenFile)
+		env = append(env, fmt.Sprintf("CLOUDSDK_AUTH_ACCESS_TOKEN_FILE=%s", tokenFile))
+		cmdArgs = append(cmdArgs, "--verbosity=error")
+	} else {
+		cmdArgs = append(cmdArgs, "--impersonate-service-account", gcloudCmdConfig.ServiceAccountEmail, "--verbosity=error")
+	}
 	cmdArgs = append(cmdArgs, positionalArgs...)
 
 	gcloud := viper.GetString("binarypaths.gcloud")
@@ -118,11 +146,33 @@ func runGcloudCommand() error {
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 	c.Stdin = os.Stdin
-	c.Env = append(os.Environ(), reasonHeader)
+	c.Env = env
 
-	if err := c.Run(); err != nil {
+	if err := runWithHooks(c, "gcloud", gcloudCmdArgs, &gcloudCmdConfig); err != nil {
 		fullCmd := fmt.Sprintf("gcloud %s", strings.Join(gcloudCmdArgs, " "))
 		return errorsutil.New(fmt.Sprintf("Failed to run command [%s]", fullCmd), err)
 	}
 	return nil
 }
+
+// writeAccessToken generates a token for the service account and writes it
+// to a temporary file that only the user can read.
+func writeAccessToken() (string, error) {
+	if gcloudCmdConfig.TokenDuration == 0 {
+		gcloudCmdConfig.TokenDuration = gcpclient.DefaultTokenDuration
+	}
+	accessToken, err := mintToken("gcloud", &gcloudCmdConfig)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "eiam-token-")
+	if err != nil {
+		return "", errorsutil.New("Failed to create access token file", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(accessToken.GetAccessToken()); err != nil {
+		os.Remove(f.Name())
+		return "", errorsutil.New("Failed to write access token file", err)
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,263 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eiam
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/replit/ephemeral-iam/internal/access"
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+	queryiam "github.com/replit/ephemeral-iam/internal/gcpclient/query_iam"
+	"github.com/replit/ephemeral-iam/internal/hooks"
+	"github.com/replit/ephemeral-iam/pkg/options"
+)
+
+// How long and how often eiam checks whether a granted role has taken effect.
+const (
+	grantPropagationTimeout  = 7 * time.Minute
+	grantPropagationInterval = 10 * time.Second
+)
+
+var (
+	grantCmdConfig options.CmdConfig
+	grantKeep      bool
+)
+
+func newCmdGrant() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "grant",
+		Short: "Temporarily grant yourself an IAM role on a GCP resource",
+		Long: dedent.Dedent(`
+			The "grant" command is an alternative to impersonating a service account. It adds an IAM
+			binding of a role for your own account to a resource, with a condition that stops it from
+			granting the role once the duration has passed. The title of the condition is the reason,
+			which includes the session ID that is also in the audit logs.
+
+			eiam waits for the role to take effect, then keeps running until the duration has passed
+			or you press Ctrl-C, and removes the binding. With --keep it exits once the role has taken
+			effect and leaves the binding in place until its condition expires.`),
+	}
+
+	cmd.AddCommand(newCmdGrantResource(queryiam.ComputeInstance, "a compute instance", `
+		eiam grant compute-instance --role roles/compute.instanceAdmin.v1 \
+		  --zone us-central1-a --instance my-instance --reason "Debug boot loop (INC-42)"`,
+		func(fs *pflag.FlagSet) {
+			options.AddZoneFlag(fs, &grantCmdConfig.Zone, true)
+			options.AddComputeInstanceFlag(fs, &grantCmdConfig.ComputeInstance, true)
+		}))
+	cmd.AddCommand(newCmdGrantResource(queryiam.Project, "a project", `
+		eiam grant project --role roles/viewer --project my-project \
+		  --duration 30m --reason "Investigate outage (INC-42)"`,
+		func(fs *pflag.FlagSet) {}))
+	cmd.AddCommand(newCmdGrantResource(queryiam.PubSubTopic, "a Pub/Sub topic", `
+		eiam grant pubsub --role roles/pubsub.publisher --topic my-topic \
+		  --reason "Replay dropped events (INC-42)"`,
+		func(fs *pflag.FlagSet) {
+			options.AddPubSubTopicFlag(fs, &grantCmdConfig.PubSubTopic, true)
+		}))
+	cmd.AddCommand(newCmdGrantResource(queryiam.ServiceAccount, "a service account", `
+		eiam grant service-account --role roles/iam.serviceAccountTokenCreator \
+		  --service-account-email example@my-project.iam.gserviceaccount.com --reason "Rotate keys (INC-42)"`,
+		func(fs *pflag.FlagSet) {
+			options.AddServiceAccountEmailFlag(fs, &grantCmdConfig.ServiceAccountEmail, true)
+		}))
+	cmd.AddCommand(newCmdGrantResource(queryiam.StorageBucket, "a storage bucket", `
+		eiam grant storage-bucket --role roles/storage.objectViewer --bucket my-bucket \
+		  --reason "Restore a backup (INC-42)"`,
+		func(fs *pflag.FlagSet) {
+			options.AddStorageBucketFlag(fs, &grantCmdConfig.StorageBucket, true)
+		}))
+
+	return cmd
+}
+
+// newCmdGrantResource returns the grant subcommand for a kind of resource.
+// addFlags adds the flags that identify the resource.
+func newCmdGrantResource(kind queryiam.ResourceKind, noun, example string, addFlags func(fs *pflag.FlagSet)) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     string(kind),
+		Short:   fmt.Sprintf("Temporarily grant yourself a role on %s", noun),
+		Example: dedent.Dedent(example),
+		Args:    cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if kind == queryiam.ServiceAccount {
+				options.FixupServiceAccountEmail(grantCmdConfig.Project, &grantCmdConfig.ServiceAccountEmail)
+			}
+			if err := options.CheckRequired(cmd.Flags()); err != nil {
+				return err
+			}
+
+			if err := options.CheckTokenDuration(grantCmdConfig.TokenDuration); err != nil {
+				return err
+			}
+
+			if err := options.CheckAccess(access.CommandGrant, &grantCmdConfig); err != nil {
+				return err
+			}
+
+			if err := options.ResolveReason(&grantCmdConfig); err != nil {
+				return err
+			}
+
+			if err := util.FormatReason(&grantCmdConfig.Reason); err != nil {
+				return err
+			}
+
+			if !options.YesOption {
+				util.Confirm(map[string]string{
+					"Resource": grantResource(kind).String(),
+					"Role":     grantCmdConfig.Role,
+					"Duration": grantCmdConfig.TokenDuration.String(),
+					"Reason":   grantCmdConfig.Reason,
+				})
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runGrant(grantResource(kind))
+		},
+	}
+
+	options.AddRoleFlag(cmd.Flags(), &grantCmdConfig.Role, true)
+	addFlags(cmd.Flags())
+	options.AddProjectFlag(cmd.Flags(), &grantCmdConfig.Project, false)
+	options.AddReasonFlag(cmd.Flags(), &grantCmdConfig.Reason, true)
+	options.AddReasonTemplateFlags(cmd.Flags(), &grantCmdConfig.ReasonTemplate, &grantCmdConfig.Ticket)
+	options.AddTokenDurationFlag(cmd.Flags(), &grantCmdConfig.TokenDuration, false)
+	cmd.Flags().BoolVar(&grantKeep, "keep", false,
+		"Exit once the role has taken effect and leave the binding in place until it expires")
+
+	return cmd
+}
+
+// grantResource returns the resource of a kind that grantCmdConfig identifies.
+func grantResource(kind queryiam.ResourceKind) queryiam.Resource {
+	r := queryiam.Resource{Kind: kind, Project: grantCmdConfig.Project}
+	switch kind {
+	case queryiam.ComputeInstance:
+		r.Zone, r.Name = grantCmdConfig.Zone, grantCmdConfig.ComputeInstance
+	case queryiam.PubSubTopic:
+		r.Name = grantCmdConfig.PubSubTopic
+	case queryiam.ServiceAccount:
+		r.Name = grantCmdConfig.ServiceAccountEmail
+	case queryiam.StorageBucket:
+		r.Name = grantCmdConfig.StorageBucket
+	}
+	return r
+}
+
+func runGrant(resource queryiam.Resource) error {
+	user, err := appconfig.Identity()
+	if err != nil {
+		return err
+	}
+	api, err := queryiam.NewPolicyAPI(resource)
+	if err != nil {
+		return err
+	}
+
+	role, member := grantCmdConfig.Role, queryiam.Member(user)
+	expiry := time.Now().Add(grantCmdConfig.TokenDuration)
+	cond := queryiam.ExpiryCondition(grantCmdConfig.Reason, expiry)
+
+	util.Logger.Infof("Granting %s on %s to %s until %s", role, resource, member, expiry.Format(time.RFC1123))
+	err = queryiam.UpdatePolicy(api, func(p *queryiam.Policy) bool {
+		return p.AddBinding(role, member, cond)
+	})
+	if err != nil {
+		return errorsutil.New(fmt.Sprintf("Failed to grant %s on %s", role, resource), err)
+	}
+
+	started := newEvent(hooks.SessionStarted, "grant", &grantCmdConfig)
+	started.ExpiresAt = expiry
+	if err := hooks.Emit(started); err != nil {
+		revokeGrant(api, role, member, cond, expiry)
+		return errorsutil.New("Refusing to start the grant", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	waitForGrant(ctx, resource, role)
+	if grantKeep && ctx.Err() == nil {
+		util.Logger.Infof("The binding stays in place and stops granting %s at %s", role, expiry.Format(time.RFC1123))
+		hooks.Wait()
+		return nil
+	}
+
+	if ctx.Err() == nil {
+		util.Logger.Infof("Press Ctrl-C to remove the binding before %s", expiry.Format(time.RFC1123))
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Until(expiry)):
+		}
+	}
+	revokeGrant(api, role, member, cond, expiry)
+
+	if err := hooks.Emit(newEvent(hooks.SessionEnded, "grant", &grantCmdConfig)); err != nil {
+		util.Logger.WithError(err).Error("Hooks failed after the grant ended")
+	}
+	hooks.Wait()
+	return nil
+}
+
+// waitForGrant waits until the permissions of role that can be tested on
+// resource have taken effect. It only warns if they have not, because the
+// binding is in place and IAM may just be slow to propagate it.
+func waitForGrant(ctx context.Context, resource queryiam.Resource, role string) {
+	perms, err := queryiam.RolePermissions(role, resource)
+	if err != nil {
+		util.Logger.WithError(err).Warn("Unable to check whether the role has taken effect")
+		return
+	}
+	if len(perms) == 0 {
+		return
+	}
+
+	util.Logger.Info("Waiting for the role to take effect")
+	waitCtx, cancel := context.WithTimeout(ctx, grantPropagationTimeout)
+	defer cancel()
+	if err := queryiam.WaitForPermissions(waitCtx, resource, perms, grantPropagationInterval); err != nil {
+		if ctx.Err() == nil {
+			util.Logger.Warnf("The role has not taken effect after %v, IAM changes can take several minutes", grantPropagationTimeout)
+		}
+		return
+	}
+	util.Logger.Infof("%s has taken effect", role)
+}
+
+// revokeGrant removes the binding that runGrant added. If that fails, the
+// binding stops granting the role when its condition expires.
+func revokeGrant(api queryiam.PolicyAPI, role, member string, cond *queryiam.Expr, expiry time.Time) {
+	util.Logger.Infof("Removing the binding of %s", role)
+	err := queryiam.UpdatePolicy(api, func(p *queryiam.Policy) bool {
+		return p.RemoveBinding(role, member, cond.Title)
+	})
+	if err != nil {
+		util.Logger.WithError(err).Errorf("Failed to remove the binding, it stops granting %s at %s",
+			role, expiry.Format(time.RFC1123))
+	}
+}
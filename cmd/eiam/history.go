@@ -0,0 +1,170 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eiam
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	"github.com/replit/ephemeral-iam/internal/history"
+	"github.com/replit/ephemeral-iam/internal/hooks"
+)
+
+// The output formats of the history command.
+const (
+	historyTable = "table"
+	historyJSON  = "json"
+	historyCSV   = "csv"
+)
+
+var (
+	historyFilter history.Filter
+	historyType   string
+	historySince  string
+	historyUntil  string
+	historyOutput string
+)
+
+func newCmdHistory() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "List the privileged sessions, tokens and commands recorded on this machine",
+		Long: dedent.Dedent(`
+			Every token eiam generates, and every session and command it starts, is recorded in the
+			history.jsonl file in the eiam config directory, unless history.enabled is false. Each
+			entry includes the hash of the previous one, so that "eiam history verify" can detect
+			entries that were changed or removed.
+
+			The --since and --until flags accept a date such as 2021-06-01, a local time such as
+			2021-06-01T15:04, or a duration such as 24h that counts back from now.`),
+		Example: dedent.Dedent(`
+			eiam history --since 7d --project my-prod
+			eiam history -s 'admin@*' --reason INC-1234 --output csv > incident.csv`),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter := historyFilter
+			filter.Type = hooks.EventType(historyType)
+			if historyType != "" {
+				if _, err := hooks.ParseEvents([]string{historyType}); err != nil {
+					return argsError(err)
+				}
+			}
+			var err error
+			if filter.Since, err = parseHistoryTime(historySince); err != nil {
+				return argsError(fmt.Errorf("invalid --since: %v", err))
+			}
+			if filter.Until, err = parseHistoryTime(historyUntil); err != nil {
+				return argsError(fmt.Errorf("invalid --until: %v", err))
+			}
+
+			entries, err := history.Default().Read()
+			if err != nil {
+				return err
+			}
+			entries = filter.Apply(entries)
+
+			switch historyOutput {
+			case historyJSON:
+				return history.WriteJSON(os.Stdout, entries)
+			case historyCSV:
+				return history.WriteCSV(os.Stdout, entries)
+			case historyTable:
+				return printHistory(entries)
+			}
+			return argsError(fmt.Errorf("--output must be one of %s, %s or %s", historyTable, historyJSON, historyCSV))
+		},
+	}
+	cmd.Flags().StringVarP(&historyFilter.ServiceAccount, "service-account-email", "s", "", "Only list entries for service accounts that match this pattern")
+	cmd.Flags().StringVarP(&historyFilter.Project, "project", "p", "", "Only list entries for projects that match this pattern")
+	cmd.Flags().StringVar(&historyFilter.Reason, "reason", "", "Only list entries whose reason contains this text")
+	cmd.Flags().StringVar(&historyType, "type", "", "Only list entries of this event type, such as session_started")
+	cmd.Flags().StringVar(&historySince, "since", "", "Only list entries from this time on")
+	cmd.Flags().StringVar(&historyUntil, "until", "", "Only list entries before this time")
+	cmd.Flags().StringVarP(&historyOutput, "output", "o", historyTable, "The output format: table, json or csv")
+
+	cmd.AddCommand(newCmdHistoryVerify())
+	return cmd
+}
+
+func newCmdHistoryVerify() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify",
+		Short: "Check that the history has not been tampered with",
+		Long: dedent.Dedent(`
+			The "history verify" command checks the hash chain of the history and fails if an entry
+			was changed, removed or reordered. Entries removed from the end of the history cannot be
+			detected.`),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			log := history.Default()
+			count, err := log.Verify()
+			var tamperErr *history.TamperError
+			if errors.As(err, &tamperErr) {
+				return fmt.Errorf("%s: %v", log.Path, err)
+			} else if err != nil {
+				return err
+			}
+			util.Logger.Infof("The %d entries of %s are intact", count, log.Path)
+			return nil
+		},
+	}
+}
+
+func printHistory(entries []history.Entry) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tEVENT\tSERVICE ACCOUNT\tPROJECT\tCOMMAND\tREASON")
+	for _, e := range entries {
+		command := strings.Join(append([]string{e.Command}, e.Args...), " ")
+		if e.Type == hooks.CommandFinished && e.Error != "" {
+			command += fmt.Sprintf(" (exit %d)", e.ExitCode)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Time.Local().Format("2006-01-02 15:04:05"), e.Type, e.ServiceAccount, e.Project, command, e.Reason)
+	}
+	return w.Flush()
+}
+
+// parseHistoryTime parses the --since and --until flags.
+func parseHistoryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if strings.HasSuffix(value, "d") {
+		if days, err := time.ParseDuration(strings.TrimSuffix(value, "d") + "h"); err == nil {
+			return time.Now().Add(-24 * days), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02", policyTestTimeLayout} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date, time or duration", value)
+	}
+	return t, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eiam
+
+import (
+	"errors"
+	"os/exec"
+
+	"cloud.google.com/go/iam/credentials/apiv1/credentialspb"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+	"github.com/replit/ephemeral-iam/internal/gcpclient"
+	"github.com/replit/ephemeral-iam/internal/hooks"
+	"github.com/replit/ephemeral-iam/pkg/options"
+)
+
+// newEvent returns a lifecycle event for an eiam command run with cfg.
+func newEvent(t hooks.EventType, command string, cfg *options.CmdConfig) hooks.Event {
+	e := hooks.Event{
+		Type:           t,
+		Command:        command,
+		Project:        cfg.Project,
+		ServiceAccount: cfg.ServiceAccountEmail,
+		Reason:         cfg.Reason,
+	}
+	if user, err := appconfig.Identity(); err == nil {
+		e.User = user
+	}
+	if cfg.TokenDuration > 0 {
+		e.Duration = cfg.TokenDuration.String()
+	}
+	return e
+}
+
+// mintToken generates a short-lived access token for the service account in
+// cfg and emits the token_minted event.
+func mintToken(command string, cfg *options.CmdConfig) (*credentialspb.GenerateAccessTokenResponse, error) {
+	accessToken, err := gcpclient.GenerateTemporaryAccessToken(cfg.ServiceAccountEmail, cfg.Reason, cfg.TokenDuration, cfg.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	event := newEvent(hooks.TokenMinted, command, cfg)
+	event.ExpiresAt = accessToken.GetExpireTime().AsTime()
+	if err := hooks.Emit(event); err != nil {
+		return nil, errorsutil.New("Refusing to continue", err)
+	}
+	return accessToken, nil
+}
+
+// runWithHooks runs c, emitting the command_started event before and the
+// command_finished event after. args are the arguments shown to hooks, which
+// must not include access tokens.
+func runWithHooks(c *exec.Cmd, command string, args []string, cfg *options.CmdConfig) error {
+	started := newEvent(hooks.CommandStarted, command, cfg)
+	started.Args = args
+	if err := hooks.Emit(started); err != nil {
+		return errorsutil.New("Refusing to run command", err)
+	}
+
+	runErr := c.Run()
+
+	finished := newEvent(hooks.CommandFinished, command, cfg)
+	finished.Args = args
+	if runErr != nil {
+		finished.Error = runErr.Error()
+		finished.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(runErr, &exitErr) {
+			finished.ExitCode = exitErr.ExitCode()
+		}
+	}
+	if err := hooks.Emit(finished); err != nil {
+		util.Logger.WithError(err).Error("Hooks failed after the command finished")
+	}
+	return runErr
+}
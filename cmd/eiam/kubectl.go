@@ -24,6 +24,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/replit/ephemeral-iam/internal/access"
 	util "github.com/replit/ephemeral-iam/internal/eiamutil"
 	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
 	"github.com/replit/ephemeral-iam/internal/gcpclient"
@@ -63,6 +64,24 @@ func newCmdKubectl() *cobra.Command {
 			}
 
 			kubectlCmdArgs = util.ExtractUnknownArgs(cmd.Flags(), os.Args)
+
+			if err := options.CheckServiceAccount(kubectlCmdConfig.ServiceAccountEmail); err != nil {
+				return err
+			}
+
+			if err := options.CheckAccess(access.CommandKubectl, &kubectlCmdConfig); err != nil {
+				return err
+			}
+			if kubectlCmdConfig.ReadOnly {
+				if err := checkReadOnlyKubectl(kubectlCmdArgs); err != nil {
+					return err
+				}
+			}
+
+			if err := options.ResolveReason(&kubectlCmdConfig); err != nil {
+				return err
+			}
+
 			if err := util.FormatReason(&kubectlCmdConfig.Reason); err != nil {
 				return err
 			}
@@ -75,7 +94,7 @@ func newCmdKubectl() *cobra.Command {
 					"Command":         fmt.Sprintf("kubectl %s", strings.Join(kubectlCmdArgs, " ")),
 				})
 			}
-			return nil
+			return awaitApproval(&kubectlCmdConfig)
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runKubectlCommand()
@@ -84,12 +103,33 @@ func newCmdKubectl() *cobra.Command {
 
 	options.AddServiceAccountEmailFlag(cmd.Flags(), &kubectlCmdConfig.ServiceAccountEmail, true)
 	options.AddReasonFlag(cmd.Flags(), &kubectlCmdConfig.Reason, true)
+	options.AddReasonTemplateFlags(cmd.Flags(), &kubectlCmdConfig.ReasonTemplate, &kubectlCmdConfig.Ticket)
 	options.AddProjectFlag(cmd.Flags(), &kubectlCmdConfig.Project, false)
 	options.AddTokenDurationFlag(cmd.Flags(), &kubectlCmdConfig.TokenDuration, false)
 
 	return cmd
 }
 
+// readOnlyKubectlCommands are the kubectl commands that do not change the
+// cluster.
+var readOnlyKubectlCommands = []string{
+	"api-resources", "api-versions", "cluster-info", "describe", "explain", "get", "logs", "top", "version",
+}
+
+// checkReadOnlyKubectl ensures that a kubectl command only reads from the
+// cluster. The command must be the first argument so that it cannot be
+// mistaken for the value of a flag.
+func checkReadOnlyKubectl(args []string) error {
+	if len(args) > 0 && util.Contains(readOnlyKubectlCommands, args[0]) {
+		return nil
+	}
+	if len(args) > 1 && args[0] == "auth" && args[1] == "can-i" {
+		return nil
+	}
+	return fmt.Errorf("the access policy only allows read-only kubectl commands, which must come first: %s, auth can-i",
+		strings.Join(readOnlyKubectlCommands, ", "))
+}
+
 func runKubectlCommand() error {
 	hasAccess, err := gcpclient.CanImpersonate(kubectlCmdConfig.Project, kubectlCmdConfig.ServiceAccountEmail)
 	if err != nil {
@@ -99,11 +139,7 @@ func runKubectlCommand() error {
 	}
 
 	util.Logger.Infof("Fetching access token for %s", kubectlCmdConfig.ServiceAccountEmail)
-	accessToken, err := gcpclient.GenerateTemporaryAccessToken(
-		kubectlCmdConfig.ServiceAccountEmail,
-		kubectlCmdConfig.Reason,
-		kubectlCmdConfig.TokenDuration,
-	)
+	accessToken, err := mintToken("kubectl", &kubectlCmdConfig)
 	if err != nil {
 		return err
 	}
@@ -116,7 +152,7 @@ func runKubectlCommand() error {
 	c.Stderr = os.Stderr
 	c.Stdin = os.Stdin
 
-	if err := c.Run(); err != nil {
+	if err := runWithHooks(c, "kubectl", kubectlCmdArgs, &kubectlCmdConfig); err != nil {
 		fullCmd := fmt.Sprintf("kubectl %s", strings.Join(kubectlCmdArgs, " "))
 		return errorsutil.New(fmt.Sprintf("Failed to run command [%s]", fullCmd), err)
 	}
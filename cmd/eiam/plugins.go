@@ -15,9 +15,11 @@
 package eiam
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"regexp"
+	"path/filepath"
+	"text/tabwriter"
 
 	"github.com/lithammer/dedent"
 	"github.com/manifoldco/promptui"
@@ -26,6 +28,7 @@ import (
 	util "github.com/replit/ephemeral-iam/internal/eiamutil"
 	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
 	"github.com/replit/ephemeral-iam/internal/plugins"
+	"github.com/replit/ephemeral-iam/pkg/options"
 )
 
 func newCmdPlugins() *cobra.Command {
@@ -44,11 +47,33 @@ func newCmdPlugins() *cobra.Command {
 			If the plugin you want to install is hosted in a Github repo and the binary is published as
 			a release in the repository, you can install the plugin using the 'eiam plugin install'
 			command.
+
+			-------------------------------     Updating a plugin     ---------------------------------
+			Plugins installed with 'eiam plugins install' are tracked in the 'manifest.json' file of
+			the plugins directory. Use 'eiam plugins outdated' to see which of them have a newer
+			release, 'eiam plugins update' to install it, and 'eiam plugins rollback' to go back to
+			the previously installed version.
+
+			-------------------------------     Disabling a plugin     --------------------------------
+			Use 'eiam plugins disable' to stop eiam from loading a plugin without deleting it, and
+			'eiam plugins enable' to load it again. A plugin that fails to load is quarantined: it is
+			skipped until its binary is replaced or it is re-enabled, and the reason is shown by
+			'eiam plugins list'.
+
+			-------------------------------     Syncing plugins     -----------------------------------
+			A team can list the plugins it uses in a 'plugins.yaml' file and run 'eiam plugins sync'
+			to install, upgrade, and remove plugins until the plugins directory matches it.
 		`),
 	}
 
 	cmd.AddCommand(newCmdPluginsList())
 	cmd.AddCommand(newCmdPluginsInstall())
+	cmd.AddCommand(newCmdPluginsUpdate())
+	cmd.AddCommand(newCmdPluginsOutdated())
+	cmd.AddCommand(newCmdPluginsRollback())
+	cmd.AddCommand(newCmdPluginsSync())
+	cmd.AddCommand(newCmdPluginsDisable())
+	cmd.AddCommand(newCmdPluginsEnable())
 	cmd.AddCommand(newCmdPluginsRemove())
 	cmd.AddCommand(newCmdPluginsAuth())
 	return cmd
@@ -57,9 +82,17 @@ func newCmdPlugins() *cobra.Command {
 func newCmdPluginsList() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "list",
-		Short: "Show the list of loaded plugins",
+		Short: "Show the list of installed plugins",
+		Long: dedent.Dedent(`
+			The "plugins list" command shows every installed plugin along with its status:
+
+			  enabled       The plugin was loaded and its command is available
+			  disabled      The plugin was disabled with 'eiam plugins disable'
+			  quarantined   The plugin failed to load. The reason is shown in the description
+			                and the plugin is skipped until its binary is replaced or it is
+			                re-enabled with 'eiam plugins enable'`),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if len(RootCommand.Plugins) == 0 {
+			if len(RootCommand.Plugins) == 0 && len(RootCommand.Inactive) == 0 {
 				util.Logger.Warn("No plugins are currently installed")
 				return nil
 			}
@@ -74,50 +107,394 @@ func newCmdPluginsInstall() *cobra.Command {
 	var (
 		url       string
 		tokenName string
-		repoOwner string
-		repoName  string
 	)
 	cmd := &cobra.Command{
-		Use:   "install",
+		Use:   "install [SOURCE]",
 		Short: "Install a new eiam plugin",
 		Long: dedent.Dedent(`
-			The "plugins install" command installs a plugin from a Github repository.
+			The "plugins install" command installs a plugin from one of the following sources:
+
+			  github.com/owner/repo[@version]           A Github release
+			  gitlab://host/group/project[@version]     A GitLab release, including self-hosted instances
+			  https://example.com/plugin.tar.gz         A tarball or binary served over HTTPS
+			  gs://bucket/path/plugin.tar.gz[#gen]      A Cloud Storage object, fetched with your credentials
+			  /path/to/plugin or ./plugin.tar.gz        A tarball or binary on the local filesystem
 
-			The latest release in the provided repository is downloaded, extracted, and
+			For Github and GitLab, the latest release is installed unless a version is given.
+			The release asset matching your OS and architecture is downloaded, extracted, and
 			the binary files are moved to the "plugins" directory.
 
-			If the plugin is hosted in a private repository, you need to provide
+			If the plugin is already installed, the existing binary is kept so that
+			it can be restored with 'eiam plugins rollback'.
+
+			If the plugin is hosted in a private Github repository, you need to provide
 			ephemeral-iam with a Github personal access token to authenticate
-			with. See 'eiam plugins auth --help' for more details.
+			with. See 'eiam plugins auth --help' for more details. Private GitLab
+			projects are accessed with the token in the GITLAB_TOKEN environment variable.
 		`),
+		Example: dedent.Dedent(`
+			eiam plugins install --url github.com/user/repo-name
+			eiam plugins install github.com/user/repo-name@v1.2.3
+			eiam plugins install gitlab://gitlab.example.com/infra/eiam-plugin
+			eiam plugins install gs://my-artifacts/eiam/plugin_linux_amd64.tar.gz
+			eiam plugins install ./bin/my-plugin`),
 		Args: func(cmd *cobra.Command, args []string) error {
-			urlRegex := regexp.MustCompile(`^github\.com/(?P<user>[[:alnum:]\-]+)/(?P<repo>[[:alnum:]\.\-_]+)$`)
-			match := urlRegex.FindStringSubmatch(url)
-			if match == nil {
-				err := fmt.Errorf("%s is not a valid Github repo URL", url)
-				return errorsutil.New("Invalid input parameter", err)
-			}
-			for i, grpName := range urlRegex.SubexpNames() {
-				if grpName == "user" {
-					repoOwner = match[i]
-				} else if grpName == "repo" {
-					repoName = match[i]
-				}
+			if len(args) > 1 || (len(args) == 1 && url != "") {
+				return argsError(errors.New("provide a single plugin source as an argument or with --url"))
+			}
+			if len(args) == 1 {
+				url = args[0]
+			}
+			if url == "" {
+				return argsError(errors.New("requires a plugin source"))
 			}
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return plugins.InstallPlugin(repoOwner, repoName, tokenName)
+			return plugins.InstallPlugin(url, tokenName)
 		},
 	}
-	cmd.Flags().StringVarP(&url, "url", "u", "", "The URL of the plugin's Github repo")
-	if err := cmd.MarkFlagRequired("url"); err != nil {
-		util.Logger.Fatal(err.Error())
+	cmd.Flags().StringVarP(&url, "url", "u", "", "The plugin source, optionally followed by @version")
+	cmd.Flags().StringVarP(&tokenName, "token", "t", "", "The name of the Github access token to use for private repos")
+	return cmd
+}
+
+func newCmdPluginsUpdate() *cobra.Command {
+	var (
+		all       bool
+		tokenName string
+	)
+	cmd := &cobra.Command{
+		Use:   "update [PLUGIN_NAME]",
+		Short: "Update installed plugins to their latest release",
+		Long: dedent.Dedent(`
+			The "plugins update" command compares the installed version of a plugin with the
+			latest release in the repository it was installed from and installs the latest
+			release if it is newer. The replaced binary is kept so the update can be undone
+			with 'eiam plugins rollback'.
+
+			Only plugins installed with 'eiam plugins install' can be updated.`),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if all && len(args) > 0 {
+				return argsError(errors.New("a plugin name cannot be used with --all"))
+			}
+			if !all && len(args) != 1 {
+				return argsError(errors.New("requires either a plugin name or --all"))
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manifest, err := plugins.LoadManifest()
+			if err != nil {
+				return err
+			}
+			if !all {
+				binary, err := resolvePluginBinary(args[0])
+				if err != nil {
+					return err
+				}
+				return updatePlugin(manifest, binary, tokenName)
+			}
+
+			// A plugin that fails to update does not keep the others from
+			// being updated.
+			binaries := manifest.Names()
+			var errs []error
+			for _, binary := range binaries {
+				if err := updatePlugin(manifest, binary, tokenName); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", binary, err))
+				}
+			}
+			if len(errs) > 0 {
+				return errorsutil.New(
+					fmt.Sprintf("Failed to update %d of %d plugins", len(errs), len(binaries)),
+					errors.Join(errs...),
+				)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&all, "all", "a", false, "Update every installed plugin")
+	cmd.Flags().StringVarP(&tokenName, "token", "t", "", "The name of the Github access token to use for private repos")
+	return cmd
+}
+
+func updatePlugin(manifest *plugins.Manifest, binary, tokenName string) error {
+	entry, ok := manifest.Plugins[binary]
+	if !ok {
+		return errorsutil.New(
+			fmt.Sprintf("Failed to update %s", binary),
+			errors.New("the plugin was not installed with 'eiam plugins install'"),
+		)
+	}
+	latest, err := plugins.LatestVersion(entry.Source, tokenName)
+	if err != nil {
+		return err
+	}
+	if latest == "" {
+		util.Logger.Infof("%s is installed from %s, which is not versioned. Reinstalling it", binary, entry.Source)
+		return plugins.InstallPlugin(entry.Source, tokenName)
+	}
+	installed := installedVersion(binary, entry)
+	if !plugins.IsNewer(latest, installed) {
+		util.Logger.Infof("%s is up to date (%s)", binary, installed)
+		return nil
+	}
+
+	util.Logger.Infof("Updating %s from %s to %s", binary, installed, latest)
+	src, _, err := plugins.ParseSource(entry.Source, plugins.SourceOptions{})
+	if err != nil {
+		return errorsutil.New(fmt.Sprintf("Invalid source recorded for %s", binary), err)
+	}
+	if _, ok := src.(*plugins.GithubSource); ok {
+		return plugins.InstallPlugin(fmt.Sprintf("%s@%s", entry.Source, latest), tokenName)
+	}
+	return plugins.Install(src, latest, plugins.InstallOptions{})
+}
+
+func newCmdPluginsOutdated() *cobra.Command {
+	var tokenName string
+	cmd := &cobra.Command{
+		Use:   "outdated",
+		Short: "List installed plugins that have a newer release available",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manifest, err := plugins.LoadManifest()
+			if err != nil {
+				return err
+			}
+			if len(manifest.Plugins) == 0 {
+				util.Logger.Warn("No plugins have been installed with 'eiam plugins install'")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+			fmt.Fprintln(w, "\nPLUGIN\tINSTALLED\tLATEST\tSOURCE")
+			outdated := 0
+			for _, binary := range manifest.Names() {
+				entry := manifest.Plugins[binary]
+				latest, err := plugins.LatestVersion(entry.Source, tokenName)
+				if err != nil {
+					util.Logger.WithError(err).Errorf("Failed to check %s for updates", binary)
+					continue
+				}
+				installed := installedVersion(binary, entry)
+				if latest != "" && plugins.IsNewer(latest, installed) {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", binary, installed, latest, entry.Source)
+					outdated++
+				}
+			}
+			if outdated == 0 {
+				util.Logger.Info("All installed plugins are up to date")
+				return nil
+			}
+			w.Flush()
+			fmt.Println()
+			return nil
+		},
 	}
 	cmd.Flags().StringVarP(&tokenName, "token", "t", "", "The name of the Github access token to use for private repos")
 	return cmd
 }
 
+func newCmdPluginsRollback() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollback PLUGIN_NAME",
+		Short: "Restore the previously installed version of a plugin",
+		Long: dedent.Dedent(`
+			The "plugins rollback" command swaps the installed binary of a plugin with the
+			one it replaced during the last install or update. Running it again restores
+			the newer version.`),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			binary, err := resolvePluginBinary(args[0])
+			if err != nil {
+				return err
+			}
+			entry, err := plugins.Rollback(binary)
+			if err != nil {
+				return errorsutil.New(fmt.Sprintf("Failed to roll back %s", binary), err)
+			}
+			util.Logger.Infof("Rolled back %s to %s", binary, entry.Version)
+			return nil
+		},
+	}
+	return cmd
+}
+
+func newCmdPluginsSync() *cobra.Command {
+	var (
+		file   string
+		dryRun bool
+	)
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Install, upgrade, and remove plugins to match a plugin manifest",
+		Long: dedent.Dedent(`
+			The "plugins sync" command makes the plugins directory match a plugin manifest,
+			usually a 'plugins.yaml' file checked into a shared repository:
+
+			  plugins:
+			    - name: eiam-plugin-example       # The file name of the plugin binary
+			      source: github.com/user/repo    # Any source accepted by 'eiam plugins install'
+			      version: v1.2.3                 # Optional, follows the latest release if omitted
+			      checksum: sha256:1f2e...        # Optional, the SHA-256 digest of the binary
+			      token: my-token                 # Optional, a Github token added with 'plugins auth'
+
+			Plugins that are missing are installed, plugins whose source, version, or checksum
+			differ are reinstalled, plugins without a version are upgraded when a newer release
+			is published, and plugins that are not listed are removed. Downloaded
+			binaries are only moved into the plugins directory if they match their checksum.
+
+			Use --dry-run to see the changes without making them.`),
+		Example: dedent.Dedent(`
+			eiam plugins sync -f plugins.yaml --dry-run
+			eiam plugins sync -f plugins.yaml -y`),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tm, err := plugins.LoadTeamManifest(file)
+			if err != nil {
+				return err
+			}
+			manifest, err := plugins.LoadManifest()
+			if err != nil {
+				return err
+			}
+			binaries, err := plugins.InstalledBinaries()
+			if err != nil {
+				return err
+			}
+			tm.ResolveLatest(cmd.Context())
+
+			actions := plugins.PlanSync(tm, manifest, binaries)
+			if len(actions) == 0 {
+				util.Logger.Info("Installed plugins already match the manifest")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+			fmt.Fprintln(w, "\nACTION\tPLUGIN\tFROM\tTO")
+			for _, a := range actions {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.Kind, a.Name, a.From, a.To)
+			}
+			fmt.Fprintln(w)
+			w.Flush()
+
+			if dryRun {
+				return nil
+			}
+			if !options.YesOption {
+				util.Confirm(map[string]string{"Manifest": file, "Changes": fmt.Sprint(len(actions))})
+			}
+			return plugins.ApplySync(actions)
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "plugins.yaml", "The plugin manifest to sync with")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the changes without making them")
+	return cmd
+}
+
+func newCmdPluginsDisable() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "disable PLUGIN_NAME",
+		Short: "Stop loading a plugin without removing it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			binary, err := resolvePluginBinary(args[0])
+			if err != nil {
+				return err
+			}
+			manifest, err := plugins.LoadManifest()
+			if err != nil {
+				return err
+			}
+			if _, ok := manifest.Disabled[binary]; ok {
+				util.Logger.Infof("%s is already disabled", args[0])
+				return nil
+			}
+
+			name := ""
+			for _, p := range RootCommand.Plugins {
+				if filepath.Base(p.Path) == binary {
+					name = p.Name
+				}
+			}
+			manifest.SetDisabled(binary, name, true)
+			if err := manifest.Save(); err != nil {
+				return err
+			}
+			util.Logger.Infof("Disabled %s", args[0])
+			return nil
+		},
+	}
+	return cmd
+}
+
+func newCmdPluginsEnable() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "enable PLUGIN_NAME",
+		Short: "Load a disabled or quarantined plugin again",
+		Long: dedent.Dedent(`
+			The "plugins enable" command re-enables a plugin that was disabled with
+			'eiam plugins disable'. It also lifts the quarantine of a plugin that failed to
+			load so that eiam tries to load it again on the next run.`),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manifest, err := plugins.LoadManifest()
+			if err != nil {
+				return err
+			}
+			binary, ok := manifest.FindBinary(args[0])
+			if !ok {
+				return argsError(fmt.Errorf("%s is not disabled or quarantined", args[0]))
+			}
+			_, disabled := manifest.Disabled[binary]
+			_, quarantined := manifest.Quarantined[binary]
+			if !disabled && !quarantined {
+				util.Logger.Infof("%s is already enabled", args[0])
+				return nil
+			}
+			manifest.SetDisabled(binary, "", false)
+			if err := manifest.Save(); err != nil {
+				return err
+			}
+			util.Logger.Infof("Enabled %s", args[0])
+			return nil
+		},
+	}
+	return cmd
+}
+
+// installedVersion returns the version recorded when a plugin was installed,
+// falling back to the version reported by the loaded plugin.
+func installedVersion(binary string, entry *plugins.ManifestEntry) string {
+	if entry.Version != "" {
+		return entry.Version
+	}
+	for _, p := range RootCommand.Plugins {
+		if filepath.Base(p.Path) == binary {
+			return p.Version
+		}
+	}
+	return ""
+}
+
+// resolvePluginBinary maps either the name of a loaded plugin or the file name
+// of a plugin binary to the file name of the binary.
+func resolvePluginBinary(name string) (string, error) {
+	for _, p := range allPlugins() {
+		if p.Name == name {
+			return filepath.Base(p.Path), nil
+		}
+	}
+	if plugins.IsReservedFile(name) || filepath.Base(name) != name {
+		return "", argsError(fmt.Errorf("%s is not a valid plugin name", name))
+	}
+	if _, err := os.Stat(filepath.Join(plugins.PluginDir(), name)); err != nil {
+		return "", argsError(fmt.Errorf("no plugin named %s is installed", name))
+	}
+	return name, nil
+}
+
 func newCmdPluginsRemove() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "remove",
@@ -125,10 +502,11 @@ func newCmdPluginsRemove() *cobra.Command {
 		Long: dedent.Dedent(`
 			The "plugins remove" command removes a currently installed plugin.
 			
-			You will be prompted to select the plugin to uninstall from the list of plugins loaded
-			by eiam. If no plugins are currently installed, a warning is shown.`),
+			You will be prompted to select the plugin to uninstall from the list of installed
+			plugins, including disabled and quarantined ones. If no plugins are currently
+			installed, a warning is shown.`),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if len(RootCommand.Plugins) == 0 {
+			if len(allPlugins()) == 0 {
 				util.Logger.Warn("No plugins are currently installed")
 				return nil
 			}
@@ -138,8 +516,8 @@ func newCmdPluginsRemove() *cobra.Command {
 				return err
 			}
 
-			if err := os.Remove(plugin.Path); err != nil {
-				return errorsutil.New(fmt.Sprintf("Failed to remove plugin file %s", plugin.Path), err)
+			if err := plugins.Uninstall(filepath.Base(plugin.Path)); err != nil {
+				return err
 			}
 			util.Logger.Infof("Successfully removed %s", plugin.Name)
 			return nil
@@ -148,7 +526,16 @@ func newCmdPluginsRemove() *cobra.Command {
 	return cmd
 }
 
+// allPlugins returns the loaded plugins followed by the disabled and
+// quarantined ones.
+func allPlugins() []*plugins.EphemeralIamPlugin {
+	all := make([]*plugins.EphemeralIamPlugin, 0, len(RootCommand.Plugins)+len(RootCommand.Inactive))
+	all = append(all, RootCommand.Plugins...)
+	return append(all, RootCommand.Inactive...)
+}
+
 func selectPlugin() (*plugins.EphemeralIamPlugin, error) {
+	installed := allPlugins()
 	templates := &promptui.SelectTemplates{
 		Label:    "{{ . }}",
 		Active:   " ►  {{ .Name | red }}",
@@ -157,12 +544,13 @@ func selectPlugin() (*plugins.EphemeralIamPlugin, error) {
 		Details: `
 --------- Plugin ----------
 {{ "Name:" | faint }}	{{ .Name }}
+{{ "Status:" | faint }}	{{ .Status }}
 {{ "Description:" | faint }}	{{ .Description }}`,
 	}
 
 	prompt := promptui.Select{
 		Label:     "Plugin to remove",
-		Items:     RootCommand.Plugins,
+		Items:     installed,
 		Templates: templates,
 	}
 
@@ -170,5 +558,5 @@ func selectPlugin() (*plugins.EphemeralIamPlugin, error) {
 	if err != nil {
 		return nil, errorsutil.New("Select-plugin prompt failed", err)
 	}
-	return RootCommand.Plugins[i], nil
+	return installed[i], nil
 }
@@ -2,7 +2,9 @@ package eiam
 
 import (
 	"fmt"
+	"os"
 	"regexp"
+	"text/tabwriter"
 
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
@@ -11,9 +13,13 @@ import (
 	"github.com/replit/ephemeral-iam/internal/appconfig"
 	util "github.com/replit/ephemeral-iam/internal/eiamutil"
 	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+	"github.com/replit/ephemeral-iam/internal/secrets"
 )
 
-var tokenName string
+var (
+	tokenName   string
+	tokenSource secrets.Source
+)
 
 func newCmdPluginsAuth() *cobra.Command {
 	cmd := &cobra.Command{
@@ -27,6 +33,10 @@ make authenticated calls to Github and manage the credentials that it uses.
 
 You can configure multiple tokens at once and designate which token to use by
 referencing the name that it was given when it was added (defaults to "default").
+
+Tokens are kept in an encrypted secrets file, not in the configuration file. A token
+can also be read from a command, such as a password manager, or from an environment
+variable each time it is used.
 		`,
 	}
 	cmd.AddCommand(newCmdPluginsAuthAdd())
@@ -36,12 +46,21 @@ referencing the name that it was given when it was added (defaults to "default")
 }
 
 func newCmdPluginsAuthAdd() *cobra.Command {
-	tokenConfig := viper.GetStringMapString(appconfig.GithubTokens)
 	cmd := &cobra.Command{
 		Use:   "add",
 		Short: "Add a Github personal access token to use to install plugins from private repositories",
+		Example: `
+eiam plugins auth add --name work
+eiam plugins auth add --name personal --command "pass show github"
+eiam plugins auth add --name ci --env GITHUB_TOKEN`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if tokenSource.Command != "" && tokenSource.Env != "" {
+				return argsError(fmt.Errorf("only one of --command and --env may be set"))
+			}
+			return nil
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if _, ok := tokenConfig[tokenName]; ok {
+			if _, ok := appconfig.GithubTokenSource(tokenName); ok {
 				util.Logger.Warnf("A token with the name %s already exists", tokenName)
 				prompt := promptui.Prompt{
 					Label:     fmt.Sprintf("Overwrite %s", tokenName),
@@ -54,39 +73,44 @@ func newCmdPluginsAuthAdd() *cobra.Command {
 				}
 			}
 			util.Logger.Infof("Adding token with the name %s", tokenName)
-			prompt := promptui.Prompt{
-				Label: "Enter your Github Personal Access Token: ",
-				Mask:  '●',
-				Validate: func(input string) error {
-					if len(input) != 40 {
-						return fmt.Errorf("incorrect input length: expected 40, got %d", len(input))
-					}
-					tokenRegex := regexp.MustCompile(`^(?:ghp_)?[[:alnum:]]{36}(?:[[:alnum:]]{4})?$`)
-					if !tokenRegex.MatchString(input) {
-						return fmt.Errorf("invalid input: input was not a valid personal access token")
-					}
-					return nil
-				},
-			}
 
-			token, err := prompt.Run()
-			if err != nil {
-				return errorsutil.New("User input failed", err)
-			}
+			var token string
+			if tokenSource.IsZero() {
+				prompt := promptui.Prompt{
+					Label: "Enter your Github Personal Access Token: ",
+					Mask:  '●',
+					Validate: func(input string) error {
+						if len(input) != 40 {
+							return fmt.Errorf("incorrect input length: expected 40, got %d", len(input))
+						}
+						tokenRegex := regexp.MustCompile(`^(?:ghp_)?[[:alnum:]]{36}(?:[[:alnum:]]{4})?$`)
+						if !tokenRegex.MatchString(input) {
+							return fmt.Errorf("invalid input: input was not a valid personal access token")
+						}
+						return nil
+					},
+				}
 
-			tokenConfig[tokenName] = token
-			viper.Set(appconfig.GithubTokens, tokenConfig)
-			if !viper.GetBool(appconfig.GithubAuth) {
-				viper.Set(appconfig.GithubAuth, true)
+				var err error
+				if token, err = prompt.Run(); err != nil {
+					return errorsutil.New("User input failed", err)
+				}
+			} else if _, err := secrets.Resolve(nil, "", tokenSource); err != nil {
+				util.Logger.WithError(err).Warnf("The token could not be read from its %s", tokenSource)
 			}
 
-			if err := viper.WriteConfig(); err != nil {
+			if err := appconfig.AddGithubToken(tokenName, token, tokenSource); err != nil {
+				return errorsutil.New("Failed to save access token", err)
+			}
+			if err := appconfig.WriteConfig(); err != nil {
 				return errorsutil.New("Failed to write updated configuration", err)
 			}
 			return nil
 		},
 	}
 	cmd.Flags().StringVarP(&tokenName, "name", "n", "default", "The name associated with the target access token")
+	cmd.Flags().StringVar(&tokenSource.Command, "command", "", "A command that prints the token, instead of storing it")
+	cmd.Flags().StringVar(&tokenSource.Env, "env", "", "An environment variable that holds the token, instead of storing it")
 	return cmd
 }
 
@@ -99,31 +123,32 @@ func newCmdPluginsAuthList() *cobra.Command {
 				util.Logger.Warn("No Github credentials are currently configured.")
 				return nil
 			}
-			fmt.Println("\n GITHUB ACCESS TOKENS\n----------------------")
-			for tokenName := range viper.GetStringMapString(appconfig.GithubTokens) {
-				fmt.Println(tokenName)
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+			fmt.Fprintln(w, "\nNAME\tSOURCE")
+			for _, name := range appconfig.GithubTokenNames() {
+				src, _ := appconfig.GithubTokenSource(name)
+				fmt.Fprintf(w, "%s\t%s\n", name, src)
 			}
-			fmt.Printf("\n")
-			return nil
+			fmt.Fprintln(w)
+			return w.Flush()
 		},
 	}
 	return cmd
 }
 
 func newCmdPluginsAuthDelete() *cobra.Command {
-	tokenConfig := viper.GetStringMapString(appconfig.GithubTokens)
 	cmd := &cobra.Command{
 		Use:   "delete",
 		Short: "Delete an existing Github personal access token from the config",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if tokenName == "" {
-				tn, err := util.SelectToken(tokenConfig)
+				tn, err := util.SelectToken(appconfig.GithubTokenNames())
 				if err != nil {
 					return errorsutil.New("Failed to select access token", err)
 				}
 				tokenName = tn
 			}
-			if _, ok := tokenConfig[tokenName]; !ok {
+			if _, ok := appconfig.GithubTokenSource(tokenName); !ok {
 				err := fmt.Errorf("no token with the name %s exists in the config", tokenName)
 				return errorsutil.New("Failed to delete token from config", err)
 			}
@@ -138,14 +163,10 @@ func newCmdPluginsAuthDelete() *cobra.Command {
 				return nil
 			}
 
-			delete(tokenConfig, tokenName)
-			viper.Set(appconfig.GithubTokens, tokenConfig)
-
-			if len(tokenConfig) == 0 {
-				viper.Set(appconfig.GithubAuth, false)
+			if err := appconfig.DeleteGithubToken(tokenName); err != nil {
+				return errorsutil.New("Failed to delete token from the secrets file", err)
 			}
-
-			if err := viper.WriteConfig(); err != nil {
+			if err := appconfig.WriteConfig(); err != nil {
 				return errorsutil.New("Failed to write updated configuration", err)
 			}
 			util.Logger.Infof("%s was successfully deleted", tokenName)
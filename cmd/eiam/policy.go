@@ -0,0 +1,133 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eiam
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/replit/ephemeral-iam/internal/access"
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+)
+
+var (
+	policyTestFile    string
+	policyTestRequest access.Request
+	policyTestAt      string
+)
+
+// policyTestTimeLayout is the format of the --at flag.
+const policyTestTimeLayout = "2006-01-02T15:04"
+
+func newCmdPolicy() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Inspect the access policy",
+		Long: dedent.Dedent(`
+			The access policy in the access.policyfile setting decides who may use which service
+			accounts, with which commands, when, and for how long. It is evaluated before eiam
+			generates a token. See docs/access for the format of the policy file.`),
+	}
+	cmd.AddCommand(newCmdPolicyTest())
+	return cmd
+}
+
+func newCmdPolicyTest() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "test",
+		Short: "Evaluate a hypothetical request against the access policy",
+		Long: dedent.Dedent(`
+			The "policy test" command evaluates a request against the access policy and explains
+			which rule decided it. The user defaults to your account and the time to now. The
+			command fails if the request is denied.`),
+		Example: dedent.Dedent(`
+			eiam policy test -s admin@prod.iam.gserviceaccount.com -p prod --command gcloud
+			eiam policy test -s admin@prod.iam.gserviceaccount.com --user jane@example.com \
+			  --at 2021-06-05T23:00 --duration 1h --file ./access-policy.yml`),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file := policyTestFile
+			if file == "" {
+				if file = viper.GetString(appconfig.AccessPolicyFile); file == "" {
+					return fmt.Errorf("no access policy is configured, set %s or use --file", appconfig.AccessPolicyFile)
+				}
+			}
+			policy, err := access.Load(file)
+			if err != nil {
+				return err
+			}
+
+			req := policyTestRequest
+			if req.User == "" {
+				if req.User, err = appconfig.Identity(); err != nil {
+					return err
+				}
+			}
+			req.Time = time.Now()
+			if policyTestAt != "" {
+				if req.Time, err = time.ParseInLocation(policyTestTimeLayout, policyTestAt, time.Local); err != nil {
+					return argsError(fmt.Errorf("--at must be a time such as 2021-06-01T15:04: %v", err))
+				}
+			}
+
+			decision := policy.Evaluate(req)
+			for _, line := range decision.Trace {
+				fmt.Println(line)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(w, "\nUser:\t%s\n", req.User)
+			fmt.Fprintf(w, "Service account:\t%s\n", req.ServiceAccount)
+			fmt.Fprintf(w, "Project:\t%s\n", req.Project)
+			fmt.Fprintf(w, "Command:\t%s\n", req.Command)
+			fmt.Fprintf(w, "Time:\t%s\n", req.Time.Format(time.RFC1123))
+			fmt.Fprintf(w, "Decision:\t%s\n", decision.Explain())
+			if decision.Allowed {
+				if decision.MaxDuration > 0 {
+					fmt.Fprintf(w, "Max duration:\t%v\n", decision.MaxDuration)
+				}
+				fmt.Fprintf(w, "Read-only:\t%t\n", decision.ReadOnly)
+				if decision.TicketPattern != "" {
+					fmt.Fprintf(w, "Ticket pattern:\t%s\n", decision.TicketPattern)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			if !decision.Allowed {
+				return errors.New("the request is denied")
+			}
+			if decision.MaxDuration > 0 && req.Duration > decision.MaxDuration {
+				return fmt.Errorf("the request is denied because its duration exceeds %v", decision.MaxDuration)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&policyTestFile, "file", "", "The access policy file to test. Defaults to the access.policyfile setting")
+	cmd.Flags().StringVar(&policyTestRequest.User, "user", "", "The user making the request. Defaults to your account")
+	cmd.Flags().StringVarP(&policyTestRequest.ServiceAccount, "service-account-email", "s", "", "The service account to use")
+	cmd.Flags().StringVarP(&policyTestRequest.Project, "project", "p", "", "The GCP project")
+	cmd.Flags().StringVar(&policyTestRequest.Command, "command", access.CommandAssumePrivileges, "The eiam command")
+	cmd.Flags().StringVar(&policyTestAt, "at", "", "The local time of the request, such as 2021-06-01T15:04. Defaults to now")
+	cmd.Flags().DurationVarP(&policyTestRequest.Duration, "duration", "d", 0, "The token duration of the request")
+	return cmd
+}
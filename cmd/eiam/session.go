@@ -0,0 +1,124 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eiam
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+
+	"github.com/replit/ephemeral-iam/internal/gcpclient"
+	"github.com/replit/ephemeral-iam/internal/proxy"
+	"github.com/replit/ephemeral-iam/pkg/options"
+)
+
+var (
+	sessionSeconds  bool
+	sessionDuration time.Duration
+)
+
+// errNoSession is returned by the session commands outside of the sub-shell
+// of a privileged session.
+var errNoSession = errors.New("not in the sub-shell of a privileged session, run `eiam assume-privileges` first")
+
+func newCmdSession() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "session",
+		Short: "Show and extend the privileged session of the current sub-shell",
+		Long: dedent.Dedent(`
+			The "session" commands are run in the sub-shell of "eiam assume-privileges". The prompt
+			of the sub-shell already shows the time left in the session.`),
+	}
+	cmd.AddCommand(newCmdSessionRemaining())
+	cmd.AddCommand(newCmdSessionExtend())
+	return cmd
+}
+
+func newCmdSessionRemaining() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remaining",
+		Short: "Print the time left in the privileged session",
+		Long: dedent.Dedent(`
+			The "session remaining" command prints the time left in the privileged session, such as
+			"1h05m" or "expired", for use in custom prompts. With --seconds it prints the number of
+			seconds instead.`),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sessionFile := os.Getenv("EIAM_SESSION_FILE")
+			if sessionFile == "" {
+				return errNoSession
+			}
+			expiry, err := proxy.ReadSessionExpiry(sessionFile)
+			if err != nil {
+				return err
+			}
+			remaining := time.Until(expiry)
+			if sessionSeconds {
+				fmt.Println(max(int64(remaining.Seconds()), 0))
+			} else {
+				fmt.Println(proxy.FormatRemaining(remaining))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&sessionSeconds, "seconds", false, "Print the number of seconds left")
+	return cmd
+}
+
+func newCmdSessionExtend() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "extend",
+		Short: "Replace the token of the privileged session with one that lasts longer",
+		Long: dedent.Dedent(`
+			The "session extend" command generates a new token for the privileged session that
+			lasts for the given duration from now, like the token of a new session would. The auth
+			proxy and kubectl use it right away. GOOGLE_OAUTH_ACCESS_TOKEN keeps the token the
+			sub-shell started with, so tools that read it need a new sub-shell.
+
+			Like the session itself, the new token is subject to the tokens.maxduration setting,
+			the access policy, approvals and hooks.`),
+		Example: dedent.Dedent(`
+			eiam session extend --duration 30m`),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			socket := os.Getenv("EIAM_SESSION_SOCKET")
+			if socket == "" {
+				return errNoSession
+			}
+			if err := options.CheckTokenDuration(sessionDuration); err != nil {
+				return argsError(err)
+			}
+			expiry, err := proxy.ExtendSession(socket, sessionDuration)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("The privileged session now lasts until %s (%s)\n",
+				expiry.Local().Format(time.RFC1123), proxy.FormatRemaining(time.Until(expiry)))
+			return nil
+		},
+	}
+	cmd.Flags().DurationVarP(
+		&sessionDuration,
+		options.TokenDurationFlag.Name,
+		options.TokenDurationFlag.Shorthand,
+		gcpclient.DefaultTokenDuration,
+		"How long the new token lasts from now",
+	)
+	return cmd
+}
@@ -16,11 +16,10 @@ package main
 
 import (
 	"errors"
-	"math/rand"
-	"time"
 
-	"github.com/hashicorp/go-hclog"
 	"github.com/spf13/cobra"
+
+	eiamplugin "github.com/replit/ephemeral-iam/pkg/plugins"
 )
 
 const (
@@ -29,39 +28,29 @@ const (
 	version = "v0.0.1"
 )
 
-// BasicPlugin is the implementation of the ephemeral-iam plugin interface.
-type BasicPlugin struct {
-	// Logger is the logger for the plugin to use to send log entries to eiam
-	// to be formatted and output to the user.
-	Logger hclog.Logger
-}
-
-// GetInfo is the function that eiam invokes to get metadata about the plugin.
-func (p *BasicPlugin) GetInfo() (n, d, v string, err error) {
-	return name, desc, version, nil
-}
+// logger sends log entries to eiam to be formatted and output to the user.
+var logger = eiamplugin.NewLogger(name)
 
-// Run is the function that eiam uses to invoke the plugin command.
-func (p *BasicPlugin) Run() error {
-	rootCmd := newRootCmd(p)
-	return rootCmd.Execute()
+func main() {
+	eiamplugin.Serve(eiamplugin.NewCobraPlugin(newRootCmd(), version))
 }
 
-func newRootCmd(p *BasicPlugin) *cobra.Command {
+func newRootCmd() *cobra.Command {
+	var fail bool
 	cmd := &cobra.Command{
 		Use:   name,
 		Short: desc,
 		// Plugins should use the RunE/PreRunE fields and return their errors
 		// to be handled by eiam.
 		RunE: func(cmd *cobra.Command, args []string) error {
-			p.Logger.Info("This is printed in the same format as other `eiam` INFO logs")
-			p.Logger.Error("This is an error message")
-			rand.Seed(time.Now().UnixNano())
-			if rand.Intn(2) == 1 {
+			logger.Info("This is printed in the same format as other `eiam` INFO logs")
+			logger.Error("This is an error message")
+			if fail {
 				return errors.New("this is an example error returned to eiam")
 			}
 			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&fail, "fail", false, "Return an example error")
 	return cmd
 }
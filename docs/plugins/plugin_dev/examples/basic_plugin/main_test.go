@@ -0,0 +1,42 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	eiamplugin "github.com/replit/ephemeral-iam/pkg/plugins"
+	"github.com/replit/ephemeral-iam/pkg/plugins/plugintest"
+)
+
+func TestBasicPlugin(t *testing.T) {
+	h := plugintest.New(t, eiamplugin.NewCobraPlugin(newRootCmd(), version))
+	if h.Name != name {
+		t.Errorf("expected the plugin to be named %s, got %s", name, h.Name)
+	}
+
+	res := h.Run()
+	if res.Err != nil {
+		t.Fatalf("unexpected error: %v", res.Err)
+	}
+	if len(res.Logs) != 2 || res.Logs[1].Level != "error" {
+		t.Errorf("expected an info and an error log entry, got %+v", res.Logs)
+	}
+
+	res = h.Run("--fail")
+	if res.Err == nil || res.Err.Error() != "this is an example error returned to eiam" {
+		t.Errorf("expected the example error, got %v", res.Err)
+	}
+}
@@ -15,10 +15,10 @@
 package main // All plugins must have a main package.
 
 import (
-	"github.com/hashicorp/go-hclog"
 	"github.com/spf13/cobra"
 
 	"github.com/replit/ephemeral-iam/pkg/options"
+	eiamplugin "github.com/replit/ephemeral-iam/pkg/plugins"
 )
 
 const (
@@ -31,27 +31,16 @@ var (
 	project string
 	bucket  string
 	verbose bool
-)
-
-// BasicPlugin is the implementation of the ephemeral-iam plugin interface.
-type BasicPlugin struct {
-	// Logger is the logger for the plugin to use to send log entries to eiam
-	// to be formatted and output to the user.
-	Logger hclog.Logger
-}
 
-// GetInfo is the function that eiam invokes to get metadata about the plugin.
-func (p *BasicPlugin) GetInfo() (n, d, v string, err error) {
-	return name, desc, version, nil
-}
+	// logger sends log entries to eiam to be formatted and output to the user.
+	logger = eiamplugin.NewLogger(name)
+)
 
-// Run is the function that eiam uses to invoke the plugin command.
-func (p *BasicPlugin) Run() error {
-	rootCmd := newRootCmd(p)
-	return rootCmd.Execute()
+func main() {
+	eiamplugin.Serve(eiamplugin.NewCobraPlugin(newRootCmd(), version))
 }
 
-func newRootCmd(p *BasicPlugin) *cobra.Command {
+func newRootCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   name,
 		Short: desc,
@@ -61,9 +50,9 @@ func newRootCmd(p *BasicPlugin) *cobra.Command {
 			return options.CheckRequired(cmd.Flags())
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			p.Logger.Info("The project field defaults to the value in the user's gcloud config", "project", project)
+			logger.Info("The project field defaults to the value in the user's gcloud config", "project", project)
 			if verbose {
-				p.Logger.Info("Verbose logging is enabled")
+				logger.Info("Verbose logging is enabled")
 			}
 			return nil
 		},
@@ -15,54 +15,45 @@
 package main
 
 import (
-	"github.com/hashicorp/go-hclog"
 	"github.com/spf13/cobra"
 
 	"github.com/replit/ephemeral-iam/pkg/options"
+	eiamplugin "github.com/replit/ephemeral-iam/pkg/plugins"
 )
 
 const (
-	name    = "basic-plugin"
-	desc    = "An example of a basic eiam plugin command"
+	name    = "subcommands-plugin"
+	desc    = "An example of an eiam plugin command with subcommands"
 	version = "v0.0.1"
 )
 
-var project string
+var (
+	project string
 
-// BasicPlugin is the implementation of the ephemeral-iam plugin interface.
-type BasicPlugin struct {
-	// Logger is the logger for the plugin to use to send log entries to eiam
-	// to be formatted and output to the user.
-	Logger hclog.Logger
-}
-
-// GetInfo is the function that eiam invokes to get metadata about the plugin.
-func (p *BasicPlugin) GetInfo() (n, d, v string, err error) {
-	return name, desc, version, nil
-}
+	// logger sends log entries to eiam to be formatted and output to the user.
+	logger = eiamplugin.NewLogger(name)
+)
 
-// Run is the function that eiam uses to invoke the plugin command.
-func (p *BasicPlugin) Run() error {
-	rootCmd := newRootCmd(p)
-	return rootCmd.Execute()
+func main() {
+	eiamplugin.Serve(eiamplugin.NewCobraPlugin(newRootCmd(), version))
 }
 
-func newRootCmd(p *BasicPlugin) *cobra.Command {
+func newRootCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   name,
 		Short: desc,
 	}
-	cmd.AddCommand(newCmdExampleSubcommand(p))
-	cmd.AddCommand(newCmdAnotherSubcommand(p))
+	cmd.AddCommand(newCmdExampleSubcommand())
+	cmd.AddCommand(newCmdAnotherSubcommand())
 	return cmd
 }
 
-func newCmdExampleSubcommand(p *BasicPlugin) *cobra.Command {
+func newCmdExampleSubcommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "example-subcommand",
 		Short: "This is a subcommand of the plugin",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			p.Logger.Info("We can access the current user's project even if the flag isn't provided", "project", project)
+			logger.Info("We can access the current user's project even if the flag isn't provided", "project", project)
 			return nil
 		},
 	}
@@ -70,12 +61,12 @@ func newCmdExampleSubcommand(p *BasicPlugin) *cobra.Command {
 	return cmd
 }
 
-func newCmdAnotherSubcommand(p *BasicPlugin) *cobra.Command {
+func newCmdAnotherSubcommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "another-subcommand",
 		Short: "This is another subcommand of the plugin",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			p.Logger.Info("The user's project is empty because the flag was not explicitly added to it", "project", project)
+			logger.Info("The user's project is empty because the flag was not explicitly added to it", "project", project)
 			return nil
 		},
 	}
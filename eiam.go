@@ -18,6 +18,7 @@ import (
 	"github.com/replit/ephemeral-iam/cmd/eiam"                   //nolint: depguard
 	"github.com/replit/ephemeral-iam/internal/appconfig"         //nolint: depguard
 	errorsutil "github.com/replit/ephemeral-iam/internal/errors" //nolint: depguard
+	"github.com/replit/ephemeral-iam/internal/hooks"             //nolint: depguard
 )
 
 func main() {
@@ -31,11 +32,17 @@ func main() {
 	rootCmd, err := eiam.NewEphemeralIamCommand()
 	errorsutil.CheckError(err)
 
+	err = rootCmd.Execute()
+
+	// Give hooks running in the background a chance to finish before the
+	// plugins are killed.
+	hooks.Wait()
+
 	// Kill the loaded plugin clients. This is happening here to ensure that
-	// Kill is called after the command has finished running, but also accounts
-	// for any errors that occur during execution.
+	// Kill is called after the command has finished running, but before
+	// CheckError exits if an error occurred during execution.
 	for _, plugin := range rootCmd.Plugins {
-		defer plugin.Client.Kill() //nolint: gocritic
+		plugin.Client.Kill()
 	}
-	errorsutil.CheckError(rootCmd.Execute())
+	errorsutil.CheckError(err)
 }
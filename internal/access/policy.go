@@ -0,0 +1,326 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package access evaluates the local access policy, which decides who may
+// escalate to which service account, when, and for how long, before eiam
+// generates a token.
+package access
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+// Effect is the outcome of a rule.
+type Effect string
+
+// The effects of a rule.
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// The commands that the access policy applies to.
+const (
+	CommandAssumePrivileges = "assume-privileges"
+	CommandCloudSQLProxy    = "cloud_sql_proxy"
+	CommandGcloud           = "gcloud"
+	CommandGrant            = "grant"
+	CommandKubectl          = "kubectl"
+)
+
+// Commands are the commands that the access policy applies to.
+var Commands = []string{CommandAssumePrivileges, CommandCloudSQLProxy, CommandGcloud, CommandGrant, CommandKubectl}
+
+var days = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Policy is the access policy file. Its rules are evaluated in order and the
+// first one that matches a request decides it. Requests that no rule matches
+// are decided by Default, which is deny if it is not set.
+type Policy struct {
+	Default Effect `yaml:"default"`
+	Rules   []Rule `yaml:"rules"`
+}
+
+// Rule matches requests by the user, service account, project, command and
+// time. Users, service accounts and projects are glob patterns, and a list
+// that is empty matches everything.
+type Rule struct {
+	Name            string   `yaml:"name"`
+	Effect          Effect   `yaml:"effect"`
+	Users           []string `yaml:"users"`
+	ServiceAccounts []string `yaml:"serviceaccounts"`
+	Projects        []string `yaml:"projects"`
+	Commands        []string `yaml:"commands"`
+	// Days are the days of the week, such as 'mon', on which the rule applies.
+	Days []string `yaml:"days"`
+	// Hours is the time of day at which the rule applies, such as
+	// '09:00-17:00'. The range may span midnight.
+	Hours string `yaml:"hours"`
+	// TimeZone is the location of Days and Hours. It defaults to the local
+	// time zone.
+	TimeZone string `yaml:"timezone"`
+
+	// The limits of the requests that the rule allows.
+	MaxDuration   time.Duration `yaml:"maxduration"`
+	ReadOnly      bool          `yaml:"readonly"`
+	TicketPattern string        `yaml:"ticketpattern"`
+	// Message is shown when the rule denies a request.
+	Message string `yaml:"message"`
+
+	location           *time.Location
+	startHour, endHour int
+}
+
+// Request is a request to escalate privileges.
+type Request struct {
+	User           string
+	ServiceAccount string
+	Project        string
+	Command        string
+	Time           time.Time
+	Duration       time.Duration
+}
+
+// Decision is the result of evaluating a request.
+type Decision struct {
+	Allowed bool
+	// Rule is the rule that matched and Index is its position in the policy,
+	// or nil and -1 if the request was decided by the default effect.
+	Rule  *Rule
+	Index int
+
+	MaxDuration   time.Duration
+	ReadOnly      bool
+	TicketPattern string
+
+	// Trace explains why each rule before the matching one did not match.
+	Trace []string
+}
+
+// Load reads and validates an access policy file.
+func Load(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errorsutil.New("Failed to read access policy file", err)
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, errorsutil.New(fmt.Sprintf("Invalid access policy file %s", file), err)
+	}
+	return p, nil
+}
+
+// Parse decodes and validates an access policy.
+func Parse(data []byte) (*Policy, error) {
+	p := &Policy{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	switch p.Default {
+	case "":
+		p.Default = Deny
+	case Allow, Deny:
+	default:
+		return nil, fmt.Errorf("default must be allow or deny, not %q", p.Default)
+	}
+	for i := range p.Rules {
+		if err := p.Rules[i].init(); err != nil {
+			return nil, fmt.Errorf("rule %s: %v", p.Rules[i].label(i), err)
+		}
+	}
+	return p, nil
+}
+
+func (r *Rule) init() error {
+	switch r.Effect {
+	case "":
+		r.Effect = Allow
+	case Allow, Deny:
+	default:
+		return fmt.Errorf("effect must be allow or deny, not %q", r.Effect)
+	}
+	for _, command := range r.Commands {
+		if !contains(Commands, command) {
+			return fmt.Errorf("invalid command %q, must be one of %v", command, Commands)
+		}
+	}
+	for _, day := range r.Days {
+		if !contains(days, strings.ToLower(day)) {
+			return fmt.Errorf("invalid day %q, must be one of %v", day, days)
+		}
+	}
+	r.location = time.Local
+	if r.TimeZone != "" {
+		loc, err := time.LoadLocation(r.TimeZone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone: %v", err)
+		}
+		r.location = loc
+	}
+	if r.Hours != "" {
+		start, end, ok := strings.Cut(r.Hours, "-")
+		var err error
+		if !ok {
+			return fmt.Errorf("hours must be a range such as 09:00-17:00, not %q", r.Hours)
+		}
+		if r.startHour, err = parseClock(start); err != nil {
+			return err
+		}
+		if r.endHour, err = parseClock(end); err != nil {
+			return err
+		}
+	}
+	if r.TicketPattern != "" {
+		if _, err := regexp.Compile(r.TicketPattern); err != nil {
+			return fmt.Errorf("invalid ticketpattern: %v", err)
+		}
+	}
+	for _, patterns := range [][]string{r.Users, r.ServiceAccounts, r.Projects} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock returns the minutes since midnight of a time such as '09:30'.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, must be HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// label names a rule in messages.
+func (r *Rule) label(i int) string {
+	if r.Name != "" {
+		return fmt.Sprintf("%d (%s)", i+1, r.Name)
+	}
+	return fmt.Sprint(i + 1)
+}
+
+// Evaluate decides a request.
+func (p *Policy) Evaluate(req Request) Decision {
+	d := Decision{Index: -1}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if why := r.mismatch(req); why != "" {
+			d.Trace = append(d.Trace, fmt.Sprintf("rule %s does not match: %s", r.label(i), why))
+			continue
+		}
+		d.Rule, d.Index = r, i
+		d.Allowed = r.Effect == Allow
+		if d.Allowed {
+			d.MaxDuration, d.ReadOnly, d.TicketPattern = r.MaxDuration, r.ReadOnly, r.TicketPattern
+		}
+		return d
+	}
+	d.Allowed = p.Default == Allow
+	return d
+}
+
+// mismatch returns why a rule does not match a request, or an empty string
+// if it does.
+func (r *Rule) mismatch(req Request) string {
+	switch {
+	case !matchAny(r.Users, req.User):
+		return fmt.Sprintf("user %s is not one of %v", req.User, r.Users)
+	case !matchAny(r.ServiceAccounts, req.ServiceAccount):
+		return fmt.Sprintf("service account %s is not one of %v", req.ServiceAccount, r.ServiceAccounts)
+	case !matchAny(r.Projects, req.Project):
+		return fmt.Sprintf("project %s is not one of %v", req.Project, r.Projects)
+	case len(r.Commands) > 0 && !contains(r.Commands, req.Command):
+		return fmt.Sprintf("command %s is not one of %v", req.Command, r.Commands)
+	}
+
+	t := req.Time.In(r.location)
+	day := days[t.Weekday()]
+	if len(r.Days) > 0 && !containsFold(r.Days, day) {
+		return fmt.Sprintf("%s is not one of %v", day, r.Days)
+	}
+	if r.Hours != "" {
+		minute := t.Hour()*60 + t.Minute()
+		in := minute >= r.startHour && minute < r.endHour
+		if r.startHour > r.endHour {
+			in = minute >= r.startHour || minute < r.endHour
+		}
+		if !in {
+			return fmt.Sprintf("%s is outside of %s", t.Format("15:04 MST"), r.Hours)
+		}
+	}
+	return ""
+}
+
+// Explain describes the decision for 'eiam policy test' and error messages.
+func (d Decision) Explain() string {
+	effect := "denied"
+	if d.Allowed {
+		effect = "allowed"
+	}
+	if d.Rule == nil {
+		return fmt.Sprintf("%s by the default of the access policy because no rule matched", effect)
+	}
+	msg := fmt.Sprintf("%s by rule %s", effect, d.Rule.label(d.Index))
+	if !d.Allowed && d.Rule.Message != "" {
+		msg += ": " + d.Rule.Message
+	}
+	return msg
+}
+
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package access
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPolicy = `
+rules:
+  - name: no-prod-on-weekends
+    effect: deny
+    serviceaccounts: ["*@prod.iam.gserviceaccount.com"]
+    days: [sat, sun]
+    timezone: UTC
+    message: Page the on-call engineer instead
+  - name: oncall-prod
+    users: ["*@example.com"]
+    serviceaccounts: ["*@prod.iam.gserviceaccount.com"]
+    commands: [gcloud, kubectl]
+    hours: "22:00-06:00"
+    timezone: UTC
+    maxduration: 30m
+    ticketpattern: 'INC-[0-9]+'
+  - name: readers
+    users: ["*@example.com"]
+    projects: ["prod-*"]
+    readonly: true
+`
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	// 2021-06-02 is a Wednesday and 2021-06-05 is a Saturday.
+	wednesdayNight := time.Date(2021, 6, 2, 23, 30, 0, 0, time.UTC)
+	wednesdayNoon := time.Date(2021, 6, 2, 12, 0, 0, 0, time.UTC)
+	saturday := time.Date(2021, 6, 5, 23, 30, 0, 0, time.UTC)
+	prodSA := "admin@prod.iam.gserviceaccount.com"
+
+	tests := []struct {
+		name    string
+		req     Request
+		allowed bool
+		index   int
+		explain string
+	}{
+		{
+			name:    "deny rule",
+			req:     Request{User: "a@example.com", ServiceAccount: prodSA, Command: CommandGcloud, Time: saturday},
+			index:   0,
+			explain: "denied by rule 1 (no-prod-on-weekends): Page the on-call engineer instead",
+		},
+		{
+			name:    "hours spanning midnight",
+			req:     Request{User: "a@example.com", ServiceAccount: prodSA, Command: CommandGcloud, Time: wednesdayNight},
+			allowed: true,
+			index:   1,
+			explain: "allowed by rule 2 (oncall-prod)",
+		},
+		{
+			name:    "read-only fallback",
+			req:     Request{User: "a@example.com", ServiceAccount: prodSA, Project: "prod-db", Command: CommandGcloud, Time: wednesdayNoon},
+			allowed: true,
+			index:   2,
+		},
+		{
+			name:    "default deny",
+			req:     Request{User: "a@other.com", ServiceAccount: prodSA, Command: CommandKubectl, Time: wednesdayNight},
+			index:   -1,
+			explain: "denied by the default of the access policy because no rule matched",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := p.Evaluate(tt.req)
+			if d.Allowed != tt.allowed || d.Index != tt.index {
+				t.Fatalf("Evaluate() = allowed %t by rule %d, want %t by %d\n%s", d.Allowed, d.Index, tt.allowed, tt.index, strings.Join(d.Trace, "\n"))
+			}
+			if tt.explain != "" && d.Explain() != tt.explain {
+				t.Errorf("Explain() = %q, want %q", d.Explain(), tt.explain)
+			}
+		})
+	}
+
+	d := p.Evaluate(Request{User: "a@example.com", ServiceAccount: prodSA, Command: CommandGcloud, Time: wednesdayNight})
+	if d.MaxDuration != 30*time.Minute || d.TicketPattern != "INC-[0-9]+" || d.ReadOnly {
+		t.Errorf("limits of rule 2 = %v, %q, %t", d.MaxDuration, d.TicketPattern, d.ReadOnly)
+	}
+	d = p.Evaluate(Request{User: "a@example.com", ServiceAccount: prodSA, Project: "prod-db", Command: CommandKubectl, Time: wednesdayNoon})
+	if !d.ReadOnly {
+		t.Error("expected rule 3 to be read-only")
+	}
+	if len(d.Trace) != 2 || !strings.Contains(d.Trace[1], "12:00 UTC is outside of 22:00-06:00") {
+		t.Errorf("Trace = %q", d.Trace)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		policy, err string
+	}{
+		{"default: maybe", `default must be allow or deny`},
+		{"rules:\n  - effect: permit", `rule 1: effect must be allow or deny`},
+		{"rules:\n  - name: x\n    days: [someday]", `rule 1 (x): invalid day "someday"`},
+		{"rules:\n  - hours: 9-17", `rule 1: invalid time "9"`},
+		{"rules:\n  - commands: [bash]", `rule 1: invalid command "bash"`},
+		{"rules:\n  - maxduration: forever", `into time.Duration`},
+		{"rules:\n  - user: [a]", `field user not found`},
+	}
+	for _, tt := range tests {
+		if _, err := Parse([]byte(tt.policy)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.policy, err, tt.err)
+		}
+	}
+}
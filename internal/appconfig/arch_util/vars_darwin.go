@@ -23,4 +23,7 @@ const (
 	// ConfigPath is the path relative to the users home directory to store the
 	// ephemeral-iam config.
 	ConfigPath = "/Library/Application Support/ephemeral-iam"
+	// PolicyPath is the path of the policy file that an organization can use to
+	// manage the configuration of all users of a machine.
+	PolicyPath = "/Library/Application Support/ephemeral-iam/policy.yml"
 )
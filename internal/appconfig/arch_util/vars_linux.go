@@ -24,4 +24,7 @@ const (
 	// ConfigPath is the path relative to the users home directory to store the
 	// ephemeral-iam config.
 	ConfigPath = ".config/ephemeral-iam"
+	// PolicyPath is the path of the policy file that an organization can use to
+	// manage the configuration of all users of a machine.
+	PolicyPath = "/etc/ephemeral-iam/policy.yml"
 )
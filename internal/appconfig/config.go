@@ -37,16 +37,62 @@ const (
 	AuthProxyLogDir        = "authproxy.logdir"
 	AuthProxyCertFile      = "authproxy.certfile"
 	AuthProxyKeyFile       = "authproxy.keyfile"
+	AuthProxyAllowedHosts  = "authproxy.allowedhosts"
+	AccessPolicyFile       = "access.policyfile"
+	AllowedServiceAccounts = "access.allowedserviceaccounts"
+	ApprovalsPollInterval  = "approvals.pollinterval"
+	ApprovalsTimeout       = "approvals.timeout"
+	ApprovalsToken         = "approvals.token" //nolint:gosec // Not hardcoded credentials
+	ApprovalsTokenCommand  = "approvals.tokencommand"
+	ApprovalsTokenEnv      = "approvals.tokenenv"
+	ApprovalsURL           = "approvals.url"
+	ApprovalsAccounts      = "approvals.serviceaccounts"
 	DefaultServiceAccounts = "serviceaccounts"
 	CloudSQLProxyPath      = "binarypaths.cloudsqlproxy"
 	GcloudPath             = "binarypaths.gcloud"
 	KubectlPath            = "binarypaths.kubectl"
+	AuditEndpoint          = "audit.endpoint"
 	GithubAuth             = "github.auth"
 	GithubTokens           = "github.tokens" //nolint:gosec // Not hardcoded credentials
+	ActiveProfile          = "activeprofile"
+	ConfigVersion          = "configversion"
+	DefaultProject         = "defaults.project"
+	Profiles               = "profiles"
+	Hooks                  = "hooks"
+	HistoryEnabled         = "history.enabled"
 	LoggingFormat          = "logging.format"
 	LoggingLevel           = "logging.level"
 	LoggingLevelTruncation = "logging.disableleveltruncation"
 	LoggingPadLevelText    = "logging.padleveltext"
+	Notifications          = "notifications"
+	PluginRuntimeAutoMTLS  = "pluginruntime.automtls"
+	PluginRuntimeLogLevels = "pluginruntime.loglevels"
+	PluginRuntimeTimeout   = "pluginruntime.timeout"
+	PluginRuntimeTimeouts  = "pluginruntime.timeouts"
+	ReasonPattern          = "reason.pattern"
+	ReasonRules            = "reason.rules"
+	ReasonTemplates        = "reason.templates"
+	ReasonTicketPattern    = "reason.ticketpattern"
+	SecretsFile            = "secrets.file"
+	SecretsKeyFile         = "secrets.keyfile"
+	SecretsKeySource       = "secrets.keysource"
+	SessionShell           = "session.shell"
+	SessionTerminalTitle   = "session.terminaltitle"
+	SessionWarnings        = "session.warnings"
+	TokenMaxDuration       = "tokens.maxduration"
+	UpdatesChannel         = "updates.channel"
+
+	// The settings of the reason verifier.
+	ReasonVerifierType         = "reason.verifier.type"
+	ReasonVerifierURL          = "reason.verifier.url"
+	ReasonVerifierTimeout      = "reason.verifier.timeout"
+	ReasonVerifierUser         = "reason.verifier.user"
+	ReasonVerifierToken        = "reason.verifier.token" //nolint:gosec // Not hardcoded credentials
+	ReasonVerifierTokenCommand = "reason.verifier.tokencommand"
+	ReasonVerifierTokenEnv     = "reason.verifier.tokenenv"
+	ReasonVerifierAuthHeader   = "reason.verifier.authheader"
+	ReasonVerifierAllowedField = "reason.verifier.allowedfield"
+	ReasonVerifierMessageField = "reason.verifier.messagefield"
 )
 
 var (
@@ -67,14 +113,30 @@ func InitConfig() error {
 	viper.AutomaticEnv()
 	viper.SetConfigType("yml")
 
+	// The defaults are set before the config file is read so that settings
+	// added after it was first written, or unset since, still have a value.
+	setDefaults()
+
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
 			return errorsutil.New("Failed to initialize configuration", err)
 		}
 		initConfig()
+		if err := viper.ReadInConfig(); err != nil {
+			return errorsutil.New("Failed to initialize configuration", err)
+		}
 	}
 
-	// Instantiate logger now that the config is loaded.
+	// Instantiate logger now that the config is loaded, and again once the
+	// selected profile and the policy are layered over the base configuration
+	// since they may change the logging settings.
+	util.Logger = util.NewLogger()
+	if err := loadProfile(os.Args[1:]); err != nil {
+		return err
+	}
+	if err := loadPolicy(); err != nil {
+		return err
+	}
 	util.Logger = util.NewLogger()
 
 	// Find the paths to gcloud, kubectl, and cloud_sql_proxy and write them to the config.
@@ -86,18 +148,6 @@ func InitConfig() error {
 }
 
 func initConfig() {
-	viper.SetDefault(AuthProxyAddress, "127.0.0.1")
-	viper.SetDefault(AuthProxyPort, "8084")
-	viper.SetDefault(AuthProxyVerbose, false)
-	viper.SetDefault(AuthProxyLogDir, filepath.Join(GetConfigDir(), "log"))
-	viper.SetDefault(AuthProxyCertFile, filepath.Join(GetConfigDir(), "server.pem"))
-	viper.SetDefault(AuthProxyKeyFile, filepath.Join(GetConfigDir(), "server.key"))
-	viper.SetDefault(GithubAuth, false)
-	viper.SetDefault(LoggingFormat, "text")
-	viper.SetDefault(LoggingLevel, "info")
-	viper.SetDefault(LoggingLevelTruncation, true)
-	viper.SetDefault(LoggingPadLevelText, true)
-
 	if err := viper.SafeWriteConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileAlreadyExistsError); !ok {
 			log.Fatalf("failed to write config file %s/config.yml: %v", GetConfigDir(), err)
@@ -118,12 +168,12 @@ func getBinPaths() error {
 				}
 				util.Logger.Debug("Could not find path to cloud_sql_proxy binary")
 			}
-			viper.Set(configKey, binPath)
+			Set(configKey, binPath)
 		}
 	}
 
 	if updated {
-		if err := viper.WriteConfig(); err != nil {
+		if err := WriteConfig(); err != nil {
 			return errorsutil.New("Failed to write binary paths to configuration file", err)
 		}
 	}
@@ -0,0 +1,176 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appconfig
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+// migration upgrades the contents of a configuration file by one version.
+type migration struct {
+	description string
+	migrate     func(config map[string]interface{}) error
+}
+
+// migrations upgrade configuration files to CurrentConfigVersion. The
+// migration at index i upgrades a file from version i to version i+1, so new
+// migrations are only ever appended.
+var migrations = []migration{
+	{
+		description: "store authproxy.proxyport as an integer",
+		migrate: func(config map[string]interface{}) error {
+			return eachSection(config, func(section map[string]interface{}) error {
+				port, ok := getPath(section, AuthProxyPort)
+				if s, isString := port.(string); ok && isString {
+					i, err := strconv.Atoi(s)
+					if err != nil {
+						return fmt.Errorf("invalid %s %q", AuthProxyPort, s)
+					}
+					setPath(section, AuthProxyPort, i)
+				}
+				return nil
+			})
+		},
+	},
+	{
+		description: "move Github access tokens to the secrets file",
+		migrate: func(config map[string]interface{}) error {
+			tokens, ok := getPath(config, GithubTokens)
+			if !ok {
+				return nil
+			}
+			tokenMap, _ := tokens.(map[string]interface{})
+			for name, value := range tokenMap {
+				token, ok := value.(string)
+				if !ok {
+					continue
+				}
+				if err := SecretStore().Set(githubTokenSecret(name), token); err != nil {
+					return err
+				}
+				tokenMap[name] = map[string]interface{}{}
+			}
+			return nil
+		},
+	},
+}
+
+// CurrentConfigVersion is the version of the configuration files written by
+// this release of eiam.
+var CurrentConfigVersion = len(migrations)
+
+// eachSection calls fn with the base configuration and the settings of each
+// profile, which share the same layout.
+func eachSection(config map[string]interface{}, fn func(map[string]interface{}) error) error {
+	if err := fn(config); err != nil {
+		return err
+	}
+	profiles, _ := config[Profiles].(map[string]interface{})
+	for name, settings := range profiles {
+		section, ok := settings.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if err := fn(section); err != nil {
+			return fmt.Errorf("profile %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// configVersion returns the version of a configuration file. Files written
+// before the version was recorded are version 0.
+func configVersion(config map[string]interface{}) (int, error) {
+	value, ok := config[ConfigVersion]
+	if !ok {
+		return 0, nil
+	}
+	version, ok := value.(int)
+	if !ok || version < 0 {
+		return 0, fmt.Errorf("invalid %s %v", ConfigVersion, value)
+	}
+	return version, nil
+}
+
+// migrateConfig upgrades the configuration file to the current version. The
+// original file is copied to config.yml.v<version>.bak before it is changed.
+func migrateConfig(path string) error {
+	version, err := configVersion(fileConfig)
+	if err != nil {
+		return errorsutil.New("Failed to read configuration version", err)
+	}
+	if version > CurrentConfigVersion {
+		return errorsutil.New("Failed to load configuration",
+			fmt.Errorf("%s was written by a newer version of eiam (configuration version %d, this version supports %d)",
+				path, version, CurrentConfigVersion))
+	}
+	if version == CurrentConfigVersion {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errorsutil.New("Failed to read configuration file", err)
+	}
+	if data, err = redactBackup(data); err != nil {
+		return errorsutil.New("Failed to back up configuration file", err)
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", path, version)
+	if err := os.WriteFile(backup, data, 0o600); err != nil {
+		return errorsutil.New("Failed to back up configuration file", err)
+	}
+
+	for i := version; i < CurrentConfigVersion; i++ {
+		util.Logger.Debugf("Migrating configuration to version %d: %s", i+1, migrations[i].description)
+		if err := migrations[i].migrate(fileConfig); err != nil {
+			return errorsutil.New(fmt.Sprintf("Failed to migrate configuration to version %d", i+1), err)
+		}
+	}
+	fileConfig[ConfigVersion] = CurrentConfigVersion
+	if err := WriteConfig(); err != nil {
+		return errorsutil.New("Failed to write migrated configuration", err)
+	}
+	util.Logger.Infof("Migrated %s from version %d to %d, the original was saved to %s", path, version, CurrentConfigVersion, backup)
+	return nil
+}
+
+// redactBackup removes plaintext Github access tokens from the backup of a
+// configuration file, since the migration moves them to the secrets file.
+func redactBackup(data []byte) ([]byte, error) {
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	tokens, _ := getPath(config, GithubTokens)
+	tokenMap, _ := tokens.(map[string]interface{})
+	redacted := false
+	for name, value := range tokenMap {
+		if _, ok := value.(string); ok {
+			tokenMap[name] = "moved to the secrets file"
+			redacted = true
+		}
+	}
+	if !redacted {
+		return data, nil
+	}
+	return yaml.Marshal(config)
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+)
+
+func readTestConfig(t *testing.T, contents string) string {
+	t.Helper()
+	configFile := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(configFile, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Reset()
+	viper.SetConfigFile(configFile)
+	viper.SetConfigType("yml")
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(viper.Reset)
+	return configFile
+}
+
+func TestMigrateConfig(t *testing.T) {
+	util.Logger = logrus.New()
+	original := "authproxy:\n  proxyport: \"8090\"\nprofiles:\n  prod:\n    authproxy:\n      proxyport: \"9000\"\n"
+	configFile := readTestConfig(t, original)
+
+	if err := loadProfile([]string{"--profile", "prod"}); err != nil {
+		t.Fatalf("loadProfile failed: %v", err)
+	}
+	if got := viper.Get(AuthProxyPort); got != 9000 {
+		t.Errorf("expected the profile's proxy port to be migrated, got %#v", got)
+	}
+	if got := fileConfig[ConfigVersion]; got != CurrentConfigVersion {
+		t.Errorf("expected configversion %d, got %v", CurrentConfigVersion, got)
+	}
+	if port, _ := getPath(fileConfig, AuthProxyPort); port != 8090 {
+		t.Errorf("expected the base proxy port to be migrated, got %#v", port)
+	}
+
+	backup, err := os.ReadFile(configFile + ".v0.bak")
+	if err != nil {
+		t.Fatalf("expected a backup of the original file: %v", err)
+	}
+	if string(backup) != original {
+		t.Errorf("unexpected backup contents:\n%s", backup)
+	}
+}
+
+func TestMigrateConfigNewerVersion(t *testing.T) {
+	util.Logger = logrus.New()
+	readTestConfig(t, fmt.Sprintf("configversion: %d\n", CurrentConfigVersion+1))
+	if err := loadProfile(nil); err == nil {
+		t.Error("expected an error loading a configuration from a newer version")
+	}
+}
+
+func TestMigrateGithubTokens(t *testing.T) {
+	util.Logger = logrus.New()
+	dir := t.TempDir()
+	original := fmt.Sprintf("configversion: 1\nsecrets:\n  file: %s\n  keyfile: %s\n"+
+		"github:\n  auth: true\n  tokens:\n    personal: ghp_plaintext\n",
+		filepath.Join(dir, "secrets.enc"), filepath.Join(dir, "secrets.key"))
+	configFile := readTestConfig(t, original)
+	secretStore, secretStoreOnce = nil, sync.Once{}
+	defer func() { secretStore, secretStoreOnce = nil, sync.Once{} }()
+
+	if err := loadProfile(nil); err != nil {
+		t.Fatalf("loadProfile failed: %v", err)
+	}
+	if got, err := GithubToken("personal"); err != nil || got != "ghp_plaintext" {
+		t.Errorf("GithubToken = %q, %v, want the migrated token", got, err)
+	}
+	for _, file := range []string{configFile, configFile + ".v1.bak"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "ghp_plaintext") {
+			t.Errorf("expected the token to be removed from %s:\n%s", file, data)
+		}
+	}
+}
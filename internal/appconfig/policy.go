@@ -0,0 +1,208 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+
+	archutil "github.com/replit/ephemeral-iam/internal/appconfig/arch_util"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+// PolicyFileEnv is the environment variable that names an additional policy
+// file. It is applied on top of the system policy and cannot undo what the
+// system policy enforces.
+const PolicyFileEnv = "EIAM_POLICY_FILE"
+
+// Where the value of a setting came from.
+const (
+	SourceDefault       = "default"
+	SourcePolicyDefault = "policy default"
+	SourceConfig        = "config file"
+	SourceProfile       = "profile"
+	SourcePolicy        = "enforced by policy"
+)
+
+// Policy is a system-wide file that an organization uses to manage the
+// configuration of eiam. Defaults are used for the settings that a user has
+// not set, and enforced settings override the user's configuration and
+// profiles and cannot be changed.
+type Policy struct {
+	Defaults map[string]interface{} `yaml:"defaults"`
+	Enforced map[string]interface{} `yaml:"enforced"`
+}
+
+var (
+	// policyDefaults and lockedKeys map the keys of the settings in the
+	// policy's sections to their values.
+	policyDefaults = map[string]interface{}{}
+	lockedKeys     = map[string]interface{}{}
+	// lockedBy maps the keys of the enforced settings to their policy file.
+	lockedBy = map[string]string{}
+
+	// systemPolicyFile is the location of the system policy.
+	systemPolicyFile = archutil.PolicyPath
+)
+
+// PolicyFile returns the policy file that enforces a setting returned by
+// Locked.
+func PolicyFile(lockedKey string) string {
+	if path, ok := lockedBy[strings.ToLower(lockedKey)]; ok {
+		return path
+	}
+	return systemPolicyFile
+}
+
+// loadPolicy applies the system policy file if there is one, and then the one
+// in EIAM_POLICY_FILE. The latter can add defaults and enforced settings, but
+// the settings that the system policy enforces always win, so that users
+// cannot lift them by pointing EIAM_POLICY_FILE at another file.
+func loadPolicy() error {
+	policyDefaults = map[string]interface{}{}
+	lockedKeys = map[string]interface{}{}
+	lockedBy = map[string]string{}
+
+	if err := applyPolicyFile(systemPolicyFile, false); err != nil {
+		return err
+	}
+	if path := os.Getenv(PolicyFileEnv); path != "" && path != systemPolicyFile {
+		if err := applyPolicyFile(path, true); err != nil {
+			return err
+		}
+	}
+
+	for key, value := range policyDefaults {
+		viper.SetDefault(key, value)
+	}
+	for key, value := range lockedKeys {
+		viper.Set(key, value)
+	}
+	return nil
+}
+
+// applyPolicyFile adds the settings of a policy file to the ones of the files
+// applied before it. A missing file is only an error if it is required.
+func applyPolicyFile(path string, required bool) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return nil
+	} else if err != nil {
+		return errorsutil.New("Failed to read policy file", err)
+	}
+
+	var policy Policy
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&policy); err != nil && !errors.Is(err, io.EOF) {
+		return errorsutil.New(fmt.Sprintf("Failed to parse policy file %s", path), err)
+	}
+
+	defaults := flatten("", policy.Defaults, map[string]interface{}{})
+	enforced := flatten("", policy.Enforced, map[string]interface{}{})
+	for _, settings := range []map[string]interface{}{defaults, enforced} {
+		if err := checkPolicy(settings); err != nil {
+			return errorsutil.New(fmt.Sprintf("Invalid setting in policy file %s", path), err)
+		}
+	}
+	for key, value := range defaults {
+		policyDefaults[key] = value
+	}
+	for key, value := range enforced {
+		if by, ok := lockedBy[key]; ok {
+			util.Logger.Warnf("Ignoring %s in %s, it is enforced by %s", key, path, by)
+			continue
+		}
+		lockedKeys[key] = value
+		lockedBy[key] = path
+	}
+	return nil
+}
+
+// checkPolicy checks the settings of a policy section against the schema.
+func checkPolicy(settings map[string]interface{}) error {
+	for key, value := range settings {
+		f, ok := FindField(key)
+		if !ok {
+			return fmt.Errorf("%s: unknown setting", key)
+		}
+		if err := f.Check(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flatten adds the leaf values of a nested map to dst with dotted keys.
+func flatten(prefix string, m, dst map[string]interface{}) map[string]interface{} {
+	for k, v := range m {
+		key := strings.ToLower(k)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			flatten(key, nested, dst)
+		} else {
+			dst[key] = v
+		}
+	}
+	return dst
+}
+
+// Locked reports whether a setting, or a setting within it, is enforced by the
+// policy. The enforced key is returned.
+func Locked(key string) (string, bool) {
+	key = strings.ToLower(key)
+	locked := make([]string, 0, len(lockedKeys))
+	for k := range lockedKeys {
+		locked = append(locked, k)
+	}
+	sort.Strings(locked)
+	for _, k := range locked {
+		if k == key || strings.HasPrefix(k, key+".") || strings.HasPrefix(key, k+".") {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// Source returns where the value of a setting came from.
+func Source(key string) string {
+	key = strings.ToLower(key)
+	if _, ok := lockedKeys[key]; ok {
+		return SourcePolicy
+	}
+	if settings, ok := profileSettings(activeProfile); ok && activeProfile != "" {
+		if _, ok := getPath(settings, key); ok {
+			return SourceProfile + " " + activeProfile
+		}
+	}
+	if _, ok := getPath(fileConfig, key); ok {
+		return SourceConfig
+	}
+	if _, ok := policyDefaults[key]; ok {
+		return SourcePolicyDefault
+	}
+	return SourceDefault
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+)
+
+func TestPolicy(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(configFile, []byte("tokens:\n  maxduration: 2h\nupdates:\n  channel: prerelease\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policyFile := filepath.Join(dir, "policy.yml")
+	policy := "defaults:\n  updates:\n    channel: disabled\n  reason:\n    pattern: '.+'\n" +
+		"enforced:\n  tokens:\n    maxduration: 30m\n"
+	if err := os.WriteFile(policyFile, []byte(policy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(PolicyFileEnv, policyFile)
+
+	viper.Reset()
+	viper.SetConfigFile(configFile)
+	viper.SetConfigType("yml")
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	defer viper.Reset()
+	if err := loadProfile(nil); err != nil {
+		t.Fatalf("loadProfile failed: %v", err)
+	}
+	if err := loadPolicy(); err != nil {
+		t.Fatalf("loadPolicy failed: %v", err)
+	}
+	defer func() {
+		policyDefaults = map[string]interface{}{}
+		lockedKeys = map[string]interface{}{}
+	}()
+
+	tests := []struct {
+		key, value, source string
+	}{
+		{TokenMaxDuration, "30m", SourcePolicy},
+		{UpdatesChannel, "prerelease", SourceConfig},
+		{ReasonPattern, ".+", SourcePolicyDefault},
+	}
+	for _, tt := range tests {
+		if got := viper.GetString(tt.key); got != tt.value {
+			t.Errorf("%s = %q, want %q", tt.key, got, tt.value)
+		}
+		if got := Source(tt.key); got != tt.source {
+			t.Errorf("Source(%s) = %q, want %q", tt.key, got, tt.source)
+		}
+	}
+
+	for _, key := range []string{"tokens", TokenMaxDuration, "TOKENS.MaxDuration"} {
+		if _, ok := Locked(key); !ok {
+			t.Errorf("expected %s to be locked", key)
+		}
+	}
+	if _, ok := Locked(UpdatesChannel); ok {
+		t.Errorf("expected %s not to be locked", UpdatesChannel)
+	}
+
+	// Writing the configuration must not drop the enforced settings.
+	Set(TokenMaxDuration, "4h")
+	if err := WriteConfig(); err != nil {
+		t.Fatalf("WriteConfig failed: %v", err)
+	}
+	if got := viper.GetString(TokenMaxDuration); got != "30m" {
+		t.Errorf("expected the enforced duration after writing, got %s", got)
+	}
+}
+
+func TestPolicyUnknownSection(t *testing.T) {
+	util.Logger = logrus.New()
+	policyFile := filepath.Join(t.TempDir(), "policy.yml")
+	if err := os.WriteFile(policyFile, []byte("enforce:\n  tokens:\n    maxduration: 30m\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(PolicyFileEnv, policyFile)
+	if err := loadPolicy(); err == nil {
+		t.Error("expected an error for an unknown policy section")
+	}
+
+	t.Setenv(PolicyFileEnv, filepath.Join(t.TempDir(), "missing.yml"))
+	if err := loadPolicy(); err == nil {
+		t.Error("expected an error for a missing policy file set with EIAM_POLICY_FILE")
+	}
+}
+
+func TestPolicyEnvFileCannotLiftSystemPolicy(t *testing.T) {
+	util.Logger = logrus.New()
+	dir := t.TempDir()
+	systemFile := filepath.Join(dir, "system.yml")
+	system := "enforced:\n  tokens:\n    maxduration: 30m\n"
+	if err := os.WriteFile(systemFile, []byte(system), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	envFile := filepath.Join(dir, "env.yml")
+	env := "defaults:\n  updates:\n    channel: disabled\n" +
+		"enforced:\n  tokens:\n    maxduration: 4h\n  reason:\n    pattern: '.+'\n"
+	if err := os.WriteFile(envFile, []byte(env), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(path string) { systemPolicyFile = path }(systemPolicyFile)
+	systemPolicyFile = systemFile
+
+	viper.Reset()
+	defer viper.Reset()
+	defer func() {
+		policyDefaults = map[string]interface{}{}
+		lockedKeys = map[string]interface{}{}
+		lockedBy = map[string]string{}
+	}()
+
+	// An empty file in EIAM_POLICY_FILE does not replace the system policy.
+	t.Setenv(PolicyFileEnv, os.DevNull)
+	if err := loadPolicy(); err != nil {
+		t.Fatalf("loadPolicy failed: %v", err)
+	}
+	if got := viper.GetString(TokenMaxDuration); got != "30m" {
+		t.Errorf("expected the system policy to be enforced, got %s", got)
+	}
+
+	// The settings of EIAM_POLICY_FILE are added, except for the ones the
+	// system policy enforces.
+	t.Setenv(PolicyFileEnv, envFile)
+	if err := loadPolicy(); err != nil {
+		t.Fatalf("loadPolicy failed: %v", err)
+	}
+	tests := []struct {
+		key, value, file string
+	}{
+		{TokenMaxDuration, "30m", systemFile},
+		{ReasonPattern, ".+", envFile},
+	}
+	for _, tt := range tests {
+		if got := viper.GetString(tt.key); got != tt.value {
+			t.Errorf("%s = %q, want %q", tt.key, got, tt.value)
+		}
+		if got := PolicyFile(tt.key); got != tt.file {
+			t.Errorf("PolicyFile(%s) = %q, want %q", tt.key, got, tt.file)
+		}
+	}
+	if got := viper.GetString(UpdatesChannel); got != "disabled" {
+		t.Errorf("expected the default of %s, got %q", PolicyFileEnv, got)
+	}
+}
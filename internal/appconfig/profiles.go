@@ -0,0 +1,266 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appconfig
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+const (
+	// DefaultProfile is the name of the base configuration when it is used
+	// as a profile.
+	DefaultProfile = "default"
+
+	// ProfileEnv is the environment variable that selects a profile when the
+	// --profile flag is not used.
+	ProfileEnv = "EIAM_PROFILE"
+
+	// ProfileFlag is the flag that selects a profile for a single command.
+	ProfileFlag = "profile"
+)
+
+var (
+	profileName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+	// fileConfig holds the contents of the configuration file without the
+	// active profile applied. It is what WriteConfig writes.
+	fileConfig = map[string]interface{}{}
+
+	// activeProfile is the name of the profile applied to the configuration,
+	// or an empty string if none is.
+	activeProfile string
+)
+
+// loadProfile reads and migrates the configuration file and applies the
+// profile selected by the --profile flag, the EIAM_PROFILE environment
+// variable, or the activeprofile setting, in that order.
+func loadProfile(args []string) error {
+	data, err := os.ReadFile(viper.ConfigFileUsed())
+	if err != nil {
+		return errorsutil.New("Failed to read configuration file", err)
+	}
+	fileConfig = map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &fileConfig); err != nil {
+		return errorsutil.New("Failed to parse configuration file", err)
+	}
+	if fileConfig == nil {
+		fileConfig = map[string]interface{}{}
+	}
+	if err := migrateConfig(viper.ConfigFileUsed()); err != nil {
+		return err
+	}
+
+	name := profileFromArgs(args)
+	if name == "" {
+		name = os.Getenv(ProfileEnv)
+	}
+	if name == "" {
+		name, _ = fileConfig[ActiveProfile].(string)
+	}
+	if name == "" || name == DefaultProfile {
+		activeProfile = ""
+		return nil
+	}
+
+	if _, ok := profileSettings(name); !ok {
+		return errorsutil.New("Failed to load configuration profile", fmt.Errorf("profile %q does not exist", name))
+	}
+	activeProfile = name
+	if err := applyConfig(); err != nil {
+		return errorsutil.New("Failed to load configuration profile", err)
+	}
+	return nil
+}
+
+// applyConfig replaces the configuration that viper reads with the base
+// configuration and the active profile layered over it.
+func applyConfig() error {
+	data, err := yaml.Marshal(fileConfig)
+	if err != nil {
+		return err
+	}
+	if err := viper.ReadConfig(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if settings, ok := profileSettings(activeProfile); ok && activeProfile != "" {
+		return viper.MergeConfigMap(settings)
+	}
+	return nil
+}
+
+// profileFromArgs returns the value of the --profile flag. The configuration
+// is loaded before the command line is parsed, so it is found here instead.
+func profileFromArgs(args []string) string {
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			return ""
+		case arg == "--"+ProfileFlag && i+1 < len(args):
+			return args[i+1]
+		case strings.HasPrefix(arg, "--"+ProfileFlag+"="):
+			return strings.TrimPrefix(arg, "--"+ProfileFlag+"=")
+		}
+	}
+	return ""
+}
+
+func profileSettings(name string) (map[string]interface{}, bool) {
+	profiles, _ := fileConfig[Profiles].(map[string]interface{})
+	value, ok := profiles[name]
+	if !ok {
+		return nil, false
+	}
+	settings, _ := value.(map[string]interface{})
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	return settings, true
+}
+
+// Profile returns the name of the active profile.
+func Profile() string {
+	if activeProfile == "" {
+		return DefaultProfile
+	}
+	return activeProfile
+}
+
+// ProfileNames returns the names of the configured profiles, including the
+// default profile.
+func ProfileNames() []string {
+	profiles, _ := fileConfig[Profiles].(map[string]interface{})
+	names := []string{DefaultProfile}
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// SavedProfile returns the name of the profile that is used when neither the
+// --profile flag nor the EIAM_PROFILE environment variable is set.
+func SavedProfile() string {
+	if name, _ := fileConfig[ActiveProfile].(string); name != "" {
+		return name
+	}
+	return DefaultProfile
+}
+
+// CreateProfile adds an empty profile to the configuration file.
+func CreateProfile(name string) error {
+	if name == DefaultProfile || !profileName.MatchString(name) {
+		return fmt.Errorf("%q is not a valid profile name", name)
+	}
+	if _, ok := profileSettings(name); ok {
+		return fmt.Errorf("profile %q already exists", name)
+	}
+	setPath(fileConfig, Profiles+"."+name, map[string]interface{}{})
+	return WriteConfig()
+}
+
+// DeleteProfile removes a profile from the configuration file. If it was the
+// saved profile, the default profile is used instead.
+func DeleteProfile(name string) error {
+	if _, ok := profileSettings(name); !ok {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	profiles := fileConfig[Profiles].(map[string]interface{})
+	delete(profiles, name)
+	if SavedProfile() == name {
+		delete(fileConfig, ActiveProfile)
+	}
+	return WriteConfig()
+}
+
+// UseProfile saves the profile to use when neither the --profile flag nor the
+// EIAM_PROFILE environment variable is set.
+func UseProfile(name string) error {
+	if name == DefaultProfile {
+		delete(fileConfig, ActiveProfile)
+		return WriteConfig()
+	}
+	if _, ok := profileSettings(name); !ok {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	fileConfig[ActiveProfile] = name
+	return WriteConfig()
+}
+
+// Set changes the value of a setting in the base configuration. The change
+// takes effect when it is saved by WriteConfig, unless the active profile
+// overrides the setting.
+func Set(key string, value interface{}) {
+	setPath(fileConfig, key, value)
+}
+
+// SetProfile changes the value of a setting in the active profile, or in the
+// base configuration if no profile is active. The change takes effect when it
+// is saved by WriteConfig.
+func SetProfile(key string, value interface{}) {
+	if activeProfile == "" {
+		setPath(fileConfig, key, value)
+		return
+	}
+	setPath(fileConfig, Profiles+"."+activeProfile+"."+key, value)
+}
+
+// WriteConfig writes the base configuration and the profiles to the
+// configuration file and applies the changes made with Set and SetProfile.
+func WriteConfig() error {
+	data, err := yaml.Marshal(fileConfig)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(viper.ConfigFileUsed(), data, 0o600); err != nil {
+		return err
+	}
+	return applyConfig()
+}
+
+func setPath(m map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(strings.ToLower(key), ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[part] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = value
+}
+
+func getPath(m map[string]interface{}, key string) (interface{}, bool) {
+	parts := strings.Split(strings.ToLower(key), ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		m = next
+	}
+	value, ok := m[parts[len(parts)-1]]
+	return value, ok
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestProfileFromArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"config", "view", "logging.level"}, ""},
+		{[]string{"--profile", "prod", "gcloud", "projects", "list"}, "prod"},
+		{[]string{"gcloud", "--profile=staging"}, "staging"},
+		{[]string{"gcloud", "--", "--profile", "prod"}, ""},
+	}
+	for _, tt := range tests {
+		if got := profileFromArgs(tt.args); got != tt.want {
+			t.Errorf("profileFromArgs(%v) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestProfileLayering(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yml")
+	base := "authproxy:\n  proxyport: \"8084\"\nlogging:\n  level: info\n" +
+		"profiles:\n  prod:\n    authproxy:\n      proxyport: \"8085\"\n"
+	if err := os.WriteFile(configFile, []byte(base), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Reset()
+	viper.SetConfigFile(configFile)
+	viper.SetConfigType("yml")
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	defer viper.Reset()
+
+	if err := loadProfile([]string{"--profile", "prod"}); err != nil {
+		t.Fatalf("loadProfile failed: %v", err)
+	}
+	if got := viper.GetString(AuthProxyPort); got != "8085" {
+		t.Errorf("expected the profile's proxy port, got %s", got)
+	}
+	if got := viper.GetString(LoggingLevel); got != "info" {
+		t.Errorf("expected the base logging level, got %s", got)
+	}
+
+	SetProfile(LoggingLevel, "debug")
+	Set(AuthProxyPort, "9000")
+	if err := WriteConfig(); err != nil {
+		t.Fatalf("WriteConfig failed: %v", err)
+	}
+	if got := viper.GetString(LoggingLevel); got != "debug" {
+		t.Errorf("expected the updated profile logging level, got %s", got)
+	}
+	if got := viper.GetString(AuthProxyPort); got != "8085" {
+		t.Errorf("expected the profile to still override the proxy port, got %s", got)
+	}
+
+	if err := DeleteProfile("prod"); err != nil {
+		t.Fatalf("DeleteProfile failed: %v", err)
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "8085") || !strings.Contains(string(data), "9000") {
+		t.Errorf("unexpected configuration file after deleting the profile:\n%s", data)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appconfig
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+// The values of the reason.verifier.type setting.
+const (
+	VerifierNone    = "none"
+	VerifierWebhook = "webhook"
+	VerifierJira    = "jira"
+)
+
+// VerifierTypes are the valid values of the reason.verifier.type setting.
+var VerifierTypes = []string{VerifierNone, VerifierWebhook, VerifierJira}
+
+// ReasonRule requires the reasons of the commands that use a matching project
+// and service account to reference a ticket. Project and ServiceAccount are
+// glob patterns and an empty pattern matches everything.
+type ReasonRule struct {
+	Project        string `mapstructure:"project"`
+	ServiceAccount string `mapstructure:"serviceaccount"`
+	TicketPattern  string `mapstructure:"ticketpattern"`
+}
+
+// Matches reports whether the rule applies to a project and service account.
+func (r ReasonRule) Matches(project, serviceAccount string) bool {
+	return globMatch(r.Project, project) && globMatch(r.ServiceAccount, serviceAccount)
+}
+
+func globMatch(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
+
+// TicketPattern returns the pattern of the ticket that a reason must reference
+// when using a project and service account, and the setting it came from. The
+// first matching rule in reason.rules is used, and reason.ticketpattern when
+// none match. An empty pattern means that no ticket is required.
+func TicketPattern(project, serviceAccount string) (pattern, source string, err error) {
+	var rules []ReasonRule
+	if err := viper.UnmarshalKey(ReasonRules, &rules); err != nil {
+		return "", "", errorsutil.New(fmt.Sprintf("Invalid %s setting", ReasonRules), err)
+	}
+	for i, rule := range rules {
+		if rule.Matches(project, serviceAccount) {
+			return rule.TicketPattern, fmt.Sprintf("%s[%d]", ReasonRules, i), nil
+		}
+	}
+	return viper.GetString(ReasonTicketPattern), ReasonTicketPattern, nil
+}
+
+// ReasonTemplate returns the text of a reason template.
+func ReasonTemplate(name string) (string, bool) {
+	text, ok := viper.GetStringMapString(ReasonTemplates)[strings.ToLower(name)]
+	return text, ok
+}
+
+// ReasonTemplateNames returns the sorted names of the reason templates.
+func ReasonTemplateNames() []string {
+	names := []string{}
+	for name := range viper.GetStringMapString(ReasonTemplates) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
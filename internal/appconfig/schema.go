@@ -0,0 +1,694 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appconfig
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/viper"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+)
+
+// FieldType is the type of the value of a configuration setting.
+type FieldType string
+
+// The types of configuration settings.
+const (
+	TypeString   FieldType = "string"
+	TypeBool     FieldType = "bool"
+	TypeInt      FieldType = "int"
+	TypeDuration FieldType = "duration"
+	TypeRegexp   FieldType = "regexp"
+	TypeTemplate FieldType = "template"
+	TypeStrings  FieldType = "[]string"
+	TypeList     FieldType = "list"
+	TypeMap      FieldType = "map"
+	TypePlugin   FieldType = "plugin"
+)
+
+var (
+	// LoggingLevels are the valid values of the logging.level setting.
+	LoggingLevels = []string{"trace", "debug", "info", "warn", "error", "fatal", "panic"}
+	// LoggingFormats are the valid values of the logging.format setting.
+	LoggingFormats = []string{"text", "json", "debug"}
+	// PluginLogLevels are the valid values of the pluginruntime.loglevels
+	// settings.
+	PluginLogLevels = []string{"trace", "debug", "info", "warn", "error", "off"}
+)
+
+// Field describes a configuration setting. A segment of the key in angle
+// brackets, such as plugins.<name>, matches any name.
+type Field struct {
+	Key         string
+	Type        FieldType
+	Allowed     []string
+	Default     interface{}
+	Description string
+	Secret      bool
+	// Managed is the error returned by 'config set' for settings that are
+	// changed by another command.
+	Managed string
+}
+
+// Schema returns the configuration settings in the order of their keys.
+func Schema() []Field {
+	return []Field{
+		{
+			Key:         AccessPolicyFile,
+			Type:        TypeString,
+			Default:     "",
+			Description: "An access policy file that decides who may use which service accounts, when, and for how long. See docs/access",
+		},
+		{
+			Key:         AllowedServiceAccounts,
+			Type:        TypeStrings,
+			Default:     []string{},
+			Description: "Glob patterns of the service accounts that may be impersonated. Empty allows all accounts",
+		},
+		{
+			Key:         ActiveProfile,
+			Type:        TypeString,
+			Description: "The profile used when neither the --profile flag nor EIAM_PROFILE is set",
+			Managed:     "please use the 'config profiles use' command to change the active profile",
+		},
+		{
+			Key:         AuthProxyAllowedHosts,
+			Type:        TypeStrings,
+			Default:     []string{},
+			Description: "Glob patterns of the hosts that the auth proxy forwards requests to, e.g. '*.googleapis.com'. Empty allows all hosts",
+		},
+		{
+			Key:         AuthProxyCertFile,
+			Type:        TypeString,
+			Default:     filepath.Join(GetConfigDir(), "server.pem"),
+			Description: "The path to the auth proxy's TLS certificate",
+		},
+		{
+			Key:         AuthProxyKeyFile,
+			Type:        TypeString,
+			Default:     filepath.Join(GetConfigDir(), "server.key"),
+			Description: "The path to the auth proxy's x509 key",
+		},
+		{
+			Key:         AuthProxyLogDir,
+			Type:        TypeString,
+			Default:     filepath.Join(GetConfigDir(), "log"),
+			Description: "The directory that auth proxy logs will be written to",
+		},
+		{
+			Key:         AuditEndpoint,
+			Type:        TypeString,
+			Description: "The Cloud Logging API endpoint that 'eiam audit' searches. The public endpoint if empty",
+		},
+		{
+			Key:         ApprovalsAccounts,
+			Type:        TypeStrings,
+			Default:     []string{},
+			Description: "Service accounts, as glob patterns, whose privileged sessions must be approved by a second engineer",
+		},
+		{
+			Key:         ApprovalsURL,
+			Type:        TypeString,
+			Description: "The URL of the approval server. See docs/approvals for its API",
+		},
+		{
+			Key:         ApprovalsTimeout,
+			Type:        TypeDuration,
+			Default:     "15m",
+			Description: "How long to wait for a request to be approved",
+		},
+		{
+			Key:         ApprovalsPollInterval,
+			Type:        TypeDuration,
+			Default:     "5s",
+			Description: "How often to check whether a request has been approved",
+		},
+		{
+			Key:         ApprovalsToken,
+			Type:        TypeString,
+			Description: "The credential sent to the approval server as a bearer token. It is saved in the secrets file",
+			Secret:      true,
+		},
+		{
+			Key:         ApprovalsTokenCommand,
+			Type:        TypeString,
+			Description: "A command that prints the credential of the approval server, used instead of approvals.token",
+		},
+		{
+			Key:         ApprovalsTokenEnv,
+			Type:        TypeString,
+			Description: "An environment variable that holds the credential of the approval server, used instead of approvals.token",
+		},
+		{
+			Key:         AuthProxyAddress,
+			Type:        TypeString,
+			Default:     "127.0.0.1",
+			Description: "The address that the auth proxy is hosted on",
+		},
+		{
+			Key:         AuthProxyPort,
+			Type:        TypeInt,
+			Default:     8084,
+			Description: "The port that the auth proxy runs on",
+		},
+		{
+			Key:         AuthProxyVerbose,
+			Type:        TypeBool,
+			Default:     false,
+			Description: "When set to 'true', verbose output for proxy logs will be enabled",
+		},
+		{
+			Key:         CloudSQLProxyPath,
+			Type:        TypeString,
+			Default:     "",
+			Description: "The path to the cloud_sql_proxy binary on your filesystem",
+		},
+		{
+			Key:         GcloudPath,
+			Type:        TypeString,
+			Default:     "",
+			Description: "The path to the gcloud binary on your filesystem",
+		},
+		{
+			Key:         KubectlPath,
+			Type:        TypeString,
+			Default:     "",
+			Description: "The path to the kubectl binary on your filesystem",
+		},
+		{
+			Key:         ConfigVersion,
+			Type:        TypeInt,
+			Default:     CurrentConfigVersion,
+			Description: "The version of the configuration file's layout. It is updated when eiam migrates the file",
+			Managed:     "the configversion is updated by eiam when it migrates the configuration file",
+		},
+		{
+			Key:         DefaultProject,
+			Type:        TypeString,
+			Default:     "",
+			Description: "The GCP project to use when the --project flag is not set, instead of the project in the active gcloud config",
+		},
+		{
+			Key:         GithubAuth,
+			Type:        TypeBool,
+			Default:     false,
+			Description: "When set to 'true', the \"plugins install\" command will use a configured personal access token to authenticate to the Github API",
+		},
+		{
+			Key:         GithubTokens + ".<name>.command",
+			Type:        TypeString,
+			Description: "A command that prints the named Github personal access token. Tokens without a command or env are kept in the secrets file",
+			Managed:     "please use the 'plugins auth' commands to edit configured Github access tokens",
+		},
+		{
+			Key:         GithubTokens + ".<name>.env",
+			Type:        TypeString,
+			Description: "An environment variable that holds the named Github personal access token",
+			Managed:     "please use the 'plugins auth' commands to edit configured Github access tokens",
+		},
+		{
+			Key:         Hooks,
+			Type:        TypeList,
+			Description: "Executables to run on lifecycle events. See docs/hooks for the format",
+			Managed:     "please edit the hooks list in the configuration file directly",
+		},
+		{
+			Key:         HistoryEnabled,
+			Type:        TypeBool,
+			Default:     true,
+			Description: "When set to 'true', sessions, tokens and commands are recorded in the local history file",
+		},
+		{
+			Key:         LoggingLevelTruncation,
+			Type:        TypeBool,
+			Default:     true,
+			Description: "When set to 'true', the level indicator for logs will not be trucated",
+		},
+		{
+			Key:         LoggingFormat,
+			Type:        TypeString,
+			Allowed:     LoggingFormats,
+			Default:     "text",
+			Description: "The format for which to write console logs",
+		},
+		{
+			Key:         LoggingLevel,
+			Type:        TypeString,
+			Allowed:     LoggingLevels,
+			Default:     "info",
+			Description: "The logging level to write to the console",
+		},
+		{
+			Key:         LoggingPadLevelText,
+			Type:        TypeBool,
+			Default:     true,
+			Description: "When set to 'true', output logs will align evenly with their output level indicator",
+		},
+		{
+			Key:         Notifications,
+			Type:        TypeList,
+			Description: "Webhooks and chat channels to notify of sessions and commands. See docs/notifications for the format",
+			Managed:     "please edit the notifications list in the configuration file directly",
+		},
+		{
+			Key:         "plugins.<name>.<field>",
+			Type:        TypePlugin,
+			Description: "Settings of an installed plugin. The fields of loaded plugins are listed below",
+		},
+		{
+			Key:         PluginRuntimeAutoMTLS,
+			Type:        TypeBool,
+			Default:     true,
+			Description: "When set to 'true', eiam and its plugins communicate over mutually authenticated TLS",
+		},
+		{
+			Key:         PluginRuntimeLogLevels + ".<name>",
+			Type:        TypeString,
+			Allowed:     PluginLogLevels,
+			Description: "The logging level for the named plugin's logs",
+		},
+		{
+			Key:         PluginRuntimeTimeout,
+			Type:        TypeDuration,
+			Default:     "0s",
+			Description: "How long a plugin's command may run before it is cancelled, e.g. '10m'. '0s' means no limit",
+		},
+		{
+			Key:         PluginRuntimeTimeouts + ".<name>",
+			Type:        TypeDuration,
+			Description: "Overrides pluginruntime.timeout for the named plugin",
+		},
+		{
+			Key:         Profiles + ".<name>",
+			Type:        TypeMap,
+			Description: "Settings that override the ones above when the profile is active. See the 'config profiles' command",
+			Managed:     "please use the --profile flag to change the settings of a profile",
+		},
+		{
+			Key:         ReasonPattern,
+			Type:        TypeRegexp,
+			Default:     "",
+			Description: "A regular expression that the --reason flag must match. Empty accepts any reason",
+		},
+		{
+			Key:         ReasonRules,
+			Type:        TypeList,
+			Description: "Ticket patterns for the projects and service accounts that match a rule. See the README for the format",
+			Managed:     "please edit the reason.rules list in the configuration file directly",
+		},
+		{
+			Key:         ReasonTemplates + ".<name>",
+			Type:        TypeTemplate,
+			Description: "A reason used with --reason-template. {{.Ticket}}, {{.Details}}, {{.Project}} and {{.ServiceAccount}} are replaced with the --ticket, --reason, project and service account",
+		},
+		{
+			Key:         ReasonTicketPattern,
+			Type:        TypeRegexp,
+			Default:     "",
+			Description: "A regular expression for the ticket that a reason must reference, e.g. 'JIRA-[0-9]+'. Empty requires no ticket",
+		},
+		{
+			Key:         ReasonVerifierType,
+			Type:        TypeString,
+			Allowed:     VerifierTypes,
+			Default:     VerifierNone,
+			Description: "The ticket system that verifies the ticket of a reason before a token is generated",
+		},
+		{
+			Key:         ReasonVerifierURL,
+			Type:        TypeString,
+			Description: "The URL of the webhook, or the base URL of the Jira site",
+		},
+		{
+			Key:         ReasonVerifierTimeout,
+			Type:        TypeDuration,
+			Default:     "10s",
+			Description: "How long the reason verifier may take to respond",
+		},
+		{
+			Key:         ReasonVerifierToken,
+			Type:        TypeString,
+			Description: "The credential of the reason verifier. It is saved in the secrets file",
+			Secret:      true,
+		},
+		{
+			Key:         ReasonVerifierTokenCommand,
+			Type:        TypeString,
+			Description: "A command that prints the credential of the reason verifier, used instead of reason.verifier.token",
+		},
+		{
+			Key:         ReasonVerifierTokenEnv,
+			Type:        TypeString,
+			Description: "An environment variable that holds the credential of the reason verifier, used instead of reason.verifier.token",
+		},
+		{
+			Key:         ReasonVerifierUser,
+			Type:        TypeString,
+			Description: "The Jira user that the credential belongs to. Without it, the credential is sent as a bearer token",
+		},
+		{
+			Key:         ReasonVerifierAuthHeader,
+			Type:        TypeString,
+			Default:     "Authorization",
+			Description: "The header that the webhook credential is sent in. It is sent as a bearer token in the Authorization header",
+		},
+		{
+			Key:         ReasonVerifierAllowedField,
+			Type:        TypeString,
+			Default:     "allowed",
+			Description: "The boolean field of the webhook's JSON response that approves the reason, e.g. 'result.allowed'",
+		},
+		{
+			Key:         ReasonVerifierMessageField,
+			Type:        TypeString,
+			Default:     "message",
+			Description: "The field of the webhook's JSON response that explains a rejection",
+		},
+		{
+			Key:         SecretsFile,
+			Type:        TypeString,
+			Default:     filepath.Join(GetConfigDir(), "secrets.enc"),
+			Description: "The encrypted file that holds Github access tokens and the secret settings of plugins",
+		},
+		{
+			Key:         SecretsKeyFile,
+			Type:        TypeString,
+			Default:     DefaultSecretsKeyFile(),
+			Description: "The key that encrypts the secrets file when secrets.keysource is 'keyfile'. It is created if it does not exist. It is kept outside of the config directory, so that copies of that directory do not include it",
+		},
+		{
+			Key:         SecretsKeySource,
+			Type:        TypeString,
+			Allowed:     SecretsKeySources,
+			Default:     KeySourceFile,
+			Description: "How the secrets file is encrypted: with the key file, or with a passphrase that is read from EIAM_SECRETS_PASSPHRASE or prompted for",
+		},
+		{
+			Key:         SessionShell,
+			Type:        TypeString,
+			Description: "The shell of privileged sessions. $SHELL, or bash if it is not set, by default. bash, zsh and fish show the session in their prompt",
+		},
+		{
+			Key:         SessionTerminalTitle,
+			Type:        TypeBool,
+			Default:     true,
+			Description: "When set to 'true', the terminal title shows the service account and remaining time of privileged sessions",
+		},
+		{
+			Key:         SessionWarnings,
+			Type:        TypeStrings,
+			Default:     []string{"5m", "1m"},
+			Description: "How long before a privileged session expires to warn that it is about to, e.g. '5m,1m'",
+		},
+		{
+			Key:         DefaultServiceAccounts + ".<project>",
+			Type:        TypeString,
+			Description: "The default service account of a project, set via the 'default-service-accounts' command. Stored in the active profile",
+			Managed:     "please use the 'default-service-accounts' commands to edit configured default service accounts",
+		},
+		{
+			Key:         TokenMaxDuration,
+			Type:        TypeDuration,
+			Default:     "1h",
+			Description: "The longest duration that may be requested for a service account token, e.g. '1h'",
+		},
+		{
+			Key:         UpdatesChannel,
+			Type:        TypeString,
+			Allowed:     UpdateChannels,
+			Default:     UpdatesStable,
+			Description: "The releases to check for updates",
+		},
+	}
+}
+
+// FindField returns the schema of a setting.
+func FindField(key string) (Field, bool) {
+	parts := strings.Split(strings.ToLower(key), ".")
+	for _, f := range Schema() {
+		if matchKey(strings.Split(f.Key, "."), parts) {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+func matchKey(pattern, parts []string) bool {
+	if len(pattern) != len(parts) {
+		return false
+	}
+	for i, p := range pattern {
+		if parts[i] == "" || (p != parts[i] && !strings.HasPrefix(p, "<")) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasFields reports whether a key is a section that contains settings.
+func hasFields(key string) bool {
+	parts := strings.Split(strings.ToLower(key), ".")
+	for _, f := range Schema() {
+		pattern := strings.Split(f.Key, ".")
+		if len(pattern) > len(parts) && matchKey(pattern[:len(parts)], parts) {
+			return true
+		}
+	}
+	return false
+}
+
+// setDefaults makes the defaults of the schema the lowest layer of the
+// configuration, so that a setting is never empty once it is unset.
+func setDefaults() {
+	for _, f := range Schema() {
+		if f.Default != nil {
+			viper.SetDefault(f.Key, f.Default)
+		}
+	}
+}
+
+// Parse converts a value given on the command line to the type of the
+// setting.
+func (f Field) Parse(value string) (interface{}, error) {
+	switch f.Type {
+	case TypeBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("the %s value must be either true or false", f.Key)
+		}
+		return b, nil
+	case TypeInt:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("the %s value must be an integer", f.Key)
+		}
+		return i, nil
+	case TypeStrings:
+		values := []string{}
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		return values, nil
+	case TypeList, TypeMap:
+		return nil, fmt.Errorf("the %s value cannot be set from the command line", f.Key)
+	}
+	return value, f.Check(value)
+}
+
+// Check reports whether a value read from a configuration or policy file is
+// valid for the setting.
+func (f Field) Check(value interface{}) error {
+	switch f.Type {
+	case TypeBool:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("the %s value must be either true or false", f.Key)
+		}
+		return nil
+	case TypeInt:
+		switch v := value.(type) {
+		case int:
+			return nil
+		case string:
+			if _, err := strconv.Atoi(v); err == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("the %s value must be an integer", f.Key)
+	case TypeStrings:
+		items, ok := value.([]interface{})
+		if !ok {
+			if _, ok := value.([]string); ok {
+				return nil
+			}
+			return fmt.Errorf("the %s value must be a list of strings", f.Key)
+		}
+		for _, item := range items {
+			if _, ok := item.(string); !ok {
+				return fmt.Errorf("the %s value must be a list of strings", f.Key)
+			}
+		}
+		return nil
+	case TypeList:
+		if _, ok := value.([]interface{}); !ok {
+			return fmt.Errorf("the %s value must be a list", f.Key)
+		}
+		return nil
+	case TypeMap:
+		if _, ok := value.(map[string]interface{}); !ok && value != nil {
+			return fmt.Errorf("the %s value must be a map", f.Key)
+		}
+		return nil
+	case TypePlugin:
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return fmt.Errorf("the %s value must be a single value", f.Key)
+		}
+		return nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("the %s value must be a string", f.Key)
+	}
+	if len(f.Allowed) > 0 && !util.Contains(f.Allowed, s) {
+		return fmt.Errorf("the %s value must be one of %v", f.Key, f.Allowed)
+	}
+	switch f.Type {
+	case TypeDuration:
+		if _, err := time.ParseDuration(s); err != nil {
+			return fmt.Errorf("the %s value must be a duration such as '30m'", f.Key)
+		}
+	case TypeRegexp:
+		if _, err := regexp.Compile(s); err != nil {
+			return fmt.Errorf("the %s value must be a valid regular expression: %v", f.Key, err)
+		}
+	case TypeTemplate:
+		if _, err := template.New(f.Key).Parse(s); err != nil {
+			return fmt.Errorf("the %s value must be a valid template: %v", f.Key, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the configuration file and its profiles against the schema
+// and returns every problem that it finds. checkPlugin, if it is not nil, is
+// called to check the settings of plugins.
+func Validate(checkPlugin func(key string, value interface{}) error) []error {
+	var errs []error
+	base := make(map[string]interface{}, len(fileConfig))
+	for key, value := range fileConfig {
+		if key != Profiles {
+			base[key] = value
+		}
+	}
+	validateSection("", base, "", checkPlugin, &errs)
+
+	for _, name := range ProfileNames()[1:] {
+		settings, _ := profileSettings(name)
+		validateSection("", settings, Profiles+"."+name+".", checkPlugin, &errs)
+	}
+	return errs
+}
+
+// validateSection checks the settings in a section of the configuration.
+// display is prepended to the keys in errors.
+func validateSection(prefix string, section map[string]interface{}, display string, checkPlugin func(string, interface{}) error, errs *[]error) {
+	keys := make([]string, 0, len(section))
+	for key := range section {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		key := strings.ToLower(k)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		value := section[k]
+		if f, ok := FindField(key); ok {
+			err := f.Check(value)
+			if err == nil && f.Type == TypePlugin && checkPlugin != nil {
+				err = checkPlugin(key, value)
+			}
+			if err != nil {
+				*errs = append(*errs, fmt.Errorf("%s%s: %v", display, key, err))
+			}
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok && hasFields(key) {
+			validateSection(key, nested, display, checkPlugin, errs)
+			continue
+		}
+		*errs = append(*errs, fmt.Errorf("%s%s: unknown setting", display, key))
+	}
+}
+
+// ListEntries returns the entries of a list setting, such as hooks, so that
+// each of them can be decoded and validated on its own with DecodeEntry.
+func ListEntries(key string) ([]map[string]interface{}, error) {
+	var entries []map[string]interface{}
+	if err := viper.UnmarshalKey(key, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// DecodeEntry decodes an entry returned by ListEntries into out the same way
+// viper decodes settings.
+func DecodeEntry(entry map[string]interface{}, out interface{}) error {
+	v := viper.New()
+	v.Set("entry", entry)
+	return v.UnmarshalKey("entry", out)
+}
+
+// UnsetProfile removes a setting from the active profile, or from the base
+// configuration if no profile is active, so that the value underneath it is
+// used. It reports whether the setting was set. The change takes effect when
+// it is saved by WriteConfig.
+func UnsetProfile(key string) bool {
+	if activeProfile == "" {
+		return deletePath(fileConfig, key)
+	}
+	settings, _ := profileSettings(activeProfile)
+	return deletePath(settings, key)
+}
+
+// deletePath removes a setting and the sections that it leaves empty.
+func deletePath(m map[string]interface{}, key string) bool {
+	parts := strings.SplitN(strings.ToLower(key), ".", 2)
+	if len(parts) == 1 {
+		_, ok := m[parts[0]]
+		delete(m, parts[0])
+		return ok
+	}
+	next, ok := m[parts[0]].(map[string]interface{})
+	if !ok || !deletePath(next, parts[1]) {
+		return false
+	}
+	if len(next) == 0 {
+		delete(m, parts[0])
+	}
+	return true
+}
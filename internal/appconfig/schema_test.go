@@ -0,0 +1,135 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appconfig
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindField(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{LoggingLevel, LoggingLevel},
+		{"Logging.Level", LoggingLevel},
+		{"pluginruntime.timeouts.my-plugin", PluginRuntimeTimeouts + ".<name>"},
+		{"plugins.my-plugin.apikey", "plugins.<name>.<field>"},
+		{"pluginruntime.timeouts.", ""},
+		{"logging", ""},
+		{"logging.level.extra", ""},
+	}
+	for _, tt := range tests {
+		f, ok := FindField(tt.key)
+		if ok != (tt.want != "") || f.Key != tt.want {
+			t.Errorf("FindField(%q) = %q, %v, want %q", tt.key, f.Key, ok, tt.want)
+		}
+	}
+}
+
+func TestFieldParse(t *testing.T) {
+	tests := []struct {
+		key, value string
+		want       interface{}
+		wantErr    bool
+	}{
+		{AuthProxyVerbose, "true", true, false},
+		{AuthProxyVerbose, "maybe", nil, true},
+		{AuthProxyPort, "8085", 8085, false},
+		{AuthProxyPort, "port", nil, true},
+		{LoggingFormat, "json", "json", false},
+		{LoggingFormat, "xml", nil, true},
+		{TokenMaxDuration, "45m", "45m", false},
+		{TokenMaxDuration, "a while", nil, true},
+		{ReasonPattern, "[A-Z]+-[0-9]+", "[A-Z]+-[0-9]+", false},
+		{ReasonPattern, "[A-Z", nil, true},
+		{AuthProxyAllowedHosts, "*.googleapis.com, ,*.example.com", []string{"*.googleapis.com", "*.example.com"}, false},
+		{Hooks, "hook", nil, true},
+	}
+	for _, tt := range tests {
+		f, _ := FindField(tt.key)
+		got, err := f.Parse(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%s, %q) error = %v, wantErr %v", tt.key, tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%s, %q) = %#v, want %#v", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	fileConfig = map[string]interface{}{
+		"logging": map[string]interface{}{"level": "info", "format": "xml"},
+		"authproxy": map[string]interface{}{
+			"proxyport":    "8084",
+			"allowedhosts": []interface{}{"*.googleapis.com", 1},
+		},
+		"unknown": true,
+		"plugins": map[string]interface{}{"my-plugin": map[string]interface{}{"apikey": "secret"}},
+		"profiles": map[string]interface{}{
+			"prod": map[string]interface{}{"tokens": map[string]interface{}{"maxduration": "1y"}},
+		},
+	}
+	defer func() { fileConfig = map[string]interface{}{} }()
+
+	var checked []string
+	errs := Validate(func(key string, value interface{}) error {
+		checked = append(checked, key)
+		return nil
+	})
+	want := []string{
+		"authproxy.allowedhosts:",
+		"logging.format:",
+		"unknown: unknown setting",
+		"profiles.prod.tokens.maxduration:",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got %v", len(want), errs)
+	}
+	for i, err := range errs {
+		if !strings.HasPrefix(err.Error(), want[i]) {
+			t.Errorf("error %d = %q, want prefix %q", i, err, want[i])
+		}
+	}
+	if !reflect.DeepEqual(checked, []string{"plugins.my-plugin.apikey"}) {
+		t.Errorf("expected the plugin setting to be checked, got %v", checked)
+	}
+}
+
+func TestDeletePath(t *testing.T) {
+	m := map[string]interface{}{
+		"logging": map[string]interface{}{"level": "info"},
+		"authproxy": map[string]interface{}{
+			"proxyport": 8084,
+			"verbose":   true,
+		},
+	}
+	if !deletePath(m, "Logging.Level") {
+		t.Error("expected logging.level to be deleted")
+	}
+	if _, ok := m["logging"]; ok {
+		t.Error("expected the empty logging section to be removed")
+	}
+	if !deletePath(m, AuthProxyPort) || deletePath(m, AuthProxyPort) {
+		t.Error("expected authproxy.proxyport to be deleted once")
+	}
+	if _, ok := getPath(m, AuthProxyVerbose); !ok {
+		t.Error("expected authproxy.verbose to be kept")
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/viper"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	"github.com/replit/ephemeral-iam/internal/secrets"
+)
+
+// SecretsPassphraseEnv is the environment variable that holds the passphrase
+// of the secrets file when secrets.keysource is 'passphrase'.
+const SecretsPassphraseEnv = "EIAM_SECRETS_PASSPHRASE" //nolint:gosec // Not hardcoded credentials
+
+// The values of the secrets.keysource setting.
+const (
+	KeySourceFile       = "keyfile"
+	KeySourcePassphrase = "passphrase"
+)
+
+// SecretsKeySources are the valid values of the secrets.keysource setting.
+var SecretsKeySources = []string{KeySourceFile, KeySourcePassphrase}
+
+var (
+	secretStore     secrets.Store
+	secretStoreOnce sync.Once
+)
+
+// secretsKeyName is the file name of the key of the secrets file.
+const secretsKeyName = "secrets.key"
+
+// DefaultSecretsKeyFile returns the default location of the key of the
+// secrets file: secrets.key in $XDG_DATA_HOME/ephemeral-iam, or in
+// ~/.local/share/ephemeral-iam. The key is kept apart from the secrets file so
+// that a copy of the config directory, such as a backup or a dotfiles
+// repository, cannot be decrypted on its own. It does not protect the secrets
+// from programs that run as the user, see the passphrase key source for that.
+func DefaultSecretsKeyFile() string {
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if dataDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return filepath.Join(GetConfigDir(), secretsKeyName)
+		}
+		dataDir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataDir, "ephemeral-iam", secretsKeyName)
+}
+
+// moveLegacyKeyFile moves the key that earlier releases created in the config
+// directory to path, the new default location, if there is no key there yet.
+func moveLegacyKeyFile(path string) error {
+	legacy := filepath.Join(GetConfigDir(), secretsKeyName)
+	if path != DefaultSecretsKeyFile() || path == legacy {
+		return nil
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return nil
+	}
+	data, err := os.ReadFile(legacy)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+	return os.Remove(legacy)
+}
+
+// SecretStore returns the encrypted store that holds the Github access tokens
+// and the secret settings of plugins.
+func SecretStore() secrets.Store {
+	secretStoreOnce.Do(func() {
+		keyFile := viper.GetString(SecretsKeyFile)
+		if err := moveLegacyKeyFile(keyFile); err != nil {
+			util.Logger.WithError(err).Warnf("Failed to move the key of the secrets file to %s", keyFile)
+		}
+		key := secrets.KeyFile(keyFile)
+		if viper.GetString(SecretsKeySource) == KeySourcePassphrase {
+			key = secrets.Passphrase(secretsPassphrase)
+		}
+		secretStore = secrets.NewFileStore(viper.GetString(SecretsFile), key)
+	})
+	return secretStore
+}
+
+func secretsPassphrase() (string, error) {
+	if passphrase := os.Getenv(SecretsPassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	prompt := promptui.Prompt{
+		Label: "Enter the passphrase of the eiam secrets file",
+		Mask:  '●',
+	}
+	return prompt.Run()
+}
+
+// githubTokenSecret is the name of a Github access token in the secret store.
+func githubTokenSecret(name string) string {
+	return GithubTokens + "." + strings.ToLower(name)
+}
+
+// GithubTokenNames returns the names of the configured Github access tokens.
+func GithubTokenNames() []string {
+	tokens := viper.GetStringMap(GithubTokens)
+	names := make([]string, 0, len(tokens))
+	for name := range tokens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GithubTokenSource returns where a Github access token is read from.
+func GithubTokenSource(name string) (secrets.Source, bool) {
+	value, ok := viper.GetStringMap(GithubTokens)[strings.ToLower(name)]
+	if !ok {
+		return secrets.Source{}, false
+	}
+	settings, _ := value.(map[string]interface{})
+	command, _ := settings["command"].(string)
+	env, _ := settings["env"].(string)
+	return secrets.Source{Command: command, Env: env}, true
+}
+
+// GithubToken returns the value of a configured Github access token.
+func GithubToken(name string) (string, error) {
+	src, ok := GithubTokenSource(name)
+	if !ok {
+		return "", fmt.Errorf("no Github token named %s exists", name)
+	}
+	token, err := secrets.Resolve(SecretStore(), githubTokenSecret(name), src)
+	if errors.Is(err, secrets.ErrNotFound) {
+		return "", fmt.Errorf("the Github token %s is missing from %s", name, viper.GetString(SecretsFile))
+	}
+	return token, err
+}
+
+// AddGithubToken configures a Github access token. The token is saved in the
+// secret store unless src says where to read it from. The change is saved by
+// WriteConfig.
+func AddGithubToken(name, token string, src secrets.Source) error {
+	settings := map[string]interface{}{}
+	if src.IsZero() {
+		if err := SecretStore().Set(githubTokenSecret(name), token); err != nil {
+			return err
+		}
+	} else {
+		if src.Command != "" {
+			settings["command"] = src.Command
+		}
+		if src.Env != "" {
+			settings["env"] = src.Env
+		}
+		// Remove a token that this one replaces.
+		if err := SecretStore().Delete(githubTokenSecret(name)); err != nil && !errors.Is(err, secrets.ErrNotFound) {
+			return err
+		}
+	}
+	Set(githubTokenSecret(name), settings)
+	Set(GithubAuth, true)
+	return nil
+}
+
+// DeleteGithubToken removes a Github access token from the configuration and
+// the secret store. The change is saved by WriteConfig.
+func DeleteGithubToken(name string) error {
+	if err := SecretStore().Delete(githubTokenSecret(name)); err != nil && !errors.Is(err, secrets.ErrNotFound) {
+		return err
+	}
+	deletePath(fileConfig, githubTokenSecret(name))
+	if tokens, _ := getPath(fileConfig, GithubTokens); tokens == nil {
+		Set(GithubAuth, false)
+	}
+	return nil
+}
+
+// SecretSetting returns the value of a secret setting that is kept in the
+// secret store, or read from the command or environment variable named by the
+// commandKey and envKey settings. It returns an empty string if the secret is
+// not configured.
+func SecretSetting(key, commandKey, envKey string) (string, error) {
+	src := secrets.Source{
+		Command: viper.GetString(commandKey),
+		Env:     viper.GetString(envKey),
+	}
+	value, err := secrets.Resolve(SecretStore(), key, src)
+	if errors.Is(err, secrets.ErrNotFound) {
+		return "", nil
+	}
+	return value, err
+}
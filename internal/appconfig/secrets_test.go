@@ -0,0 +1,72 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestMoveLegacyKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	configDir, once = filepath.Join(dir, "config"), sync.Once{}
+	once.Do(func() {})
+	defer func() { configDir, once = "", sync.Once{} }()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "data"))
+
+	legacy := filepath.Join(configDir, "secrets.key")
+	if err := os.MkdirAll(configDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(legacy, []byte("legacy-key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile := DefaultSecretsKeyFile()
+	if want := filepath.Join(dir, "data", "ephemeral-iam", "secrets.key"); keyFile != want {
+		t.Fatalf("DefaultSecretsKeyFile = %s, want %s", keyFile, want)
+	}
+	if err := moveLegacyKeyFile(keyFile); err != nil {
+		t.Fatalf("moveLegacyKeyFile failed: %v", err)
+	}
+	if data, err := os.ReadFile(keyFile); err != nil || string(data) != "legacy-key" {
+		t.Errorf("key file = %q, %v, want the legacy key", data, err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("expected the legacy key file to be removed, got %v", err)
+	}
+
+	// A key at the new location is never overwritten.
+	if err := os.WriteFile(legacy, []byte("other-key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveLegacyKeyFile(keyFile); err != nil {
+		t.Fatalf("moveLegacyKeyFile failed: %v", err)
+	}
+	if data, _ := os.ReadFile(keyFile); string(data) != "legacy-key" {
+		t.Errorf("key file = %q, want it to be kept", data)
+	}
+
+	// A key file set explicitly in the config is used as is.
+	custom := filepath.Join(dir, "custom.key")
+	if err := moveLegacyKeyFile(custom); err != nil {
+		t.Fatalf("moveLegacyKeyFile failed: %v", err)
+	}
+	if _, err := os.Stat(custom); !os.IsNotExist(err) {
+		t.Errorf("expected %s not to be created, got %v", custom, err)
+	}
+}
@@ -32,6 +32,10 @@ import (
 	"github.com/replit/ephemeral-iam/internal/gcpclient"
 )
 
+// adcIdentity is the email of the application default credentials, which is
+// set once they have been checked.
+var adcIdentity string
+
 // Setup ensures that the prequisites for running ephemeral-iam are met.
 func Setup() error {
 	if err := checkValidADCExists(); err != nil {
@@ -78,11 +82,22 @@ func checkValidADCExists() error {
 			return errorsutil.New("Failed to parse OAuth token", err)
 		}
 
+		adcIdentity = tokenInfo.Email
 		return checkADCIdentity(tokenInfo.Email)
 	}
 	return nil
 }
 
+// Identity returns the email of the user that eiam acts as: the identity of
+// the application default credentials, or the active gcloud account if they
+// have not been checked.
+func Identity() (string, error) {
+	if adcIdentity != "" {
+		return adcIdentity, nil
+	}
+	return gcpclient.CheckActiveAccountSet()
+}
+
 // checkADCIdentity checks the active account set in the users gcloud config
 // against the identity associated with the application default credentials.
 func checkADCIdentity(tokenEmail string) error {
@@ -21,10 +21,21 @@ import (
 
 	"github.com/google/go-github/v33/github"
 	"github.com/manifoldco/promptui"
+	"github.com/spf13/viper"
 
 	util "github.com/replit/ephemeral-iam/internal/eiamutil"
 )
 
+// The values of the updates.channel setting.
+const (
+	UpdatesStable     = "stable"
+	UpdatesPrerelease = "prerelease"
+	UpdatesDisabled   = "disabled"
+)
+
+// UpdateChannels are the valid values of the updates.channel setting.
+var UpdateChannels = []string{UpdatesStable, UpdatesPrerelease, UpdatesDisabled}
+
 var (
 	repoOwner = "rigup"
 	repoName  = "ephemeral-iam"
@@ -34,9 +45,22 @@ var (
 	Version = "v0.0.0"
 )
 
-// CheckForNewRelease checks to see if there is a new version of eiam available.
+// CheckForNewRelease checks to see if there is a new version of eiam available
+// on the channel in the updates.channel setting.
 func CheckForNewRelease() {
-	release, err := util.GetLatestRelease(repoOwner, repoName, "")
+	var (
+		release *github.RepositoryRelease
+		err     error
+	)
+	switch viper.GetString(UpdatesChannel) {
+	case UpdatesDisabled:
+		util.Logger.Debug("Update checks are disabled")
+		return
+	case UpdatesPrerelease:
+		release, err = util.GetLatestPrerelease(repoOwner, repoName, "")
+	default:
+		release, err = util.GetLatestRelease(repoOwner, repoName, "")
+	}
 	if err != nil {
 		util.Logger.WithError(err).Error("Failed to get latest release, skipping update")
 		return
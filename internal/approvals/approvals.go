@@ -0,0 +1,218 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package approvals asks a second engineer, through an approval server, to
+// approve privileged sessions for sensitive service accounts.
+package approvals
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Status is the state of an approval request.
+type Status string
+
+// The states of an approval request. A request is pending until it is
+// decided, it expires, or the requester cancels it.
+const (
+	Pending   Status = "pending"
+	Approved  Status = "approved"
+	Denied    Status = "denied"
+	Expired   Status = "expired"
+	Cancelled Status = "cancelled"
+)
+
+// maxResponseSize limits how much of a response is read.
+const maxResponseSize = 1 << 20
+
+// Request is a request for approval of a privileged session.
+type Request struct {
+	ID             string    `json:"id,omitempty"`
+	Requester      string    `json:"requester"`
+	Project        string    `json:"project,omitempty"`
+	ServiceAccount string    `json:"serviceAccount"`
+	Reason         string    `json:"reason"`
+	Duration       string    `json:"duration,omitempty"`
+	Status         Status    `json:"status,omitempty"`
+	Approver       string    `json:"approver,omitempty"`
+	Comment        string    `json:"comment,omitempty"`
+	CreatedAt      time.Time `json:"createdAt,omitempty"`
+}
+
+// Decision is the body of an approve or deny call.
+type Decision struct {
+	Approver string `json:"approver"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// Client calls the HTTP API of an approval server:
+//
+//	POST   /requests               creates a request and returns it with its ID
+//	GET    /requests?status=S      lists the requests, optionally with a status
+//	GET    /requests/ID            returns a request
+//	POST   /requests/ID/approve    approves a request with a Decision
+//	POST   /requests/ID/deny       denies a request with a Decision
+//	DELETE /requests/ID            cancels a pending request
+//
+// Errors are returned with a non-2xx status and an optional JSON body of the
+// form {"error": "message"}.
+type Client struct {
+	URL   string
+	Token string
+	HTTP  *http.Client
+}
+
+// Create submits a request for approval.
+func (c *Client) Create(ctx context.Context, req Request) (*Request, error) {
+	var created Request
+	if err := c.do(ctx, http.MethodPost, "/requests", req, &created); err != nil {
+		return nil, err
+	}
+	if created.ID == "" {
+		return nil, fmt.Errorf("the approval server did not return the ID of the request")
+	}
+	return &created, nil
+}
+
+// Get returns a request.
+func (c *Client) Get(ctx context.Context, id string) (*Request, error) {
+	var req Request
+	if err := c.do(ctx, http.MethodGet, "/requests/"+url.PathEscape(id), nil, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// List returns the requests with a status, or all requests if status is
+// empty.
+func (c *Client) List(ctx context.Context, status Status) ([]Request, error) {
+	path := "/requests"
+	if status != "" {
+		path += "?status=" + url.QueryEscape(string(status))
+	}
+	var reqs []Request
+	if err := c.do(ctx, http.MethodGet, path, nil, &reqs); err != nil {
+		return nil, err
+	}
+	return reqs, nil
+}
+
+// Approve approves a request.
+func (c *Client) Approve(ctx context.Context, id string, d Decision) (*Request, error) {
+	var req Request
+	if err := c.do(ctx, http.MethodPost, "/requests/"+url.PathEscape(id)+"/approve", d, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// Deny denies a request.
+func (c *Client) Deny(ctx context.Context, id string, d Decision) (*Request, error) {
+	var req Request
+	if err := c.do(ctx, http.MethodPost, "/requests/"+url.PathEscape(id)+"/deny", d, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// Cancel withdraws a pending request.
+func (c *Client) Cancel(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/requests/"+url.PathEscape(id), nil, nil)
+}
+
+// Wait polls a request every interval until it is no longer pending or ctx is
+// done, in which case ctx.Err() is returned. poll, if it is not nil, is called with the request after every poll.
+func (c *Client) Wait(ctx context.Context, id string, interval time.Duration, poll func(*Request)) (*Request, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		req, err := c.Get(ctx, id)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		} else if err != nil {
+			return nil, err
+		}
+		if poll != nil {
+			poll(req)
+		}
+		if req.Status != Pending {
+			return req, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.URL, "/")+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the approval server: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("failed to read the response of the approval server: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("the approval server responded with %s: %s", resp.Status, apiErr.Error)
+		}
+		return fmt.Errorf("the approval server responded with %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("the approval server responded with invalid JSON: %v", err)
+	}
+	return nil
+}
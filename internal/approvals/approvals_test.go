@@ -0,0 +1,132 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package approvals
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubServer is an in-memory approval server.
+type stubServer struct {
+	mu       sync.Mutex
+	requests map[string]*Request
+}
+
+func (s *stubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": "bad token"}`))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 1:
+		var req Request
+		json.NewDecoder(r.Body).Decode(&req)
+		req.ID = fmt.Sprintf("req-%d", len(s.requests)+1)
+		req.Status = Pending
+		s.requests[req.ID] = &req
+		json.NewEncoder(w).Encode(req)
+	case r.Method == http.MethodGet && len(parts) == 1:
+		reqs := []Request{}
+		for _, req := range s.requests {
+			if status := r.URL.Query().Get("status"); status == "" || string(req.Status) == status {
+				reqs = append(reqs, *req)
+			}
+		}
+		json.NewEncoder(w).Encode(reqs)
+	case len(parts) >= 2 && s.requests[parts[1]] != nil:
+		req := s.requests[parts[1]]
+		switch {
+		case r.Method == http.MethodDelete:
+			req.Status = Cancelled
+		case r.Method == http.MethodPost && len(parts) == 3:
+			var d Decision
+			json.NewDecoder(r.Body).Decode(&d)
+			req.Approver, req.Comment = d.Approver, d.Comment
+			req.Status = Approved
+			if parts[2] == "deny" {
+				req.Status = Denied
+			}
+		}
+		json.NewEncoder(w).Encode(req)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestClient(t *testing.T) {
+	stub := &stubServer{requests: map[string]*Request{}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	client := &Client{URL: server.URL, Token: "secret"}
+	ctx := context.Background()
+
+	created, err := client.Create(ctx, Request{Requester: "a@example.com", ServiceAccount: "sa@example.com", Reason: "INC-1"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	pending, err := client.List(ctx, Pending)
+	if err != nil || len(pending) != 1 || pending[0].ID != created.ID {
+		t.Fatalf("List(pending) = %v, %v, want the created request", pending, err)
+	}
+
+	polls := 0
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		if _, err := client.Approve(ctx, created.ID, Decision{Approver: "b@example.com"}); err != nil {
+			t.Errorf("Approve failed: %v", err)
+		}
+	}()
+	decided, err := client.Wait(ctx, created.ID, 5*time.Millisecond, func(*Request) { polls++ })
+	if err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if decided.Status != Approved || decided.Approver != "b@example.com" {
+		t.Errorf("Wait() = %+v, want approved by b@example.com", decided)
+	}
+	if polls < 2 {
+		t.Errorf("poll was called %d times, want at least 2", polls)
+	}
+
+	second, _ := client.Create(ctx, Request{Requester: "a@example.com", ServiceAccount: "sa@example.com"})
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.Wait(timeoutCtx, second.ID, 5*time.Millisecond, nil); err != context.DeadlineExceeded {
+		t.Errorf("Wait() error = %v, want deadline exceeded", err)
+	}
+	if err := client.Cancel(ctx, second.ID); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if req, _ := client.Get(ctx, second.ID); req.Status != Cancelled {
+		t.Errorf("status after Cancel = %s, want cancelled", req.Status)
+	}
+
+	client.Token = "wrong"
+	if _, err := client.Get(ctx, created.ID); err == nil || !strings.Contains(err.Error(), "bad token") {
+		t.Errorf("Get with a wrong token = %v, want the server's error", err)
+	}
+}
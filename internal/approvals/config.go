@@ -0,0 +1,60 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package approvals
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+// requestTimeout is how long a single call to the approval server may take.
+const requestTimeout = 30 * time.Second
+
+// Required reports whether sessions for a service account must be approved,
+// which is when it matches one of the patterns in approvals.serviceaccounts.
+func Required(serviceAccountEmail string) bool {
+	for _, pattern := range viper.GetStringSlice(appconfig.ApprovalsAccounts) {
+		if ok, _ := path.Match(pattern, serviceAccountEmail); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// NewClient returns a client for the approval server in the approvals.url
+// setting.
+func NewClient() (*Client, error) {
+	serverURL := viper.GetString(appconfig.ApprovalsURL)
+	if serverURL == "" {
+		return nil, fmt.Errorf("the %s setting is not set", appconfig.ApprovalsURL)
+	}
+	token, err := appconfig.SecretSetting(
+		appconfig.ApprovalsToken, appconfig.ApprovalsTokenCommand, appconfig.ApprovalsTokenEnv)
+	if err != nil {
+		return nil, errorsutil.New("Failed to read the approval server token", err)
+	}
+	return &Client{
+		URL:   serverURL,
+		Token: token,
+		HTTP:  &http.Client{Timeout: requestTimeout},
+	}, nil
+}
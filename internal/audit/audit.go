@@ -0,0 +1,238 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit searches Cloud Audit Logs for the API calls of an eiam
+// session. FormatReason puts the session ID into the reason of every call,
+// which is logged in protoPayload.requestMetadata.requestAttributes.reason.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	logging "google.golang.org/api/logging/v2"
+	"google.golang.org/api/option"
+
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+var sessionIDRegex = regexp.MustCompile(`^[[:xdigit:]]{16}$`)
+
+// errLimit stops the search once the limit of a query is reached.
+var errLimit = errors.New("limit reached")
+
+// Query selects the audit log entries of a session.
+type Query struct {
+	SessionID string
+	Projects  []string
+
+	// Since and Until limit the time of the entries. Until is not used if it
+	// is zero.
+	Since time.Time
+	Until time.Time
+
+	// Limit is the most calls to return, or zero for all of them.
+	Limit int
+}
+
+// Call is an API call recorded in the audit logs.
+type Call struct {
+	Time      time.Time `json:"time"`
+	Project   string    `json:"project"`
+	Principal string    `json:"principal"`
+	Service   string    `json:"service"`
+	Method    string    `json:"method"`
+	Resource  string    `json:"resource"`
+	Code      int64     `json:"code"`
+	Status    string    `json:"status"`
+	Log       string    `json:"log"`
+}
+
+// Failed reports whether the call failed.
+func (c Call) Failed() bool {
+	return c.Code != 0
+}
+
+// MethodCount is the number of calls of a method.
+type MethodCount struct {
+	Method string `json:"method"`
+	Calls  int    `json:"calls"`
+	Failed int    `json:"failed"`
+}
+
+// Summary describes the API calls of a session.
+type Summary struct {
+	SessionID string        `json:"sessionId"`
+	Projects  []string      `json:"projects"`
+	Calls     []Call        `json:"calls"`
+	Failed    int           `json:"failed"`
+	Methods   []MethodCount `json:"methods"`
+}
+
+// Client searches Cloud Logging.
+type Client struct {
+	svc *logging.Service
+}
+
+// NewClient returns a Client. opts can point it at another endpoint, such as
+// a fake Logging server in tests.
+func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error) {
+	svc, err := logging.NewService(ctx, opts...)
+	if err != nil {
+		return nil, errorsutil.NewSDKError("Cloud Logging", "", err)
+	}
+	return &Client{svc: svc}, nil
+}
+
+// CheckSessionID checks that id has the format of the session IDs that
+// FormatReason generates.
+func CheckSessionID(id string) error {
+	if !sessionIDRegex.MatchString(id) {
+		return fmt.Errorf("%q is not a session ID, which has 16 hexadecimal digits", id)
+	}
+	return nil
+}
+
+// Filter returns the Cloud Logging filter of q.
+func Filter(q Query) string {
+	clauses := []string{
+		`logName:"cloudaudit.googleapis.com"`,
+		fmt.Sprintf(`protoPayload.requestMetadata.requestAttributes.reason:"ephemeral-iam %s"`, q.SessionID),
+	}
+	if !q.Since.IsZero() {
+		clauses = append(clauses, fmt.Sprintf(`timestamp>=%q`, q.Since.UTC().Format(time.RFC3339)))
+	}
+	if !q.Until.IsZero() {
+		clauses = append(clauses, fmt.Sprintf(`timestamp<%q`, q.Until.UTC().Format(time.RFC3339)))
+	}
+	return strings.Join(clauses, " AND ")
+}
+
+// Search returns the API calls of the session of q, oldest first.
+func (c *Client) Search(ctx context.Context, q Query) ([]Call, error) {
+	resources := make([]string, len(q.Projects))
+	for i, project := range q.Projects {
+		resources[i] = "projects/" + project
+	}
+	req := &logging.ListLogEntriesRequest{
+		ResourceNames: resources,
+		Filter:        Filter(q),
+		OrderBy:       "timestamp asc",
+		PageSize:      1000,
+	}
+
+	var calls []Call
+	err := c.svc.Entries.List(req).Pages(ctx, func(resp *logging.ListLogEntriesResponse) error {
+		for _, entry := range resp.Entries {
+			call, err := parseEntry(entry)
+			if err != nil {
+				return err
+			}
+			calls = append(calls, call)
+			if q.Limit > 0 && len(calls) >= q.Limit {
+				return errLimit
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errLimit) {
+		return nil, errorsutil.New("Failed to search the audit logs", err)
+	}
+	return calls, nil
+}
+
+// Summarize returns the summary of the calls of a session.
+func Summarize(q Query, calls []Call) *Summary {
+	s := &Summary{SessionID: q.SessionID, Projects: q.Projects, Calls: calls}
+	if s.Calls == nil {
+		s.Calls = []Call{}
+	}
+	counts := map[string]*MethodCount{}
+	for _, call := range calls {
+		count, ok := counts[call.Method]
+		if !ok {
+			count = &MethodCount{Method: call.Method}
+			counts[call.Method] = count
+		}
+		count.Calls++
+		if call.Failed() {
+			count.Failed++
+			s.Failed++
+		}
+	}
+	s.Methods = []MethodCount{}
+	for _, count := range counts {
+		s.Methods = append(s.Methods, *count)
+	}
+	sort.Slice(s.Methods, func(i, j int) bool {
+		if s.Methods[i].Calls != s.Methods[j].Calls {
+			return s.Methods[i].Calls > s.Methods[j].Calls
+		}
+		return s.Methods[i].Method < s.Methods[j].Method
+	})
+	return s
+}
+
+// auditLog is the part of the AuditLog protoPayload that eiam reports.
+type auditLog struct {
+	ServiceName        string `json:"serviceName"`
+	MethodName         string `json:"methodName"`
+	ResourceName       string `json:"resourceName"`
+	AuthenticationInfo struct {
+		PrincipalEmail string `json:"principalEmail"`
+	} `json:"authenticationInfo"`
+	Status struct {
+		Code    int64  `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+func parseEntry(entry *logging.LogEntry) (Call, error) {
+	var payload auditLog
+	if len(entry.ProtoPayload) > 0 {
+		if err := json.Unmarshal(entry.ProtoPayload, &payload); err != nil {
+			return Call{}, errorsutil.New(fmt.Sprintf("Failed to parse audit log entry %s", entry.InsertId), err)
+		}
+	}
+	call := Call{
+		Principal: payload.AuthenticationInfo.PrincipalEmail,
+		Service:   payload.ServiceName,
+		Method:    payload.MethodName,
+		Resource:  payload.ResourceName,
+		Code:      payload.Status.Code,
+		Status:    "OK",
+		Log:       entry.LogName,
+	}
+	if call.Failed() {
+		call.Status = payload.Status.Message
+		if call.Status == "" {
+			call.Status = fmt.Sprintf("code %d", call.Code)
+		}
+	}
+	if t, err := time.Parse(time.RFC3339Nano, entry.Timestamp); err == nil {
+		call.Time = t
+	}
+	// The log name is projects/<project>/logs/cloudaudit.googleapis.com%2F<kind>.
+	if parts := strings.SplitN(entry.LogName, "/", 4); len(parts) == 4 && parts[0] == "projects" {
+		call.Project = parts[1]
+		call.Log = strings.TrimPrefix(parts[3], "cloudaudit.googleapis.com%2F")
+	}
+	return call, nil
+}
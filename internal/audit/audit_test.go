@@ -0,0 +1,148 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	logging "google.golang.org/api/logging/v2"
+	"google.golang.org/api/option"
+)
+
+// fakeLogging serves entries:list from entries, two per page.
+func fakeLogging(t *testing.T, entries []map[string]interface{}, requests *[]logging.ListLogEntriesRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/entries:list" {
+			http.NotFound(w, r)
+			return
+		}
+		var req logging.ListLogEntriesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		*requests = append(*requests, req)
+
+		start := 0
+		if req.PageToken != "" {
+			fmt.Sscan(req.PageToken, &start)
+		}
+		end := start + 2
+		resp := map[string]interface{}{}
+		if end < len(entries) {
+			resp["nextPageToken"] = fmt.Sprint(end)
+		} else {
+			end = len(entries)
+		}
+		resp["entries"] = entries[start:end]
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func auditEntry(method string, code int, at time.Time) map[string]interface{} {
+	payload := map[string]interface{}{
+		"@type":              "type.googleapis.com/google.cloud.audit.AuditLog",
+		"serviceName":        "compute.googleapis.com",
+		"methodName":         method,
+		"resourceName":       "projects/prod/zones/us-central1-a/instances/vm-1",
+		"authenticationInfo": map[string]string{"principalEmail": "admin@prod.iam.gserviceaccount.com"},
+	}
+	if code != 0 {
+		payload["status"] = map[string]interface{}{"code": code, "message": "PERMISSION_DENIED"}
+	}
+	return map[string]interface{}{
+		"insertId":     method,
+		"logName":      "projects/prod/logs/cloudaudit.googleapis.com%2Factivity",
+		"timestamp":    at.Format(time.RFC3339Nano),
+		"protoPayload": payload,
+	}
+}
+
+func TestSearch(t *testing.T) {
+	at := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	entries := []map[string]interface{}{
+		auditEntry("v1.compute.instances.stop", 0, at),
+		auditEntry("v1.compute.instances.start", 7, at.Add(time.Second)),
+		auditEntry("v1.compute.instances.start", 0, at.Add(2*time.Second)),
+	}
+	var requests []logging.ListLogEntriesRequest
+	server := fakeLogging(t, entries, &requests)
+	defer server.Close()
+
+	client, err := NewClient(context.Background(), option.WithEndpoint(server.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := Query{SessionID: "0123456789abcdef", Projects: []string{"prod"}, Since: at.Add(-time.Hour)}
+	calls, err := client.Search(context.Background(), q)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(requests) != 2 {
+		t.Errorf("expected 2 pages to be requested, got %d", len(requests))
+	}
+	if req := requests[0]; len(req.ResourceNames) != 1 || req.ResourceNames[0] != "projects/prod" ||
+		!strings.Contains(req.Filter, `requestAttributes.reason:"ephemeral-iam 0123456789abcdef"`) ||
+		!strings.Contains(req.Filter, `timestamp>="2021-06-01T11:00:00Z"`) {
+		t.Errorf("unexpected request: %+v", req)
+	}
+
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 calls, got %d", len(calls))
+	}
+	want := Call{
+		Time:      at.Add(time.Second),
+		Project:   "prod",
+		Principal: "admin@prod.iam.gserviceaccount.com",
+		Service:   "compute.googleapis.com",
+		Method:    "v1.compute.instances.start",
+		Resource:  "projects/prod/zones/us-central1-a/instances/vm-1",
+		Code:      7,
+		Status:    "PERMISSION_DENIED",
+		Log:       "activity",
+	}
+	if calls[1] != want {
+		t.Errorf("got %+v, want %+v", calls[1], want)
+	}
+
+	summary := Summarize(q, calls)
+	if summary.Failed != 1 || len(summary.Methods) != 2 ||
+		summary.Methods[0] != (MethodCount{Method: "v1.compute.instances.start", Calls: 2, Failed: 1}) {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+
+	q.Limit = 1
+	if calls, err := client.Search(context.Background(), q); err != nil || len(calls) != 1 {
+		t.Errorf("expected the limit to stop the search, got %d calls and %v", len(calls), err)
+	}
+}
+
+func TestCheckSessionID(t *testing.T) {
+	if err := CheckSessionID("0123456789abcdef"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, id := range []string{"", "0123456789abcde", "0123456789abcdeg", "ephemeral-iam 0123456789abcdef"} {
+		if err := CheckSessionID(id); err == nil {
+			t.Errorf("expected an error for %q", id)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"regexp"
 	"sort"
 	"strings"
 	"text/tabwriter"
@@ -28,6 +29,8 @@ import (
 	"github.com/spf13/pflag"
 )
 
+var reasonRegex = regexp.MustCompile(`^ephemeral-iam ([[:xdigit:]]{16}): `)
+
 // FormatReason formats the reason field for logging visibility.
 func FormatReason(reason *string) error {
 	randomID, err := sessionID()
@@ -39,6 +42,16 @@ func FormatReason(reason *string) error {
 	return nil
 }
 
+// ReasonSessionID returns the session ID that FormatReason added to a reason,
+// or an empty string if the reason was not formatted.
+func ReasonSessionID(reason string) string {
+	match := reasonRegex.FindStringSubmatch(reason)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
 func sessionID() (string, error) {
 	idBytes := make([]byte, 8)
 	if _, err := rand.Read(idBytes); err != nil {
@@ -78,11 +91,7 @@ func Confirm(vals map[string]string) {
 }
 
 // SelectToken prompts the user to select an existing Github personal access token.
-func SelectToken(tokenConfig map[string]string) (string, error) {
-	tokenNames := []string{}
-	for name := range tokenConfig {
-		tokenNames = append(tokenNames, name)
-	}
+func SelectToken(tokenNames []string) (string, error) {
 	templates := &promptui.SelectTemplates{
 		Label:    "{{ . }}",
 		Active:   " ►  {{ . | blue }}",
@@ -14,6 +14,10 @@ import (
 	"strings"
 )
 
+// MaxDownloadSize is the largest file that will be written while downloading
+// or extracting a release.
+const MaxDownloadSize = 2 << (10 * 3)
+
 func MoveFile(src, dst string) error {
 	inputFile, err := os.Open(src)
 	if err != nil {
@@ -61,13 +65,19 @@ func DownloadAndExtract(url, tmpDir, token string) error {
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		return errors.New(resp.Status)
 	}
-	defer resp.Body.Close()
 
 	Logger.Info("Successfully downloaded the archive, now extracting its contents")
-	gzr, err := gzip.NewReader(resp.Body)
+	return ExtractTarGz(resp.Body, tmpDir)
+}
+
+// ExtractTarGz extracts the regular files and directories in a gzipped tarball
+// into dir.
+func ExtractTarGz(r io.Reader, dir string) error {
+	gzr, err := gzip.NewReader(r)
 	if err != nil {
 		return err
 	}
@@ -76,24 +86,24 @@ func DownloadAndExtract(url, tmpDir, token string) error {
 	tarReader := tar.NewReader(gzr)
 	for {
 		header, err := tarReader.Next()
-		if strings.Contains(header.Name, "..") {
-			panic("tar file contained relative path which is not supported")
-		}
 		if err != nil {
 			if err == io.EOF {
 				return nil
 			}
 			return err
 		}
+		if strings.Contains(header.Name, "..") {
+			return fmt.Errorf("tar file contained relative path %s which is not supported", header.Name)
+		}
 
 		switch header.Typeflag {
 		case tar.TypeDir:
-			target := filepath.Join(tmpDir, filepath.Clean(header.Name))
+			target := filepath.Join(dir, filepath.Clean(header.Name))
 			if sErr := os.MkdirAll(target, 0o755); sErr != nil {
 				return sErr
 			}
 		case tar.TypeReg:
-			target := filepath.Join(tmpDir, filepath.Clean(header.Name))
+			target := filepath.Join(dir, filepath.Clean(header.Name))
 			var f *os.File
 			mode, err := safeInt64ToUint32(header.Mode)
 			if err != nil {
@@ -104,9 +114,8 @@ func DownloadAndExtract(url, tmpDir, token string) error {
 				return err
 			}
 			// Limit readable amount to 2GB to prevent decompression bomb.
-			maxSize := 2 << (10 * 3)
-			limiter := io.LimitReader(tarReader, int64(maxSize))
-			if _, err = io.Copy(f, limiter); err != nil {
+			if _, err = io.Copy(f, io.LimitReader(tarReader, MaxDownloadSize)); err != nil {
+				f.Close()
 				return err
 			}
 			// Manually close here after each file operation; defering would cause each file close
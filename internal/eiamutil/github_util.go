@@ -14,18 +14,36 @@ import (
 )
 
 func GetLatestRelease(repoOwner, repoName, token string) (*github.RepositoryRelease, error) {
-	httpClient := http.Client{}
-	if token != "" {
-		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
-		httpClient = *oauth2.NewClient(context.Background(), ts)
+	release, _, err := newGithubClient(token).Repositories.GetLatestRelease(context.Background(), repoOwner, repoName)
+	if err != nil {
+		return nil, err
 	}
-	githubClient := github.NewClient(&httpClient)
+	return release, nil
+}
 
-	release, _, err := githubClient.Repositories.GetLatestRelease(context.Background(), repoOwner, repoName)
+// GetLatestPrerelease returns the newest release of a repository, including
+// prereleases.
+func GetLatestPrerelease(repoOwner, repoName, token string) (*github.RepositoryRelease, error) {
+	releases, _, err := newGithubClient(token).Repositories.ListReleases(
+		context.Background(), repoOwner, repoName, &github.ListOptions{PerPage: 10})
 	if err != nil {
 		return nil, err
 	}
-	return release, nil
+	for _, release := range releases {
+		if !release.GetDraft() {
+			return release, nil
+		}
+	}
+	return nil, fmt.Errorf("no releases of %s/%s were found", repoOwner, repoName)
+}
+
+func newGithubClient(token string) *github.Client {
+	httpClient := http.Client{}
+	if token != "" {
+		ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+		httpClient = *oauth2.NewClient(context.Background(), ts)
+	}
+	return github.NewClient(&httpClient)
 }
 
 func GetReleaseDownloadURL(r *github.RepositoryRelease, isPlugin bool) (string, error) {
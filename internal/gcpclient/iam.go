@@ -38,13 +38,18 @@ var (
 const (
 	// DefaultTokenDuration is the default duration for tokens.
 	DefaultTokenDuration = 10 * time.Minute
+
+	// ReadOnlyScope limits a token to read-only access to GCP APIs.
+	ReadOnlyScope = "https://www.googleapis.com/auth/cloud-platform.read-only"
 )
 
-// GenerateTemporaryAccessToken generates short-lived credentials for the given service account.
+// GenerateTemporaryAccessToken generates short-lived credentials for the given
+// service account. A read-only token can only read from GCP APIs.
 func GenerateTemporaryAccessToken(
 	svcAcct,
 	reason string,
 	tokenDuration time.Duration,
+	readOnly bool,
 ) (*credentialspb.GenerateAccessTokenResponse, error) {
 	client, err := ClientWithReason(reason)
 	if err != nil {
@@ -55,11 +60,15 @@ func GenerateTemporaryAccessToken(
 		Seconds: int64(tokenDuration.Seconds()),
 	}
 
+	scope := iam.CloudPlatformScope
+	if readOnly {
+		scope = ReadOnlyScope
+	}
 	req := credentialspb.GenerateAccessTokenRequest{
 		Name:     fmt.Sprintf("projects/-/serviceAccounts/%s", svcAcct),
 		Lifetime: sessionDuration,
 		Scope: []string{
-			iam.CloudPlatformScope,
+			scope,
 			"https://www.googleapis.com/auth/userinfo.email",
 		},
 	}
@@ -0,0 +1,485 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcpclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	crm "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/pubsub/v1"
+	"google.golang.org/api/storage/v1"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+// ResourceKind is a type of resource that roles can be granted on. The kinds
+// match the subcommands of "eiam query-permissions".
+type ResourceKind string
+
+// The resource kinds that roles can be granted on.
+const (
+	ComputeInstance ResourceKind = "compute-instance"
+	Project         ResourceKind = "project"
+	PubSubTopic     ResourceKind = "pubsub"
+	ServiceAccount  ResourceKind = "service-account"
+	StorageBucket   ResourceKind = "storage-bucket"
+)
+
+// conditionPolicyVersion is the IAM policy version that supports conditional
+// role bindings.
+const conditionPolicyVersion = 3
+
+// The limits that IAM sets on the title and description of a condition.
+const (
+	maxConditionTitle       = 100
+	maxConditionDescription = 256
+)
+
+// maxPolicyUpdates is how many times UpdatePolicy re-reads a policy that was
+// changed concurrently before it gives up.
+const maxPolicyUpdates = 5
+
+// policyRetryDelay is how long UpdatePolicy waits before its first retry. The
+// wait grows with each retry.
+var policyRetryDelay = 500 * time.Millisecond
+
+// Resource is a resource that roles can be granted on.
+type Resource struct {
+	Kind    ResourceKind
+	Project string
+
+	// Zone is the zone of a compute instance.
+	Zone string
+
+	// Name is the name of the compute instance, Pub/Sub topic or storage
+	// bucket, or the email of the service account. It is not used for
+	// projects.
+	Name string
+}
+
+// FullName returns the full resource name of r, as used by
+// QueryTestablePermissionsOnResource.
+func (r Resource) FullName() string {
+	switch r.Kind {
+	case ComputeInstance:
+		return fmt.Sprintf("//compute.googleapis.com/projects/%s/zones/%s/instances/%s", r.Project, r.Zone, r.Name)
+	case PubSubTopic:
+		return fmt.Sprintf("//pubsub.googleapis.com/projects/%s/topics/%s", r.Project, r.Name)
+	case ServiceAccount:
+		return fmt.Sprintf("//iam.googleapis.com/projects/%s/serviceAccounts/%s", r.Project, r.Name)
+	case StorageBucket:
+		return fmt.Sprintf("//storage.googleapis.com/projects/_/buckets/%s", r.Name)
+	}
+	return fmt.Sprintf("//cloudresourcemanager.googleapis.com/projects/%s", r.Project)
+}
+
+// String returns the full resource name of r without the service prefix.
+func (r Resource) String() string {
+	name := strings.TrimPrefix(r.FullName(), "//")
+	return name[strings.Index(name, "/")+1:]
+}
+
+// TestPermissions returns the permissions in perms that the authenticated
+// member has on r.
+func (r Resource) TestPermissions(perms []string) ([]string, error) {
+	switch r.Kind {
+	case ComputeInstance:
+		return QueryComputeInstancePermissions(perms, r.Project, r.Zone, r.Name, "", "")
+	case PubSubTopic:
+		return QueryPubSubPermissions(perms, r.Project, r.Name, "", "")
+	case ServiceAccount:
+		return QueryServiceAccountPermissions(perms, r.Project, r.Name)
+	case StorageBucket:
+		return QueryStorageBucketPermissions(perms, r.Name, "", "")
+	}
+	return QueryProjectPermissions(perms, r.Project, "", "")
+}
+
+// Expr is the condition of a role binding.
+type Expr struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Expression  string `json:"expression,omitempty"`
+}
+
+// Binding binds members to a role, optionally under a condition.
+type Binding struct {
+	Role      string   `json:"role,omitempty"`
+	Members   []string `json:"members,omitempty"`
+	Condition *Expr    `json:"condition,omitempty"`
+}
+
+// Policy is the part of an IAM policy that is common to all resource kinds.
+// It is converted to and from the policy type of each API through JSON, and
+// the fields it does not know about, such as audit configs, are kept as they
+// were read.
+type Policy struct {
+	Bindings []*Binding `json:"bindings"`
+	Etag     string     `json:"etag,omitempty"`
+	Version  int64      `json:"version,omitempty"`
+}
+
+// AddBinding binds member to role under cond. Bindings with a condition
+// cannot be shared, so a new binding is added unless member already has an
+// identical one. It reports whether the policy changed.
+func (p *Policy) AddBinding(role, member string, cond *Expr) bool {
+	for _, b := range p.Bindings {
+		if b.Role == role && sameCondition(b.Condition, cond) && contains(b.Members, member) {
+			return false
+		}
+	}
+	p.Bindings = append(p.Bindings, &Binding{Role: role, Members: []string{member}, Condition: cond})
+	if cond != nil {
+		p.Version = conditionPolicyVersion
+	}
+	return true
+}
+
+// RemoveBinding removes member from the bindings of role whose condition has
+// the given title, and drops bindings that are left without members. It
+// reports whether the policy changed.
+func (p *Policy) RemoveBinding(role, member, title string) bool {
+	changed := false
+	bindings := p.Bindings[:0]
+	for _, b := range p.Bindings {
+		if b.Role == role && b.Condition != nil && b.Condition.Title == title && contains(b.Members, member) {
+			changed = true
+			b.Members = removeMember(b.Members, member)
+			if len(b.Members) == 0 {
+				continue
+			}
+		}
+		bindings = append(bindings, b)
+	}
+	p.Bindings = bindings
+	return changed
+}
+
+// ExpiryCondition returns a condition that holds until expiry. The title and
+// description are the reason, which identifies the eiam session, truncated to
+// the lengths that IAM allows.
+func ExpiryCondition(reason string, expiry time.Time) *Expr {
+	return &Expr{
+		Title:       truncate(reason, maxConditionTitle),
+		Description: truncate(reason, maxConditionDescription),
+		Expression:  fmt.Sprintf("request.time < timestamp(%q)", expiry.UTC().Format(time.RFC3339)),
+	}
+}
+
+// Member returns the IAM member for an account email.
+func Member(email string) string {
+	if strings.HasSuffix(email, ".gserviceaccount.com") {
+		return "serviceAccount:" + email
+	}
+	return "user:" + email
+}
+
+// PolicyAPI reads and writes the IAM policy of a resource in the policy type
+// of its API.
+type PolicyAPI interface {
+	// Get returns the policy of the resource.
+	Get() (interface{}, error)
+
+	// Set replaces the policy of the resource. It fails if the policy has been
+	// changed since it was read.
+	Set(policy interface{}) error
+}
+
+// UpdatePolicy reads the policy through api, applies update to it and writes
+// it back if update reports a change. If the policy was changed concurrently,
+// which IAM detects with its etag, the update is retried on a fresh copy.
+func UpdatePolicy(api PolicyAPI, update func(p *Policy) bool) error {
+	var err error
+	for attempt := 0; attempt < maxPolicyUpdates; attempt++ {
+		if attempt > 0 {
+			util.Logger.Debugf("The IAM policy changed while it was updated, retrying: %v", err)
+			time.Sleep(time.Duration(attempt) * policyRetryDelay)
+		}
+
+		var native interface{}
+		if native, err = api.Get(); err != nil {
+			return err
+		}
+		var policy Policy
+		if err = convertPolicy(native, &policy); err != nil {
+			return err
+		}
+		if policy.Version < conditionPolicyVersion {
+			policy.Version = conditionPolicyVersion
+		}
+		if !update(&policy) {
+			return nil
+		}
+		var updated interface{}
+		if updated, err = mergePolicy(&policy, native); err != nil {
+			return err
+		}
+		if err = api.Set(updated); err == nil || !isConcurrentUpdate(err) {
+			return err
+		}
+	}
+	return errorsutil.New(fmt.Sprintf("The IAM policy kept changing after %d attempts", maxPolicyUpdates), err)
+}
+
+// NewPolicyAPI returns the PolicyAPI for r.
+func NewPolicyAPI(r Resource) (PolicyAPI, error) {
+	switch r.Kind {
+	case ComputeInstance:
+		svc, err := compute.NewService(ctx)
+		if err != nil {
+			return nil, errorsutil.NewSDKError("Compute", "", err)
+		}
+		return &computePolicy{svc.Instances, r}, nil
+	case PubSubTopic:
+		svc, err := pubsub.NewService(ctx)
+		if err != nil {
+			return nil, errorsutil.NewSDKError("PubSub", "", err)
+		}
+		return &pubsubPolicy{svc.Projects.Topics, r}, nil
+	case ServiceAccount:
+		svc, err := iam.NewService(ctx)
+		if err != nil {
+			return nil, errorsutil.NewSDKError("Cloud IAM", "", err)
+		}
+		return &serviceAccountPolicy{svc.Projects.ServiceAccounts, r}, nil
+	case StorageBucket:
+		svc, err := storage.NewService(ctx)
+		if err != nil {
+			return nil, errorsutil.NewSDKError("Cloud Storage", "", err)
+		}
+		return &storagePolicy{svc.Buckets, r}, nil
+	case Project:
+		svc, err := crm.NewService(ctx)
+		if err != nil {
+			return nil, errorsutil.NewSDKError("Cloud Resource Manager", "", err)
+		}
+		return &projectPolicy{svc.Projects, r}, nil
+	}
+	return nil, fmt.Errorf("roles cannot be granted on %q resources", r.Kind)
+}
+
+// RolePermissions returns the permissions that role includes and that can
+// be tested on r.
+func RolePermissions(role string, r Resource) ([]string, error) {
+	iamService, err := iam.NewService(ctx)
+	if err != nil {
+		return nil, errorsutil.NewSDKError("Cloud IAM", "", err)
+	}
+	// The roles API accepts the names of predefined and custom roles alike.
+	def, err := iamService.Roles.Get(role).Do()
+	if err != nil {
+		return nil, errorsutil.New(fmt.Sprintf("Failed to get role %s", role), err)
+	}
+	testable, err := QueryTestablePermissionsOnResource(r.FullName())
+	if err != nil {
+		return nil, err
+	}
+	var perms []string
+	for _, perm := range def.IncludedPermissions {
+		if contains(testable, perm) {
+			perms = append(perms, perm)
+		}
+	}
+	return perms, nil
+}
+
+// WaitForPermissions polls r until the authenticated member has all of perms
+// or ctx is done. IAM changes usually take effect within a minute, but can
+// take several.
+func WaitForPermissions(ctx context.Context, r Resource, perms []string, interval time.Duration) error {
+	for {
+		granted, err := r.TestPermissions(append([]string(nil), perms...))
+		if err != nil {
+			util.Logger.WithError(err).Debug("Failed to test permissions")
+		} else if len(granted) >= len(perms) {
+			return nil
+		} else {
+			util.Logger.Debugf("%d of %d permissions have propagated", len(granted), len(perms))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+type projectPolicy struct {
+	svc *crm.ProjectsService
+	r   Resource
+}
+
+func (a *projectPolicy) Get() (interface{}, error) {
+	return a.svc.GetIamPolicy(a.r.Project, &crm.GetIamPolicyRequest{
+		Options: &crm.GetPolicyOptions{RequestedPolicyVersion: conditionPolicyVersion},
+	}).Do()
+}
+
+func (a *projectPolicy) Set(policy interface{}) error {
+	_, err := a.svc.SetIamPolicy(a.r.Project, &crm.SetIamPolicyRequest{Policy: policy.(*crm.Policy)}).Do()
+	return err
+}
+
+type computePolicy struct {
+	svc *compute.InstancesService
+	r   Resource
+}
+
+func (a *computePolicy) Get() (interface{}, error) {
+	return a.svc.GetIamPolicy(a.r.Project, a.r.Zone, a.r.Name).OptionsRequestedPolicyVersion(conditionPolicyVersion).Do()
+}
+
+func (a *computePolicy) Set(policy interface{}) error {
+	_, err := a.svc.SetIamPolicy(a.r.Project, a.r.Zone, a.r.Name, &compute.ZoneSetPolicyRequest{
+		Policy: policy.(*compute.Policy),
+	}).Do()
+	return err
+}
+
+type pubsubPolicy struct {
+	svc *pubsub.ProjectsTopicsService
+	r   Resource
+}
+
+func (a *pubsubPolicy) Get() (interface{}, error) {
+	return a.svc.GetIamPolicy(a.r.String()).OptionsRequestedPolicyVersion(conditionPolicyVersion).Do()
+}
+
+func (a *pubsubPolicy) Set(policy interface{}) error {
+	_, err := a.svc.SetIamPolicy(a.r.String(), &pubsub.SetIamPolicyRequest{Policy: policy.(*pubsub.Policy)}).Do()
+	return err
+}
+
+type serviceAccountPolicy struct {
+	svc *iam.ProjectsServiceAccountsService
+	r   Resource
+}
+
+func (a *serviceAccountPolicy) Get() (interface{}, error) {
+	return a.svc.GetIamPolicy(a.r.String()).OptionsRequestedPolicyVersion(conditionPolicyVersion).Do()
+}
+
+func (a *serviceAccountPolicy) Set(policy interface{}) error {
+	_, err := a.svc.SetIamPolicy(a.r.String(), &iam.SetIamPolicyRequest{Policy: policy.(*iam.Policy)}).Do()
+	return err
+}
+
+type storagePolicy struct {
+	svc *storage.BucketsService
+	r   Resource
+}
+
+func (a *storagePolicy) Get() (interface{}, error) {
+	return a.svc.GetIamPolicy(a.r.Name).OptionsRequestedPolicyVersion(conditionPolicyVersion).Do()
+}
+
+func (a *storagePolicy) Set(policy interface{}) error {
+	_, err := a.svc.SetIamPolicy(a.r.Name, policy.(*storage.Policy)).Do()
+	return err
+}
+
+// mergePolicy returns a new policy of the type of native with the fields of
+// policy and the fields of native that Policy does not have, such as audit
+// configs. The result is decoded into a fresh value, because decoding into
+// native would keep the fields of its bindings that policy omits, such as the
+// condition of a binding that moved up after one was removed.
+func mergePolicy(policy *Policy, native interface{}) (interface{}, error) {
+	var fields, updates map[string]json.RawMessage
+	if err := convertPolicy(native, &fields); err != nil {
+		return nil, err
+	}
+	if err := convertPolicy(policy, &updates); err != nil {
+		return nil, err
+	}
+	for name, value := range updates {
+		fields[name] = value
+	}
+	merged := reflect.New(reflect.TypeOf(native).Elem()).Interface()
+	if err := convertPolicy(fields, merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// convertPolicy copies the fields of src to dst through their JSON encoding.
+func convertPolicy(src, dst interface{}) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return errorsutil.New("Failed to encode the IAM policy", err)
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return errorsutil.New("Failed to decode the IAM policy", err)
+	}
+	return nil
+}
+
+// isConcurrentUpdate reports whether err is the error IAM returns when a
+// policy is written with a stale etag.
+func isConcurrentUpdate(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Code == http.StatusConflict || apiErr.Code == http.StatusPreconditionFailed
+}
+
+func sameCondition(a, b *Expr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Title == b.Title && a.Expression == b.Expression
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeMember(members []string, member string) []string {
+	kept := make([]string, 0, len(members))
+	for _, m := range members {
+		if m != member {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
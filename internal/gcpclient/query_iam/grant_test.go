@@ -0,0 +1,184 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcpclient
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	crm "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+// fakePolicyAPI stores a project policy. It fails the first conflicts writes
+// with a conflict and rejects writes with a stale etag like IAM.
+type fakePolicyAPI struct {
+	policy    *crm.Policy
+	conflicts int
+	writes    int
+}
+
+func (f *fakePolicyAPI) Get() (interface{}, error) {
+	copied := *f.policy
+	copied.Bindings = append([]*crm.Binding(nil), f.policy.Bindings...)
+	return &copied, nil
+}
+
+func (f *fakePolicyAPI) Set(policy interface{}) error {
+	p := policy.(*crm.Policy)
+	if f.conflicts > 0 {
+		f.conflicts--
+		// Another client changed the policy in the meantime.
+		f.policy.Etag += "x"
+		return &googleapi.Error{Code: http.StatusConflict, Message: "etag mismatch"}
+	}
+	if p.Etag != f.policy.Etag {
+		return &googleapi.Error{Code: http.StatusConflict, Message: "stale etag"}
+	}
+	f.writes++
+	p.Etag += "+"
+	f.policy = p
+	return nil
+}
+
+func TestAddAndRemoveBinding(t *testing.T) {
+	cond := ExpiryCondition("ephemeral-iam 0123456789abcdef: Fix prod", time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+	if cond.Expression != `request.time < timestamp("2021-06-01T12:00:00Z")` {
+		t.Errorf("unexpected expression %q", cond.Expression)
+	}
+
+	p := &Policy{Bindings: []*Binding{{Role: "roles/viewer", Members: []string{"user:bob@example.com"}}}}
+	if !p.AddBinding("roles/editor", "user:jane@example.com", cond) {
+		t.Fatal("expected the binding to be added")
+	}
+	if p.AddBinding("roles/editor", "user:jane@example.com", cond) {
+		t.Error("expected an identical binding not to be added again")
+	}
+	if p.Version != conditionPolicyVersion {
+		t.Errorf("expected policy version %d, got %d", conditionPolicyVersion, p.Version)
+	}
+
+	if p.RemoveBinding("roles/editor", "user:jane@example.com", "another title") {
+		t.Error("expected bindings with another condition to be kept")
+	}
+	if !p.RemoveBinding("roles/editor", "user:jane@example.com", cond.Title) {
+		t.Fatal("expected the binding to be removed")
+	}
+	if len(p.Bindings) != 1 || p.Bindings[0].Role != "roles/viewer" {
+		t.Errorf("expected only the viewer binding to be left, got %+v", p.Bindings)
+	}
+}
+
+func TestExpiryConditionLimits(t *testing.T) {
+	reason := strings.Repeat("é", 200)
+	cond := ExpiryCondition("ephemeral-iam 0123456789abcdef: "+reason, time.Now())
+	if len(cond.Title) > maxConditionTitle || len(cond.Description) > maxConditionDescription {
+		t.Errorf("condition exceeds the IAM limits: %d, %d", len(cond.Title), len(cond.Description))
+	}
+	if !strings.HasPrefix(cond.Title, "ephemeral-iam 0123456789abcdef: ") {
+		t.Errorf("expected the title to start with the reason, got %q", cond.Title)
+	}
+	if !strings.HasSuffix(cond.Title, "é") {
+		t.Errorf("expected the title to be truncated at a rune boundary, got %q", cond.Title)
+	}
+}
+
+func TestUpdatePolicy(t *testing.T) {
+	util.Logger = logrus.New()
+	policyRetryDelay = time.Millisecond
+	audit := []*crm.AuditConfig{{Service: "allServices"}}
+	cond := ExpiryCondition("ephemeral-iam 0123456789abcdef: Fix prod", time.Now().Add(time.Hour))
+
+	api := &fakePolicyAPI{
+		policy:    &crm.Policy{Etag: "a", Version: 1, AuditConfigs: audit},
+		conflicts: 2,
+	}
+	err := UpdatePolicy(api, func(p *Policy) bool {
+		return p.AddBinding("roles/editor", "user:jane@example.com", cond)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if api.writes != 1 {
+		t.Errorf("expected 1 write, got %d", api.writes)
+	}
+	if len(api.policy.Bindings) != 1 || api.policy.Bindings[0].Condition.Title != cond.Title {
+		t.Errorf("expected the conditional binding, got %+v", api.policy.Bindings)
+	}
+	if api.policy.Version != conditionPolicyVersion {
+		t.Errorf("expected policy version %d, got %d", conditionPolicyVersion, api.policy.Version)
+	}
+	if len(api.policy.AuditConfigs) != 1 {
+		t.Error("expected the audit configs to be kept")
+	}
+
+	err = UpdatePolicy(api, func(p *Policy) bool { return false })
+	if err != nil || api.writes != 1 {
+		t.Errorf("expected an unchanged policy not to be written, got %v after %d writes", err, api.writes)
+	}
+
+	api.conflicts = maxPolicyUpdates
+	err = UpdatePolicy(api, func(p *Policy) bool {
+		return p.RemoveBinding("roles/editor", "user:jane@example.com", cond.Title)
+	})
+	var eiamErr errorsutil.EiamError
+	if !errors.As(err, &eiamErr) || !strings.Contains(eiamErr.Msg, "kept changing") {
+		t.Errorf("expected the conflict after %d attempts, got %v", maxPolicyUpdates, err)
+	}
+}
+
+func TestUpdatePolicyRemovesBinding(t *testing.T) {
+	util.Logger = logrus.New()
+	cond := ExpiryCondition("ephemeral-iam 0123456789abcdef: Fix prod", time.Now().Add(time.Hour))
+	api := &fakePolicyAPI{policy: &crm.Policy{
+		Etag:    "a",
+		Version: conditionPolicyVersion,
+		Bindings: []*crm.Binding{
+			{
+				Role:    "roles/editor",
+				Members: []string{"user:jane@example.com"},
+				Condition: &crm.Expr{
+					Title:      cond.Title,
+					Expression: cond.Expression,
+				},
+			},
+			{Role: "roles/owner", Members: []string{"user:bob@example.com"}},
+		},
+		AuditConfigs: []*crm.AuditConfig{{Service: "allServices"}},
+	}}
+
+	err := UpdatePolicy(api, func(p *Policy) bool {
+		return p.RemoveBinding("roles/editor", "user:jane@example.com", cond.Title)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if api.writes != 1 || len(api.policy.Bindings) != 1 {
+		t.Fatalf("expected the conditional binding to be removed, got %+v", api.policy.Bindings)
+	}
+	if owner := api.policy.Bindings[0]; owner.Role != "roles/owner" || owner.Condition != nil {
+		t.Errorf("expected the unconditional owner binding, got %+v with condition %+v", owner, owner.Condition)
+	}
+	if len(api.policy.AuditConfigs) != 1 {
+		t.Error("expected the audit configs to be kept")
+	}
+}
@@ -161,9 +161,13 @@ func QueryProjectPermissions(permsToTest []string, project, svcAcct, reason stri
 
 	wg.Add(len(chunked))
 
-	var userPermissions []string
+	var (
+		userPermissions []string
+		mu              sync.Mutex
+	)
 	for _, permSet := range chunked {
 		go func(permissions []string, granted *[]string) {
+			defer wg.Done()
 			resp, err := crmProjService.TestIamPermissions(project, &crm.TestIamPermissionsRequest{
 				Permissions: permissions,
 			}).Do()
@@ -171,8 +175,9 @@ func QueryProjectPermissions(permsToTest []string, project, svcAcct, reason stri
 				util.Logger.Errorf("Failed to query permissions on projects/%s", project)
 				return
 			}
+			mu.Lock()
 			*granted = append(*granted, resp.Permissions...)
-			wg.Done()
+			mu.Unlock()
 		}(permSet, &userPermissions)
 	}
 	// Wait until each of the go routines have finished before returning.
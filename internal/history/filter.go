@@ -0,0 +1,119 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/replit/ephemeral-iam/internal/hooks"
+)
+
+// Filter selects entries of the history. Its zero value selects all of them.
+type Filter struct {
+	// ServiceAccount and Project are glob patterns.
+	ServiceAccount string
+	Project        string
+
+	// Reason is text that the reason must contain, ignoring case.
+	Reason string
+
+	// Type is the event type of the entries.
+	Type hooks.EventType
+
+	// Since and Until limit the time of the entries.
+	Since time.Time
+	Until time.Time
+}
+
+// Match reports whether the filter selects e.
+func (f Filter) Match(e Entry) bool {
+	switch {
+	case f.ServiceAccount != "" && !match(f.ServiceAccount, e.ServiceAccount):
+		return false
+	case f.Project != "" && !match(f.Project, e.Project):
+		return false
+	case f.Reason != "" && !strings.Contains(strings.ToLower(e.Reason), strings.ToLower(f.Reason)):
+		return false
+	case f.Type != "" && e.Type != f.Type:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !e.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// Apply returns the entries that the filter selects.
+func (f Filter) Apply(entries []Entry) []Entry {
+	var selected []Entry
+	for _, e := range entries {
+		if f.Match(e) {
+			selected = append(selected, e)
+		}
+	}
+	return selected
+}
+
+// CSVHeader is the header row of WriteCSV.
+var CSVHeader = []string{
+	"seq", "time", "type", "session_id", "user", "command", "args", "project", "service_account",
+	"reason", "duration", "expires_at", "exit_code", "error", "hash",
+}
+
+// WriteCSV writes entries as CSV with a header row.
+func WriteCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVHeader); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		expires := ""
+		if !e.ExpiresAt.IsZero() {
+			expires = e.ExpiresAt.Format(time.RFC3339)
+		}
+		record := []string{
+			strconv.FormatInt(e.Seq, 10), e.Time.Format(time.RFC3339), string(e.Type), e.SessionID, e.User,
+			e.Command, strings.Join(e.Args, " "), e.Project, e.ServiceAccount, e.Reason, e.Duration, expires,
+			strconv.Itoa(e.ExitCode), e.Error, e.Hash,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes entries as an indented JSON array.
+func WriteJSON(w io.Writer, entries []Entry) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+func match(pattern, s string) bool {
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
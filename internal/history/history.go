@@ -0,0 +1,243 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package history keeps a local record of the privileged sessions, tokens and
+// commands of eiam. The record is an append-only JSON lines file in which
+// every entry includes the hash of the previous one, so that changes to past
+// entries can be detected.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+	"github.com/replit/ephemeral-iam/internal/hooks"
+)
+
+// FileName is the name of the history file in the eiam config directory.
+const FileName = "history.jsonl"
+
+// Entry is a line of the history file.
+type Entry struct {
+	Seq int64 `json:"seq"`
+	hooks.Event
+
+	// PrevHash is the hash of the previous entry, or empty for the first one.
+	PrevHash string `json:"prevHash"`
+
+	// Hash is the SHA-256 hash of the entry without its hash.
+	Hash string `json:"hash"`
+}
+
+// TamperError reports an entry that does not fit into the hash chain.
+type TamperError struct {
+	Line   int
+	Reason string
+}
+
+func (e *TamperError) Error() string {
+	return fmt.Sprintf("line %d of the history has been tampered with: %s", e.Line, e.Reason)
+}
+
+// Log is a history file.
+type Log struct {
+	Path string
+}
+
+// Default returns the history file in the eiam config directory.
+func Default() *Log {
+	return &Log{Path: filepath.Join(appconfig.GetConfigDir(), FileName)}
+}
+
+// Hook returns a hook that records every lifecycle event in l. It runs before
+// the action that emitted the event goes ahead, but a failure to record an
+// event is only logged.
+func (l *Log) Hook() *hooks.Hook {
+	return &hooks.Hook{
+		Name:     "history",
+		Required: true,
+		Handler: func(_ context.Context, e hooks.Event) error {
+			if _, err := l.Append(e); err != nil {
+				util.Logger.WithError(err).Warnf("Failed to record %s in the history", e.Type)
+			}
+			return nil
+		},
+	}
+}
+
+// Append adds an entry for e to the end of the history. The file is locked
+// while the previous entry is read and the new one is written, so concurrent
+// eiam processes do not fork the chain.
+func (l *Log) Append(e hooks.Event) (*Entry, error) {
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0o700); err != nil {
+		return nil, errorsutil.New("Failed to create the history directory", err)
+	}
+	f, err := os.OpenFile(l.Path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, errorsutil.New("Failed to open the history file", err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, errorsutil.New("Failed to lock the history file", err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN) //nolint:errcheck // Released on close
+
+	entry := &Entry{Seq: 1, Event: e}
+	last, err := lastLine(f)
+	if err != nil {
+		return nil, errorsutil.New("Failed to read the history file", err)
+	}
+	if len(last) > 0 {
+		var prev Entry
+		if err := json.Unmarshal(last, &prev); err != nil {
+			return nil, errorsutil.New("Failed to parse the last entry of the history", err)
+		}
+		entry.Seq, entry.PrevHash = prev.Seq+1, prev.Hash
+	}
+	if entry.Hash, err = entry.hash(); err != nil {
+		return nil, err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil, errorsutil.New("Failed to encode the history entry", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return nil, errorsutil.New("Failed to write the history file", err)
+	}
+	return entry, nil
+}
+
+// Read returns the entries of the history. A missing history has no entries.
+func (l *Log) Read() ([]Entry, error) {
+	var entries []Entry
+	err := l.scan(func(n int, line []byte) error {
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("line %d of the history is not a valid entry: %v", n, err)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// Verify checks the hash chain of the history and returns the number of
+// entries. It returns a TamperError for the first entry that was changed,
+// removed or reordered. Entries removed from the end of the history cannot be
+// detected.
+func (l *Log) Verify() (int, error) {
+	var prev *Entry
+	count := 0
+	err := l.scan(func(n int, line []byte) error {
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return &TamperError{Line: n, Reason: fmt.Sprintf("it is not a valid entry: %v", err)}
+		}
+		hash, err := entry.hash()
+		if err != nil {
+			return err
+		}
+		switch {
+		case hash != entry.Hash:
+			return &TamperError{Line: n, Reason: "its content does not match its hash"}
+		case prev == nil && (entry.Seq != 1 || entry.PrevHash != ""):
+			return &TamperError{Line: n, Reason: fmt.Sprintf("the history starts at entry %d", entry.Seq)}
+		case prev != nil && entry.Seq != prev.Seq+1:
+			return &TamperError{Line: n, Reason: fmt.Sprintf("entry %d follows entry %d", entry.Seq, prev.Seq)}
+		case prev != nil && entry.PrevHash != prev.Hash:
+			return &TamperError{Line: n, Reason: "it does not link to the previous entry"}
+		}
+		prev = &entry
+		count++
+		return nil
+	})
+	return count, err
+}
+
+func (l *Log) scan(fn func(n int, line []byte) error) error {
+	f, err := os.Open(l.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return errorsutil.New("Failed to open the history file", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	n := 0
+	for scanner.Scan() {
+		n++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if err := fn(n, scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// hash returns the hash of the entry without its hash.
+func (e Entry) hash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", errorsutil.New("Failed to encode the history entry", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lastLine returns the last non-empty line of f by reading it backwards from
+// the end.
+func lastLine(f *os.File) ([]byte, error) {
+	const chunk = 4096
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	var tail []byte
+	for offset := end; offset > 0; {
+		size := int64(chunk)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		buf := make([]byte, size)
+		if _, err := f.ReadAt(buf, offset); err != nil {
+			return nil, err
+		}
+		tail = append(buf, tail...)
+		trimmed := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+	}
+	return bytes.TrimRight(tail, "\n"), nil
+}
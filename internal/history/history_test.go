@@ -0,0 +1,172 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/replit/ephemeral-iam/internal/hooks"
+)
+
+func newLog(t *testing.T) *Log {
+	t.Helper()
+	return &Log{Path: filepath.Join(t.TempDir(), "eiam", FileName)}
+}
+
+func event(t hooks.EventType, sa, reason string, at time.Time) hooks.Event {
+	return hooks.Event{
+		Type:           t,
+		Time:           at,
+		Command:        "gcloud",
+		Project:        "prod",
+		ServiceAccount: sa,
+		Reason:         reason,
+	}
+}
+
+func TestAppendAndVerify(t *testing.T) {
+	l := newLog(t)
+	if n, err := l.Verify(); err != nil || n != 0 {
+		t.Fatalf("expected an empty history to verify, got %d entries and %v", n, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := l.Append(event(hooks.TokenMinted, "a@prod.iam.gserviceaccount.com", "Fix prod", time.Now())); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	n, err := l.Verify()
+	if err != nil || n != 20 {
+		t.Fatalf("expected 20 chained entries, got %d and %v", n, err)
+	}
+	entries, err := l.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[19].Seq != 20 || entries[19].PrevHash != entries[18].Hash {
+		t.Errorf("expected the last entry to link to the previous one, got %+v", entries[19])
+	}
+	if info, _ := os.Stat(l.Path); info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the history to be private, got %v", info.Mode().Perm())
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+		line   int
+	}{
+		{"edited", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], "Fix prod", "Routine work", 1)
+			return lines
+		}, 2},
+		{"removed", func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}, 2},
+		{"reordered", func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, 2},
+		{"truncated start", func(lines []string) []string {
+			return lines[1:]
+		}, 1},
+	}
+	for _, tt := range tests {
+		l := newLog(t)
+		for i := 0; i < 3; i++ {
+			if _, err := l.Append(event(hooks.CommandStarted, "a@prod.iam.gserviceaccount.com", "Fix prod", time.Now())); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data, _ := os.ReadFile(l.Path)
+		lines := tt.tamper(strings.Split(strings.TrimSpace(string(data)), "\n"))
+		if err := os.WriteFile(l.Path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := l.Verify()
+		var tamperErr *TamperError
+		if !errors.As(err, &tamperErr) || tamperErr.Line != tt.line {
+			t.Errorf("%s: expected tampering on line %d, got %v", tt.name, tt.line, err)
+		}
+	}
+}
+
+func TestFilterAndExport(t *testing.T) {
+	day := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := newLog(t)
+	for _, e := range []hooks.Event{
+		event(hooks.SessionStarted, "admin@prod.iam.gserviceaccount.com", "Fix OUTAGE (INC-1)", day),
+		event(hooks.SessionStarted, "reader@prod.iam.gserviceaccount.com", "Check logs", day.Add(24*time.Hour)),
+		event(hooks.SessionEnded, "admin@prod.iam.gserviceaccount.com", "Fix OUTAGE (INC-1)", day.Add(48*time.Hour)),
+	} {
+		if _, err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := l.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter Filter
+		want   []int64
+	}{
+		{Filter{}, []int64{1, 2, 3}},
+		{Filter{ServiceAccount: "admin@*"}, []int64{1, 3}},
+		{Filter{Reason: "outage"}, []int64{1, 3}},
+		{Filter{Type: hooks.SessionStarted}, []int64{1, 2}},
+		{Filter{Since: day.Add(time.Hour), Until: day.Add(48 * time.Hour)}, []int64{2}},
+		{Filter{Project: "dev"}, nil},
+	}
+	for _, tt := range tests {
+		var got []int64
+		for _, e := range tt.filter.Apply(entries) {
+			got = append(got, e.Seq)
+		}
+		if len(got) != len(tt.want) || (len(got) > 0 && got[len(got)-1] != tt.want[len(tt.want)-1]) {
+			t.Errorf("filter %+v selected %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || records[1][9] != "Fix OUTAGE (INC-1)" || records[0][9] != "reason" {
+		t.Errorf("unexpected CSV: %v", records)
+	}
+}
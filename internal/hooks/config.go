@@ -0,0 +1,198 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+// Config is an entry of the 'hooks' section of the eiam config. An entry
+// either runs an executable or changes how a plugin's subscription is handled.
+type Config struct {
+	Name     string        `mapstructure:"name"`
+	Events   []string      `mapstructure:"events"`
+	Command  string        `mapstructure:"command"`
+	Args     []string      `mapstructure:"args"`
+	Plugin   string        `mapstructure:"plugin"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	Required bool          `mapstructure:"required"`
+}
+
+// LoadConfig reads and validates the 'hooks' section of the eiam config. It
+// returns the valid entries along with an error that describes the invalid
+// ones. required reports whether any of the invalid entries is a required
+// hook, or could not be read well enough to tell.
+func LoadConfig() (configs []Config, required bool, err error) {
+	entries, err := appconfig.ListEntries(appconfig.Hooks)
+	if err != nil {
+		return nil, true, errorsutil.New("Failed to parse hooks configuration", err)
+	}
+	configs = []Config{}
+	var errs []error
+	for i, entry := range entries {
+		var c Config
+		if err := appconfig.DecodeEntry(entry, &c); err != nil {
+			errs = append(errs, fmt.Errorf("hook %d: %v", i+1, err))
+			if r, ok := entry["required"].(bool); r || (!ok && entry["required"] != nil) {
+				required = true
+			}
+			continue
+		}
+		if err := c.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("hook %d: %v", i+1, err))
+			required = required || c.Required
+			continue
+		}
+		configs = append(configs, c)
+	}
+	if len(errs) > 0 {
+		return configs, required, errorsutil.New("Invalid hooks configuration", errors.Join(errs...))
+	}
+	return configs, false, nil
+}
+
+func (c Config) validate() error {
+	if (c.Command == "") == (c.Plugin == "") {
+		return errors.New("must set exactly one of command or plugin")
+	}
+	_, err := ParseEvents(c.Events)
+	return err
+}
+
+// RegisterConfigured registers the hook executables from the eiam config.
+// Invalid entries are skipped with a warning, so that a broken hook never
+// keeps eiam, and "eiam config" in particular, from running. If any of them is
+// a required hook, the privileged events are refused instead of going ahead
+// without it.
+func RegisterConfigured() {
+	configs, required, err := LoadConfig()
+	if err != nil {
+		util.Logger.WithError(err).Warnf("Skipping invalid entries of the %s setting", appconfig.Hooks)
+		if required {
+			RegisterInvalid("invalid required hook", err)
+		}
+	}
+	for _, c := range configs {
+		if c.Command == "" {
+			continue
+		}
+		events, _ := ParseEvents(c.Events)
+		name := c.Name
+		if name == "" {
+			name = c.Command
+		}
+		Register(&Hook{
+			Name:     name,
+			Events:   events,
+			Timeout:  c.Timeout,
+			Required: c.Required,
+			Handler:  commandHandler(c.Command, c.Args),
+		})
+	}
+}
+
+// PluginHook returns the hook for a plugin that subscribed to events,
+// applying the settings of a 'hooks' entry for the plugin if there is one. It
+// returns nil if the entry filters out all of the plugin's events.
+func PluginHook(plugin string, events []EventType, handler func(ctx context.Context, e Event) error) *Hook {
+	h := &Hook{Name: "plugin " + plugin, Events: events, Handler: handler}
+	// Invalid entries were already reported by RegisterConfigured.
+	configs, _, _ := LoadConfig()
+	for _, c := range configs {
+		if c.Plugin != plugin {
+			continue
+		}
+		h.Timeout = c.Timeout
+		h.Required = c.Required
+		if filter, _ := ParseEvents(c.Events); len(filter) > 0 {
+			if h.Events = intersect(events, filter); len(h.Events) == 0 {
+				return nil
+			}
+		}
+	}
+	return h
+}
+
+// commandHandler runs an executable with the event as JSON on stdin and its
+// type in the EIAM_EVENT environment variable.
+func commandHandler(command string, args []string) func(ctx context.Context, e Event) error {
+	return func(ctx context.Context, e Event) error {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		var output bytes.Buffer
+		c := exec.CommandContext(ctx, os.ExpandEnv(command), args...) //nolint:gosec // Configured by the user
+		c.Stdin = bytes.NewReader(payload)
+		c.Stdout = &output
+		c.Stderr = &output
+		c.Env = append(os.Environ(), fmt.Sprintf("EIAM_EVENT=%s", e.Type))
+		err = c.Run()
+		if out := strings.TrimSpace(output.String()); out != "" {
+			util.Logger.Debugf("Output of hook %s: %s", command, out)
+		}
+		if err != nil && output.Len() > 0 {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(output.String()))
+		}
+		return err
+	}
+}
+
+// ParseEvents checks the names of events from the eiam config.
+func ParseEvents(names []string) ([]EventType, error) {
+	events := make([]EventType, 0, len(names))
+	for _, name := range names {
+		known := false
+		for _, t := range EventTypes {
+			if string(t) == name {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown event %q, must be one of %v", name, EventTypes)
+		}
+		events = append(events, EventType(name))
+	}
+	return events, nil
+}
+
+// intersect returns the events of a that are also in b. A nil a stands for
+// all events.
+func intersect(a, b []EventType) []EventType {
+	if len(a) == 0 {
+		return b
+	}
+	out := []EventType{}
+	for _, e := range a {
+		for _, f := range b {
+			if e == f {
+				out = append(out, e)
+			}
+		}
+	}
+	return out
+}
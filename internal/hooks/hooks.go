@@ -0,0 +1,219 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hooks emits lifecycle events to the executables configured in the
+// 'hooks' section of the eiam config and to plugins that subscribe to them.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+)
+
+// EventType is the kind of lifecycle event.
+type EventType string
+
+// The lifecycle events emitted by eiam.
+const (
+	SessionStarted  EventType = "session_started"
+	TokenMinted     EventType = "token_minted"
+	CommandStarted  EventType = "command_started"
+	CommandFinished EventType = "command_finished"
+	SessionEnded    EventType = "session_ended"
+)
+
+// EventTypes is the list of all lifecycle events.
+var EventTypes = []EventType{SessionStarted, TokenMinted, CommandStarted, CommandFinished, SessionEnded}
+
+// PrivilegedEvents are the events emitted before a privileged action goes
+// ahead. A required hook that fails one of them aborts the action.
+var PrivilegedEvents = []EventType{SessionStarted, TokenMinted, CommandStarted}
+
+// DefaultTimeout is how long a hook may run if it does not set a timeout.
+const DefaultTimeout = 10 * time.Second
+
+// Event is the payload sent to hooks. It is encoded as JSON for hook
+// executables and plugins.
+type Event struct {
+	Type           EventType `json:"type"`
+	Time           time.Time `json:"time"`
+	SessionID      string    `json:"sessionId,omitempty"`
+	User           string    `json:"user,omitempty"`
+	Command        string    `json:"command,omitempty"`
+	Args           []string  `json:"args,omitempty"`
+	Project        string    `json:"project,omitempty"`
+	ServiceAccount string    `json:"serviceAccount,omitempty"`
+	Reason         string    `json:"reason,omitempty"`
+	Duration       string    `json:"duration,omitempty"`
+	ExpiresAt      time.Time `json:"expiresAt,omitempty"`
+	ExitCode       int       `json:"exitCode,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// Hook is a subscriber to lifecycle events.
+type Hook struct {
+	// Name identifies the hook in logs.
+	Name string
+
+	// Events are the events the hook is subscribed to. A hook without events
+	// receives all of them.
+	Events []EventType
+
+	// Timeout limits how long the handler may run. DefaultTimeout is used if it
+	// is zero.
+	Timeout time.Duration
+
+	// Required hooks are run before the action that emitted the event goes
+	// ahead, and a failure aborts it. Other hooks run in the background and
+	// their failures are only logged.
+	Required bool
+
+	Handler func(ctx context.Context, e Event) error
+}
+
+var (
+	mu         sync.Mutex
+	registered []*Hook
+	background sync.WaitGroup
+)
+
+// Register subscribes a hook to lifecycle events.
+func Register(h *Hook) {
+	mu.Lock()
+	defer mu.Unlock()
+	registered = append(registered, h)
+}
+
+// RegisterInvalid subscribes a required hook that refuses the privileged
+// events, in place of a required hook or notification whose configuration is
+// invalid. Commands that do not emit events, such as "eiam config", keep
+// working so that the configuration can be fixed.
+func RegisterInvalid(name string, err error) {
+	Register(&Hook{
+		Name:     name,
+		Events:   PrivilegedEvents,
+		Required: true,
+		Handler: func(ctx context.Context, e Event) error {
+			return fmt.Errorf("invalid configuration: %v", err)
+		},
+	})
+}
+
+// Reset removes all registered hooks.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	registered = nil
+}
+
+// Subscribed reports whether a hook receives events of type t.
+func (h *Hook) Subscribed(t EventType) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit sends an event to the subscribed hooks. It waits for the required
+// hooks and returns an error if any of them failed. Other hooks keep running
+// in the background until Wait is called.
+func Emit(e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.SessionID == "" {
+		e.SessionID = util.ReasonSessionID(e.Reason)
+	}
+
+	mu.Lock()
+	hooks := append([]*Hook(nil), registered...)
+	mu.Unlock()
+
+	var (
+		required sync.WaitGroup
+		errMu    sync.Mutex
+		failures []string
+	)
+	for _, h := range hooks {
+		if !h.Subscribed(e.Type) {
+			continue
+		}
+		h := h
+		if !h.Required {
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				if err := h.run(e); err != nil {
+					util.Logger.WithError(err).Warnf("Hook %s failed to handle %s", h.Name, e.Type)
+				}
+			}()
+			continue
+		}
+		required.Add(1)
+		go func() {
+			defer required.Done()
+			if err := h.run(e); err != nil {
+				errMu.Lock()
+				failures = append(failures, fmt.Sprintf("%s: %v", h.Name, err))
+				errMu.Unlock()
+			}
+		}()
+	}
+	required.Wait()
+
+	if len(failures) > 0 {
+		return fmt.Errorf("required hooks failed to handle %s: %s", e.Type, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Wait blocks until the hooks running in the background have finished. Each
+// of them is bounded by its timeout.
+func Wait() {
+	background.Wait()
+}
+
+func (h *Hook) run(e Event) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h.Handler(ctx, e)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s", timeout)
+		}
+		return ctx.Err()
+	}
+}
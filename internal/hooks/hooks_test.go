@@ -0,0 +1,223 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+)
+
+func TestMain(m *testing.M) {
+	util.Logger = logrus.New()
+	util.Logger.Out = &bytes.Buffer{}
+	os.Exit(m.Run())
+}
+
+func TestEmitRequiredAndOptionalHooks(t *testing.T) {
+	defer Reset()
+
+	Register(&Hook{
+		Name:   "optional",
+		Events: []EventType{SessionStarted},
+		Handler: func(ctx context.Context, e Event) error {
+			<-ctx.Done()
+			return errors.New("optional hooks cannot block")
+		},
+		Timeout: 50 * time.Millisecond,
+	})
+	Register(&Hook{
+		Name:     "required",
+		Events:   []EventType{SessionStarted},
+		Required: true,
+		Handler: func(ctx context.Context, e Event) error {
+			if e.SessionID != "0123456789abcdef" {
+				t.Errorf("expected the session ID from the reason, got %q", e.SessionID)
+			}
+			return errors.New("ticket tracker unavailable")
+		},
+	})
+	Register(&Hook{
+		Name:     "unsubscribed",
+		Events:   []EventType{SessionEnded},
+		Required: true,
+		Handler: func(ctx context.Context, e Event) error {
+			t.Error("hook was called for an event it did not subscribe to")
+			return nil
+		},
+	})
+
+	start := time.Now()
+	err := Emit(Event{Type: SessionStarted, Reason: "ephemeral-iam 0123456789abcdef: testing"})
+	if err == nil || !strings.Contains(err.Error(), "ticket tracker unavailable") {
+		t.Errorf("expected the required hook's error, got %v", err)
+	}
+	if time.Since(start) > 40*time.Millisecond {
+		t.Error("expected Emit to return without waiting for optional hooks")
+	}
+
+	Wait()
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("expected Wait to block until the optional hook timed out")
+	}
+}
+
+func TestRequiredHookTimeout(t *testing.T) {
+	defer Reset()
+	Register(&Hook{
+		Name:     "slow",
+		Required: true,
+		Timeout:  20 * time.Millisecond,
+		Handler: func(ctx context.Context, e Event) error {
+			time.Sleep(time.Second)
+			return nil
+		},
+	})
+	start := time.Now()
+	if err := Emit(Event{Type: TokenMinted}); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("expected the required hook to be abandoned after its timeout")
+	}
+}
+
+func TestCommandHook(t *testing.T) {
+	defer Reset()
+	defer viper.Set("hooks", nil)
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "event.json")
+	script := filepath.Join(dir, "hook.sh")
+	body := "#!/bin/sh\necho \"$EIAM_EVENT\" > " + out + ".type\ncat > " + out + "\n"
+	if err := os.WriteFile(script, []byte(body), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("hooks", []map[string]interface{}{
+		{"command": script, "events": []string{"command_finished"}, "required": true, "timeout": "5s"},
+	})
+	RegisterConfigured()
+
+	if err := Emit(Event{Type: CommandFinished, Command: "gcloud", ExitCode: 2}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("hook did not receive the event: %v", err)
+	}
+	var e Event
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatalf("hook received invalid JSON: %v", err)
+	}
+	if e.Command != "gcloud" || e.ExitCode != 2 {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if typ, _ := os.ReadFile(out + ".type"); strings.TrimSpace(string(typ)) != "command_finished" {
+		t.Errorf("expected EIAM_EVENT to be command_finished, got %q", typ)
+	}
+}
+
+func TestLoadConfigRejectsInvalidHooks(t *testing.T) {
+	defer viper.Set("hooks", nil)
+	viper.Set("hooks", []map[string]interface{}{{"command": "/bin/true", "events": []string{"session_paused"}}})
+	if _, _, err := LoadConfig(); err == nil {
+		t.Error("expected an error for an unknown event")
+	}
+	viper.Set("hooks", []map[string]interface{}{{"command": "/bin/true", "plugin": "example"}})
+	if _, _, err := LoadConfig(); err == nil {
+		t.Error("expected an error for a hook with both a command and a plugin")
+	}
+
+	// The valid entries are kept.
+	viper.Set("hooks", []map[string]interface{}{
+		{"command": "/bin/true", "events": []string{"session_paused"}},
+		{"name": "audit", "command": "/bin/true"},
+	})
+	configs, required, err := LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), "hook 1") {
+		t.Errorf("expected an error for the first hook, got %v", err)
+	}
+	if len(configs) != 1 || configs[0].Name != "audit" {
+		t.Errorf("expected the valid hook, got %+v", configs)
+	}
+	if required {
+		t.Error("expected the invalid hook not to be reported as required")
+	}
+}
+
+func TestInvalidRequiredHookRefusesPrivilegedEvents(t *testing.T) {
+	defer viper.Set("hooks", nil)
+
+	tests := []struct {
+		name   string
+		hooks  interface{}
+		refuse bool
+	}{
+		{
+			name:  "invalid optional hook",
+			hooks: []map[string]interface{}{{"command": "/bin/true", "events": []string{"session_paused"}}},
+		},
+		{
+			name: "unknown event",
+			hooks: []map[string]interface{}{
+				{"command": "/bin/true", "events": []string{"session_paused"}, "required": true},
+			},
+			refuse: true,
+		},
+		{
+			name:   "command and plugin",
+			hooks:  []map[string]interface{}{{"command": "/bin/true", "plugin": "example", "required": true}},
+			refuse: true,
+		},
+		{
+			name:   "malformed entry",
+			hooks:  []map[string]interface{}{{"command": "/bin/true", "timeout": "soon", "required": true}},
+			refuse: true,
+		},
+		{
+			name:   "malformed section",
+			hooks:  "/bin/true",
+			refuse: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer Reset()
+			viper.Set("hooks", tt.hooks)
+			RegisterConfigured()
+
+			for _, e := range PrivilegedEvents {
+				if err := Emit(Event{Type: e}); (err != nil) != tt.refuse {
+					t.Errorf("Emit(%s) = %v, want refused: %v", e, err, tt.refuse)
+				}
+			}
+			if err := Emit(Event{Type: SessionEnded}); err != nil {
+				t.Errorf("expected %s to go ahead, got %v", SessionEnded, err)
+			}
+		})
+	}
+}
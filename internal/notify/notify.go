@@ -0,0 +1,335 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package notify posts lifecycle events to the webhooks and chat channels in
+// the 'notifications' section of the eiam config. Each notification is
+// registered as a hook.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+	"github.com/replit/ephemeral-iam/internal/hooks"
+)
+
+// Format is the format of the request body of a notification.
+type Format string
+
+// The formats of notifications.
+const (
+	Webhook    Format = "webhook"
+	Slack      Format = "slack"
+	GoogleChat Format = "googlechat"
+)
+
+// Formats are the formats of notifications.
+var Formats = []Format{Webhook, Slack, GoogleChat}
+
+// The ways a failed notification is handled.
+const (
+	Warn  = "warn"
+	Block = "block"
+)
+
+// DefaultEvents are the events a notification is sent for if it does not
+// list any: the start and end of sessions and commands.
+var DefaultEvents = []hooks.EventType{
+	hooks.SessionStarted, hooks.SessionEnded, hooks.CommandStarted, hooks.CommandFinished,
+}
+
+// Config is an entry of the 'notifications' section of the eiam config.
+type Config struct {
+	Name            string            `mapstructure:"name"`
+	Format          Format            `mapstructure:"format"`
+	URL             string            `mapstructure:"url"`
+	Headers         map[string]string `mapstructure:"headers"`
+	Body            string            `mapstructure:"body"`
+	Events          []string          `mapstructure:"events"`
+	Projects        []string          `mapstructure:"projects"`
+	ServiceAccounts []string          `mapstructure:"serviceaccounts"`
+	OnFailure       string            `mapstructure:"onfailure"`
+	Timeout         time.Duration     `mapstructure:"timeout"`
+}
+
+// Notifier sends the notifications of a Config.
+type Notifier struct {
+	Config
+
+	// Client is used to send notifications. http.DefaultClient is used if it
+	// is nil.
+	Client *http.Client
+
+	events []hooks.EventType
+	body   *template.Template
+}
+
+// RegisterConfigured registers a hook for each notification in the eiam
+// config. Invalid notifications are skipped with a warning. If one of them
+// blocks on failure, or the section cannot be read at all, the privileged
+// events are refused instead of going ahead without the notification.
+func RegisterConfigured() {
+	entries, err := appconfig.ListEntries(appconfig.Notifications)
+	if err != nil {
+		err = errorsutil.New("Failed to parse notifications configuration", err)
+		util.Logger.WithError(err).Warnf("Ignoring the %s setting", appconfig.Notifications)
+		hooks.RegisterInvalid("invalid notifications", err)
+		return
+	}
+	for i, entry := range entries {
+		var c Config
+		err := appconfig.DecodeEntry(entry, &c)
+		var n *Notifier
+		if err == nil {
+			n, err = New(c)
+		}
+		if err != nil {
+			util.Logger.WithError(err).Warnf("Skipping notification %d of the %s setting", i+1, appconfig.Notifications)
+			if blocks(entry) {
+				hooks.RegisterInvalid(fmt.Sprintf("invalid notification %d", i+1), err)
+			}
+			continue
+		}
+		hooks.Register(n.Hook())
+	}
+}
+
+// blocks reports whether a notification entry may block on failure: it sets
+// onfailure to anything but warn.
+func blocks(entry map[string]interface{}) bool {
+	v, ok := entry["onfailure"]
+	if !ok || v == nil {
+		return false
+	}
+	onFailure, ok := v.(string)
+	return !ok || (onFailure != "" && onFailure != Warn)
+}
+
+// New validates c and returns its Notifier.
+func New(c Config) (*Notifier, error) {
+	n := &Notifier{Config: c}
+	if n.Format == "" {
+		n.Format = Webhook
+	}
+	if !knownFormat(n.Format) {
+		return nil, fmt.Errorf("unknown format %q, must be one of %v", n.Format, Formats)
+	}
+	if n.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	switch n.OnFailure {
+	case "":
+		n.OnFailure = Warn
+	case Warn, Block:
+	default:
+		return nil, fmt.Errorf("invalid onfailure %q, must be %s or %s", n.OnFailure, Warn, Block)
+	}
+	if n.Body != "" {
+		if n.Format != Webhook {
+			return nil, fmt.Errorf("a body can only be set for the %s format", Webhook)
+		}
+		tmpl, err := template.New("body").Funcs(template.FuncMap{
+			"json":    toJSON,
+			"message": Message,
+		}).Parse(n.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body: %v", err)
+		}
+		n.body = tmpl
+	}
+	for _, pattern := range append(append([]string(nil), n.Projects...), n.ServiceAccounts...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+
+	n.events = DefaultEvents
+	if len(n.Events) > 0 {
+		events, err := hooks.ParseEvents(n.Events)
+		if err != nil {
+			return nil, err
+		}
+		n.events = events
+	}
+	if n.Name == "" {
+		n.Name = fmt.Sprintf("%s notification", n.Format)
+	}
+	return n, nil
+}
+
+// Hook returns the hook that sends the notifications. A notification that
+// blocks on failure is a required hook.
+func (n *Notifier) Hook() *hooks.Hook {
+	return &hooks.Hook{
+		Name:     n.Name,
+		Events:   n.events,
+		Timeout:  n.Timeout,
+		Required: n.OnFailure == Block,
+		Handler: func(ctx context.Context, e hooks.Event) error {
+			if !n.Matches(e) {
+				return nil
+			}
+			return n.Send(ctx, e)
+		},
+	}
+}
+
+// Matches reports whether the project and service account of e match the
+// patterns of the notification.
+func (n *Notifier) Matches(e hooks.Event) bool {
+	return matchAny(n.Projects, e.Project) && matchAny(n.ServiceAccounts, e.ServiceAccount)
+}
+
+// Payload returns the request body of the notification of e.
+func (n *Notifier) Payload(e hooks.Event) ([]byte, error) {
+	switch n.Format {
+	case Slack:
+		text := slackEscape(Message(e))
+		return json.Marshal(map[string]interface{}{
+			"text": text,
+			"blocks": []interface{}{map[string]interface{}{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": text},
+			}},
+		})
+	case GoogleChat:
+		return json.Marshal(map[string]string{"text": Message(e)})
+	}
+	if n.body == nil {
+		return json.Marshal(e)
+	}
+	var buf bytes.Buffer
+	if err := n.body.Execute(&buf, e); err != nil {
+		return nil, fmt.Errorf("failed to render the body: %v", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("the body is not valid JSON: %s", buf.String())
+	}
+	return buf.Bytes(), nil
+}
+
+// Send posts the notification of e.
+func (n *Notifier) Send(ctx context.Context, e hooks.Event) error {
+	payload, err := n.Payload(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, os.ExpandEnv(n.URL), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	for name, value := range n.Headers {
+		req.Header.Set(name, os.ExpandEnv(value))
+	}
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Message returns a summary of e for chat channels, with *bold* labels.
+func Message(e hooks.Event) string {
+	who := e.User
+	if who == "" {
+		who = "Someone"
+	}
+	var lines []string
+	switch e.Type {
+	case hooks.SessionStarted:
+		lines = append(lines, fmt.Sprintf("%s started a privileged %s session", who, e.Command))
+	case hooks.SessionEnded:
+		lines = append(lines, fmt.Sprintf("%s ended a privileged %s session", who, e.Command))
+	case hooks.TokenMinted:
+		lines = append(lines, fmt.Sprintf("%s generated an access token for %s", who, e.Command))
+	case hooks.CommandStarted:
+		lines = append(lines, fmt.Sprintf("%s is running %s", who, strings.Join(append([]string{e.Command}, e.Args...), " ")))
+	case hooks.CommandFinished:
+		status := "succeeded"
+		if e.Error != "" {
+			status = fmt.Sprintf("failed with exit code %d", e.ExitCode)
+		}
+		lines = append(lines, fmt.Sprintf("%s ran %s, which %s", who, strings.Join(append([]string{e.Command}, e.Args...), " "), status))
+	default:
+		lines = append(lines, fmt.Sprintf("%s: %s", e.Type, e.Command))
+	}
+
+	for _, field := range []struct{ label, value string }{
+		{"Service account", e.ServiceAccount},
+		{"Project", e.Project},
+		{"Duration", e.Duration},
+		{"Reason", e.Reason},
+	} {
+		if field.value != "" {
+			lines = append(lines, fmt.Sprintf("*%s:* %s", field.label, field.value))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// slackEscape escapes the characters that Slack uses for its markup.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func knownFormat(f Format) bool {
+	for _, known := range Formats {
+		if f == known {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether s matches one of patterns. Every value matches an
+// empty list.
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	"github.com/replit/ephemeral-iam/internal/hooks"
+)
+
+func TestMain(m *testing.M) {
+	util.Logger = logrus.New()
+	util.Logger.Out = &bytes.Buffer{}
+	os.Exit(m.Run())
+}
+
+var started = hooks.Event{
+	Type:           hooks.SessionStarted,
+	User:           "jane@example.com",
+	Command:        "assume-privileges",
+	Project:        "prod",
+	ServiceAccount: "admin@prod.iam.gserviceaccount.com",
+	Reason:         "ephemeral-iam 0123456789abcdef: Fix <prod> (INC-42)",
+	Duration:       "1h0m0s",
+}
+
+func TestPayload(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   string
+	}{
+		{
+			name:   "webhook template",
+			config: Config{URL: "http://x", Body: `{"who": {{ .User | json }}, "sa": {{ .ServiceAccount | json }}}`},
+			want:   `{"who": "jane@example.com", "sa": "admin@prod.iam.gserviceaccount.com"}`,
+		},
+		{
+			name:   "google chat",
+			config: Config{URL: "http://x", Format: GoogleChat},
+			want:   "*Reason:* ephemeral-iam 0123456789abcdef: Fix <prod> (INC-42)",
+		},
+		{
+			name:   "slack",
+			config: Config{URL: "http://x", Format: Slack},
+			want:   "*Reason:* ephemeral-iam 0123456789abcdef: Fix &lt;prod&gt; (INC-42)",
+		},
+	}
+	for _, tt := range tests {
+		n, err := New(tt.config)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		payload, err := n.Payload(started)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		var text struct{ Text string }
+		_ = json.Unmarshal(payload, &text)
+		if tt.config.Body != "" {
+			if string(payload) != tt.want {
+				t.Errorf("%s: got %s, want %s", tt.name, payload, tt.want)
+			}
+			continue
+		}
+		if !strings.HasSuffix(text.Text, tt.want) ||
+			!strings.HasPrefix(text.Text, "jane@example.com started a privileged assume-privileges session") {
+			t.Errorf("%s: unexpected message %q", tt.name, text.Text)
+		}
+	}
+
+	n, _ := New(Config{URL: "http://x", Body: `{"user": {{ .User }}}`})
+	if _, err := n.Payload(started); err == nil {
+		t.Error("expected an error for a body that is not valid JSON")
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	invalid := []Config{
+		{},
+		{URL: "http://x", Format: "teams"},
+		{URL: "http://x", OnFailure: "retry"},
+		{URL: "http://x", Format: Slack, Body: "{}"},
+		{URL: "http://x", Body: "{{ .User "},
+		{URL: "http://x", Events: []string{"session_paused"}},
+		{URL: "http://x", Projects: []string{"[prod"}},
+	}
+	for _, c := range invalid {
+		if _, err := New(c); err == nil {
+			t.Errorf("expected an error for %+v", c)
+		}
+	}
+}
+
+func TestHook(t *testing.T) {
+	defer hooks.Reset()
+
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer s3cret" {
+			t.Errorf("expected the expanded header, got %q", got)
+		}
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, string(body))
+		if strings.Contains(string(body), "session_ended") {
+			http.Error(w, "channel archived", http.StatusGone)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("NOTIFY_TOKEN", "s3cret")
+
+	n, err := New(Config{
+		URL:             server.URL,
+		Headers:         map[string]string{"Authorization": "Bearer ${NOTIFY_TOKEN}"},
+		ServiceAccounts: []string{"*@prod.iam.gserviceaccount.com"},
+		OnFailure:       Block,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hooks.Register(n.Hook())
+
+	if err := hooks.Emit(started); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	other := started
+	other.ServiceAccount = "admin@dev.iam.gserviceaccount.com"
+	if err := hooks.Emit(other); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(received) != 1 {
+		t.Fatalf("expected only the matching service account to be notified, got %d notifications", len(received))
+	}
+
+	ended := started
+	ended.Type = hooks.SessionEnded
+	err = n.Send(context.Background(), ended)
+	if err == nil || !strings.Contains(err.Error(), "channel archived") {
+		t.Errorf("expected the error of the channel, got %v", err)
+	}
+	if !n.Hook().Required {
+		t.Error("expected a notification that blocks on failure to be a required hook")
+	}
+}
+
+func TestRegisterConfiguredSkipsInvalid(t *testing.T) {
+	defer hooks.Reset()
+	defer viper.Set("notifications", nil)
+
+	received := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	defer srv.Close()
+
+	viper.Set("notifications", []map[string]interface{}{
+		{"name": "broken", "format": "teams", "url": srv.URL},
+		{"name": "ops", "url": srv.URL, "onfailure": "block"},
+		{"name": "slow", "url": srv.URL, "timeout": "soon"},
+	})
+	RegisterConfigured()
+
+	if err := hooks.Emit(started); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-received:
+	default:
+		t.Error("expected the valid notification to be sent")
+	}
+}
+
+func TestRegisterConfiguredRefusesInvalidBlocking(t *testing.T) {
+	defer viper.Set("notifications", nil)
+
+	tests := []struct {
+		name          string
+		notifications interface{}
+	}{
+		{
+			name:          "missing url",
+			notifications: []map[string]interface{}{{"name": "ops", "onfailure": "block"}},
+		},
+		{
+			name: "unknown event",
+			notifications: []map[string]interface{}{
+				{"url": "https://example.com", "events": []string{"session_paused"}, "onfailure": "block"},
+			},
+		},
+		{
+			name: "invalid body",
+			notifications: []map[string]interface{}{
+				{"url": "https://example.com", "body": "{{ .Type", "onfailure": "block"},
+			},
+		},
+		{
+			name: "invalid pattern",
+			notifications: []map[string]interface{}{
+				{"url": "https://example.com", "projects": []string{"["}, "onfailure": "block"},
+			},
+		},
+		{
+			name: "malformed entry",
+			notifications: []map[string]interface{}{
+				{"url": "https://example.com", "timeout": "soon", "onfailure": "block"},
+			},
+		},
+		{
+			name:          "unknown onfailure",
+			notifications: []map[string]interface{}{{"url": "https://example.com", "onfailure": "blok"}},
+		},
+		{
+			name:          "malformed section",
+			notifications: "https://example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer hooks.Reset()
+			viper.Set("notifications", tt.notifications)
+			RegisterConfigured()
+
+			for _, e := range hooks.PrivilegedEvents {
+				if err := hooks.Emit(hooks.Event{Type: e}); err == nil {
+					t.Errorf("expected %s to be refused", e)
+				}
+			}
+		})
+	}
+}
@@ -16,12 +16,23 @@ package plugins
 
 import (
 	"context"
+	"encoding/json"
+	"sync"
 
+	hcplugin "github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+
+	"github.com/replit/ephemeral-iam/internal/hooks"
 	pb "github.com/replit/ephemeral-iam/internal/plugins/proto"
 )
 
 type GRPCClient struct {
 	Client pb.EIAMPluginClient
+	Broker *hcplugin.GRPCBroker
+
+	name   string
+	schema []ConfigField
+	events []hooks.EventType
 }
 
 // GetInfo is the gRPC method that is called to get metadata about a plugin.
@@ -30,12 +41,76 @@ func (m *GRPCClient) GetInfo() (name, desc, version string, err error) {
 	if err != nil {
 		return "", "", "", err
 	}
+	m.name = resp.Name
+	m.schema = fromProtoFields(resp.Config)
+	m.events = nil
+	for _, e := range resp.Events {
+		m.events = append(m.events, hooks.EventType(e))
+	}
 	return resp.Name, resp.Description, resp.Version, nil
 }
 
+// ConfigSchema returns the configuration fields reported by GetInfo.
+func (m *GRPCClient) ConfigSchema() []ConfigField {
+	return m.schema
+}
+
+// Events returns the lifecycle events the plugin subscribed to in GetInfo.
+func (m *GRPCClient) Events() []hooks.EventType {
+	return m.events
+}
+
+// HandleEvent is the gRPC method that delivers a lifecycle event to the plugin.
+func (m *GRPCClient) HandleEvent(ctx context.Context, e hooks.Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = m.Client.HandleEvent(ctx, &pb.Event{Type: string(e.Type), Payload: payload})
+	return err
+}
+
 // Run is the gRPC method that is called to invoke a plugin's root command.
 func (m *GRPCClient) Run() error {
-	_, err := m.Client.Run(context.Background(), &pb.Empty{})
+	return m.RunContext(context.Background())
+}
+
+// RunContext invokes the plugin's root command and cancels it when ctx is
+// done. While the command runs, the EIAMHost service is served to the plugin.
+func (m *GRPCClient) RunContext(ctx context.Context) error {
+	req := &pb.RunRequest{}
+	if m.Broker != nil {
+		// The broker creates the server on its own goroutine. A server created
+		// after the command returned is stopped right away, so that the broker
+		// closes its listener either way.
+		var (
+			mu      sync.Mutex
+			server  *grpc.Server
+			stopped bool
+		)
+		serve := func(opts []grpc.ServerOption) *grpc.Server {
+			mu.Lock()
+			defer mu.Unlock()
+			server = grpc.NewServer(opts...)
+			pb.RegisterEIAMHostServer(server, &hostServer{name: m.name, schema: m.schema})
+			if stopped {
+				server.Stop()
+			}
+			return server
+		}
+		req.HostServiceId = m.Broker.NextId()
+		go m.Broker.AcceptAndServe(req.HostServiceId, serve)
+		defer func() {
+			mu.Lock()
+			defer mu.Unlock()
+			stopped = true
+			if server != nil {
+				server.Stop()
+			}
+		}()
+	}
+
+	_, err := m.Client.Run(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,116 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	"github.com/replit/ephemeral-iam/internal/secrets"
+)
+
+// ConfigPrefix is the section of the eiam configuration that holds the settings
+// of each plugin under plugins.<name>.
+const ConfigPrefix = "plugins"
+
+// ConfigKey returns the eiam configuration key of a plugin setting.
+func ConfigKey(plugin, field string) string {
+	return strings.ToLower(fmt.Sprintf("%s.%s.%s", ConfigPrefix, plugin, field))
+}
+
+// SplitConfigKey splits a key of the form plugins.<name>.<field>. It returns
+// false if the key is not in the plugins section.
+func SplitConfigKey(key string) (plugin, field string, ok bool) {
+	parts := strings.SplitN(key, ".", 3)
+	if len(parts) != 3 || parts[0] != ConfigPrefix || parts[1] == "" || parts[2] == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// FindField returns the field of a schema with the given name.
+func FindField(schema []ConfigField, name string) (ConfigField, bool) {
+	for _, f := range schema {
+		if strings.EqualFold(f.Name, name) {
+			return f, true
+		}
+	}
+	return ConfigField{}, false
+}
+
+// ParseValue checks a value against a field's type and allowed values and
+// returns it converted to the type that is stored in the config.
+func (f ConfigField) ParseValue(value string) (interface{}, error) {
+	if len(f.Allowed) > 0 && !util.Contains(f.Allowed, value) {
+		return nil, fmt.Errorf("%s must be one of %v", f.Name, f.Allowed)
+	}
+	switch f.Type {
+	case "", ConfigString:
+		return value, nil
+	case ConfigBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("the %s value must be either true or false", f.Name)
+		}
+		return b, nil
+	case ConfigInt:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("the %s value must be an integer", f.Name)
+		}
+		return i, nil
+	case ConfigDuration:
+		if _, err := time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("the %s value must be a duration such as 30s or 5m", f.Name)
+		}
+		return value, nil
+	default:
+		return nil, fmt.Errorf("%s has unsupported type %q", f.Name, f.Type)
+	}
+}
+
+// PluginConfig returns the settings of a plugin as strings, falling back to
+// the defaults from its schema. Secret settings are read from the secret
+// store.
+func PluginConfig(plugin string, schema []ConfigField) map[string]string {
+	values := map[string]string{}
+	for _, f := range schema {
+		if f.Default != "" {
+			values[strings.ToLower(f.Name)] = f.Default
+		}
+	}
+	for key, val := range viper.GetStringMap(fmt.Sprintf("%s.%s", ConfigPrefix, plugin)) {
+		values[key] = fmt.Sprint(val)
+	}
+	for _, f := range schema {
+		if !f.Secret {
+			continue
+		}
+		secret, err := appconfig.SecretStore().Get(ConfigKey(plugin, f.Name))
+		if err == nil {
+			values[strings.ToLower(f.Name)] = secret
+		} else if !errors.Is(err, secrets.ErrNotFound) {
+			util.Logger.WithError(err).Warnf("Failed to read the %s setting of plugin %s", f.Name, plugin)
+		}
+	}
+	return values
+}
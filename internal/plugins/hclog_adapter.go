@@ -15,11 +15,13 @@
 package plugins
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"reflect"
+	"strings"
+	"sync"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/sirupsen/logrus"
@@ -28,20 +30,33 @@ import (
 // NewHCLogAdapter takes an instance of a Logrus logger and returns an hclog
 // logger in the form of an HCLogAdapter.
 func NewHCLogAdapter(l *logrus.Logger, name string) hclog.Logger {
-	return &HCLogAdapter{l, name, nil}
+	return &HCLogAdapter{log: l, name: name, level: &sharedLevel{level: hclog.NoLevel}}
 }
 
 // HCLogAdapter implements the hclog interface.  Plugins use hclog to send
 // log entries back to ephemeral-iam and this adapter allows for those logs
 // to be handled by ephemeral-iam's Logrus logger.
+//
+// The adapter's name and implied arguments are added to each entry as
+// fields. Its level is shared with the loggers created from it with Named and
+// With, and is applied without changing the level of the Logrus logger.
 type HCLogAdapter struct {
 	log  *logrus.Logger
 	name string
 
 	impliedArgs []interface{}
+	level       *sharedLevel
+}
+
+// sharedLevel holds the level set on an adapter. When it is set, entries are
+// written with a copy of the Logrus logger at that level.
+type sharedLevel struct {
+	mu     sync.RWMutex
+	level  hclog.Level
+	logger *logrus.Logger
 }
 
-func (h HCLogAdapter) Log(level hclog.Level, msg string, args ...interface{}) {
+func (h *HCLogAdapter) Log(level hclog.Level, msg string, args ...interface{}) {
 	switch level {
 	case hclog.Off:
 		return
@@ -60,84 +75,189 @@ func (h HCLogAdapter) Log(level hclog.Level, msg string, args ...interface{}) {
 	}
 }
 
-func (h HCLogAdapter) Trace(msg string, args ...interface{}) {
-	h.log.WithFields(toLogrusFields(args)).Trace(msg)
+func (h *HCLogAdapter) Trace(msg string, args ...interface{}) {
+	h.entry(args).Trace(msg)
 }
 
-func (h HCLogAdapter) Debug(msg string, args ...interface{}) {
-	h.log.WithFields(toLogrusFields(args)).Debug(msg)
+func (h *HCLogAdapter) Debug(msg string, args ...interface{}) {
+	h.entry(args).Debug(msg)
 }
 
-func (h HCLogAdapter) Info(msg string, args ...interface{}) {
-	h.log.WithFields(toLogrusFields(args)).Info(msg)
+func (h *HCLogAdapter) Info(msg string, args ...interface{}) {
+	h.entry(args).Info(msg)
 }
 
-func (h HCLogAdapter) Warn(msg string, args ...interface{}) {
-	h.log.WithFields(toLogrusFields(args)).Warn(msg)
+func (h *HCLogAdapter) Warn(msg string, args ...interface{}) {
+	h.entry(args).Warn(msg)
 }
 
-func (h HCLogAdapter) Error(msg string, args ...interface{}) {
-	h.log.WithFields(toLogrusFields(args)).Error(msg)
+func (h *HCLogAdapter) Error(msg string, args ...interface{}) {
+	h.entry(args).Error(msg)
 }
 
-func (h HCLogAdapter) IsTrace() bool {
-	return h.log.GetLevel() >= logrus.TraceLevel
+func (h *HCLogAdapter) IsTrace() bool {
+	return h.logger().IsLevelEnabled(logrus.TraceLevel)
 }
 
-func (h HCLogAdapter) IsDebug() bool {
-	return h.log.GetLevel() >= logrus.DebugLevel
+func (h *HCLogAdapter) IsDebug() bool {
+	return h.logger().IsLevelEnabled(logrus.DebugLevel)
 }
 
-func (h HCLogAdapter) IsInfo() bool {
-	return h.log.GetLevel() >= logrus.InfoLevel
+func (h *HCLogAdapter) IsInfo() bool {
+	return h.logger().IsLevelEnabled(logrus.InfoLevel)
 }
 
-func (h HCLogAdapter) IsWarn() bool {
-	return h.log.GetLevel() >= logrus.WarnLevel
+func (h *HCLogAdapter) IsWarn() bool {
+	return h.logger().IsLevelEnabled(logrus.WarnLevel)
 }
 
-func (h HCLogAdapter) IsError() bool {
-	return h.log.GetLevel() >= logrus.ErrorLevel
+func (h *HCLogAdapter) IsError() bool {
+	return h.logger().IsLevelEnabled(logrus.ErrorLevel)
 }
 
-func (h HCLogAdapter) ImpliedArgs() []interface{} {
-	// Not supported.
-	return nil
+func (h *HCLogAdapter) ImpliedArgs() []interface{} {
+	return h.impliedArgs
 }
 
-func (h HCLogAdapter) With(args ...interface{}) hclog.Logger {
-	return &h
+// With returns a logger that adds args to every entry.
+func (h *HCLogAdapter) With(args ...interface{}) hclog.Logger {
+	l := *h
+	l.impliedArgs = append(append([]interface{}{}, h.impliedArgs...), args...)
+	return &l
 }
 
-func (h HCLogAdapter) Name() string {
+func (h *HCLogAdapter) Name() string {
 	return h.name
 }
 
-func (h HCLogAdapter) Named(name string) hclog.Logger {
-	return NewHCLogAdapter(h.log, name)
+// Named returns a logger whose name is appended to this logger's name.
+func (h *HCLogAdapter) Named(name string) hclog.Logger {
+	if h.name != "" {
+		name = h.name + "." + name
+	}
+	return h.ResetNamed(name)
 }
 
-func (h HCLogAdapter) ResetNamed(name string) hclog.Logger {
-	return &h
+// ResetNamed returns a logger with the name instead of this logger's name.
+func (h *HCLogAdapter) ResetNamed(name string) hclog.Logger {
+	l := *h
+	l.name = name
+	return &l
 }
 
+// SetLevel sets the level of this logger and the loggers created from it. The
+// Logrus logger is not changed.
 func (h *HCLogAdapter) SetLevel(level hclog.Level) {
-	h.log.SetLevel(convertLevel(level))
+	h.level.mu.Lock()
+	defer h.level.mu.Unlock()
+
+	h.level.level = level
+	h.level.logger = nil
+	if level == hclog.NoLevel {
+		return
+	}
+	h.level.logger = &logrus.Logger{
+		Out:          h.log.Out,
+		Hooks:        h.log.Hooks,
+		Formatter:    h.log.Formatter,
+		ReportCaller: h.log.ReportCaller,
+		Level:        convertLevel(level),
+		ExitFunc:     h.log.ExitFunc,
+		BufferPool:   h.log.BufferPool,
+	}
+	if level == hclog.Off {
+		h.level.logger.Level = logrus.PanicLevel
+	}
 }
 
 func (h *HCLogAdapter) GetLevel() hclog.Level {
+	h.level.mu.RLock()
+	defer h.level.mu.RUnlock()
+
+	if h.level.level != hclog.NoLevel {
+		return h.level.level
+	}
 	return toHclogLevel(h.log.GetLevel())
 }
 
-func (h HCLogAdapter) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+func (h *HCLogAdapter) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
 	if opts == nil {
 		opts = &hclog.StandardLoggerOptions{}
 	}
 	return log.New(h.StandardWriter(opts), "", 0)
 }
 
-func (h HCLogAdapter) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
-	return os.Stderr
+// StandardWriter returns a writer that logs each line written to it. The
+// level is taken from opts, or from a "[LEVEL]" prefix on the line if
+// opts.InferLevels is set.
+func (h *HCLogAdapter) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	if opts == nil {
+		opts = &hclog.StandardLoggerOptions{}
+	}
+	return &standardWriter{log: h, opts: opts}
+}
+
+// logger returns the Logrus logger that entries are written with.
+func (h *HCLogAdapter) logger() *logrus.Logger {
+	h.level.mu.RLock()
+	defer h.level.mu.RUnlock()
+
+	if h.level.logger != nil {
+		return h.level.logger
+	}
+	return h.log
+}
+
+// entry returns a Logrus entry with the logger's name, implied arguments, and
+// args as fields. Arguments override implied arguments with the same key, and
+// a "@module" argument sent by a plugin overrides the name.
+func (h *HCLogAdapter) entry(args []interface{}) *logrus.Entry {
+	fields := logrus.Fields{}
+	if h.name != "" {
+		fields["@module"] = h.name
+	}
+	for k, v := range toLogrusFields(h.impliedArgs) {
+		fields[k] = v
+	}
+	for k, v := range toLogrusFields(args) {
+		fields[k] = v
+	}
+	return h.logger().WithFields(fields)
+}
+
+// standardWriter logs the lines written to it by a standard library logger.
+type standardWriter struct {
+	log  *HCLogAdapter
+	opts *hclog.StandardLoggerOptions
+}
+
+var levelPrefixes = map[string]hclog.Level{
+	"[TRACE]": hclog.Trace,
+	"[DEBUG]": hclog.Debug,
+	"[INFO]":  hclog.Info,
+	"[WARN]":  hclog.Warn,
+	"[ERROR]": hclog.Error,
+}
+
+func (w *standardWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte{'\n'}) {
+		msg := strings.TrimSpace(string(line))
+		level := hclog.Info
+		if w.opts.InferLevels {
+			for prefix, l := range levelPrefixes {
+				if strings.HasPrefix(msg, prefix) {
+					level = l
+					msg = strings.TrimSpace(strings.TrimPrefix(msg, prefix))
+					break
+				}
+			}
+		}
+		if w.opts.ForceLevel != hclog.NoLevel {
+			level = w.opts.ForceLevel
+		}
+		w.log.Log(level, msg)
+	}
+	return len(p), nil
 }
 
 // convertLevel maps hclog levels to Logrus levels.
@@ -0,0 +1,169 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	"github.com/replit/ephemeral-iam/internal/plugins"
+)
+
+func newTestLogger() (*logrus.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.Out = &buf
+	l.Formatter = &logrus.JSONFormatter{}
+	l.Level = logrus.InfoLevel
+	return l, &buf
+}
+
+func readEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid log entry %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	buf.Reset()
+	return entries
+}
+
+func TestHCLogAdapterFields(t *testing.T) {
+	l, buf := newTestLogger()
+	logger := plugins.NewHCLogAdapter(l, "").With("plugin", "foo").Named("foo")
+	logger.Named("sub").Info("hello", "key", "value", "timestamp", "ignored")
+	logger.Info("from the plugin", "@module", "foo.client")
+
+	entries := readEntries(t, buf)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", entries)
+	}
+	want := map[string]interface{}{"msg": "hello", "plugin": "foo", "@module": "foo.sub", "key": "value"}
+	for k, v := range want {
+		if entries[0][k] != v {
+			t.Errorf("expected %s=%v, got %v", k, v, entries[0][k])
+		}
+	}
+	if _, ok := entries[0]["timestamp"]; ok {
+		t.Error("expected the hclog timestamp to be dropped")
+	}
+	if entries[1]["@module"] != "foo.client" {
+		t.Errorf("expected the plugin's module to be kept, got %v", entries[1]["@module"])
+	}
+	if args := logger.ImpliedArgs(); len(args) != 2 || args[1] != "foo" {
+		t.Errorf("unexpected implied args %v", args)
+	}
+}
+
+func TestHCLogAdapterLevel(t *testing.T) {
+	l, buf := newTestLogger()
+	logger := plugins.NewHCLogAdapter(l, "").With("plugin", "foo")
+	derived := logger.Named("foo")
+
+	logger.SetLevel(hclog.Debug)
+	derived.Debug("visible")
+	if l.Level != logrus.InfoLevel {
+		t.Errorf("expected the host logger to stay at info, got %s", l.Level)
+	}
+	if got := readEntries(t, buf); len(got) != 1 || !derived.IsDebug() {
+		t.Errorf("expected the derived logger to log at debug, got %v", got)
+	}
+
+	logger.SetLevel(hclog.Error)
+	derived.Warn("hidden")
+	if got := readEntries(t, buf); len(got) != 0 || logger.GetLevel() != hclog.Error {
+		t.Errorf("expected warnings to be dropped at error, got %v", got)
+	}
+
+	logger.SetLevel(hclog.NoLevel)
+	if logger.GetLevel() != hclog.Info {
+		t.Errorf("expected the host level after resetting, got %s", logger.GetLevel())
+	}
+}
+
+func TestHCLogAdapterStandardWriter(t *testing.T) {
+	l, buf := newTestLogger()
+	logger := plugins.NewHCLogAdapter(l, "").With("plugin", "foo")
+
+	w := logger.StandardWriter(&hclog.StandardLoggerOptions{InferLevels: true})
+	if _, err := w.Write([]byte("[WARN] careful\nplain line\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	entries := readEntries(t, buf)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", entries)
+	}
+	if entries[0]["level"] != "warning" || entries[0]["msg"] != "careful" || entries[0]["plugin"] != "foo" {
+		t.Errorf("unexpected entry for the prefixed line: %v", entries[0])
+	}
+	if entries[1]["level"] != "info" || entries[1]["msg"] != "plain line" {
+		t.Errorf("unexpected entry for the plain line: %v", entries[1])
+	}
+}
+
+func TestPluginLoggerLevel(t *testing.T) {
+	l, buf := newTestLogger()
+	defer func(orig *logrus.Logger) { util.Logger = orig }(util.Logger)
+	util.Logger = l
+	key := appconfig.PluginRuntimeLogLevels + ".eiam-plugin-level"
+	defer viper.Set(key, nil)
+
+	tests := []struct {
+		name     string
+		setting  interface{}
+		want     hclog.Level
+		wantWarn bool
+		// followsEiam is set if the logger follows changes to the eiam level.
+		followsEiam bool
+	}{
+		{name: "unset", want: hclog.Info, followsEiam: true},
+		{name: "valid", setting: "debug", want: hclog.Debug},
+		{name: "invalid", setting: "verbose", want: hclog.Info, wantWarn: true, followsEiam: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set(key, tt.setting)
+			logger := plugins.PluginLogger("eiam-plugin-level")
+			if got := logger.GetLevel(); got != tt.want {
+				t.Errorf("GetLevel = %v, want %v", got, tt.want)
+			}
+			if warned := strings.Contains(buf.String(), "Ignoring invalid"); warned != tt.wantWarn {
+				t.Errorf("warned = %v, want %v: %s", warned, tt.wantWarn, buf.String())
+			}
+			buf.Reset()
+
+			l.SetLevel(logrus.WarnLevel)
+			defer l.SetLevel(logrus.InfoLevel)
+			if tt.followsEiam && logger.GetLevel() != hclog.Warn {
+				t.Errorf("GetLevel = %v, want the eiam level %v", logger.GetLevel(), hclog.Warn)
+			}
+		})
+	}
+}
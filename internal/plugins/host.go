@@ -0,0 +1,77 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"context"
+
+	pb "github.com/replit/ephemeral-iam/internal/plugins/proto"
+)
+
+// hostServer is the EIAMHost service that eiam serves to a running plugin.
+type hostServer struct {
+	pb.UnimplementedEIAMHostServer
+	name   string
+	schema []ConfigField
+}
+
+// GetConfig is the gRPC method that returns the settings of the plugin.
+func (h *hostServer) GetConfig(ctx context.Context, req *pb.GetConfigRequest) (*pb.PluginConfig, error) {
+	return &pb.PluginConfig{Values: PluginConfig(h.name, h.schema)}, nil
+}
+
+// hostClient is the Host used by the plugin to call back into eiam.
+type hostClient struct {
+	client pb.EIAMHostClient
+}
+
+// GetConfig returns the plugin's settings from the eiam config.
+func (h *hostClient) GetConfig() (map[string]string, error) {
+	resp, err := h.client.GetConfig(context.Background(), &pb.GetConfigRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Values, nil
+}
+
+func toProtoFields(fields []ConfigField) []*pb.ConfigField {
+	pbFields := make([]*pb.ConfigField, 0, len(fields))
+	for _, f := range fields {
+		pbFields = append(pbFields, &pb.ConfigField{
+			Name:        f.Name,
+			Description: f.Description,
+			Type:        f.Type,
+			Secret:      f.Secret,
+			Default:     f.Default,
+			Allowed:     f.Allowed,
+		})
+	}
+	return pbFields
+}
+
+func fromProtoFields(pbFields []*pb.ConfigField) []ConfigField {
+	fields := make([]ConfigField, 0, len(pbFields))
+	for _, f := range pbFields {
+		fields = append(fields, ConfigField{
+			Name:        f.Name,
+			Description: f.Description,
+			Type:        f.Type,
+			Secret:      f.Secret,
+			Default:     f.Default,
+			Allowed:     f.Allowed,
+		})
+	}
+	return fields
+}
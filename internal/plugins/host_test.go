@@ -0,0 +1,209 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	hcplugin "github.com/hashicorp/go-plugin"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	"github.com/replit/ephemeral-iam/internal/hooks"
+	"github.com/replit/ephemeral-iam/internal/plugins"
+	eiamplugin "github.com/replit/ephemeral-iam/pkg/plugins"
+)
+
+type configPlugin struct {
+	host   eiamplugin.Host
+	config map[string]string
+	events []eiamplugin.Event
+}
+
+func (p *configPlugin) GetInfo() (name, desc, version string, err error) {
+	return "config-plugin", "A plugin with settings", "v0.0.1", nil
+}
+
+func (p *configPlugin) ConfigSchema() []eiamplugin.ConfigField {
+	return []eiamplugin.ConfigField{
+		{Name: "region", Description: "The region to use", Default: "us-central1"},
+		{Name: "apiKey", Description: "The API key", Secret: true},
+	}
+}
+
+func (p *configPlugin) SetHost(host eiamplugin.Host) {
+	p.host = host
+}
+
+func (p *configPlugin) Events() []eiamplugin.EventType {
+	return []eiamplugin.EventType{eiamplugin.SessionStarted}
+}
+
+func (p *configPlugin) HandleEvent(e eiamplugin.Event) error {
+	p.events = append(p.events, e)
+	return nil
+}
+
+func (p *configPlugin) Run() (err error) {
+	p.config, err = p.host.GetConfig()
+	return err
+}
+
+func TestPluginConfigThroughHostService(t *testing.T) {
+	viper.Set("plugins.config-plugin.apikey", "hunter2")
+	defer viper.Set("plugins.config-plugin", nil)
+
+	impl := &configPlugin{}
+	client, _ := hcplugin.TestPluginGRPCConn(t, false, map[string]hcplugin.Plugin{
+		"run-command": &eiamplugin.Command{Impl: impl},
+	})
+	defer client.Close()
+
+	raw, err := client.Dispense("run-command")
+	if err != nil {
+		t.Fatalf("failed to dispense plugin: %v", err)
+	}
+	p := raw.(plugins.EIAMPlugin)
+	if _, _, _, err := p.GetInfo(); err != nil {
+		t.Fatalf("GetInfo failed: %v", err)
+	}
+
+	schema := p.(plugins.ConfigurablePlugin).ConfigSchema()
+	if f, ok := plugins.FindField(schema, "apikey"); !ok || !f.Secret {
+		t.Errorf("expected the apiKey field to be reported as secret, got %+v", schema)
+	}
+
+	if err := p.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if impl.config["region"] != "us-central1" {
+		t.Errorf("expected the default region, got %q", impl.config["region"])
+	}
+	if impl.config["apikey"] != "hunter2" {
+		t.Errorf("expected the configured API key, got %q", impl.config["apikey"])
+	}
+}
+
+func TestConfigFieldParseValue(t *testing.T) {
+	tests := []struct {
+		field   plugins.ConfigField
+		value   string
+		wantErr bool
+	}{
+		{plugins.ConfigField{Name: "a"}, "anything", false},
+		{plugins.ConfigField{Name: "a", Type: plugins.ConfigBool}, "yes", true},
+		{plugins.ConfigField{Name: "a", Type: plugins.ConfigBool}, "true", false},
+		{plugins.ConfigField{Name: "a", Type: plugins.ConfigInt}, "1.5", true},
+		{plugins.ConfigField{Name: "a", Type: plugins.ConfigDuration}, "5m", false},
+		{plugins.ConfigField{Name: "a", Type: plugins.ConfigDuration}, "5", true},
+		{plugins.ConfigField{Name: "a", Allowed: []string{"x", "y"}}, "z", true},
+	}
+	for _, tt := range tests {
+		if _, err := tt.field.ParseValue(tt.value); (err != nil) != tt.wantErr {
+			t.Errorf("ParseValue(%q) on %+v: got error %v, want error %v", tt.value, tt.field, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPluginEventSubscription(t *testing.T) {
+	impl := &configPlugin{}
+	client, _ := hcplugin.TestPluginGRPCConn(t, false, map[string]hcplugin.Plugin{
+		"run-command": &eiamplugin.Command{Impl: impl},
+	})
+	defer client.Close()
+
+	raw, err := client.Dispense("run-command")
+	if err != nil {
+		t.Fatalf("failed to dispense plugin: %v", err)
+	}
+	p := raw.(plugins.EIAMPlugin)
+	if _, _, _, err := p.GetInfo(); err != nil {
+		t.Fatalf("GetInfo failed: %v", err)
+	}
+
+	sub := p.(plugins.EventSubscriber)
+	if events := sub.Events(); len(events) != 1 || events[0] != hooks.SessionStarted {
+		t.Fatalf("expected a subscription to session_started, got %v", events)
+	}
+	event := hooks.Event{Type: hooks.SessionStarted, ServiceAccount: "sa@project.iam.gserviceaccount.com"}
+	if err := sub.HandleEvent(context.Background(), event); err != nil {
+		t.Fatalf("HandleEvent failed: %v", err)
+	}
+	if len(impl.events) != 1 || impl.events[0].ServiceAccount != event.ServiceAccount {
+		t.Errorf("plugin did not receive the event, got %+v", impl.events)
+	}
+}
+
+type blockingPlugin struct {
+	cancelled chan struct{}
+}
+
+func (p *blockingPlugin) GetInfo() (name, desc, version string, err error) {
+	return "blocking-plugin", "A plugin that runs until it is cancelled", "v0.0.1", nil
+}
+
+func (p *blockingPlugin) Run() error {
+	return p.RunContext(context.Background())
+}
+
+func (p *blockingPlugin) RunContext(ctx context.Context) error {
+	<-ctx.Done()
+	close(p.cancelled)
+	return ctx.Err()
+}
+
+func TestPluginRunCancelled(t *testing.T) {
+	impl := &blockingPlugin{cancelled: make(chan struct{})}
+	client, _ := hcplugin.TestPluginGRPCConn(t, false, map[string]hcplugin.Plugin{
+		"run-command": &eiamplugin.Command{Impl: impl},
+	})
+	defer client.Close()
+
+	raw, err := client.Dispense("run-command")
+	if err != nil {
+		t.Fatalf("failed to dispense plugin: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := raw.(plugins.ContextPlugin).RunContext(ctx); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected the run to exceed its deadline, got %v", err)
+	}
+
+	select {
+	case <-impl.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the plugin's context was not cancelled")
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	viper.Set(appconfig.PluginRuntimeTimeout, "5m")
+	viper.Set(appconfig.PluginRuntimeTimeouts+".slow-plugin", "1h")
+	defer func() {
+		viper.Set(appconfig.PluginRuntimeTimeout, nil)
+		viper.Set(appconfig.PluginRuntimeTimeouts, nil)
+	}()
+
+	if d := plugins.RunTimeout("other-plugin"); d != 5*time.Minute {
+		t.Errorf("expected the default timeout, got %s", d)
+	}
+	if d := plugins.RunTimeout("slow-plugin"); d != time.Hour {
+		t.Errorf("expected the plugin's timeout, got %s", d)
+	}
+}
@@ -1,100 +1,327 @@
 package plugins
 
 import (
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
-	"github.com/google/go-github/v33/github"
 	"github.com/h2non/filetype"
 	"github.com/manifoldco/promptui"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"golang.org/x/mod/semver"
 
 	"github.com/replit/ephemeral-iam/internal/appconfig"
 	util "github.com/replit/ephemeral-iam/internal/eiamutil"
 	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
 )
 
-// InstallPlugin attempts to install an ephemeral-iam plugin from a given Github repo.
-func InstallPlugin(repoOwner, repoName, tokenName string) error {
-	token := ""
-	if viper.GetBool(appconfig.GithubAuth) && tokenName != "" {
-		tokenConfig := viper.GetStringMapString(appconfig.GithubTokens)
-		if t, ok := tokenConfig[tokenName]; ok {
-			token = t
-		} else {
-			util.Logger.Errorf("No Github token named %s exists. Continuing without authentication", tokenName)
+var githubURLRegex = regexp.MustCompile(
+	`^github\.com/(?P<user>[[:alnum:]\-]+)/(?P<repo>[[:alnum:]\.\-_]+?)(?:@(?P<version>[[:alnum:]\.\-_+]+))?$`,
+)
+
+// ParseGithubURL splits a plugin URL of the form github.com/owner/repo[@version]
+// into its parts. The version is empty if none was given.
+func ParseGithubURL(url string) (repoOwner, repoName, version string, err error) {
+	match := githubURLRegex.FindStringSubmatch(url)
+	if match == nil {
+		return "", "", "", fmt.Errorf("%s is not a valid Github repo URL", url)
+	}
+	for i, grpName := range githubURLRegex.SubexpNames() {
+		switch grpName {
+		case "user":
+			repoOwner = match[i]
+		case "repo":
+			repoName = match[i]
+		case "version":
+			version = match[i]
 		}
 	}
-	release, err := util.GetLatestRelease(repoOwner, repoName, token)
+	return repoOwner, repoName, version, nil
+}
+
+// InstallPlugin installs the plugin referenced by ref. If no version is given
+// in the reference, the latest one is installed. See ParseSource for the
+// supported references.
+func InstallPlugin(ref, tokenName string) error {
+	src, version, err := ParseSource(ref, SourceOptions{Token: githubToken(tokenName)})
 	if err != nil {
-		if sErr, ok := err.(*github.ErrorResponse); ok {
-			if sErr.Message == "Not Found" {
-				return handleRepoNotFound(repoOwner, repoName)
+		return errorsutil.New("Invalid plugin source", err)
+	}
+	if err := Install(src, version, InstallOptions{}); err != nil {
+		if errors.Is(err, errRepoNotFound) {
+			tokenName, retry, err := handleRepoNotFound(src)
+			if err != nil || !retry {
+				return err
 			}
-			return errorsutil.New(sErr.Message, errors.New(sErr.Response.Status))
+			return InstallPlugin(ref, tokenName)
 		}
-		return errorsutil.New("Failed to get release from repository", err)
-	}
-	downloadURL, err := util.GetReleaseDownloadURL(release, true)
-	if err != nil {
-		return errorsutil.New("Failed to get download URL for release", err)
+		return err
 	}
+	return nil
+}
+
+// InstallOptions restricts which of the fetched binaries are installed.
+type InstallOptions struct {
+	// Binary limits the install to the executable with this file name.
+	Binary string
 
+	// Checksum is the expected digest of the installed binary in the form
+	// "sha256:<hex>". The install fails if the binary does not match.
+	Checksum string
+}
+
+// Install fetches a version of a plugin from src, moves its binaries into the
+// plugins directory and records them in the plugin manifest.
+func Install(src Source, version string, opts InstallOptions) error {
 	tmpDir, err := os.MkdirTemp(os.TempDir(), "eiamplugin")
 	if err != nil {
 		return errorsutil.New("Failed to create temp dir for plugin", err)
 	}
-	defer os.Remove(tmpDir)
-	if err := util.DownloadAndExtract(downloadURL, tmpDir, token); err != nil {
-		return errorsutil.New("Failed to process the plugin release", err)
+	defer os.RemoveAll(tmpDir)
+
+	fetched, err := src.Fetch(context.Background(), version, tmpDir)
+	if err != nil {
+		if errors.Is(err, errRepoNotFound) {
+			return err
+		}
+		return errorsutil.New(fmt.Sprintf("Failed to fetch plugin from %s", src), err)
+	}
+	return installDownloadedPlugin(tmpDir, src.String(), fetched, opts)
+}
+
+// LatestVersion returns the newest version available from a plugin source.
+// Unversioned sources return an empty string.
+func LatestVersion(source, tokenName string) (string, error) {
+	src, _, err := ParseSource(source, SourceOptions{Token: githubToken(tokenName)})
+	if err != nil {
+		return "", err
+	}
+	latest, err := src.LatestVersion(context.Background())
+	if err != nil {
+		return "", errorsutil.New(fmt.Sprintf("Failed to get latest version of %s", source), err)
 	}
-	return installDownloadedPlugin(tmpDir)
+	return latest, nil
 }
 
-func installDownloadedPlugin(tmpDir string) error {
+// Checksum returns the digest of a plugin binary in the form "sha256:<hex>".
+func Checksum(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// FileChecksum returns the digest of the plugin binary at path.
+func FileChecksum(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return Checksum(data), nil
+}
+
+// IsNewer reports whether the latest version is newer than the installed one.
+// Versions that are not valid semantic versions are considered outdated
+// whenever they differ.
+func IsNewer(latest, installed string) bool {
+	if semver.IsValid(latest) && semver.IsValid(installed) {
+		return semver.Compare(latest, installed) > 0
+	}
+	return latest != installed
+}
+
+func githubToken(tokenName string) string {
+	if !viper.GetBool(appconfig.GithubAuth) || tokenName == "" {
+		return ""
+	}
+	token, err := appconfig.GithubToken(tokenName)
+	if err != nil {
+		util.Logger.WithError(err).Error("Failed to read Github token. Continuing without authentication")
+		return ""
+	}
+	return token
+}
+
+func installDownloadedPlugin(tmpDir, source, version string, opts InstallOptions) error {
 	files, err := os.ReadDir(tmpDir)
 	if err != nil {
 		return errorsutil.New("Failed to list downloaded files", err)
 	}
 
-	pluginDir := filepath.Join(appconfig.GetConfigDir(), "plugins")
+	manifest, err := LoadManifest()
+	if err != nil {
+		return err
+	}
+
+	// The binaries are replaced one at a time, and all of them are put back if
+	// any of them, or saving the manifest, fails.
+	type install struct {
+		binary   string
+		checksum string
+		backup   *pluginBackup
+	}
+	var installs []install
+	fail := func(err error) error {
+		errs := []error{err}
+		for i := len(installs) - 1; i >= 0; i-- {
+			if rbErr := installs[i].backup.restore(); rbErr != nil {
+				errs = append(errs, rbErr)
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	pluginDir := PluginDir()
 	for _, file := range files {
+		if opts.Binary != "" && file.Name() != opts.Binary {
+			continue
+		}
 		fp := filepath.Join(tmpDir, file.Name())
 		buf, err := os.ReadFile(fp)
 		if err != nil {
-			return errorsutil.New("Failed to read file downloaded in release", err)
+			return fail(errorsutil.New("Failed to read file downloaded in release", err))
 		}
 		kind, err := filetype.Match(buf)
 		if err != nil {
-			return errorsutil.New("Failed to determine MIME type of file downloaded in release", err)
+			return fail(errorsutil.New("Failed to determine MIME type of file downloaded in release", err))
 		}
-		if kind.MIME.Value == "application/x-executable" {
+		if kind.MIME.Value == "application/x-executable" || kind.MIME.Value == "application/x-mach-binary" {
+			checksum := Checksum(buf)
+			if opts.Checksum != "" && !strings.EqualFold(opts.Checksum, checksum) {
+				return fail(errorsutil.New(
+					fmt.Sprintf("Refusing to install %s", file.Name()),
+					fmt.Errorf("checksum %s does not match the expected %s", checksum, opts.Checksum),
+				))
+			}
 			targetPath := filepath.Join(pluginDir, file.Name())
+			backup, err := backupPlugin(file.Name())
+			if err != nil {
+				return fail(err)
+			}
+			installs = append(installs, install{binary: file.Name(), checksum: checksum, backup: backup})
 			if err := util.MoveFile(fp, targetPath); err != nil {
-				return errorsutil.New("Failed to move plugin binary to plugins directory", err)
+				return fail(errorsutil.New("Failed to move plugin binary to plugins directory", err))
 			}
 			if err := os.Chmod(targetPath, 0o700); err != nil {
-				if rmErr := os.Remove(targetPath); rmErr != nil {
-					return errorsutil.New("Failed to update file permissions then remove binary", rmErr)
-				}
-				return errorsutil.New("Failed to make plugin binary executable", err)
+				return fail(errorsutil.New("Failed to make plugin binary executable", err))
 			}
 		}
 	}
+	if len(installs) == 0 {
+		if opts.Binary != "" {
+			err := fmt.Errorf("%s did not contain an executable named %s", source, opts.Binary)
+			return errorsutil.New("Failed to install plugin", err)
+		}
+		return errorsutil.New("Failed to install plugin", fmt.Errorf("%s did not contain any executables", source))
+	}
+
+	for _, in := range installs {
+		manifest.record(in.binary, source, version, in.checksum, in.backup.backup != "")
+	}
+	if err := manifest.Save(); err != nil {
+		return fail(err)
+	}
+	for _, in := range installs {
+		in.backup.commit()
+		util.Logger.Infof("Installed %s %s from %s", in.binary, version, source)
+	}
 	return nil
 }
 
-func handleRepoNotFound(repoOwner, repoName string) error {
+// pluginBackup is the backup of an installed binary taken before it is
+// replaced, so that a failed install can put it back.
+type pluginBackup struct {
+	current string
+	// backup is empty if the plugin was not installed.
+	backup string
+	// older holds the backup that was already there, if any.
+	older string
+}
+
+// backupPlugin moves an already installed binary into the backup directory so
+// that it can be restored with Rollback. The backup it replaces is kept aside
+// until commit or restore is called.
+func backupPlugin(binary string) (*pluginBackup, error) {
+	b := &pluginBackup{current: filepath.Join(PluginDir(), binary)}
+	if _, err := os.Stat(b.current); os.IsNotExist(err) {
+		return b, nil
+	} else if err != nil {
+		return nil, errorsutil.New(fmt.Sprintf("Failed to check for existing plugin %s", binary), err)
+	}
+
+	backupDir := filepath.Join(PluginDir(), BackupDir)
+	if err := os.MkdirAll(backupDir, 0o700); err != nil {
+		return nil, errorsutil.New("Failed to create plugin backup directory", err)
+	}
+	backup := filepath.Join(backupDir, binary)
+	if _, err := os.Stat(backup); err == nil {
+		b.older = backup + ".swap"
+		if err := os.Rename(backup, b.older); err != nil {
+			return nil, errorsutil.New(fmt.Sprintf("Failed to move aside the backup of %s", binary), err)
+		}
+	}
+	if err := os.Rename(b.current, backup); err != nil {
+		if b.older != "" {
+			if rbErr := os.Rename(b.older, backup); rbErr != nil {
+				util.Logger.WithError(rbErr).Errorf("Failed to restore the backup of %s", binary)
+			}
+		}
+		return nil, errorsutil.New(fmt.Sprintf("Failed to back up existing plugin %s", binary), err)
+	}
+	b.backup = backup
+	return b, nil
+}
+
+// commit drops the replaced backup once the new binary is installed. It
+// reports whether a backup of the previous install was taken.
+func (b *pluginBackup) commit() bool {
+	if b.older != "" {
+		if err := os.Remove(b.older); err != nil {
+			util.Logger.WithError(err).Warnf("Failed to remove %s", b.older)
+		}
+	}
+	return b.backup != ""
+}
+
+// restore puts the backed up binary, and the backup it replaced, back in place
+// after a failed install. If the plugin was not installed before, the new
+// binary is removed instead.
+func (b *pluginBackup) restore() error {
+	if b.backup == "" {
+		if err := os.Remove(b.current); err != nil && !os.IsNotExist(err) {
+			return errorsutil.New(fmt.Sprintf("Failed to remove %s after a failed install", b.current), err)
+		}
+		return nil
+	}
+	if err := os.Rename(b.backup, b.current); err != nil {
+		return errorsutil.New(fmt.Sprintf("Failed to restore %s after a failed install", b.current), err)
+	}
+	if b.older != "" {
+		if err := os.Rename(b.older, b.backup); err != nil {
+			return errorsutil.New(fmt.Sprintf("Failed to restore %s after a failed install", b.backup), err)
+		}
+	}
+	return nil
+}
+
+// handleRepoNotFound explains why a plugin source could not be found and
+// offers to retry with one of the Github access tokens. It returns the name of
+// the token to retry with, if the user picked one.
+func handleRepoNotFound(src Source) (tokenName string, retry bool, err error) {
 	util.Logger.WithFields(logrus.Fields{
-		"repo": fmt.Sprintf("github.com/%s/%s", repoOwner, repoName),
-	}).Errorf("Repository either doesn't exist, or it is private")
+		"repo": src.String(),
+	}).Errorf("Repository or release either doesn't exist, or it is private")
 
+	if _, ok := src.(*GithubSource); !ok {
+		util.Logger.Warn("If the repo is private, set the GITLAB_TOKEN environment variable to a token that can read it")
+		return "", false, nil
+	}
 	if !viper.GetBool(appconfig.GithubAuth) {
 		util.Logger.Warn("If the repo is private, add an access token with the 'plugins auth add' command")
-		return nil
+		return "", false, nil
 	}
 
 	prompt := promptui.Prompt{
@@ -104,31 +331,26 @@ func handleRepoNotFound(repoOwner, repoName string) error {
 
 	fmt.Println()
 	if _, err := prompt.Run(); err != nil {
-		return nil
+		return "", false, nil
 	}
 	fmt.Println()
-	tokenConfig := viper.GetStringMapString(appconfig.GithubTokens)
+	tokenNames := appconfig.GithubTokenNames()
 
-	if len(tokenConfig) == 0 {
-		viper.Set(appconfig.GithubAuth, false)
-		if err := viper.WriteConfig(); err != nil {
-			return errorsutil.New("Failed to update 'github.auth' field in config", err)
+	if len(tokenNames) == 0 {
+		appconfig.Set(appconfig.GithubAuth, false)
+		if err := appconfig.WriteConfig(); err != nil {
+			return "", false, errorsutil.New("Failed to update 'github.auth' field in config", err)
 		}
 		err := errors.New("no Github access tokens found")
-		return errorsutil.New("Please add a Github access token using the 'plugins auth add' command", err)
+		return "", false, errorsutil.New("Please add a Github access token using the 'plugins auth add' command", err)
 	}
 
-	var token string
-	if len(tokenConfig) == 1 {
-		for key := range tokenConfig {
-			token = key
-		}
-	} else {
-		tokenName, err := util.SelectToken(tokenConfig)
-		if err != nil {
-			return errorsutil.New("Failed to select access token", err)
-		}
-		token = tokenName
+	if len(tokenNames) == 1 {
+		return tokenNames[0], true, nil
+	}
+	tokenName, err = util.SelectToken(tokenNames)
+	if err != nil {
+		return "", false, errorsutil.New("Failed to select access token", err)
 	}
-	return InstallPlugin(repoOwner, repoName, token)
+	return tokenName, true, nil
 }
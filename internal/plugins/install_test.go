@@ -0,0 +1,131 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupPluginRestore(t *testing.T) {
+	binary := "eiam-plugin-restore"
+	current := filepath.Join(PluginDir(), binary)
+	backup := filepath.Join(PluginDir(), BackupDir, binary)
+	if err := os.MkdirAll(filepath.Dir(backup), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(current)
+	defer os.Remove(backup)
+	if err := os.WriteFile(current, []byte("v2"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backup, []byte("v1"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := backupPlugin(binary)
+	if err != nil {
+		t.Fatalf("backupPlugin failed: %v", err)
+	}
+	if _, err := os.Stat(current); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be moved to the backup directory, got %v", current, err)
+	}
+
+	if err := b.restore(); err != nil {
+		t.Errorf("restore failed: %v", err)
+	}
+	for path, want := range map[string]string{current: "v2", backup: "v1"} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", path, data, err, want)
+		}
+	}
+
+	b, err = backupPlugin(binary)
+	if err != nil {
+		t.Fatalf("backupPlugin failed: %v", err)
+	}
+	if !b.commit() {
+		t.Error("commit = false, want a backup to be reported")
+	}
+	if data, err := os.ReadFile(backup); err != nil || string(data) != "v2" {
+		t.Errorf("backup = %q, %v, want the replaced binary", data, err)
+	}
+	if _, err := os.Stat(backup + ".swap"); !os.IsNotExist(err) {
+		t.Errorf("expected the older backup to be removed, got %v", err)
+	}
+
+	b, err = backupPlugin("eiam-plugin-missing")
+	if err != nil {
+		t.Fatalf("backupPlugin failed: %v", err)
+	}
+	missing := filepath.Join(PluginDir(), "eiam-plugin-missing")
+	if err := os.WriteFile(missing, []byte("v1"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.restore(); err != nil {
+		t.Errorf("restore failed: %v", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("expected the new binary of a plugin that was not installed to be removed, got %v", err)
+	}
+	if b.commit() {
+		t.Error("commit = true for a plugin that was not installed")
+	}
+}
+
+func TestInstallRestoresAllBinariesOnFailure(t *testing.T) {
+	installed := filepath.Join(PluginDir(), "eiam-plugin-a")
+	if err := os.MkdirAll(PluginDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(installed)
+	defer os.Remove(filepath.Join(PluginDir(), ManifestFile))
+	if err := os.WriteFile(installed, []byte("v1"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	m := newTestManifest()
+	m.record("eiam-plugin-a", "github.com/a/b", "v1.0.0", "abc", false)
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first binary is replaced before the second one fails to be read.
+	tmpDir := t.TempDir()
+	elf := append([]byte("\x7fELF"), make([]byte, 60)...)
+	if err := os.WriteFile(filepath.Join(tmpDir, "eiam-plugin-a"), elf, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(tmpDir, "eiam-plugin-b"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := installDownloadedPlugin(tmpDir, "github.com/a/b", "v1.1.0", InstallOptions{}); err == nil {
+		t.Fatal("expected the install to fail")
+	}
+
+	if data, err := os.ReadFile(installed); err != nil || string(data) != "v1" {
+		t.Errorf("installed binary = %q, %v, want the previous binary", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(PluginDir(), BackupDir, "eiam-plugin-a")); !os.IsNotExist(err) {
+		t.Errorf("expected no backup to be left behind, got %v", err)
+	}
+	loaded, err := LoadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := loaded.Plugins["eiam-plugin-a"]; entry == nil || entry.Version != "v1.0.0" || entry.Previous != nil {
+		t.Errorf("manifest entry = %+v, want the previous install", entry)
+	}
+}
@@ -14,8 +14,78 @@
 
 package plugins
 
+import (
+	"context"
+
+	"github.com/replit/ephemeral-iam/internal/hooks"
+)
+
 // EIAMPlugin is the interface that is exposed to external plugins to implement.
 type EIAMPlugin interface {
 	GetInfo() (name, desc, version string, err error)
 	Run() error
 }
+
+// ContextPlugin is implemented by plugins whose command stops when it is
+// cancelled. RunContext is called instead of Run, and its context is cancelled
+// when the user interrupts eiam or the plugin's timeout expires.
+type ContextPlugin interface {
+	RunContext(ctx context.Context) error
+}
+
+// ConfigurablePlugin is implemented by plugins that store settings in the
+// plugins.<name> section of the eiam configuration. The fields it returns are
+// used to validate 'eiam config set plugins.<name>.<field>' and to mask
+// secrets in 'eiam config print'.
+type ConfigurablePlugin interface {
+	ConfigSchema() []ConfigField
+}
+
+// HostAware is implemented by plugins that use the services eiam provides to
+// plugins. SetHost is called before Run.
+type HostAware interface {
+	SetHost(host Host)
+}
+
+// EventHandler is implemented by plugins that subscribe to lifecycle events.
+// HandleEvent is called for each of the events returned by Events while eiam
+// runs any command, not only the plugin's own.
+type EventHandler interface {
+	Events() []hooks.EventType
+	HandleEvent(e hooks.Event) error
+}
+
+// EventSubscriber is implemented by the eiam side of a plugin connection to
+// deliver the events the plugin subscribed to.
+type EventSubscriber interface {
+	Events() []hooks.EventType
+	HandleEvent(ctx context.Context, e hooks.Event) error
+}
+
+// Host is the set of services eiam provides to a running plugin.
+type Host interface {
+	// GetConfig returns the plugin's settings with defaults from its schema
+	// applied.
+	GetConfig() (map[string]string, error)
+}
+
+// Types of plugin configuration fields.
+const (
+	ConfigString   = "string"
+	ConfigBool     = "bool"
+	ConfigInt      = "int"
+	ConfigDuration = "duration"
+)
+
+// ConfigField describes a setting that a plugin stores in the eiam config.
+type ConfigField struct {
+	Name        string
+	Description string
+	// Type is one of ConfigString, ConfigBool, ConfigInt, or ConfigDuration.
+	// An empty type is treated as ConfigString.
+	Type    string
+	Secret  bool
+	Default string
+	// Allowed restricts the field to a set of values if it is not empty.
+	Allowed []string
+}
@@ -0,0 +1,223 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+const (
+	// ManifestFile is the name of the file in the plugins directory that tracks
+	// where each installed plugin came from.
+	ManifestFile = "manifest.json"
+
+	// BackupDir is the directory in the plugins directory that holds the
+	// previously installed binary of each plugin so it can be rolled back.
+	BackupDir = ".previous"
+)
+
+// Manifest tracks the source and version of the plugins installed by eiam, and
+// which plugins are disabled or quarantined. Entries are keyed by the file name
+// of the plugin binary.
+type Manifest struct {
+	Plugins     map[string]*ManifestEntry `json:"plugins"`
+	Disabled    map[string]*Disabled      `json:"disabled,omitempty"`
+	Quarantined map[string]*Quarantine    `json:"quarantined,omitempty"`
+}
+
+// ManifestEntry is the install record for a single plugin binary.
+type ManifestEntry struct {
+	Source      string           `json:"source"`
+	Version     string           `json:"version"`
+	Checksum    string           `json:"checksum,omitempty"`
+	InstalledAt time.Time        `json:"installedAt"`
+	Previous    *PreviousInstall `json:"previous,omitempty"`
+}
+
+// PreviousInstall is the install record of the binary kept in the backup
+// directory.
+type PreviousInstall struct {
+	Source      string    `json:"source"`
+	Version     string    `json:"version"`
+	Checksum    string    `json:"checksum,omitempty"`
+	InstalledAt time.Time `json:"installedAt"`
+}
+
+// PluginDir returns the directory that plugins are loaded from.
+func PluginDir() string {
+	return filepath.Join(appconfig.GetConfigDir(), "plugins")
+}
+
+// IsReservedFile reports whether a file in the plugins directory is managed by
+// eiam itself and should not be loaded as a plugin.
+func IsReservedFile(name string) bool {
+	return name == ManifestFile || name == BackupDir
+}
+
+// LoadManifest reads the plugin manifest from the plugins directory. A missing
+// manifest is treated as an empty one.
+func LoadManifest() (*Manifest, error) {
+	m := &Manifest{
+		Plugins:     map[string]*ManifestEntry{},
+		Disabled:    map[string]*Disabled{},
+		Quarantined: map[string]*Quarantine{},
+	}
+	data, err := os.ReadFile(filepath.Join(PluginDir(), ManifestFile))
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, errorsutil.New("Failed to read plugin manifest", err)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errorsutil.New("Failed to parse plugin manifest", err)
+	}
+	if m.Plugins == nil {
+		m.Plugins = map[string]*ManifestEntry{}
+	}
+	if m.Disabled == nil {
+		m.Disabled = map[string]*Disabled{}
+	}
+	if m.Quarantined == nil {
+		m.Quarantined = map[string]*Quarantine{}
+	}
+	return m, nil
+}
+
+// Save writes the manifest back to the plugins directory.
+func (m *Manifest) Save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errorsutil.New("Failed to serialize plugin manifest", err)
+	}
+	if err := os.WriteFile(filepath.Join(PluginDir(), ManifestFile), data, 0o600); err != nil {
+		return errorsutil.New("Failed to write plugin manifest", err)
+	}
+	return nil
+}
+
+// Names returns the sorted binary names of the tracked plugins.
+func (m *Manifest) Names() []string {
+	names := make([]string, 0, len(m.Plugins))
+	for name := range m.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// record marks a binary as installed from the given source and version,
+// remembering the install it replaced if a backup was taken.
+func (m *Manifest) record(binary, source, version, checksum string, backedUp bool) {
+	entry := &ManifestEntry{
+		Source:      source,
+		Version:     version,
+		Checksum:    checksum,
+		InstalledAt: time.Now().UTC(),
+	}
+	if prev, ok := m.Plugins[binary]; ok && backedUp {
+		entry.Previous = &PreviousInstall{
+			Source:      prev.Source,
+			Version:     prev.Version,
+			Checksum:    prev.Checksum,
+			InstalledAt: prev.InstalledAt,
+		}
+	}
+	m.Plugins[binary] = entry
+	delete(m.Quarantined, binary)
+}
+
+// Rollback swaps the installed binary of a plugin with the previously installed
+// one. Rolling back twice restores the original install.
+func Rollback(binary string) (*ManifestEntry, error) {
+	manifest, err := LoadManifest()
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := manifest.Plugins[binary]
+	if !ok || entry.Previous == nil {
+		return nil, fmt.Errorf("no previous version of %s is available", binary)
+	}
+
+	current := filepath.Join(PluginDir(), binary)
+	backup := filepath.Join(PluginDir(), BackupDir, binary)
+	if _, err := os.Stat(backup); err != nil {
+		return nil, errorsutil.New(fmt.Sprintf("Failed to find backup of %s", binary), err)
+	}
+
+	swap := backup + ".swap"
+	if err := os.Rename(current, swap); err != nil {
+		return nil, errorsutil.New("Failed to move current plugin binary", err)
+	}
+	if err := os.Rename(backup, current); err != nil {
+		if rbErr := os.Rename(swap, current); rbErr != nil {
+			return nil, errorsutil.New("Failed to restore plugin binary after a failed rollback", rbErr)
+		}
+		return nil, errorsutil.New("Failed to restore previous plugin binary", err)
+	}
+	if err := os.Rename(swap, backup); err != nil {
+		return nil, errorsutil.New("Failed to keep current plugin binary as backup", err)
+	}
+
+	rolledBack := &ManifestEntry{
+		Source:      entry.Previous.Source,
+		Version:     entry.Previous.Version,
+		Checksum:    entry.Previous.Checksum,
+		InstalledAt: entry.Previous.InstalledAt,
+		Previous: &PreviousInstall{
+			Source:      entry.Source,
+			Version:     entry.Version,
+			Checksum:    entry.Checksum,
+			InstalledAt: entry.InstalledAt,
+		},
+	}
+	manifest.Plugins[binary] = rolledBack
+	if err := manifest.Save(); err != nil {
+		return nil, err
+	}
+	return rolledBack, nil
+}
+
+// Uninstall removes a plugin binary along with its backup and manifest entry.
+func Uninstall(binary string) error {
+	if err := os.Remove(filepath.Join(PluginDir(), binary)); err != nil && !os.IsNotExist(err) {
+		return errorsutil.New(fmt.Sprintf("Failed to remove plugin file %s", binary), err)
+	}
+	if err := os.Remove(filepath.Join(PluginDir(), BackupDir, binary)); err != nil && !os.IsNotExist(err) {
+		return errorsutil.New(fmt.Sprintf("Failed to remove backup of plugin %s", binary), err)
+	}
+
+	manifest, err := LoadManifest()
+	if err != nil {
+		return err
+	}
+	_, tracked := manifest.Plugins[binary]
+	_, disabled := manifest.Disabled[binary]
+	_, quarantined := manifest.Quarantined[binary]
+	if !tracked && !disabled && !quarantined {
+		return nil
+	}
+	delete(manifest.Plugins, binary)
+	delete(manifest.Disabled, binary)
+	delete(manifest.Quarantined, binary)
+	return manifest.Save()
+}
@@ -0,0 +1,238 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestManifest() *Manifest {
+	return &Manifest{
+		Plugins:     map[string]*ManifestEntry{},
+		Disabled:    map[string]*Disabled{},
+		Quarantined: map[string]*Quarantine{},
+	}
+}
+
+func TestManifestRecord(t *testing.T) {
+	tests := []struct {
+		name         string
+		existing     *ManifestEntry
+		backedUp     bool
+		wantPrevious string
+	}{
+		{name: "new install"},
+		{name: "new install with stale backup flag", backedUp: true},
+		{
+			name:     "upgrade without backup",
+			existing: &ManifestEntry{Source: "github.com/a/b", Version: "v1.0.0"},
+		},
+		{
+			name:         "upgrade with backup",
+			existing:     &ManifestEntry{Source: "github.com/a/b", Version: "v1.0.0", Checksum: "abc"},
+			backedUp:     true,
+			wantPrevious: "v1.0.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManifest()
+			if tt.existing != nil {
+				m.Plugins["eiam-plugin"] = tt.existing
+			}
+			m.Quarantined["eiam-plugin"] = &Quarantine{Name: "plugin", Reason: "handshake failed"}
+
+			m.record("eiam-plugin", "github.com/a/b", "v1.1.0", "def", tt.backedUp)
+
+			entry, ok := m.Plugins["eiam-plugin"]
+			if !ok {
+				t.Fatal("expected the plugin to be recorded")
+			}
+			if entry.Source != "github.com/a/b" || entry.Version != "v1.1.0" || entry.Checksum != "def" {
+				t.Errorf("recorded %+v, want github.com/a/b v1.1.0 def", entry)
+			}
+			if entry.InstalledAt.IsZero() {
+				t.Error("expected the install time to be set")
+			}
+			gotPrevious := ""
+			if entry.Previous != nil {
+				gotPrevious = entry.Previous.Version
+				if entry.Previous.Checksum != tt.existing.Checksum {
+					t.Errorf("previous checksum = %q, want %q", entry.Previous.Checksum, tt.existing.Checksum)
+				}
+			}
+			if gotPrevious != tt.wantPrevious {
+				t.Errorf("previous version = %q, want %q", gotPrevious, tt.wantPrevious)
+			}
+			if _, ok := m.Quarantined["eiam-plugin"]; ok {
+				t.Error("expected installing to lift the quarantine")
+			}
+			if got := m.Names(); len(got) != 1 || got[0] != "eiam-plugin" {
+				t.Errorf("Names = %v, want [eiam-plugin]", got)
+			}
+		})
+	}
+}
+
+func TestManifestSaveAndLoad(t *testing.T) {
+	if err := os.MkdirAll(PluginDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filepath.Join(PluginDir(), ManifestFile))
+
+	m := newTestManifest()
+	m.record("eiam-plugin-b", "github.com/a/b", "v1.0.0", "abc", false)
+	m.record("eiam-plugin-a", "gs://bucket/a", "v2.0.0", "def", false)
+	if err := m.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := LoadManifest()
+	if err != nil {
+		t.Fatalf("LoadManifest failed: %v", err)
+	}
+	if got := loaded.Names(); len(got) != 2 || got[0] != "eiam-plugin-a" || got[1] != "eiam-plugin-b" {
+		t.Errorf("Names = %v, want [eiam-plugin-a eiam-plugin-b]", got)
+	}
+	if entry := loaded.Plugins["eiam-plugin-a"]; entry.Source != "gs://bucket/a" || entry.Version != "v2.0.0" {
+		t.Errorf("loaded %+v, want gs://bucket/a v2.0.0", entry)
+	}
+	if loaded.Disabled == nil || loaded.Quarantined == nil {
+		t.Error("expected the loaded maps to be initialized")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   *ManifestEntry
+		backup  bool
+		wantErr bool
+	}{
+		{name: "not installed", wantErr: true},
+		{
+			name:    "no previous version",
+			entry:   &ManifestEntry{Source: "github.com/a/b", Version: "v1.1.0"},
+			wantErr: true,
+		},
+		{
+			name: "missing backup",
+			entry: &ManifestEntry{
+				Source: "github.com/a/b", Version: "v1.1.0",
+				Previous: &PreviousInstall{Source: "github.com/a/b", Version: "v1.0.0"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "previous version",
+			entry: &ManifestEntry{
+				Source: "github.com/a/b", Version: "v1.1.0",
+				Previous: &PreviousInstall{Source: "github.com/a/b", Version: "v1.0.0"},
+			},
+			backup: true,
+		},
+	}
+
+	binary := "eiam-plugin-rollback"
+	current := filepath.Join(PluginDir(), binary)
+	backup := filepath.Join(PluginDir(), BackupDir, binary)
+	if err := os.MkdirAll(filepath.Dir(backup), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer os.Remove(filepath.Join(PluginDir(), ManifestFile))
+			defer os.Remove(current)
+			defer os.Remove(backup)
+
+			m := newTestManifest()
+			if tt.entry != nil {
+				m.Plugins[binary] = tt.entry
+			}
+			if err := m.Save(); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(current, []byte("v1.1.0"), 0o700); err != nil {
+				t.Fatal(err)
+			}
+			if tt.backup {
+				if err := os.WriteFile(backup, []byte("v1.0.0"), 0o700); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			entry, err := Rollback(binary)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected Rollback to fail")
+				}
+				if data, _ := os.ReadFile(current); string(data) != "v1.1.0" {
+					t.Errorf("installed binary = %q, want it to be untouched", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Rollback failed: %v", err)
+			}
+			if entry.Version != "v1.0.0" || entry.Previous == nil || entry.Previous.Version != "v1.1.0" {
+				t.Errorf("Rollback = %+v, want v1.0.0 with previous v1.1.0", entry)
+			}
+			for path, want := range map[string]string{current: "v1.0.0", backup: "v1.1.0"} {
+				if data, err := os.ReadFile(path); err != nil || string(data) != want {
+					t.Errorf("%s = %q, %v, want %q", path, data, err, want)
+				}
+			}
+
+			// Rolling back again restores the original install.
+			entry, err = Rollback(binary)
+			if err != nil {
+				t.Fatalf("second Rollback failed: %v", err)
+			}
+			if entry.Version != "v1.1.0" {
+				t.Errorf("second Rollback = %s, want v1.1.0", entry.Version)
+			}
+			if data, _ := os.ReadFile(current); string(data) != "v1.1.0" {
+				t.Errorf("installed binary = %q, want v1.1.0", data)
+			}
+		})
+	}
+}
+
+func TestIsNewer(t *testing.T) {
+	tests := []struct {
+		latest    string
+		installed string
+		want      bool
+	}{
+		{latest: "v1.1.0", installed: "v1.0.0", want: true},
+		{latest: "v1.0.0", installed: "v1.1.0", want: false},
+		{latest: "v1.0.0", installed: "v1.0.0", want: false},
+		{latest: "v1.10.0", installed: "v1.9.0", want: true},
+		{latest: "v2.0.0", installed: "v2.0.0-rc.1", want: true},
+		{latest: "v2.0.0-rc.1", installed: "v1.9.0", want: true},
+		{latest: "nightly-2", installed: "nightly-1", want: true},
+		{latest: "nightly-1", installed: "nightly-1", want: false},
+		{latest: "v1.0.0", installed: "1.0.0", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := IsNewer(tt.latest, tt.installed); got != tt.want {
+			t.Errorf("IsNewer(%q, %q) = %v, want %v", tt.latest, tt.installed, got, tt.want)
+		}
+	}
+}
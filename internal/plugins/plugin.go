@@ -16,11 +16,15 @@ package plugins
 
 import hcplugin "github.com/hashicorp/go-plugin"
 
-// EphemeralIamPlugin holds the metadata of an installed plugin.
+// EphemeralIamPlugin holds the metadata of an installed plugin. Client is nil
+// for plugins that are disabled or quarantined.
 type EphemeralIamPlugin struct {
 	Name        string
 	Description string
 	Version     string
 	Client      *hcplugin.Client
 	Path        string
+	Status      string
+	Reason      string
+	Config      []ConfigField
 }
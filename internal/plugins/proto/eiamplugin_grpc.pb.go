@@ -1,4 +1,8 @@
 // Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v3.6.1
+// source: internal/plugins/proto/eiamplugin.proto
 
 package __
 
@@ -11,15 +15,23 @@ import (
 
 // This is a compile-time assertion to ensure that this generated file
 // is compatible with the grpc package it is being compiled against.
-// Requires gRPC-Go v1.32.0 or later.
-const _ = grpc.SupportPackageIsVersion7
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	EIAMPlugin_GetInfo_FullMethodName     = "/proto.EIAMPlugin/GetInfo"
+	EIAMPlugin_Run_FullMethodName         = "/proto.EIAMPlugin/Run"
+	EIAMPlugin_HandleEvent_FullMethodName = "/proto.EIAMPlugin/HandleEvent"
+)
 
 // EIAMPluginClient is the client API for EIAMPlugin service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type EIAMPluginClient interface {
 	GetInfo(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*PluginInfo, error)
-	Run(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error)
+	Run(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (*Empty, error)
+	// HandleEvent is only called for the events listed in PluginInfo.
+	HandleEvent(ctx context.Context, in *Event, opts ...grpc.CallOption) (*Empty, error)
 }
 
 type eIAMPluginClient struct {
@@ -31,17 +43,29 @@ func NewEIAMPluginClient(cc grpc.ClientConnInterface) EIAMPluginClient {
 }
 
 func (c *eIAMPluginClient) GetInfo(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*PluginInfo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(PluginInfo)
-	err := c.cc.Invoke(ctx, "/proto.EIAMPlugin/GetInfo", in, out, opts...)
+	err := c.cc.Invoke(ctx, EIAMPlugin_GetInfo_FullMethodName, in, out, cOpts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *eIAMPluginClient) Run(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error) {
+func (c *eIAMPluginClient) Run(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(Empty)
-	err := c.cc.Invoke(ctx, "/proto.EIAMPlugin/Run", in, out, opts...)
+	err := c.cc.Invoke(ctx, EIAMPlugin_Run_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eIAMPluginClient) HandleEvent(ctx context.Context, in *Event, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, EIAMPlugin_HandleEvent_FullMethodName, in, out, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -50,24 +74,33 @@ func (c *eIAMPluginClient) Run(ctx context.Context, in *Empty, opts ...grpc.Call
 
 // EIAMPluginServer is the server API for EIAMPlugin service.
 // All implementations must embed UnimplementedEIAMPluginServer
-// for forward compatibility
+// for forward compatibility.
 type EIAMPluginServer interface {
 	GetInfo(context.Context, *Empty) (*PluginInfo, error)
-	Run(context.Context, *Empty) (*Empty, error)
+	Run(context.Context, *RunRequest) (*Empty, error)
+	// HandleEvent is only called for the events listed in PluginInfo.
+	HandleEvent(context.Context, *Event) (*Empty, error)
 	mustEmbedUnimplementedEIAMPluginServer()
 }
 
-// UnimplementedEIAMPluginServer must be embedded to have forward compatible implementations.
-type UnimplementedEIAMPluginServer struct {
-}
+// UnimplementedEIAMPluginServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedEIAMPluginServer struct{}
 
 func (UnimplementedEIAMPluginServer) GetInfo(context.Context, *Empty) (*PluginInfo, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetInfo not implemented")
 }
-func (UnimplementedEIAMPluginServer) Run(context.Context, *Empty) (*Empty, error) {
+func (UnimplementedEIAMPluginServer) Run(context.Context, *RunRequest) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Run not implemented")
 }
+func (UnimplementedEIAMPluginServer) HandleEvent(context.Context, *Event) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HandleEvent not implemented")
+}
 func (UnimplementedEIAMPluginServer) mustEmbedUnimplementedEIAMPluginServer() {}
+func (UnimplementedEIAMPluginServer) testEmbeddedByValue()                    {}
 
 // UnsafeEIAMPluginServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to EIAMPluginServer will
@@ -77,6 +110,13 @@ type UnsafeEIAMPluginServer interface {
 }
 
 func RegisterEIAMPluginServer(s grpc.ServiceRegistrar, srv EIAMPluginServer) {
+	// If the following call pancis, it indicates UnimplementedEIAMPluginServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
 	s.RegisterService(&EIAMPlugin_ServiceDesc, srv)
 }
 
@@ -90,7 +130,7 @@ func _EIAMPlugin_GetInfo_Handler(srv interface{}, ctx context.Context, dec func(
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/proto.EIAMPlugin/GetInfo",
+		FullMethod: EIAMPlugin_GetInfo_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
 		return srv.(EIAMPluginServer).GetInfo(ctx, req.(*Empty))
@@ -99,7 +139,7 @@ func _EIAMPlugin_GetInfo_Handler(srv interface{}, ctx context.Context, dec func(
 }
 
 func _EIAMPlugin_Run_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Empty)
+	in := new(RunRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -108,10 +148,28 @@ func _EIAMPlugin_Run_Handler(srv interface{}, ctx context.Context, dec func(inte
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/proto.EIAMPlugin/Run",
+		FullMethod: EIAMPlugin_Run_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EIAMPluginServer).Run(ctx, req.(*RunRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EIAMPlugin_HandleEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Event)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EIAMPluginServer).HandleEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EIAMPlugin_HandleEvent_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(EIAMPluginServer).Run(ctx, req.(*Empty))
+		return srv.(EIAMPluginServer).HandleEvent(ctx, req.(*Event))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -131,6 +189,116 @@ var EIAMPlugin_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Run",
 			Handler:    _EIAMPlugin_Run_Handler,
 		},
+		{
+			MethodName: "HandleEvent",
+			Handler:    _EIAMPlugin_HandleEvent_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "internal/plugins/proto/eiamplugin.proto",
+}
+
+const (
+	EIAMHost_GetConfig_FullMethodName = "/proto.EIAMHost/GetConfig"
+)
+
+// EIAMHostClient is the client API for EIAMHost service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// EIAMHost is served by eiam to the plugin while its command runs.
+type EIAMHostClient interface {
+	GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*PluginConfig, error)
+}
+
+type eIAMHostClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEIAMHostClient(cc grpc.ClientConnInterface) EIAMHostClient {
+	return &eIAMHostClient{cc}
+}
+
+func (c *eIAMHostClient) GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*PluginConfig, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PluginConfig)
+	err := c.cc.Invoke(ctx, EIAMHost_GetConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EIAMHostServer is the server API for EIAMHost service.
+// All implementations must embed UnimplementedEIAMHostServer
+// for forward compatibility.
+//
+// EIAMHost is served by eiam to the plugin while its command runs.
+type EIAMHostServer interface {
+	GetConfig(context.Context, *GetConfigRequest) (*PluginConfig, error)
+	mustEmbedUnimplementedEIAMHostServer()
+}
+
+// UnimplementedEIAMHostServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedEIAMHostServer struct{}
+
+func (UnimplementedEIAMHostServer) GetConfig(context.Context, *GetConfigRequest) (*PluginConfig, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConfig not implemented")
+}
+func (UnimplementedEIAMHostServer) mustEmbedUnimplementedEIAMHostServer() {}
+func (UnimplementedEIAMHostServer) testEmbeddedByValue()                  {}
+
+// UnsafeEIAMHostServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EIAMHostServer will
+// result in compilation errors.
+type UnsafeEIAMHostServer interface {
+	mustEmbedUnimplementedEIAMHostServer()
+}
+
+func RegisterEIAMHostServer(s grpc.ServiceRegistrar, srv EIAMHostServer) {
+	// If the following call pancis, it indicates UnimplementedEIAMHostServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&EIAMHost_ServiceDesc, srv)
+}
+
+func _EIAMHost_GetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EIAMHostServer).GetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EIAMHost_GetConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EIAMHostServer).GetConfig(ctx, req.(*GetConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// EIAMHost_ServiceDesc is the grpc.ServiceDesc for EIAMHost service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var EIAMHost_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.EIAMHost",
+	HandlerType: (*EIAMHostServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetConfig",
+			Handler:    _EIAMHost_GetConfig_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "internal/plugins/proto/eiamplugin.proto",
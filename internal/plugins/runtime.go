@@ -0,0 +1,60 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+)
+
+// RunTimeout returns how long the named plugin's command may run before it is
+// cancelled. The pluginruntime.timeouts.<name> setting overrides the
+// pluginruntime.timeout setting for all plugins. Zero means that there is no
+// limit.
+func RunTimeout(name string) time.Duration {
+	key := appconfig.PluginRuntimeTimeouts + "." + name
+	if !viper.IsSet(key) {
+		key = appconfig.PluginRuntimeTimeout
+	}
+	d, err := time.ParseDuration(viper.GetString(key))
+	if err != nil {
+		util.Logger.Warnf("Ignoring invalid %s value %q", key, viper.GetString(key))
+		return 0
+	}
+	return d
+}
+
+// PluginLogger returns the logger for the plugin in the binary. Its entries
+// have a plugin field with the binary's name, and it uses the level in the
+// pluginruntime.loglevels.<binary> setting instead of the eiam logging level
+// if it is set.
+func PluginLogger(binary string) hclog.Logger {
+	logger := NewHCLogAdapter(util.Logger, "").With("plugin", binary)
+	key := appconfig.PluginRuntimeLogLevels + "." + binary
+	if viper.IsSet(key) {
+		level := hclog.LevelFromString(viper.GetString(key))
+		if level == hclog.NoLevel {
+			util.Logger.Warnf("Ignoring invalid %s value %q", key, viper.GetString(key))
+			return logger
+		}
+		logger.SetLevel(level)
+	}
+	return logger
+}
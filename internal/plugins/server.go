@@ -16,14 +16,21 @@ package plugins
 
 import (
 	"context"
+	"encoding/json"
 
+	hcplugin "github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/replit/ephemeral-iam/internal/hooks"
 	pb "github.com/replit/ephemeral-iam/internal/plugins/proto"
 )
 
 // GRPCServer is the implementation of the go-plugin gRPC server.
 type GRPCServer struct {
 	pb.UnimplementedEIAMPluginServer
-	Impl EIAMPlugin
+	Impl   EIAMPlugin
+	Broker *hcplugin.GRPCBroker
 }
 
 // GetInfo is the gRPC method that is called to get metadata about a plugin.
@@ -37,10 +44,42 @@ func (m *GRPCServer) GetInfo(ctx context.Context, req *pb.Empty) (*pb.PluginInfo
 		Description: desc,
 		Version:     version,
 	}
+	if c, ok := m.Impl.(ConfigurablePlugin); ok {
+		pi.Config = toProtoFields(c.ConfigSchema())
+	}
+	if h, ok := m.Impl.(EventHandler); ok {
+		for _, e := range h.Events() {
+			pi.Events = append(pi.Events, string(e))
+		}
+	}
 	return pi, nil
 }
 
 // Run is the gRPC method that is called to invoke a plugin's root command.
-func (m *GRPCServer) Run(ctx context.Context, args *pb.Empty) (*pb.Empty, error) {
+func (m *GRPCServer) Run(ctx context.Context, req *pb.RunRequest) (*pb.Empty, error) {
+	if h, ok := m.Impl.(HostAware); ok && req.HostServiceId != 0 && m.Broker != nil {
+		conn, err := m.Broker.Dial(req.HostServiceId)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		h.SetHost(&hostClient{client: pb.NewEIAMHostClient(conn)})
+	}
+	if c, ok := m.Impl.(ContextPlugin); ok {
+		return &pb.Empty{}, c.RunContext(ctx)
+	}
 	return &pb.Empty{}, m.Impl.Run()
 }
+
+// HandleEvent is the gRPC method that delivers a lifecycle event to the plugin.
+func (m *GRPCServer) HandleEvent(ctx context.Context, req *pb.Event) (*pb.Empty, error) {
+	h, ok := m.Impl.(EventHandler)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "plugin does not handle events")
+	}
+	var e hooks.Event
+	if err := json.Unmarshal(req.Payload, &e); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid event: %v", err)
+	}
+	return &pb.Empty{}, h.HandleEvent(e)
+}
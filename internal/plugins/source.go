@@ -0,0 +1,160 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+)
+
+// Source is a location that plugin releases can be installed from.
+type Source interface {
+	// String returns the reference that is recorded in the plugin manifest and
+	// that ParseSource turns back into an equivalent Source.
+	String() string
+
+	// LatestVersion returns the newest version available from the source. Sources
+	// that are not versioned return an empty string.
+	LatestVersion(ctx context.Context) (string, error)
+
+	// Fetch downloads the given version, or the latest one if version is empty,
+	// into dir and returns the version that was fetched.
+	Fetch(ctx context.Context, version, dir string) (string, error)
+}
+
+// SourceOptions holds the settings shared by the plugin sources.
+type SourceOptions struct {
+	// Token is the access token used to authenticate to Github.
+	Token string
+
+	// GitLabToken is the access token used to authenticate to GitLab. It is
+	// read from the GITLAB_TOKEN environment variable if it is empty. The
+	// Github token is never sent to GitLab hosts.
+	GitLabToken string
+
+	// HTTPClient is the client used to make requests. http.DefaultClient is
+	// used if it is nil.
+	HTTPClient *http.Client
+}
+
+func (o SourceOptions) httpClient() *http.Client {
+	if o.HTTPClient != nil {
+		return o.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// errRepoNotFound is returned by forge sources when a repository or release
+// does not exist or is not visible with the current credentials.
+var errRepoNotFound = errors.New("repository or release not found")
+
+// ParseSource turns a plugin reference into a Source and the version that was
+// requested with it. The supported references are:
+//
+//	github.com/owner/repo[@version]
+//	gitlab://host/group/project[@version]
+//	https://example.com/path/to/plugin.tar.gz
+//	gs://bucket/path/to/plugin.tar.gz[#generation]
+//	file:///path/to/plugin, /path/to/plugin, ./plugin or ~/plugin
+func ParseSource(ref string, opts SourceOptions) (Source, string, error) {
+	switch {
+	case strings.HasPrefix(ref, "https://github.com/"):
+		return ParseSource(strings.TrimPrefix(ref, "https://"), opts)
+	case strings.HasPrefix(ref, "github.com/"):
+		repoOwner, repoName, version, err := ParseGithubURL(ref)
+		if err != nil {
+			return nil, "", err
+		}
+		return &GithubSource{Owner: repoOwner, Repo: repoName, opts: opts}, version, nil
+	case strings.HasPrefix(ref, "gitlab://"):
+		return parseGitLabSource(strings.TrimPrefix(ref, "gitlab://"), opts)
+	case strings.HasPrefix(ref, "gs://"):
+		return parseGCSSource(strings.TrimPrefix(ref, "gs://"))
+	case strings.HasPrefix(ref, "https://"), strings.HasPrefix(ref, "http://"):
+		u, err := url.Parse(ref)
+		if err != nil || u.Host == "" {
+			return nil, "", fmt.Errorf("%s is not a valid URL", ref)
+		}
+		if u.Scheme == "http" {
+			util.Logger.Warnf("%s is not served over HTTPS", ref)
+		}
+		return &HTTPSource{URL: ref, opts: opts}, "", nil
+	case strings.HasPrefix(ref, "file://"):
+		return newLocalSource(strings.TrimPrefix(ref, "file://"))
+	case strings.HasPrefix(ref, "/"), strings.HasPrefix(ref, "./"),
+		strings.HasPrefix(ref, "../"), strings.HasPrefix(ref, "~/"):
+		return newLocalSource(ref)
+	}
+	return nil, "", fmt.Errorf("%s is not a supported plugin source", ref)
+}
+
+// download fetches a URL and unpacks the response body into dir.
+func download(ctx context.Context, client *http.Client, rawURL string, header http.Header, dir string) error {
+	util.Logger.Infof("Downloading plugin from %s", rawURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, http.NoBody)
+	if err != nil {
+		return err
+	}
+	for key, vals := range header {
+		req.Header[key] = vals
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", rawURL, resp.Status)
+	}
+
+	name := path.Base(req.URL.Path)
+	return unpack(resp.Body, name, dir)
+}
+
+// unpack extracts a gzipped tarball into dir. Anything else is treated as a
+// plugin binary and written to dir with the given file name.
+func unpack(r io.Reader, name, dir string) error {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return util.ExtractTarGz(br, dir)
+	}
+
+	if name == "" || name == "." || name == "/" {
+		return errors.New("unable to determine the plugin file name")
+	}
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o700)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, io.LimitReader(br, util.MaxDownloadSize))
+	return err
+}
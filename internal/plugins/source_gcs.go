@@ -0,0 +1,95 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+// GCSSource installs a plugin from a Cloud Storage object. Requests are made
+// with eiam's application default credentials. The object generation is used
+// as the version.
+type GCSSource struct {
+	Bucket string
+	Object string
+
+	// clientOptions overrides the options used to create the storage client.
+	clientOptions []option.ClientOption
+}
+
+func parseGCSSource(ref string) (Source, string, error) {
+	version := ""
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		ref, version = ref[:i], ref[i+1:]
+	}
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, "", fmt.Errorf("gs://%s is not a valid Cloud Storage object", ref)
+	}
+	return &GCSSource{Bucket: parts[0], Object: parts[1]}, version, nil
+}
+
+func (s *GCSSource) String() string {
+	return fmt.Sprintf("gs://%s/%s", s.Bucket, s.Object)
+}
+
+// LatestVersion returns the current generation of the object.
+func (s *GCSSource) LatestVersion(ctx context.Context) (string, error) {
+	svc, err := storage.NewService(ctx, s.clientOptions...)
+	if err != nil {
+		return "", err
+	}
+	obj, err := svc.Objects.Get(s.Bucket, s.Object).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s: %v", s, err)
+	}
+	return strconv.FormatInt(obj.Generation, 10), nil
+}
+
+// Fetch downloads the given generation of the object into dir.
+func (s *GCSSource) Fetch(ctx context.Context, version, dir string) (string, error) {
+	if version == "" {
+		latest, err := s.LatestVersion(ctx)
+		if err != nil {
+			return "", err
+		}
+		version = latest
+	}
+	generation, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a valid object generation", version)
+	}
+
+	svc, err := storage.NewService(ctx, s.clientOptions...)
+	if err != nil {
+		return "", err
+	}
+	resp, err := svc.Objects.Get(s.Bucket, s.Object).Generation(generation).Context(ctx).Download()
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %v", s, err)
+	}
+	defer resp.Body.Close()
+	if err := unpack(resp.Body, path.Base(s.Object), dir); err != nil {
+		return "", err
+	}
+	return version, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/v33/github"
+	"golang.org/x/oauth2"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+)
+
+// GithubSource installs plugins from the releases of a Github repository.
+type GithubSource struct {
+	Owner string
+	Repo  string
+
+	opts SourceOptions
+	// apiURL overrides the Github API endpoint.
+	apiURL string
+}
+
+func (s *GithubSource) String() string {
+	return fmt.Sprintf("github.com/%s/%s", s.Owner, s.Repo)
+}
+
+// LatestVersion returns the tag of the latest release of the repository.
+func (s *GithubSource) LatestVersion(ctx context.Context) (string, error) {
+	release, err := s.release(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	return release.GetTagName(), nil
+}
+
+// Fetch downloads the release asset built for the current OS and architecture.
+func (s *GithubSource) Fetch(ctx context.Context, version, dir string) (string, error) {
+	release, err := s.release(ctx, version)
+	if err != nil {
+		return "", err
+	}
+	downloadURL, err := util.GetReleaseDownloadURL(release, true)
+	if err != nil {
+		return "", err
+	}
+
+	header := http.Header{"Accept": {"application/octet-stream"}}
+	if s.opts.Token != "" {
+		header.Set("Authorization", fmt.Sprintf("token %s", s.opts.Token))
+	}
+	if err := download(ctx, s.opts.httpClient(), downloadURL, header, dir); err != nil {
+		return "", err
+	}
+	return release.GetTagName(), nil
+}
+
+func (s *GithubSource) release(ctx context.Context, tag string) (*github.RepositoryRelease, error) {
+	client, err := s.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var release *github.RepositoryRelease
+	if tag == "" {
+		release, _, err = client.Repositories.GetLatestRelease(ctx, s.Owner, s.Repo)
+	} else {
+		release, _, err = client.Repositories.GetReleaseByTag(ctx, s.Owner, s.Repo, tag)
+	}
+	if sErr, ok := err.(*github.ErrorResponse); ok && sErr.Response.StatusCode == http.StatusNotFound {
+		return nil, errRepoNotFound
+	}
+	return release, err
+}
+
+func (s *GithubSource) client(ctx context.Context) (*github.Client, error) {
+	httpClient := s.opts.httpClient()
+	if s.opts.Token != "" {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+		httpClient = oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: s.opts.Token}))
+	}
+	client := github.NewClient(httpClient)
+	if s.apiURL != "" {
+		baseURL, err := url.Parse(strings.TrimSuffix(s.apiURL, "/") + "/")
+		if err != nil {
+			return nil, err
+		}
+		client.BaseURL = baseURL
+	}
+	return client, nil
+}
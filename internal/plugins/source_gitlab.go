@@ -0,0 +1,179 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	archutil "github.com/replit/ephemeral-iam/internal/appconfig/arch_util"
+)
+
+// GitLabSource installs plugins from the releases of a GitLab project. The
+// project can be hosted on gitlab.com or on a self-hosted instance.
+type GitLabSource struct {
+	Host    string
+	Project string
+
+	opts SourceOptions
+	// apiURL overrides the GitLab API endpoint.
+	apiURL string
+}
+
+type gitlabRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  struct {
+		Links []struct {
+			Name           string `json:"name"`
+			URL            string `json:"url"`
+			DirectAssetURL string `json:"direct_asset_url"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+func parseGitLabSource(ref string, opts SourceOptions) (Source, string, error) {
+	version := ""
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		ref, version = ref[:i], ref[i+1:]
+	}
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || !strings.Contains(parts[1], "/") {
+		return nil, "", fmt.Errorf("gitlab://%s is not a valid GitLab project, expected gitlab://host/group/project", ref)
+	}
+	if opts.GitLabToken == "" {
+		opts.GitLabToken = os.Getenv("GITLAB_TOKEN")
+	}
+	return &GitLabSource{Host: parts[0], Project: strings.TrimSuffix(parts[1], "/"), opts: opts}, version, nil
+}
+
+func (s *GitLabSource) String() string {
+	return fmt.Sprintf("gitlab://%s/%s", s.Host, s.Project)
+}
+
+// LatestVersion returns the tag of the most recent release of the project.
+func (s *GitLabSource) LatestVersion(ctx context.Context) (string, error) {
+	release, err := s.release(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	return release.TagName, nil
+}
+
+// Fetch downloads the release asset link built for the current OS and
+// architecture.
+func (s *GitLabSource) Fetch(ctx context.Context, version, dir string) (string, error) {
+	release, err := s.release(ctx, version)
+	if err != nil {
+		return "", err
+	}
+
+	currentRuntime := fmt.Sprintf("%s_%s", archutil.FormattedOS, archutil.FormattedArch)
+	for _, link := range release.Assets.Links {
+		if !strings.Contains(link.Name, currentRuntime) {
+			continue
+		}
+		assetURL := link.DirectAssetURL
+		if assetURL == "" {
+			assetURL = link.URL
+		}
+		// Asset links can point anywhere, so the token is only sent to the
+		// GitLab host itself.
+		header := http.Header{}
+		if s.isAPIHost(assetURL) {
+			header = s.header()
+		}
+		if err := download(ctx, s.opts.httpClient(), assetURL, header, dir); err != nil {
+			return "", err
+		}
+		return release.TagName, nil
+	}
+	return "", fmt.Errorf("release %s of %s has no asset for %s", release.TagName, s, currentRuntime)
+}
+
+func (s *GitLabSource) apiBase() string {
+	if s.apiURL != "" {
+		return strings.TrimSuffix(s.apiURL, "/")
+	}
+	return fmt.Sprintf("https://%s/api/v4", s.Host)
+}
+
+// isAPIHost reports whether rawURL has the scheme and host of the GitLab API.
+func (s *GitLabSource) isAPIHost(rawURL string) bool {
+	api, err := url.Parse(s.apiBase())
+	if err != nil {
+		return false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == api.Scheme && strings.EqualFold(u.Host, api.Host)
+}
+
+func (s *GitLabSource) release(ctx context.Context, tag string) (*gitlabRelease, error) {
+	endpoint := fmt.Sprintf("%s/projects/%s/releases", s.apiBase(), url.PathEscape(s.Project))
+	if tag != "" {
+		endpoint = fmt.Sprintf("%s/%s", endpoint, url.PathEscape(tag))
+	} else {
+		endpoint += "?order_by=released_at&sort=desc&per_page=1"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = s.header()
+	resp, err := s.opts.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusUnauthorized, http.StatusForbidden:
+		return nil, errRepoNotFound
+	default:
+		return nil, fmt.Errorf("failed to get release of %s: %s", s, resp.Status)
+	}
+
+	if tag != "" {
+		var release gitlabRelease
+		if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+			return nil, fmt.Errorf("failed to parse release of %s: %v", s, err)
+		}
+		return &release, nil
+	}
+	var releases []gitlabRelease
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, fmt.Errorf("failed to parse releases of %s: %v", s, err)
+	}
+	if len(releases) == 0 {
+		return nil, errRepoNotFound
+	}
+	return &releases[0], nil
+}
+
+func (s *GitLabSource) header() http.Header {
+	header := http.Header{}
+	if s.opts.GitLabToken != "" {
+		header.Set("PRIVATE-TOKEN", s.opts.GitLabToken)
+	}
+	return header
+}
@@ -0,0 +1,42 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"context"
+	"net/http"
+)
+
+// HTTPSource installs a plugin from a tarball or binary served at a fixed URL.
+// The URL is not versioned, so updates always reinstall it.
+type HTTPSource struct {
+	URL string
+
+	opts SourceOptions
+}
+
+func (s *HTTPSource) String() string {
+	return s.URL
+}
+
+// LatestVersion always returns an empty string since the URL is not versioned.
+func (s *HTTPSource) LatestVersion(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+// Fetch downloads the URL into dir.
+func (s *HTTPSource) Fetch(ctx context.Context, version, dir string) (string, error) {
+	return "", download(ctx, s.opts.httpClient(), s.URL, http.Header{}, dir)
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalSource installs a plugin from a binary or tarball on the local
+// filesystem.
+type LocalSource struct {
+	Path string
+}
+
+func newLocalSource(p string) (Source, string, error) {
+	if strings.HasPrefix(p, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, "", err
+		}
+		p = filepath.Join(home, p[2:])
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return nil, "", err
+	}
+	return &LocalSource{Path: abs}, "", nil
+}
+
+func (s *LocalSource) String() string {
+	return s.Path
+}
+
+// LatestVersion always returns an empty string since local files are not
+// versioned.
+func (s *LocalSource) LatestVersion(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+// Fetch copies the file into dir, extracting it if it is a tarball.
+func (s *LocalSource) Fetch(ctx context.Context, version, dir string) (string, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %v", s.Path, err)
+	}
+	defer f.Close()
+	return "", unpack(f, filepath.Base(s.Path), dir)
+}
@@ -0,0 +1,359 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
+
+	archutil "github.com/replit/ephemeral-iam/internal/appconfig/arch_util"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+)
+
+var (
+	pluginContents = []byte("\x7fELF not really a plugin")
+	runtimeSuffix  = fmt.Sprintf("%s_%s", archutil.FormattedOS, archutil.FormattedArch)
+)
+
+func TestMain(m *testing.M) {
+	util.Logger = logrus.New()
+	util.Logger.Out = &bytes.Buffer{}
+	// Keep the plugins directory of the tests away from the real one.
+	home, err := os.MkdirTemp("", "eiam-plugins-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+func pluginTarball(t *testing.T, name string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0o755,
+		Size:     int64(len(pluginContents)),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(pluginContents); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func checkFetched(t *testing.T, dir, name string) {
+	t.Helper()
+	got, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("expected %s to be fetched: %v", name, err)
+	}
+	if !bytes.Equal(got, pluginContents) {
+		t.Errorf("unexpected contents of %s: %q", name, got)
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ref     string
+		want    string
+		version string
+	}{
+		{"github.com/user/repo", "github.com/user/repo", ""},
+		{"github.com/user/repo.go@v1.2.3", "github.com/user/repo.go", "v1.2.3"},
+		{"https://github.com/user/repo@v0.1.0", "github.com/user/repo", "v0.1.0"},
+		{"gitlab://gitlab.example.com/infra/tools/plugin@v2.0.0", "gitlab://gitlab.example.com/infra/tools/plugin", "v2.0.0"},
+		{"https://artifacts.example.com/plugin.tar.gz", "https://artifacts.example.com/plugin.tar.gz", ""},
+		{"gs://bucket/eiam/plugin.tar.gz#1234", "gs://bucket/eiam/plugin.tar.gz", "1234"},
+		{"file:///opt/plugins/plugin", "/opt/plugins/plugin", ""},
+		{"./bin/plugin", filepath.Join(cwd, "bin/plugin"), ""},
+	}
+	for _, tc := range tests {
+		src, version, err := ParseSource(tc.ref, SourceOptions{})
+		if err != nil {
+			t.Errorf("unexpected error parsing %s: %v", tc.ref, err)
+			continue
+		}
+		if src.String() != tc.want || version != tc.version {
+			t.Errorf("ParseSource(%s) = %s@%s, expected %s@%s", tc.ref, src, version, tc.want, tc.version)
+		}
+	}
+
+	for _, ref := range []string{"example.com/plugin", "gitlab://gitlab.com/project", "gs://bucket", "github.com/user"} {
+		if _, _, err := ParseSource(ref, SourceOptions{}); err == nil {
+			t.Errorf("expected error parsing invalid source %s", ref)
+		}
+	}
+}
+
+func TestGithubSourceFetch(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/user/repo/releases/latest", "/repos/user/repo/releases/tags/v1.0.0":
+			if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+				t.Errorf("unexpected Authorization header for API request: %q", got)
+			}
+			tag := "v1.1.0"
+			if r.URL.Path == "/repos/user/repo/releases/tags/v1.0.0" {
+				tag = "v1.0.0"
+			}
+			fmt.Fprintf(w, `{"tag_name": %q, "assets": [
+				{"name": "plugin_other_arch.tar.gz", "url": "%s/assets/0"},
+				{"name": "plugin_%s.tar.gz", "url": "%s/assets/1"}
+			]}`, tag, srv.URL, runtimeSuffix, srv.URL)
+		case "/assets/1":
+			if got := r.Header.Get("Authorization"); got != "token secret" {
+				t.Errorf("unexpected Authorization header for asset request: %q", got)
+			}
+			_, _ = w.Write(pluginTarball(t, "plugin"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	src := &GithubSource{Owner: "user", Repo: "repo", opts: SourceOptions{Token: "secret"}, apiURL: srv.URL}
+	latest, err := src.LatestVersion(context.Background())
+	if err != nil || latest != "v1.1.0" {
+		t.Fatalf("LatestVersion() = %s, %v", latest, err)
+	}
+
+	dir := t.TempDir()
+	version, err := src.Fetch(context.Background(), "v1.0.0", dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != "v1.0.0" {
+		t.Errorf("expected v1.0.0 to be fetched, got %s", version)
+	}
+	checkFetched(t, dir, "plugin")
+
+	missing := &GithubSource{Owner: "user", Repo: "missing", apiURL: srv.URL}
+	if _, err := missing.Fetch(context.Background(), "", t.TempDir()); err != errRepoNotFound {
+		t.Errorf("expected errRepoNotFound, got %v", err)
+	}
+}
+
+func TestGitLabSourceFetch(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("PRIVATE-TOKEN"); got != "secret" {
+			t.Errorf("unexpected PRIVATE-TOKEN header: %q", got)
+		}
+		release := map[string]interface{}{
+			"tag_name": "v2.0.0",
+			"assets": map[string]interface{}{
+				"links": []map[string]string{{
+					"name":             fmt.Sprintf("plugin_%s.tar.gz", runtimeSuffix),
+					"url":              srv.URL + "/not-used",
+					"direct_asset_url": srv.URL + "/download",
+				}},
+			},
+		}
+		switch r.URL.EscapedPath() {
+		case "/api/v4/projects/infra%2Fplugin/releases":
+			if r.URL.Query().Get("order_by") != "released_at" {
+				t.Errorf("expected releases to be ordered by release date: %s", r.URL.RawQuery)
+			}
+			_ = json.NewEncoder(w).Encode([]interface{}{release})
+		case "/api/v4/projects/infra%2Fplugin/releases/v2.0.0":
+			_ = json.NewEncoder(w).Encode(release)
+		case "/download":
+			_, _ = w.Write(pluginTarball(t, "gitlab-plugin"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	src := &GitLabSource{
+		Host:    "gitlab.example.com",
+		Project: "infra/plugin",
+		opts:    SourceOptions{GitLabToken: "secret"},
+		apiURL:  srv.URL + "/api/v4",
+	}
+	latest, err := src.LatestVersion(context.Background())
+	if err != nil || latest != "v2.0.0" {
+		t.Fatalf("LatestVersion() = %s, %v", latest, err)
+	}
+
+	dir := t.TempDir()
+	if _, err := src.Fetch(context.Background(), "v2.0.0", dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkFetched(t, dir, "gitlab-plugin")
+}
+
+func TestGitLabSourceTokens(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "gitlab-secret")
+
+	// Assets on other hosts do not get the GitLab token.
+	assets := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("PRIVATE-TOKEN"); got != "" {
+			t.Errorf("expected no PRIVATE-TOKEN header for another host, got %q", got)
+		}
+		_, _ = w.Write(pluginTarball(t, "gitlab-plugin"))
+	}))
+	defer assets.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("PRIVATE-TOKEN"); got != "gitlab-secret" {
+			t.Errorf("expected the GITLAB_TOKEN, got %q", got)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"tag_name": "v2.0.0",
+			"assets": map[string]interface{}{
+				"links": []map[string]string{{
+					"name": fmt.Sprintf("plugin_%s.tar.gz", runtimeSuffix),
+					"url":  assets.URL + "/plugin.tar.gz",
+				}},
+			},
+		})
+	}))
+	defer api.Close()
+
+	// The Github token is never sent to GitLab.
+	src, _, err := ParseSource("gitlab://gitlab.example.com/infra/plugin", SourceOptions{Token: "github-secret"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gitlab := src.(*GitLabSource)
+	gitlab.apiURL = api.URL + "/api/v4"
+	dir := t.TempDir()
+	if _, err := gitlab.Fetch(context.Background(), "v2.0.0", dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkFetched(t, dir, "gitlab-plugin")
+}
+
+func TestHTTPSourceFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/plugin.tar.gz":
+			_, _ = w.Write(pluginTarball(t, "tarball-plugin"))
+		case "/bin/raw-plugin":
+			_, _ = w.Write(pluginContents)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if _, err := (&HTTPSource{URL: srv.URL + "/plugin.tar.gz"}).Fetch(context.Background(), "", dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkFetched(t, dir, "tarball-plugin")
+
+	if _, err := (&HTTPSource{URL: srv.URL + "/bin/raw-plugin"}).Fetch(context.Background(), "", dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkFetched(t, dir, "raw-plugin")
+
+	if _, err := (&HTTPSource{URL: srv.URL + "/missing"}).Fetch(context.Background(), "", dir); err == nil {
+		t.Error("expected error fetching missing file")
+	}
+}
+
+func TestGCSSourceFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/storage/v1/b/artifacts/o/eiam/plugin.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("alt") == "media" {
+			if gen := r.URL.Query().Get("generation"); gen != "42" {
+				t.Errorf("expected generation 42 to be downloaded, got %q", gen)
+			}
+			_, _ = w.Write(pluginTarball(t, "gcs-plugin"))
+			return
+		}
+		_, _ = w.Write([]byte(`{"bucket": "artifacts", "name": "eiam/plugin.tar.gz", "generation": "42"}`))
+	}))
+	defer srv.Close()
+
+	src, _, err := parseGCSSource("artifacts/eiam/plugin.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcs := src.(*GCSSource)
+	gcs.clientOptions = []option.ClientOption{
+		option.WithEndpoint(srv.URL + "/storage/v1/"),
+		option.WithoutAuthentication(),
+	}
+
+	dir := t.TempDir()
+	version, err := gcs.Fetch(context.Background(), "", dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != "42" {
+		t.Errorf("expected generation 42 to be recorded as the version, got %s", version)
+	}
+	checkFetched(t, dir, "gcs-plugin")
+}
+
+func TestLocalSourceFetch(t *testing.T) {
+	srcDir := t.TempDir()
+	tarball := filepath.Join(srcDir, "plugin.tar.gz")
+	if err := os.WriteFile(tarball, pluginTarball(t, "local-plugin"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	binary := filepath.Join(srcDir, "local-binary")
+	if err := os.WriteFile(binary, pluginContents, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, p := range []string{tarball, binary} {
+		src, _, err := ParseSource(p, SourceOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := src.Fetch(context.Background(), "", dir); err != nil {
+			t.Fatalf("unexpected error fetching %s: %v", p, err)
+		}
+	}
+	checkFetched(t, dir, "local-plugin")
+	checkFetched(t, dir, "local-binary")
+}
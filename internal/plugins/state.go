@@ -0,0 +1,100 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Load states of an installed plugin.
+const (
+	StatusEnabled     = "enabled"
+	StatusDisabled    = "disabled"
+	StatusQuarantined = "quarantined"
+)
+
+// Disabled records a plugin that was disabled with 'eiam plugins disable'.
+type Disabled struct {
+	// Name is the command name of the plugin when it was disabled.
+	Name       string    `json:"name,omitempty"`
+	DisabledAt time.Time `json:"disabledAt"`
+}
+
+// Quarantine records a plugin that failed to load. The plugin is not loaded
+// again until its binary changes or it is re-enabled.
+type Quarantine struct {
+	// Name is the command name of the plugin, if it was known.
+	Name          string    `json:"name,omitempty"`
+	Reason        string    `json:"reason"`
+	ModTime       time.Time `json:"modTime"`
+	QuarantinedAt time.Time `json:"quarantinedAt"`
+}
+
+// Quarantine marks a plugin binary as broken so that it is skipped on the next
+// start. It is retried once the binary has been replaced.
+func (m *Manifest) Quarantine(binary, name, reason string) {
+	var modTime time.Time
+	if info, err := os.Stat(filepath.Join(PluginDir(), binary)); err == nil {
+		modTime = info.ModTime().UTC()
+	}
+	m.Quarantined[binary] = &Quarantine{
+		Name:          name,
+		Reason:        reason,
+		ModTime:       modTime,
+		QuarantinedAt: time.Now().UTC(),
+	}
+}
+
+// IsQuarantined reports whether a plugin binary is still quarantined. A
+// quarantine is lifted once the binary on disk was modified after it failed.
+func (m *Manifest) IsQuarantined(binary string, modTime time.Time) (*Quarantine, bool) {
+	q, ok := m.Quarantined[binary]
+	if !ok || !q.ModTime.Equal(modTime.UTC()) {
+		return nil, false
+	}
+	return q, true
+}
+
+// SetDisabled enables or disables a plugin binary. Enabling a plugin also lifts
+// its quarantine so that it is loaded again on the next start.
+func (m *Manifest) SetDisabled(binary, name string, disabled bool) {
+	if disabled {
+		m.Disabled[binary] = &Disabled{Name: name, DisabledAt: time.Now().UTC()}
+		return
+	}
+	delete(m.Disabled, binary)
+	delete(m.Quarantined, binary)
+}
+
+// FindBinary maps the command name of a disabled or quarantined plugin, or the
+// file name of any tracked binary, to the file name of the binary.
+func (m *Manifest) FindBinary(name string) (string, bool) {
+	for binary, d := range m.Disabled {
+		if binary == name || d.Name == name {
+			return binary, true
+		}
+	}
+	for binary, q := range m.Quarantined {
+		if binary == name || q.Name == name {
+			return binary, true
+		}
+	}
+	if _, ok := m.Plugins[name]; ok {
+		return name, true
+	}
+	return "", false
+}
@@ -0,0 +1,50 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuarantineLiftedWhenBinaryChanges(t *testing.T) {
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	m := &Manifest{
+		Plugins:  map[string]*ManifestEntry{},
+		Disabled: map[string]*Disabled{},
+		Quarantined: map[string]*Quarantine{
+			"broken": {Name: "broken-cmd", Reason: "handshake failed", ModTime: modTime},
+		},
+	}
+
+	if _, ok := m.IsQuarantined("broken", modTime.In(time.Local)); !ok {
+		t.Error("expected the unchanged binary to stay quarantined")
+	}
+	if _, ok := m.IsQuarantined("broken", modTime.Add(time.Second)); ok {
+		t.Error("expected the quarantine to be lifted for a modified binary")
+	}
+	if binary, ok := m.FindBinary("broken-cmd"); !ok || binary != "broken" {
+		t.Errorf("expected plugin name to resolve to binary broken, got %q", binary)
+	}
+
+	m.SetDisabled("broken", "broken-cmd", true)
+	if _, ok := m.Disabled["broken"]; !ok {
+		t.Fatal("expected the plugin to be disabled")
+	}
+	m.SetDisabled("broken", "", false)
+	if len(m.Disabled) != 0 || len(m.Quarantined) != 0 {
+		t.Errorf("expected enabling to clear the disabled and quarantined state, got %v %v", m.Disabled, m.Quarantined)
+	}
+}
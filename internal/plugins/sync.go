@@ -0,0 +1,271 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+// Kinds of change that Sync makes to the plugins directory.
+const (
+	SyncInstall = "install"
+	SyncUpgrade = "upgrade"
+	SyncRemove  = "remove"
+)
+
+var checksumRegex = regexp.MustCompile(`^sha256:[[:xdigit:]]{64}$`)
+
+// TeamManifest is a declarative list of the plugins that should be installed,
+// usually checked into a shared repository as plugins.yaml.
+type TeamManifest struct {
+	Plugins []*TeamPlugin `yaml:"plugins"`
+}
+
+// TeamPlugin is a single plugin listed in a TeamManifest.
+type TeamPlugin struct {
+	// Name is the file name of the plugin binary in the plugins directory.
+	Name string `yaml:"name"`
+
+	// Source is a plugin reference as accepted by 'eiam plugins install'.
+	Source string `yaml:"source"`
+
+	// Version pins the plugin to a release. The latest release is installed,
+	// and upgraded to when a newer one is published, if it is empty.
+	Version string `yaml:"version,omitempty"`
+
+	// Checksum is the expected digest of the binary in the form "sha256:<hex>".
+	Checksum string `yaml:"checksum,omitempty"`
+
+	// Token is the name of the Github access token to use for private repos.
+	Token string `yaml:"token,omitempty"`
+
+	src Source
+	// latest is the latest version of an unpinned plugin, see ResolveLatest.
+	latest string
+}
+
+// SyncAction is a single change needed to make the plugins directory match a
+// TeamManifest.
+type SyncAction struct {
+	Kind   string
+	Name   string
+	From   string
+	To     string
+	Plugin *TeamPlugin
+}
+
+// LoadTeamManifest reads and validates a team plugin manifest.
+func LoadTeamManifest(path string) (*TeamManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errorsutil.New("Failed to read plugin manifest", err)
+	}
+	tm, err := parseTeamManifest(data)
+	if err != nil {
+		return nil, errorsutil.New(fmt.Sprintf("Invalid plugin manifest %s", path), err)
+	}
+	return tm, nil
+}
+
+func parseTeamManifest(data []byte) (*TeamManifest, error) {
+	tm := &TeamManifest{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(tm); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for i, p := range tm.Plugins {
+		if p == nil || p.Name == "" || p.Source == "" {
+			return nil, fmt.Errorf("plugin %d requires both a name and a source", i+1)
+		}
+		if IsReservedFile(p.Name) || filepath.Base(p.Name) != p.Name {
+			return nil, fmt.Errorf("%s is not a valid plugin name", p.Name)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("plugin %s is listed more than once", p.Name)
+		}
+		seen[p.Name] = true
+
+		if p.Checksum != "" && !checksumRegex.MatchString(p.Checksum) {
+			return nil, fmt.Errorf("checksum of %s must be of the form sha256:<hex>", p.Name)
+		}
+		src, version, err := ParseSource(p.Source, SourceOptions{Token: githubToken(p.Token)})
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %v", p.Name, err)
+		}
+		if version != "" {
+			if p.Version != "" && p.Version != version {
+				return nil, fmt.Errorf("plugin %s has conflicting versions %s and %s", p.Name, version, p.Version)
+			}
+			p.Version = version
+		}
+		p.src = src
+	}
+	return tm, nil
+}
+
+// ResolveLatest looks up the latest version of the plugins that are not
+// pinned to one, so that PlanSync upgrades them when a newer one is published.
+// Plugins whose latest version cannot be found are left as they are.
+func (tm *TeamManifest) ResolveLatest(ctx context.Context) {
+	for _, p := range tm.Plugins {
+		if p.Version != "" {
+			continue
+		}
+		latest, err := p.src.LatestVersion(ctx)
+		if err != nil {
+			util.Logger.WithError(err).Warnf("Failed to get the latest version of %s, it will not be upgraded", p.Name)
+			continue
+		}
+		p.latest = latest
+	}
+}
+
+// targetVersion returns the version that p is synced to, which is empty for
+// the latest version of a source that is not versioned.
+func (p *TeamPlugin) targetVersion() string {
+	if p.Version != "" {
+		return p.Version
+	}
+	return p.latest
+}
+
+// InstalledBinaries returns the checksum of every plugin binary in the plugins
+// directory, keyed by file name.
+func InstalledBinaries() (map[string]string, error) {
+	files, err := os.ReadDir(PluginDir())
+	if err != nil {
+		return nil, errorsutil.New("Failed to read plugins directory", err)
+	}
+	binaries := map[string]string{}
+	for _, f := range files {
+		if f.IsDir() || IsReservedFile(f.Name()) {
+			continue
+		}
+		checksum, err := FileChecksum(filepath.Join(PluginDir(), f.Name()))
+		if err != nil {
+			return nil, errorsutil.New(fmt.Sprintf("Failed to read plugin %s", f.Name()), err)
+		}
+		binaries[f.Name()] = checksum
+	}
+	return binaries, nil
+}
+
+// PlanSync returns the changes needed to turn the installed plugins into the
+// set described by tm. binaries maps the file names of the plugin binaries on
+// disk to their checksums.
+func PlanSync(tm *TeamManifest, manifest *Manifest, binaries map[string]string) []SyncAction {
+	actions := []SyncAction{}
+	wanted := map[string]bool{}
+	for _, p := range tm.Plugins {
+		wanted[p.Name] = true
+		to := p.targetVersion()
+		if to == "" {
+			to = "latest"
+		}
+
+		checksum, onDisk := binaries[p.Name]
+		if !onDisk {
+			actions = append(actions, SyncAction{Kind: SyncInstall, Name: p.Name, To: to, Plugin: p})
+			continue
+		}
+		entry, tracked := manifest.Plugins[p.Name]
+		if !tracked {
+			actions = append(actions, SyncAction{Kind: SyncUpgrade, Name: p.Name, From: "untracked", To: to, Plugin: p})
+			continue
+		}
+		switch {
+		case entry.Source != p.src.String(),
+			p.Version != "" && entry.Version != p.Version,
+			p.Version == "" && p.latest != "" && IsNewer(p.latest, entry.Version),
+			p.Checksum != "" && !strings.EqualFold(checksum, p.Checksum):
+			actions = append(actions, SyncAction{Kind: SyncUpgrade, Name: p.Name, From: entry.Version, To: to, Plugin: p})
+		}
+	}
+
+	extra := []string{}
+	for name := range binaries {
+		if !wanted[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		from := "untracked"
+		if entry, ok := manifest.Plugins[name]; ok {
+			from = entry.Version
+		}
+		actions = append(actions, SyncAction{Kind: SyncRemove, Name: name, From: from})
+	}
+	return actions
+}
+
+// ApplySync carries out the actions returned by PlanSync. Binaries are only
+// moved into the plugins directory once their checksum has been verified.
+func ApplySync(actions []SyncAction) error {
+	for _, action := range actions {
+		switch action.Kind {
+		case SyncInstall, SyncUpgrade:
+			p := action.Plugin
+			if p.Checksum == "" {
+				util.Logger.Warnf("No checksum is listed for %s, the downloaded binary will not be verified", p.Name)
+			}
+			opts := InstallOptions{Binary: p.Name, Checksum: p.Checksum}
+			err := Install(p.src, p.targetVersion(), opts)
+			if errors.Is(err, errRepoNotFound) {
+				tokenName, retry, promptErr := handleRepoNotFound(p.src)
+				if promptErr != nil {
+					return promptErr
+				}
+				if !retry {
+					return errorsutil.New(fmt.Sprintf("Failed to sync %s", p.Name), err)
+				}
+				// Retry with the same checks, authenticated with the chosen token.
+				src, _, parseErr := ParseSource(p.Source, SourceOptions{Token: githubToken(tokenName)})
+				if parseErr != nil {
+					return errorsutil.New(fmt.Sprintf("Failed to sync %s", p.Name), parseErr)
+				}
+				if err = Install(src, p.targetVersion(), opts); errors.Is(err, errRepoNotFound) {
+					return errorsutil.New(fmt.Sprintf("Failed to sync %s", p.Name), err)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		case SyncRemove:
+			if err := Uninstall(action.Name); err != nil {
+				return err
+			}
+			util.Logger.Infof("Removed %s", action.Name)
+		}
+	}
+	return nil
+}
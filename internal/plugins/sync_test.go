@@ -0,0 +1,124 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testChecksum = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+func TestParseTeamManifest(t *testing.T) {
+	tm, err := parseTeamManifest([]byte(`
+plugins:
+  - name: eiam-plugin-a
+    source: github.com/user/plugin-a@v1.0.0
+  - name: eiam-plugin-b
+    source: https://example.com/plugin-b.tar.gz
+    checksum: ` + testChecksum + `
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tm.Plugins) != 2 {
+		t.Fatalf("expected 2 plugins, got %d", len(tm.Plugins))
+	}
+	if got := tm.Plugins[0].Version; got != "v1.0.0" {
+		t.Errorf("expected the version in the source to be used, got %q", got)
+	}
+
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"missing source", "plugins:\n  - name: a\n", "requires both a name and a source"},
+		{"duplicate", "plugins:\n  - {name: a, source: ./a}\n  - {name: a, source: ./b}\n", "listed more than once"},
+		{"bad checksum", "plugins:\n  - {name: a, source: ./a, checksum: md5:abc}\n", "sha256:<hex>"},
+		{"reserved name", "plugins:\n  - {name: manifest.json, source: ./a}\n", "not a valid plugin name"},
+		{"conflicting version", "plugins:\n  - {name: a, source: github.com/u/r@v1, version: v2}\n", "conflicting versions"},
+		{"unknown field", "plugins:\n  - {name: a, source: ./a, sha: abc}\n", "field sha not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTeamManifest([]byte(tt.yaml))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	tm, err := parseTeamManifest([]byte(`
+plugins:
+  - {name: missing, source: github.com/user/missing@v1.0.0}
+  - {name: current, source: github.com/user/current@v1.0.0}
+  - {name: old, source: github.com/user/old@v2.0.0}
+  - {name: tampered, source: github.com/user/tampered@v1.0.0, checksum: ` + testChecksum + `}
+  - {name: manual, source: github.com/user/manual}
+  - {name: outdated, source: github.com/user/outdated}
+  - {name: newest, source: github.com/user/newest}
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The latest releases of the unpinned plugins, as found by ResolveLatest.
+	for _, p := range tm.Plugins {
+		switch p.Name {
+		case "outdated":
+			p.latest = "v1.1.0"
+		case "newest":
+			p.latest = "v1.0.0"
+		}
+	}
+	manifest := &Manifest{Plugins: map[string]*ManifestEntry{
+		"current":  {Source: "github.com/user/current", Version: "v1.0.0"},
+		"old":      {Source: "github.com/user/old", Version: "v1.0.0"},
+		"tampered": {Source: "github.com/user/tampered", Version: "v1.0.0"},
+		"extra":    {Source: "github.com/user/extra", Version: "v0.1.0"},
+		"outdated": {Source: "github.com/user/outdated", Version: "v1.0.0"},
+		"newest":   {Source: "github.com/user/newest", Version: "v1.0.0"},
+	}}
+	binaries := map[string]string{
+		"current":  testChecksum,
+		"old":      testChecksum,
+		"tampered": "sha256:ffff",
+		"manual":   testChecksum,
+		"extra":    testChecksum,
+		"stray":    testChecksum,
+		"outdated": testChecksum,
+		"newest":   testChecksum,
+	}
+
+	var got []string
+	for _, a := range PlanSync(tm, manifest, binaries) {
+		got = append(got, strings.Join([]string{a.Kind, a.Name, a.From, a.To}, " "))
+	}
+	want := []string{
+		"install missing  v1.0.0",
+		"upgrade old v1.0.0 v2.0.0",
+		"upgrade tampered v1.0.0 v1.0.0",
+		"upgrade manual untracked latest",
+		"upgrade outdated v1.0.0 v1.1.0",
+		"remove extra v0.1.0 ",
+		"remove stray untracked ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected plan\n got: %q\nwant: %q", got, want)
+	}
+}
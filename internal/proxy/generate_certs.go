@@ -130,15 +130,15 @@ func checkProxyCertificate() error {
 	if certFile == "" || keyFile == "" {
 		if keyFile == "" {
 			util.Logger.Debug("Setting authproxy.keyfile")
-			viper.Set(appconfig.AuthProxyKeyFile, filepath.Join(appconfig.GetConfigDir(), "server.key"))
-			keyFile = viper.GetString(appconfig.AuthProxyKeyFile)
+			keyFile = filepath.Join(appconfig.GetConfigDir(), "server.key")
+			appconfig.Set(appconfig.AuthProxyKeyFile, keyFile)
 		}
 		if certFile == "" {
 			util.Logger.Debug("Setting authproxy.certfile")
-			viper.Set(appconfig.AuthProxyCertFile, filepath.Join(appconfig.GetConfigDir(), "server.pem"))
-			certFile = viper.GetString(appconfig.AuthProxyCertFile)
+			certFile = filepath.Join(appconfig.GetConfigDir(), "server.pem")
+			appconfig.Set(appconfig.AuthProxyCertFile, certFile)
 		}
-		if err := viper.WriteConfig(); err != nil {
+		if err := appconfig.WriteConfig(); err != nil {
 			return errorsutil.New("Failed to write configuration file", err)
 		}
 	}
@@ -17,19 +17,19 @@ package proxy
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
+	"path"
 	"path/filepath"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/elazarl/goproxy"
 	"github.com/spf13/viper"
-	"golang.org/x/term"
 
 	"github.com/replit/ephemeral-iam/internal/appconfig"
 	util "github.com/replit/ephemeral-iam/internal/eiamutil"
@@ -37,92 +37,192 @@ import (
 	"github.com/replit/ephemeral-iam/internal/gcpclient"
 )
 
+// proxyShutdownTimeout is how long the requests in flight through the auth
+// proxy have to finish when the session ends.
+const proxyShutdownTimeout = 5 * time.Second
+
 var (
 	certCache = make(map[string]*tls.Certificate)
 	certLock  = &sync.Mutex{}
 
-	wg sync.WaitGroup
-
 	funcHTTPSHandler = func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
 		return goproxy.MitmConnect, host
 	}
 )
 
-// StartProxyServer spins up the proxy that replaces the gcloud auth token.
-func StartProxyServer(
-	accessToken,
-	reason,
-	svcAcct,
-	project string,
-	expirationDate time.Time,
-	defaultCluster map[string]string,
-) error {
+// StartProxyServer spins up the proxy that replaces the gcloud auth token
+// and runs the privileged sub-shell of the session. It returns once the
+// session has ended and everything it set up has been undone, including the
+// gcloud config that was pointed at the proxy.
+func StartProxyServer(session *Session) (err error) {
+	sup := newSupervisor()
+	defer func() {
+		if shutdownErr := sup.shutdown(); shutdownErr != nil && err == nil {
+			err = errorsutil.New("Failed to end the privileged session cleanly", shutdownErr)
+		}
+	}()
+	if session.OnEnd != nil {
+		sup.onShutdown("run the end of session hooks", func() error {
+			session.OnEnd()
+			return nil
+		})
+	}
+	sup.onShutdown("restore the gcloud config", func() error {
+		errorsutil.CheckRevertGcloudConfigError(gcpclient.UnsetGcloudProxy())
+		return nil
+	})
+
 	if err := checkProxyCertificate(); err != nil {
 		return err
 	}
 
-	srv, err := createProxy(accessToken, reason)
+	state, err := newSessionState(session)
 	if err != nil {
 		return err
 	}
+	sup.onShutdown("remove the session directory", func() error {
+		return os.RemoveAll(state.dir)
+	})
 
-	// Catch interrupts to gracefully shutdown the proxy and restore the gcloud config.
-	idleConnsClosed := make(chan struct{})
-	sigint := make(chan os.Signal, 1)
+	srv, err := createProxy(state, session.Reason)
+	if err != nil {
+		return err
+	}
 	go func() {
-		signal.Notify(sigint, os.Interrupt)
-		<-sigint
-
-		// An interrupt signal was received, shutdown the proxy server.
-		if err := srv.Shutdown(context.Background()); err != nil {
-			util.Logger.WithError(err).Error("failed to properly shut down proxy server")
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			sup.fail(errorsutil.New("Failed to start the auth proxy", err))
 		}
-		close(idleConnsClosed)
-		util.Logger.Info("Stopping auth proxy and restoring gcloud config")
-		errorsutil.CheckRevertGcloudConfigError(gcpclient.UnsetGcloudProxy())
-		os.Exit(0)
 	}()
+	sup.onShutdown("stop the auth proxy", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), proxyShutdownTimeout)
+		defer cancel()
+		return srv.Shutdown(ctx)
+	})
 
-	proxyServerExit := &sync.WaitGroup{}
-	proxyServerExit.Add(1)
-	go func() {
-		defer proxyServerExit.Done()
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			util.Logger.WithError(err).Fatal("failed to start the auth proxy")
-		}
-		<-idleConnsClosed
-	}()
+	// Serve "eiam session extend" from the sub-shell.
+	if control, err := net.Listen("unix", state.controlSocket()); err != nil {
+		util.Logger.WithError(err).Warn("Failed to listen for requests to extend the session")
+	} else {
+		go serveControl(control, extendSession(session, state))
+		sup.onShutdown("stop serving requests to extend the session", control.Close)
+	}
 
-	sessionLength := time.Until(expirationDate)
-	sessionEnd := time.Now().Add(sessionLength).Format(time.RFC1123)
+	sessionEnd := state.Expiry().Format(time.RFC1123)
 	util.Logger.Infof("Starting auth proxy. Privileged session will last until %s", sessionEnd)
 
-	wg.Add(1)
-	var oldState *term.State
-	// TODO: Instead of handling errors in the startShell function, handle them here.
-	go startShell(svcAcct, accessToken, expirationDate.Format(time.RFC3339Nano), defaultCluster, &oldState)
+	sup.onShutdown("reset the terminal title", func() error {
+		clearTerminalTitle()
+		return nil
+	})
+	if err := startShell(sup, shellSession{
+		ServiceAccount: session.ServiceAccount,
+		Project:        session.Project,
+		Reason:         session.Reason,
+		AccessToken:    session.AccessToken,
+		Expiry:         session.Expiry,
+	}, state, session.DefaultCluster); err != nil {
+		return err
+	}
 
-	// Shut down the auth proxy when the user exits the sub-shell.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	expired := make(chan struct{})
 	go func() {
-		wg.Wait()
-		sigint <- syscall.SIGINT
+		if watchExpiry(ctx, state, session.ServiceAccount) {
+			close(expired)
+		}
 	}()
 
-	time.Sleep(sessionLength)
+	reason, err := sup.wait(ctx, expired)
+	cancel()
+	// Log once the terminal has been restored.
+	if shutdownErr := sup.shutdown(); shutdownErr == nil {
+		util.Logger.Infof("%s, stopped the auth proxy and restored the gcloud config", reason)
+	}
+	return err
+}
 
-	if err := term.Restore(int(os.Stdin.Fd()), oldState); err != nil {
-		return errorsutil.New("Failed to restore original shell", err)
+// extendSession returns the function that extends the session for the
+// control socket. Extensions are serialized.
+func extendSession(session *Session, state *sessionState) func(d time.Duration) (time.Time, error) {
+	var mu sync.Mutex
+	return func(d time.Duration) (time.Time, error) {
+		if session.Extend == nil {
+			return time.Time{}, fmt.Errorf("this session cannot be extended")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if session.MaxLifetime > 0 {
+			if lifetime := time.Since(session.Start) + d; lifetime > session.MaxLifetime {
+				return time.Time{}, fmt.Errorf("extending the session by %v would make it last %v, which exceeds the maximum of %v",
+					d, lifetime.Round(time.Second), session.MaxLifetime)
+			}
+		}
+		token, expiry, err := session.Extend(d)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if err := state.update(token, expiry); err != nil {
+			return time.Time{}, err
+		}
+		return expiry, nil
+	}
+}
+
+// watchExpiry returns true when the session expires, or false when ctx is
+// canceled first. Until then it keeps the terminal title up to date and warns
+// at the session.warnings thresholds.
+func watchExpiry(ctx context.Context, state *sessionState, svcAcct string) bool {
+	showTitle := viper.GetBool(appconfig.SessionTerminalTitle)
+	warnings := expiryWarnings{thresholds: warningThresholds()}
+	expiry := state.Expiry()
+	warnings.reset(time.Until(expiry))
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	title := ""
+	for {
+		if current := state.Expiry(); !current.Equal(expiry) {
+			// The session was extended.
+			expiry = current
+			warnings.reset(time.Until(expiry))
+		}
+		remaining := time.Until(expiry)
+		if remaining <= 0 {
+			return true
+		}
+		if warnings.due(remaining) > 0 {
+			// The terminal is in raw mode, so lines need a carriage return.
+			fmt.Fprintf(os.Stdout, "\r\n\033[33m[eiam] The privileged session expires in %s. "+
+				"Run `eiam session extend` to extend it.\033[m\r\n", FormatRemaining(remaining))
+		}
+		if showTitle {
+			if t := fmt.Sprintf("eiam: %s (%s left)", svcAcct, FormatRemaining(remaining)); t != title {
+				title = t
+				setTerminalTitle(title)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
 	}
+}
+
+// setTerminalTitle sets the title of the terminal window.
+func setTerminalTitle(title string) {
+	fmt.Fprintf(os.Stdout, "\033]0;%s\007", title)
+}
 
-	util.Logger.Info("Privileged session expired, stopping auth proxy and restoring gcloud config")
-	if err := srv.Shutdown(context.Background()); err != nil {
-		return errorsutil.New("Failed to properly shut down proxy server", err)
+// clearTerminalTitle resets the title of the terminal window if eiam set it.
+func clearTerminalTitle() {
+	if viper.GetBool(appconfig.SessionTerminalTitle) {
+		setTerminalTitle("")
 	}
-	errorsutil.CheckRevertGcloudConfigError(gcpclient.UnsetGcloudProxy())
-	return nil
 }
 
-func createProxy(accessToken, reason string) (*http.Server, error) {
+func createProxy(state *sessionState, reason string) (*http.Server, error) {
 	proxy := goproxy.NewProxyHttpServer()
 	proxy.Verbose = viper.GetBool(appconfig.AuthProxyVerbose)
 
@@ -145,10 +245,22 @@ func createProxy(accessToken, reason string) (*http.Server, error) {
 		return nil, err
 	}
 
-	proxy.OnRequest().HandleConnect(goproxy.FuncHttpsHandler(funcHTTPSHandler))
+	allowedHosts := viper.GetStringSlice(appconfig.AuthProxyAllowedHosts)
+	proxy.OnRequest().HandleConnect(goproxy.FuncHttpsHandler(
+		func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+			if !hostAllowed(allowedHosts, host) {
+				util.Logger.Warnf("The auth proxy refused a connection to %s, which is not in %s", host, appconfig.AuthProxyAllowedHosts)
+				return goproxy.RejectConnect, host
+			}
+			return funcHTTPSHandler(host, ctx)
+		}))
 
 	proxy.OnRequest().DoFunc(func(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
-		r.Header.Set("authorization", fmt.Sprintf("Bearer %s", accessToken))
+		if !hostAllowed(allowedHosts, r.URL.Host) {
+			return r, goproxy.NewResponse(r, goproxy.ContentTypeText, http.StatusForbidden,
+				fmt.Sprintf("%s is not in %s", r.URL.Hostname(), appconfig.AuthProxyAllowedHosts))
+		}
+		r.Header.Set("authorization", fmt.Sprintf("Bearer %s", state.Token()))
 		r.Header.Set("X-Goog-Request-Reason", reason)
 		return r, nil
 	})
@@ -163,3 +275,21 @@ func createProxy(accessToken, reason string) (*http.Server, error) {
 	}
 	return srv, nil
 }
+
+// hostAllowed reports whether the auth proxy may send the access token to the
+// host. Any host is allowed if the authproxy.allowedhosts setting is empty.
+func hostAllowed(allowed []string, hostport string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	for _, pattern := range allowed {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
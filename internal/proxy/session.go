@@ -0,0 +1,309 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+// The files in the state directory of a privileged session.
+const (
+	sessionFileName   = "session.env"
+	controlSocketName = "control.sock"
+)
+
+// Session is a privileged session served by the auth proxy.
+type Session struct {
+	AccessToken    string
+	Reason         string
+	ServiceAccount string
+	Project        string
+	Expiry         time.Time
+
+	// DefaultCluster is the GKE cluster that kubectl is configured for, if any.
+	DefaultCluster map[string]string
+
+	// Extend generates a new access token that lasts for d, to extend the
+	// session with "eiam session extend". Sessions without it cannot be
+	// extended.
+	Extend func(d time.Duration) (accessToken string, expiry time.Time, err error)
+
+	// Start is when the session started. MaxLifetime limits how long it may
+	// last in total, however many times it is extended. Zero means that there
+	// is no limit.
+	Start       time.Time
+	MaxLifetime time.Duration
+
+	// OnEnd is called once the proxy has been stopped and the gcloud config
+	// restored, whether the session expired or the user exited it.
+	OnEnd func()
+}
+
+// sessionState is the part of a session that changes when it is extended.
+// Its state directory holds the session file, which the prompt of the
+// sub-shell and "eiam session remaining" read, and the control socket.
+type sessionState struct {
+	mu         sync.Mutex
+	token      string
+	expiry     time.Time
+	kubeConfig string
+	dir        string
+}
+
+func newSessionState(s *Session) (*sessionState, error) {
+	dir, err := os.MkdirTemp("", "eiam-session-")
+	if err != nil {
+		return nil, errorsutil.New("Failed to create the session directory", err)
+	}
+	state := &sessionState{token: s.AccessToken, expiry: s.Expiry, dir: dir}
+	if err := state.writeSessionFile(); err != nil {
+		state.remove()
+		return nil, err
+	}
+	return state, nil
+}
+
+// Token returns the current access token of the session.
+func (s *sessionState) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// Expiry returns when the session expires.
+func (s *sessionState) Expiry() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expiry
+}
+
+// setKubeConfig records the temporary kubeconfig of the sub-shell, whose
+// credentials are replaced when the session is extended.
+func (s *sessionState) setKubeConfig(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kubeConfig = path
+}
+
+// update replaces the access token of an extended session.
+func (s *sessionState) update(token string, expiry time.Time) error {
+	s.mu.Lock()
+	s.token, s.expiry = token, expiry
+	kubeConfig := s.kubeConfig
+	s.mu.Unlock()
+
+	if kubeConfig != "" {
+		if err := writeCredsToKubeConfig(kubeConfig, token, expiry.Format(time.RFC3339Nano)); err != nil {
+			return err
+		}
+	}
+	return s.writeSessionFile()
+}
+
+func (s *sessionState) sessionFile() string {
+	return filepath.Join(s.dir, sessionFileName)
+}
+
+func (s *sessionState) controlSocket() string {
+	return filepath.Join(s.dir, controlSocketName)
+}
+
+// env returns the environment variables that point the sub-shell at the
+// state directory.
+func (s *sessionState) env() []string {
+	return []string{
+		fmt.Sprintf("EIAM_SESSION_FILE=%s", s.sessionFile()),
+		fmt.Sprintf("EIAM_SESSION_SOCKET=%s", s.controlSocket()),
+	}
+}
+
+// writeSessionFile writes the expiry of the session in a format that shells
+// can read without running a command. It is replaced atomically so that a
+// prompt never reads a partial file.
+func (s *sessionState) writeSessionFile() error {
+	expiry := s.Expiry()
+	content := fmt.Sprintf("EIAM_EXPIRES_AT=%s\nEIAM_EXPIRES_AT_UNIX=%d\n", expiry.Format(time.RFC3339), expiry.Unix())
+	tmp := s.sessionFile() + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0o600); err != nil {
+		return errorsutil.New("Failed to write the session file", err)
+	}
+	if err := os.Rename(tmp, s.sessionFile()); err != nil {
+		return errorsutil.New("Failed to write the session file", err)
+	}
+	return nil
+}
+
+func (s *sessionState) remove() {
+	if err := os.RemoveAll(s.dir); err != nil {
+		util.Logger.WithError(err).Warnf("Failed to remove %s", s.dir)
+	}
+}
+
+// ReadSessionExpiry returns the expiry in the session file of a privileged
+// session.
+func ReadSessionExpiry(sessionFile string) (time.Time, error) {
+	f, err := os.Open(sessionFile)
+	if err != nil {
+		return time.Time{}, errorsutil.New("Failed to read the session file", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "EIAM_EXPIRES_AT_UNIX="); ok {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return time.Time{}, errorsutil.New("Invalid session file", err)
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.Time{}, errorsutil.New("Invalid session file", errors.New("it has no expiry"))
+}
+
+// FormatRemaining formats the time left in a session the way the prompt of
+// the sub-shell does.
+func FormatRemaining(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "expired"
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%dm", int((d+time.Minute-time.Second)/time.Minute))
+}
+
+// controlRequest is a request to the control socket of a session.
+type controlRequest struct {
+	Action   string `json:"action"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// controlResponse is the response of the control socket.
+type controlResponse struct {
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// The actions of the control socket.
+const actionExtend = "extend"
+
+// serveControl answers requests to extend the session on its control socket
+// until the listener is closed.
+func serveControl(l net.Listener, extend func(d time.Duration) (time.Time, error)) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			var req controlRequest
+			var resp controlResponse
+			if err := json.NewDecoder(conn).Decode(&req); err != nil {
+				resp.Error = fmt.Sprintf("invalid request: %v", err)
+			} else if req.Action != actionExtend {
+				resp.Error = fmt.Sprintf("unknown action %q", req.Action)
+			} else if d, err := time.ParseDuration(req.Duration); err != nil {
+				resp.Error = fmt.Sprintf("invalid duration: %v", err)
+			} else if resp.ExpiresAt, err = extend(d); err != nil {
+				resp.Error = err.Error()
+			}
+			_ = json.NewEncoder(conn).Encode(resp)
+		}()
+	}
+}
+
+// ExtendSession asks the eiam process that runs the privileged session with
+// the control socket to extend it by generating a token that lasts for d. It
+// returns the new expiry.
+func ExtendSession(socket string, d time.Duration) (time.Time, error) {
+	conn, err := net.DialTimeout("unix", socket, 5*time.Second)
+	if err != nil {
+		return time.Time{}, errorsutil.New("Failed to reach the privileged session", err)
+	}
+	// There is no deadline: generating the token may have to wait for an
+	// approval, which has its own timeout.
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(controlRequest{Action: actionExtend, Duration: d.String()}); err != nil {
+		return time.Time{}, errorsutil.New("Failed to send the request to the privileged session", err)
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return time.Time{}, errorsutil.New("Failed to read the response of the privileged session", err)
+	}
+	if resp.Error != "" {
+		return time.Time{}, errors.New(resp.Error)
+	}
+	return resp.ExpiresAt, nil
+}
+
+// warningThresholds returns the session.warnings setting, longest first.
+func warningThresholds() []time.Duration {
+	var thresholds []time.Duration
+	for _, value := range viper.GetStringSlice(appconfig.SessionWarnings) {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			util.Logger.Warnf("Ignoring invalid %s value %q", appconfig.SessionWarnings, value)
+			continue
+		}
+		thresholds = append(thresholds, d)
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] > thresholds[j] })
+	return thresholds
+}
+
+// expiryWarnings tracks which warnings have been given for a session.
+type expiryWarnings struct {
+	thresholds []time.Duration
+	next       int
+}
+
+// due returns the threshold to warn about now that remaining is left, or
+// zero. Thresholds that passed while no warning was due, such as the ones
+// above the length of the session, are skipped.
+func (w *expiryWarnings) due(remaining time.Duration) time.Duration {
+	var due time.Duration
+	for w.next < len(w.thresholds) && remaining <= w.thresholds[w.next] {
+		due = w.thresholds[w.next]
+		w.next++
+	}
+	return due
+}
+
+// reset starts over after the session was extended to last for remaining.
+func (w *expiryWarnings) reset(remaining time.Duration) {
+	w.next = 0
+	for w.next < len(w.thresholds) && remaining <= w.thresholds[w.next] {
+		w.next++
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatRemaining(t *testing.T) {
+	tests := map[time.Duration]string{
+		-time.Second:                "expired",
+		0:                           "expired",
+		30 * time.Second:            "1m",
+		time.Minute:                 "1m",
+		5*time.Minute + time.Second: "6m",
+		time.Hour:                   "1h00m",
+		time.Hour + 5*time.Minute + 59*time.Second: "1h05m",
+		12*time.Hour + 30*time.Minute:              "12h30m",
+	}
+	for d, want := range tests {
+		if got := FormatRemaining(d); got != want {
+			t.Errorf("FormatRemaining(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestExpiryWarnings(t *testing.T) {
+	w := expiryWarnings{thresholds: []time.Duration{5 * time.Minute, time.Minute}}
+
+	// A session shorter than a threshold is not warned about it.
+	w.reset(3 * time.Minute)
+	if due := w.due(2 * time.Minute); due != 0 {
+		t.Errorf("expected no warning, got %v", due)
+	}
+	if due := w.due(time.Minute); due != time.Minute {
+		t.Errorf("expected the 1m warning, got %v", due)
+	}
+	if due := w.due(30 * time.Second); due != 0 {
+		t.Errorf("expected a single 1m warning, got %v", due)
+	}
+
+	// Extending the session re-arms the warnings.
+	w.reset(time.Hour)
+	if due := w.due(10 * time.Minute); due != 0 {
+		t.Errorf("expected no warning, got %v", due)
+	}
+	if due := w.due(5 * time.Minute); due != 5*time.Minute {
+		t.Errorf("expected the 5m warning, got %v", due)
+	}
+	// Thresholds passed at once are a single warning.
+	if due := w.due(0); due != time.Minute {
+		t.Errorf("expected the 1m warning, got %v", due)
+	}
+}
+
+func TestSessionState(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	state, err := newSessionState(&Session{AccessToken: "token", Expiry: expiry})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer state.remove()
+
+	if got, err := ReadSessionExpiry(state.sessionFile()); err != nil || !got.Equal(expiry) {
+		t.Fatalf("expected expiry %v, got %v (%v)", expiry, got, err)
+	}
+
+	extended := expiry.Add(30 * time.Minute)
+	if err := state.update("new-token", extended); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state.Token() != "new-token" {
+		t.Errorf("expected the new token, got %q", state.Token())
+	}
+	if got, err := ReadSessionExpiry(state.sessionFile()); err != nil || !got.Equal(extended) {
+		t.Errorf("expected expiry %v, got %v (%v)", extended, got, err)
+	}
+
+	dir := state.dir
+	state.remove()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected the session directory to be removed, got %v", err)
+	}
+}
+
+func TestExtendSession(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), controlSocketName)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	now := time.Now().Truncate(time.Second)
+	go serveControl(l, func(d time.Duration) (time.Time, error) {
+		if d > time.Hour {
+			return time.Time{}, os.ErrPermission
+		}
+		return now.Add(d), nil
+	})
+
+	got, err := ExtendSession(socket, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := now.Add(30 * time.Minute); !got.Equal(want) {
+		t.Errorf("expected expiry %v, got %v", want, got)
+	}
+
+	if _, err := ExtendSession(socket, 2*time.Hour); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("expected the error of the session, got %v", err)
+	}
+}
+
+func TestExtendSessionMaxLifetime(t *testing.T) {
+	session := &Session{
+		Expiry:      time.Now().Add(10 * time.Minute),
+		Start:       time.Now().Add(-50 * time.Minute),
+		MaxLifetime: time.Hour,
+	}
+	extended := 0
+	session.Extend = func(d time.Duration) (string, time.Time, error) {
+		extended++
+		return "token", time.Now().Add(d), nil
+	}
+	state, err := newSessionState(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.remove()
+	extend := extendSession(session, state)
+
+	if _, err := extend(5 * time.Minute); err != nil {
+		t.Fatalf("expected an extension within the maximum lifetime, got %v", err)
+	}
+	if _, err := extend(15 * time.Minute); err == nil || !strings.Contains(err.Error(), "exceeds the maximum of 1h0m0s") {
+		t.Errorf("expected the extension to be refused, got %v", err)
+	}
+	if extended != 1 {
+		t.Errorf("expected a token to be generated once, got %d", extended)
+	}
+
+	// Without a maximum lifetime the session can be extended indefinitely.
+	session.MaxLifetime = 0
+	if _, err := extend(15 * time.Minute); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -17,13 +17,12 @@ package proxy
 import (
 	"bytes"
 	"fmt"
-	"io"
-	"io/fs"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path"
 	"syscall"
+	"time"
 
 	"github.com/creack/pty"
 	"github.com/google/uuid"
@@ -37,21 +36,22 @@ import (
 	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
 )
 
-func startShell(svcAcct, accessToken, expiry string, defaultCluster map[string]string, oldState **term.State) {
-	// Copy environment variables from user, set PS1 prompt, and set the KUBECONFIG env var.
-	cmdEnv := append(
-		os.Environ(),
-		buildPrompt(svcAcct),
-		// The Terraform provider can source this and use it as the access token.
-		fmt.Sprintf("GOOGLE_OAUTH_ACCESS_TOKEN=%s", accessToken),
-	)
+// startShell starts the privileged sub-shell under sup, which undoes every
+// step when the session ends.
+func startShell(sup *supervisor, session shellSession, state *sessionState, defaultCluster map[string]string) error {
+	// Copy environment variables from user, add the session's variables, and set the KUBECONFIG env var.
+	cmdEnv := append(os.Environ(), session.env()...)
+	cmdEnv = append(cmdEnv, state.env()...)
 
 	if len(defaultCluster) > 0 {
 		tmpKubeConfig, err := createTempKubeConfig()
 		if err != nil {
-			util.Logger.WithError(err).Fatal("failed to create temp kubeconfig")
+			return errorsutil.New("Failed to create temp kubeconfig", err)
 		}
-		defer os.Remove(tmpKubeConfig.Name()) // Remove tmpKubeConfig after priv session ends.
+		tmpKubeConfig.Close()
+		sup.onShutdown("remove the temp kubeconfig", func() error {
+			return os.Remove(tmpKubeConfig.Name())
+		})
 		cmdEnv = append(
 			cmdEnv,
 			fmt.Sprintf("KUBECONFIG=%s", tmpKubeConfig.Name()),
@@ -70,79 +70,61 @@ func startShell(svcAcct, accessToken, expiry string, defaultCluster map[string]s
 		if err = c.Run(); err != nil {
 			util.Logger.Errorf(errOut.String())
 		} else {
-			util.Logger.Infof("kubectl is now authenticated as %s", svcAcct)
+			util.Logger.Infof("kubectl is now authenticated as %s", session.ServiceAccount)
 		}
-		if err = writeCredsToKubeConfig(tmpKubeConfig, accessToken, expiry); err != nil {
-			util.Logger.WithError(err).Fatal("failed to write credentials to temp kubeconfig")
+		expiry := state.Expiry().Format(time.RFC3339Nano)
+		if err = writeCredsToKubeConfig(tmpKubeConfig.Name(), state.Token(), expiry); err != nil {
+			return err
 		}
+		// Extending the session replaces the credentials in the kubeconfig.
+		state.setKubeConfig(tmpKubeConfig.Name())
 	}
 
 	// Create the shell command and copy the environment variables from the previous command.
-	shellCmd := exec.Command("bash")
-	shellCmd.Env = cmdEnv
+	shellCmd, cleanup, err := newShellCommand(sessionShell(), cmdEnv)
+	if err != nil {
+		return err
+	}
+	sup.onShutdown("remove the sub-shell's rc files", func() error {
+		cleanup()
+		return nil
+	})
 
 	util.Logger.Warn("Enter `exit` or press CTRL+D to quit privileged session")
 
+	// Save the state of the current shell so it can be restored later.
+	stdin := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(stdin)
+	if err != nil {
+		return errorsutil.New("Failed to save state of current shell", err)
+	}
+	sup.onShutdown("restore the original shell", func() error {
+		return term.Restore(stdin, oldState)
+	})
+
 	// Start the pty sub-shell.
-	ptmx, err := pty.Start(shellCmd)
+	ptmx, err := sup.startShell(shellCmd, os.Stdin, os.Stdout)
 	if err != nil {
-		util.Logger.WithError(err).Fatal("failed to start privileged sub-shell")
+		return errorsutil.New("Failed to start privileged sub-shell", err)
 	}
-	defer func() {
-		if err = ptmx.Close(); err != nil {
-			util.Logger.WithError(err).Fatal("failed to close privileged sub-shell")
-		}
-	}()
 
 	// Resize the pty shell when the user's terminal is resized.
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGWINCH)
+	sup.onShutdown("stop resizing the sub-shell", func() error {
+		signal.Stop(ch)
+		close(ch)
+		return nil
+	})
 	go func() {
 		for range ch {
-			if err = pty.InheritSize(os.Stdin, ptmx); err != nil {
-				util.Logger.WithError(err).Fatal("failed to resize pty")
+			if err := pty.InheritSize(os.Stdin, ptmx); err != nil {
+				util.Logger.WithError(err).Debug("Failed to resize pty")
 			}
 		}
 	}()
 	ch <- syscall.SIGWINCH
-
-	// Save the state of the current shell so it can be restored later.
-	if *oldState, err = term.MakeRaw(int(os.Stdin.Fd())); err != nil {
-		util.Logger.WithError(err).Fatal("failed to save state of current shell")
-	}
-	defer func() {
-		if err := term.Restore(int(os.Stdin.Fd()), *oldState); err != nil {
-			util.Logger.WithError(err).Fatal("failed to restore original shell")
-		}
-	}()
-
-	// Send user input to the sub-shell.
-	go func() {
-		if _, err := io.Copy(ptmx, os.Stdin); err != nil {
-			util.Logger.WithError(err).Error("failed to send user input to the sub-shell")
-		}
-	}()
-
-	// Write the output from the sub-shell to stdout.
-	if _, err := io.Copy(os.Stdout, ptmx); err != nil {
-		// On some linux systems, this error is thrown when CTRL-D is received.
-		if serr, ok := err.(*fs.PathError); ok {
-			if serr.Path == "/dev/ptmx" {
-				wg.Done()
-				return
-			}
-		} else {
-			util.Logger.WithError(err).Error("failed to write the output from the sub-shell to stdout")
-		}
-	}
-	wg.Done()
-}
-
-func buildPrompt(svcAcct string) string {
-	yellow := "\\[\\e[33m\\]"
-	green := "\\[\\e[36m\\]"
-	endColor := "\\[\\e[m\\]"
-	return fmt.Sprintf("PS1=\n[%s%s%s]\n[%seiam%s] > ", yellow, svcAcct, endColor, green, endColor)
+	return nil
 }
 
 func createTempKubeConfig() (*os.File, error) {
@@ -155,10 +137,10 @@ func createTempKubeConfig() (*os.File, error) {
 	return tmpKubeConfig, nil
 }
 
-func writeCredsToKubeConfig(tmpKubeConfig *os.File, accessToken, expiry string) error {
+func writeCredsToKubeConfig(tmpKubeConfig, accessToken, expiry string) error {
 	// Read the tmpKubeConfig into a client-go config object.
 	config := clientcmdapi.NewConfig()
-	configBytes, err := os.ReadFile(tmpKubeConfig.Name())
+	configBytes, err := os.ReadFile(tmpKubeConfig)
 	if err != nil {
 		return errorsutil.New("Failed to read generated tmp kubeconfig", err)
 	}
@@ -182,7 +164,7 @@ func writeCredsToKubeConfig(tmpKubeConfig *os.File, accessToken, expiry string)
 	if err != nil {
 		return errorsutil.New("Failed to serialize updated tmp kubeconfig", err)
 	}
-	if _, err := tmpKubeConfig.Write(newConfigBytes); err != nil {
+	if err := os.WriteFile(tmpKubeConfig, newConfigBytes, 0o600); err != nil {
 		return errorsutil.New("Failed to write updated tmp kubeconfig", err)
 	}
 	return nil
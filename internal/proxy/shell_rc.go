@@ -0,0 +1,256 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+// The shells whose prompt shows the privileged session.
+const (
+	shellBash  = "bash"
+	shellZsh   = "zsh"
+	shellFish  = "fish"
+	shellOther = "other"
+)
+
+// shellSession describes the privileged session of a sub-shell.
+type shellSession struct {
+	ServiceAccount string
+	Project        string
+	Reason         string
+	AccessToken    string
+	Expiry         time.Time
+}
+
+// env returns the environment variables that the sub-shell and the commands
+// run in it get. The EIAM_* variables can be used to build prompt segments.
+func (s shellSession) env() []string {
+	return []string{
+		// The Terraform provider can source this and use it as the access token.
+		fmt.Sprintf("GOOGLE_OAUTH_ACCESS_TOKEN=%s", s.AccessToken),
+		fmt.Sprintf("EIAM_SESSION_ID=%s", util.ReasonSessionID(s.Reason)),
+		fmt.Sprintf("EIAM_SERVICE_ACCOUNT=%s", s.ServiceAccount),
+		fmt.Sprintf("EIAM_PROJECT=%s", s.Project),
+		fmt.Sprintf("EIAM_REASON=%s", s.Reason),
+		fmt.Sprintf("EIAM_EXPIRES_AT=%s", s.Expiry.Format(time.RFC3339)),
+		fmt.Sprintf("EIAM_EXPIRES_AT_UNIX=%d", s.Expiry.Unix()),
+	}
+}
+
+// sessionShell returns the shell of privileged sessions: the session.shell
+// setting, $SHELL, or bash.
+func sessionShell() string {
+	if shell := viper.GetString(appconfig.SessionShell); shell != "" {
+		return shell
+	}
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return shellBash
+}
+
+// shellKind returns which of the supported shells the shell is.
+func shellKind(shell string) string {
+	switch name := strings.TrimPrefix(filepath.Base(shell), "-"); name {
+	case shellBash, shellZsh, shellFish:
+		return name
+	}
+	return shellOther
+}
+
+// newShellCommand returns the command that starts shell with env, configured
+// to show the privileged session in its prompt. The rc files it writes for
+// that source the user's own ones first and are removed by cleanup.
+func newShellCommand(shell string, env []string) (cmd *exec.Cmd, cleanup func(), err error) {
+	cleanup = func() {}
+	kind := shellKind(shell)
+	if kind == shellOther {
+		cmd = exec.Command(shell) //nolint:gosec // The user's own shell
+		cmd.Env = append(env, "PS1=[eiam ${EIAM_SERVICE_ACCOUNT}] $ ")
+		return cmd, cleanup, nil
+	}
+
+	dir, err := os.MkdirTemp("", "eiam-shell-")
+	if err != nil {
+		return nil, nil, errorsutil.New("Failed to create the sub-shell's rc directory", err)
+	}
+	cleanup = func() {
+		if err := os.RemoveAll(dir); err != nil {
+			util.Logger.WithError(err).Warnf("Failed to remove %s", dir)
+		}
+	}
+	writeRC := func(name, content string) error {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			return errorsutil.New("Failed to write the sub-shell's rc file", err)
+		}
+		return nil
+	}
+
+	switch kind {
+	case shellBash:
+		err = writeRC("bashrc", bashRC)
+		cmd = exec.Command(shell, "--rcfile", filepath.Join(dir, "bashrc"), "-i") //nolint:gosec // The user's own shell
+		cmd.Env = env
+	case shellZsh:
+		// zsh reads its rc files from ZDOTDIR. The temporary ones restore the
+		// user's ZDOTDIR and source the user's rc files before they set the prompt.
+		if err = writeRC(".zshenv", fmt.Sprintf(zshEnv, shellQuote(dir))); err == nil {
+			err = writeRC(".zshrc", zshRC)
+		}
+		cmd = exec.Command(shell, "-i") //nolint:gosec // The user's own shell
+		cmd.Env = append(env, "ZDOTDIR="+dir, "EIAM_USER_ZDOTDIR="+os.Getenv("ZDOTDIR"))
+	case shellFish:
+		rc := filepath.Join(dir, "eiam.fish")
+		err = writeRC("eiam.fish", fishRC)
+		cmd = exec.Command(shell, "-i", "--init-command", "source "+fishQuote(rc)) //nolint:gosec // The user's own shell
+		cmd.Env = env
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return cmd, cleanup, nil
+}
+
+// shellQuote quotes s for bash and zsh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fishQuote quotes s for fish.
+func fishQuote(s string) string {
+	return strconv.Quote(s)
+}
+
+const rcHeader = `# Generated by ephemeral-iam for a privileged session. It is removed when the
+# session ends. The EIAM_* environment variables describe the session.
+`
+
+// remainingFunc prints the time left in the session like FormatRemaining.
+// It reads the session file, which is rewritten when the session is extended,
+// and works in bash and zsh.
+const remainingFunc = `
+__eiam_remaining() {
+  local line expires="$EIAM_EXPIRES_AT_UNIX" left
+  if [ -r "$EIAM_SESSION_FILE" ]; then
+    while IFS= read -r line; do
+      case "$line" in
+        EIAM_EXPIRES_AT_UNIX=*) expires="${line#*=}" ;;
+      esac
+    done < "$EIAM_SESSION_FILE"
+  fi
+  [ -n "$expires" ] || return 0
+  left=$(( expires - ${EPOCHSECONDS:-$(date +%s)} ))
+  if (( left <= 0 )); then
+    printf 'expired'
+  elif (( left >= 3600 )); then
+    printf '%dh%02dm' $(( left / 3600 )) $(( left % 3600 / 60 ))
+  else
+    printf '%dm' $(( (left + 59) / 60 ))
+  fi
+}
+`
+
+const bashRC = rcHeader + `
+if [ -f "$HOME/.bashrc" ]; then
+  . "$HOME/.bashrc"
+fi
+` + remainingFunc + `
+PS1="\n[\[\e[33m\]\${EIAM_SERVICE_ACCOUNT}\[\e[m\] \$(__eiam_remaining)]\n[\[\e[36m\]eiam\[\e[m\]] ${PS1:-> }"
+`
+
+const zshEnv = rcHeader + `
+ZDOTDIR="${EIAM_USER_ZDOTDIR:-$HOME}"
+if [[ -f "$ZDOTDIR/.zshenv" ]]; then
+  source "$ZDOTDIR/.zshenv"
+fi
+EIAM_USER_ZDOTDIR="$ZDOTDIR"
+ZDOTDIR=%s
+`
+
+const zshRC = rcHeader + `
+ZDOTDIR="$EIAM_USER_ZDOTDIR"
+unset EIAM_USER_ZDOTDIR
+if [[ -f "$ZDOTDIR/.zshrc" ]]; then
+  source "$ZDOTDIR/.zshrc"
+fi
+
+zmodload -F zsh/datetime p:EPOCHSECONDS 2>/dev/null
+` + remainingFunc + `
+setopt prompt_subst
+PROMPT="%F{cyan}[eiam]%f ${PROMPT:-%# }"
+RPROMPT='%F{yellow}${EIAM_SERVICE_ACCOUNT}%f $(__eiam_remaining)'"${RPROMPT:+ $RPROMPT}"
+`
+
+const fishRC = rcHeader + `
+if functions -q fish_prompt
+    functions -c fish_prompt __eiam_user_prompt
+else
+    function __eiam_user_prompt
+        echo -n '> '
+    end
+end
+function fish_prompt
+    set_color cyan
+    echo -n '[eiam] '
+    set_color normal
+    __eiam_user_prompt
+end
+
+if functions -q fish_right_prompt
+    functions -c fish_right_prompt __eiam_user_right_prompt
+else
+    function __eiam_user_right_prompt
+    end
+end
+function __eiam_remaining
+    set -l expires $EIAM_EXPIRES_AT_UNIX
+    if test -r "$EIAM_SESSION_FILE"
+        set -l match (string match -r '^EIAM_EXPIRES_AT_UNIX=(\d+)$' < $EIAM_SESSION_FILE)
+        if set -q match[2]
+            set expires $match[2]
+        end
+    end
+    test -n "$expires"; or return 0
+    set -l left (math $expires - (date +%s))
+    if test $left -le 0
+        echo -n expired
+    else if test $left -ge 3600
+        printf '%dh%02dm' (math "floor($left / 3600)") (math "floor($left % 3600 / 60)")
+    else
+        printf '%dm' (math "floor(($left + 59) / 60)")
+    end
+end
+function fish_right_prompt
+    set_color yellow
+    echo -n $EIAM_SERVICE_ACCOUNT
+    set_color normal
+    echo -n ' '(__eiam_remaining)
+    __eiam_user_right_prompt
+end
+`
@@ -0,0 +1,119 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShellKind(t *testing.T) {
+	tests := map[string]string{
+		"/bin/bash":              shellBash,
+		"-zsh":                   shellZsh,
+		"/opt/homebrew/bin/fish": shellFish,
+		"/bin/sh":                shellOther,
+		"/usr/local/bin/nushell": shellOther,
+	}
+	for shell, want := range tests {
+		if got := shellKind(shell); got != want {
+			t.Errorf("shellKind(%q) = %q, want %q", shell, got, want)
+		}
+	}
+}
+
+func TestSessionEnv(t *testing.T) {
+	env := shellSession{
+		ServiceAccount: "admin@prod.iam.gserviceaccount.com",
+		Project:        "prod",
+		Reason:         "ephemeral-iam 0123456789abcdef: Fix prod",
+		AccessToken:    "token",
+		Expiry:         time.Unix(1622548800, 0),
+	}.env()
+	for _, want := range []string{
+		"EIAM_SESSION_ID=0123456789abcdef",
+		"EIAM_SERVICE_ACCOUNT=admin@prod.iam.gserviceaccount.com",
+		"EIAM_EXPIRES_AT_UNIX=1622548800",
+		"GOOGLE_OAUTH_ACCESS_TOKEN=token",
+	} {
+		if !contains(env, want) {
+			t.Errorf("expected %s in %v", want, env)
+		}
+	}
+}
+
+func TestNewShellCommand(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	home := t.TempDir()
+	if err := os.WriteFile(filepath.Join(home, ".bashrc"), []byte("PS1='user$ '\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The prompt reads the expiry from the session file, which overrides the
+	// one the shell started with.
+	sessionFile := filepath.Join(home, "session.env")
+	expiry := time.Now().Add(2*time.Hour + 30*time.Second).Unix()
+	if err := os.WriteFile(sessionFile, []byte(fmt.Sprintf("EIAM_EXPIRES_AT_UNIX=%d\n", expiry)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, cleanup, err := newShellCommand(bash, []string{
+		"HOME=" + home,
+		"EIAM_SERVICE_ACCOUNT=admin@prod",
+		"EIAM_EXPIRES_AT_UNIX=0",
+		"EIAM_SESSION_FILE=" + sessionFile,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cmd.Args) != 4 || cmd.Args[1] != "--rcfile" {
+		t.Fatalf("unexpected arguments %v", cmd.Args)
+	}
+	rc := cmd.Args[2]
+
+	// Source the rc file like bash does for an interactive shell and expand
+	// the prompt.
+	check := exec.Command(bash, "-c", `source "$1"; printf '%s' "${PS1@P}"`, "bash", rc) //nolint:gosec // Test
+	check.Env = cmd.Env
+	out, err := check.CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to source the rc file: %v: %s", err, out)
+	}
+	if prompt := string(out); !strings.Contains(prompt, "admin@prod\x1b[m 2h00m]") || !strings.HasSuffix(prompt, "eiam\x1b[m] user$ ") {
+		t.Errorf("expected the session and the user's prompt, got %q", prompt)
+	}
+
+	cleanup()
+	if _, err := os.Stat(filepath.Dir(rc)); !os.IsNotExist(err) {
+		t.Errorf("expected the rc directory to be removed, got %v", err)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+)
+
+// shellStopTimeout is how long the sub-shell has to exit after the hangup
+// signal before it is killed.
+var shellStopTimeout = 5 * time.Second
+
+// endReason is why a privileged session ended.
+type endReason int
+
+const (
+	endExpired endReason = iota
+	endExited
+	endSignal
+	endFailed
+	endCanceled
+)
+
+func (r endReason) String() string {
+	switch r {
+	case endExpired:
+		return "Privileged session expired"
+	case endExited:
+		return "Privileged sub-shell exited"
+	case endSignal:
+		return "Privileged session interrupted"
+	case endFailed:
+		return "Privileged session failed"
+	}
+	return "Privileged session canceled"
+}
+
+// teardownStep is a step of ending a session.
+type teardownStep struct {
+	name string
+	fn   func() error
+}
+
+// supervisor runs the parts of a privileged session and ends the session
+// when the sub-shell exits, the session expires, eiam is signalled or a part
+// fails. Every part registers how to undo it with onShutdown, and shutdown
+// undoes them in the reverse order, like deferred calls: the sub-shell is
+// stopped first, then the terminal restored, the proxy stopped, the gcloud
+// config restored and the temporary files removed.
+type supervisor struct {
+	signals chan os.Signal
+	failed  chan error
+
+	shellDone chan struct{}
+
+	mu       sync.Mutex
+	steps    []teardownStep
+	shutOnce sync.Once
+	err      error
+}
+
+// newSupervisor returns a supervisor that ends the session on SIGINT,
+// SIGTERM and SIGHUP until it is shut down.
+func newSupervisor() *supervisor {
+	s := &supervisor{
+		signals: make(chan os.Signal, 1),
+		failed:  make(chan error, 1),
+	}
+	signal.Notify(s.signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	s.onShutdown("stop handling signals", func() error {
+		signal.Stop(s.signals)
+		return nil
+	})
+	return s
+}
+
+// onShutdown registers a step of ending the session. Steps run in the
+// reverse order of their registration.
+func (s *supervisor) onShutdown(name string, fn func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.steps = append(s.steps, teardownStep{name: name, fn: fn})
+}
+
+// fail ends the session because a part of it failed. Only the first error is
+// kept.
+func (s *supervisor) fail(err error) {
+	select {
+	case s.failed <- err:
+	default:
+	}
+}
+
+// startShell starts the sub-shell on a new pty that reads from stdin and
+// writes to stdout. Its process group is hung up when the session ends.
+func (s *supervisor) startShell(cmd *exec.Cmd, stdin io.Reader, stdout io.Writer) (*os.File, error) {
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return nil, err
+	}
+	s.shellDone = make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(s.shellDone)
+	}()
+
+	// Send user input to the sub-shell.
+	go func() {
+		if _, err := io.Copy(ptmx, stdin); err != nil && !errors.Is(err, os.ErrClosed) {
+			util.Logger.WithError(err).Debug("Stopped sending user input to the sub-shell")
+		}
+	}()
+	// Write the output from the sub-shell to stdout. Reading fails with EIO
+	// once the sub-shell and its jobs have exited.
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		if _, err := io.Copy(stdout, ptmx); err != nil && !errors.Is(err, syscall.EIO) && !errors.Is(err, os.ErrClosed) {
+			util.Logger.WithError(err).Debug("Stopped writing the output from the sub-shell")
+		}
+	}()
+
+	s.onShutdown("stop the privileged sub-shell", func() error {
+		err := stopProcessGroup(cmd.Process.Pid, s.shellDone)
+		// Let the last output of the sub-shell reach the terminal.
+		select {
+		case <-outputDone:
+		case <-time.After(time.Second):
+		}
+		if cerr := ptmx.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+	return ptmx, nil
+}
+
+// stopProcessGroup hangs up the process group of the sub-shell, whose leader
+// is pid, like closing its terminal would, and kills it if it has not exited
+// after shellStopTimeout. done is closed when the leader exits.
+func stopProcessGroup(pid int, done <-chan struct{}) error {
+	select {
+	case <-done:
+		// Background jobs of the sub-shell may still be running.
+		_ = syscall.Kill(-pid, syscall.SIGHUP)
+		return nil
+	default:
+	}
+	if err := syscall.Kill(-pid, syscall.SIGHUP); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+	select {
+	case <-done:
+		return nil
+	case <-time.After(shellStopTimeout):
+	}
+	if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+	<-done
+	return nil
+}
+
+// wait returns why the session ended: the sub-shell exited, expired was
+// closed, eiam was signalled, a part failed or ctx was canceled.
+func (s *supervisor) wait(ctx context.Context, expired <-chan struct{}) (endReason, error) {
+	select {
+	case <-s.shellDone:
+		return endExited, nil
+	case <-expired:
+		return endExpired, nil
+	case sig := <-s.signals:
+		util.Logger.Debugf("Received %v", sig)
+		return endSignal, nil
+	case err := <-s.failed:
+		return endFailed, err
+	case <-ctx.Done():
+		return endCanceled, ctx.Err()
+	}
+}
+
+// shutdown runs the registered steps once, in the reverse order of their
+// registration. A step that fails does not stop the ones after it, and the
+// errors are returned together.
+func (s *supervisor) shutdown() error {
+	s.shutOnce.Do(func() {
+		s.mu.Lock()
+		steps := s.steps
+		s.steps = nil
+		s.mu.Unlock()
+
+		var errs []error
+		for i := len(steps) - 1; i >= 0; i-- {
+			util.Logger.Debugf("Ending the privileged session: %s", steps[i].name)
+			if err := steps[i].fn(); err != nil {
+				util.Logger.WithError(err).Errorf("Failed to %s", steps[i].name)
+				errs = append(errs, fmt.Errorf("failed to %s: %w", steps[i].name, err))
+			}
+		}
+		s.err = errors.Join(errs...)
+	})
+	return s.err
+}
@@ -0,0 +1,181 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+)
+
+func TestMain(m *testing.M) {
+	util.Logger = logrus.New()
+	util.Logger.Out = io.Discard
+	os.Exit(m.Run())
+}
+
+// startTestShell starts a shell under a new supervisor with the steps that
+// come before the sub-shell in a session. They record the order they ran in,
+// and whether the sub-shell had been stopped by then.
+func startTestShell(t *testing.T, script string) (sup *supervisor, stdin *io.PipeWriter, ran *[]string) {
+	t.Helper()
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not installed")
+	}
+	sup = newSupervisor()
+	ran = &[]string{}
+	for _, name := range []string{"remove temp files", "restore config", "stop proxy", "restore terminal"} {
+		name := name
+		sup.onShutdown(name, func() error {
+			select {
+			case <-sup.shellDone:
+			default:
+				t.Errorf("the sub-shell was still running when %s ran", name)
+			}
+			*ran = append(*ran, name)
+			return nil
+		})
+	}
+
+	args := []string{sh}
+	if script != "" {
+		args = append(args, "-c", script)
+	}
+	r, w := io.Pipe()
+	t.Cleanup(func() { w.Close() })
+	if _, err := sup.startShell(&exec.Cmd{Path: sh, Args: args}, r, io.Discard); err != nil {
+		t.Fatalf("failed to start the shell: %v", err)
+	}
+	return sup, w, ran
+}
+
+func waitForEnd(t *testing.T, sup *supervisor, expired <-chan struct{}) endReason {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reason, err := sup.wait(ctx, expired)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return reason
+}
+
+func checkTeardown(t *testing.T, sup *supervisor, ran *[]string) {
+	t.Helper()
+	start := time.Now()
+	if err := sup.shutdown(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("teardown took %v", took)
+	}
+	want := []string{"restore terminal", "stop proxy", "restore config", "remove temp files"}
+	if !reflect.DeepEqual(*ran, want) {
+		t.Errorf("expected the steps %v, got %v", want, *ran)
+	}
+}
+
+func TestSupervisorShellExit(t *testing.T) {
+	sup, stdin, ran := startTestShell(t, "")
+
+	// Ctrl-D on an empty line exits the shell.
+	if _, err := stdin.Write([]byte{4}); err != nil {
+		t.Fatal(err)
+	}
+	if reason := waitForEnd(t, sup, nil); reason != endExited {
+		t.Fatalf("expected the session to end with the shell, got %q", reason)
+	}
+	checkTeardown(t, sup, ran)
+}
+
+func TestSupervisorExpiry(t *testing.T) {
+	stopTimeout := shellStopTimeout
+	shellStopTimeout = 100 * time.Millisecond
+	defer func() { shellStopTimeout = stopTimeout }()
+
+	// The shell ignores the hangup, so it has to be killed.
+	sup, _, ran := startTestShell(t, `trap "" HUP; sleep 30`)
+	state, err := newSessionState(&Session{Expiry: time.Now().Add(100 * time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.remove()
+
+	expired := make(chan struct{})
+	go func() {
+		if watchExpiry(context.Background(), state, "admin@prod") {
+			close(expired)
+		}
+	}()
+	if reason := waitForEnd(t, sup, expired); reason != endExpired {
+		t.Fatalf("expected the session to expire, got %q", reason)
+	}
+	checkTeardown(t, sup, ran)
+}
+
+func TestSupervisorSignal(t *testing.T) {
+	sup, _, ran := startTestShell(t, "sleep 30")
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	if reason := waitForEnd(t, sup, nil); reason != endSignal {
+		t.Fatalf("expected the session to be interrupted, got %q", reason)
+	}
+	checkTeardown(t, sup, ran)
+}
+
+func TestSupervisorFailedStep(t *testing.T) {
+	sup := newSupervisor()
+	var ran []string
+	for _, name := range []string{"first", "second", "third"} {
+		name := name
+		sup.onShutdown(name, func() error {
+			ran = append(ran, name)
+			if name == "second" {
+				return errors.New("boom")
+			}
+			return nil
+		})
+	}
+	sup.fail(errors.New("proxy failed"))
+	if reason, err := sup.wait(context.Background(), nil); reason != endFailed || err == nil {
+		t.Fatalf("expected the failure, got %q, %v", reason, err)
+	}
+
+	err := sup.shutdown()
+	if err == nil || !strings.Contains(err.Error(), "failed to second: boom") {
+		t.Errorf("expected the error of the failed step, got %v", err)
+	}
+	if want := []string{"third", "second", "first"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("expected the steps %v, got %v", want, ran)
+	}
+	// The steps only run once.
+	if again := sup.shutdown(); again != err || len(ran) != 3 {
+		t.Errorf("expected shutdown to run once, got %v and %v", again, ran)
+	}
+}
@@ -16,60 +16,159 @@ package eiamplugin
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
+	"strings"
+	"syscall"
 	"text/tabwriter"
 
 	hcplugin "github.com/hashicorp/go-plugin"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"google.golang.org/grpc/status"
 
 	"github.com/replit/ephemeral-iam/internal/appconfig"
 	util "github.com/replit/ephemeral-iam/internal/eiamutil"
 	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+	"github.com/replit/ephemeral-iam/internal/hooks"
 	"github.com/replit/ephemeral-iam/internal/plugins"
 	eiamplugin "github.com/replit/ephemeral-iam/pkg/plugins"
 )
 
 // RootCommand is a struct that holds the loaded plugins and the top level cobra command.
+// Plugins that are disabled or quarantined are kept in Inactive.
 type RootCommand struct {
-	Plugins []*plugins.EphemeralIamPlugin
+	Plugins  []*plugins.EphemeralIamPlugin
+	Inactive []*plugins.EphemeralIamPlugin
 	cobra.Command
 }
 
 // LoadPlugins searches for files in the plugin directory and attempts to load them.
-func (rc *RootCommand) LoadPlugins() error {
-	configDir := appconfig.GetConfigDir()
-	pluginsDir := path.Join(configDir, "plugins")
+// A plugin that fails to load is quarantined instead of preventing eiam from
+// starting, and is skipped until its binary changes or it is re-enabled.
+func (rc *RootCommand) LoadPlugins() {
+	pluginsDir := plugins.PluginDir()
 
 	files, err := os.ReadDir(pluginsDir)
 	if err != nil {
-		return errorsutil.New("Failed to read plugins directory", err)
+		util.Logger.WithError(err).Error("Failed to read plugins directory")
+		return
+	}
+	manifest, err := plugins.LoadManifest()
+	if err != nil {
+		util.Logger.WithError(err).Error("Failed to read plugin manifest, plugins will not be loaded")
+		return
 	}
 
+	changed := false
 	for _, f := range files {
-		pl, plClient, err := loadPlugin(f.Name(), pluginsDir)
+		if f.IsDir() || plugins.IsReservedFile(f.Name()) {
+			continue
+		}
+		pluginPath := path.Join(pluginsDir, f.Name())
+		if d, ok := manifest.Disabled[f.Name()]; ok {
+			rc.addInactive(f.Name(), d.Name, pluginPath, plugins.StatusDisabled, "")
+			continue
+		}
+		info, err := f.Info()
 		if err != nil {
-			util.Logger.WithError(err).Errorf("Failed to load plugin: %s", f.Name())
+			util.Logger.WithError(err).Errorf("Failed to read plugin: %s", f.Name())
 			continue
 		}
-		pluginCmd, name, desc, version, err := addPluginCmd(pl)
+		if q, ok := manifest.IsQuarantined(f.Name(), info.ModTime()); ok {
+			rc.addInactive(f.Name(), q.Name, pluginPath, plugins.StatusQuarantined, q.Reason)
+			continue
+		}
+
+		plugin, name, err := rc.loadPluginCmd(f.Name(), pluginsDir)
 		if err != nil {
-			return err
+			util.Logger.WithError(err).Errorf("Failed to load plugin %s, it has been quarantined", f.Name())
+			manifest.Quarantine(f.Name(), name, err.Error())
+			rc.addInactive(f.Name(), name, pluginPath, plugins.StatusQuarantined, err.Error())
+			changed = true
+			continue
+		}
+		if _, ok := manifest.Quarantined[f.Name()]; ok {
+			delete(manifest.Quarantined, f.Name())
+			changed = true
 		}
-		rc.AddCommand(pluginCmd)
-		rc.Plugins = append(rc.Plugins, &plugins.EphemeralIamPlugin{
-			Name:        name,
-			Description: desc,
-			Version:     version,
-			Client:      plClient,
-			Path:        path.Join(pluginsDir, f.Name()),
-		})
-	}
-	return nil
+		rc.Plugins = append(rc.Plugins, plugin)
+	}
+
+	if changed {
+		if err := manifest.Save(); err != nil {
+			util.Logger.WithError(err).Error("Failed to save plugin quarantine state")
+		}
+	}
+}
+
+// loadPluginCmd starts a plugin and adds its command to the root command. The
+// plugin's name is returned along with any error if it was known.
+func (rc *RootCommand) loadPluginCmd(binary, pluginsDir string) (*plugins.EphemeralIamPlugin, string, error) {
+	pl, plClient, err := loadPlugin(binary, pluginsDir)
+	if err != nil {
+		return nil, "", err
+	}
+	pluginCmd, name, desc, version, err := addPluginCmd(pl, plClient)
+	if err != nil {
+		plClient.Kill()
+		return nil, "", err
+	}
+	if existing := rc.findCommand(name); existing != "" {
+		plClient.Kill()
+		return nil, name, fmt.Errorf("plugin name %q conflicts with the %q command", name, existing)
+	}
+	var schema []plugins.ConfigField
+	if c, ok := pl.(plugins.ConfigurablePlugin); ok {
+		schema = c.ConfigSchema()
+	}
+	if sub, ok := pl.(plugins.EventSubscriber); ok && len(sub.Events()) > 0 {
+		if h := hooks.PluginHook(name, sub.Events(), sub.HandleEvent); h != nil {
+			hooks.Register(h)
+		}
+	}
+	rc.AddCommand(pluginCmd)
+	return &plugins.EphemeralIamPlugin{
+		Name:        name,
+		Description: desc,
+		Version:     version,
+		Client:      plClient,
+		Path:        path.Join(pluginsDir, binary),
+		Status:      plugins.StatusEnabled,
+		Config:      schema,
+	}, name, nil
+}
+
+// findCommand returns the name of the command that name would shadow, if any.
+// The help and completion commands are added by cobra when eiam runs, so they
+// are reserved as well.
+func (rc *RootCommand) findCommand(name string) string {
+	if name == "" || name == "help" || name == "completion" {
+		return name
+	}
+	for _, c := range rc.Commands() {
+		if c.Name() == name || c.HasAlias(name) {
+			return c.Name()
+		}
+	}
+	return ""
+}
+
+func (rc *RootCommand) addInactive(binary, name, pluginPath, status, reason string) {
+	if name == "" {
+		name = binary
+	}
+	rc.Inactive = append(rc.Inactive, &plugins.EphemeralIamPlugin{
+		Name:   name,
+		Path:   pluginPath,
+		Status: status,
+		Reason: reason,
+	})
 }
 
 func loadPlugin(pf, pluginsDir string) (plugins.EIAMPlugin, *hcplugin.Client, error) {
@@ -80,29 +179,31 @@ func loadPlugin(pf, pluginsDir string) (plugins.EIAMPlugin, *hcplugin.Client, er
 	client := hcplugin.NewClient(&hcplugin.ClientConfig{
 		HandshakeConfig: eiamplugin.Handshake,
 		Plugins: map[string]hcplugin.Plugin{
-			"run-command": &eiamplugin.Command{},
+			eiamplugin.PluginName: &eiamplugin.Command{},
 		},
 		Cmd:              exec.Command(path.Join(pluginsDir, pf), args...), //nolint:gosec // Single string with no args
 		AllowedProtocols: []hcplugin.Protocol{hcplugin.ProtocolGRPC},
 		SyncStderr:       os.Stderr,
 		SyncStdout:       os.Stdout,
-		Logger:           plugins.NewHCLogAdapter(util.Logger, ""),
-		// AutoMTLS:         true,  // For some reason, enabling this breaks the plugin commands.
+		Logger:           plugins.PluginLogger(pf),
+		AutoMTLS:         viper.GetBool(appconfig.PluginRuntimeAutoMTLS),
 	})
 
 	rpcClient, err := client.Client()
 	if err != nil {
+		client.Kill()
 		return nil, nil, err
 	}
 
-	raw, err := rpcClient.Dispense("run-command")
+	raw, err := rpcClient.Dispense(eiamplugin.PluginName)
 	if err != nil {
+		client.Kill()
 		return nil, nil, err
 	}
 	return raw.(plugins.EIAMPlugin), client, nil //nolint: errcheck
 }
 
-func addPluginCmd(p plugins.EIAMPlugin) (cmd *cobra.Command, name, desc, version string, err error) {
+func addPluginCmd(p plugins.EIAMPlugin, client *hcplugin.Client) (cmd *cobra.Command, name, desc, version string, err error) {
 	name, desc, version, err = p.GetInfo()
 	if err != nil {
 		return nil, "", "", "", errorsutil.New("Failed to fetch plugin information", err)
@@ -114,7 +215,7 @@ func addPluginCmd(p plugins.EIAMPlugin) (cmd *cobra.Command, name, desc, version
 		FParseErrWhitelist: cobra.FParseErrWhitelist{UnknownFlags: true},
 		DisableFlagParsing: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := p.Run(); err != nil {
+			if err := runPlugin(name, p, client); err != nil {
 				if serr, ok := status.FromError(err); ok {
 					return errors.New(serr.Message())
 				}
@@ -126,13 +227,54 @@ func addPluginCmd(p plugins.EIAMPlugin) (cmd *cobra.Command, name, desc, version
 	return cmd, name, desc, version, nil
 }
 
-// PrintPlugins formats the list of loaded plugins as a table and prints them.
+// runPlugin runs the plugin's command until it finishes, the user interrupts
+// eiam, or the plugin's timeout expires. The plugin process ignores the
+// interrupt itself, so in the last two cases the command is cancelled and the
+// plugin process is killed if it does not exit.
+func runPlugin(name string, p plugins.EIAMPlugin, client *hcplugin.Client) error {
+	runner, ok := p.(plugins.ContextPlugin)
+	if !ok {
+		return p.Run()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	timeout := plugins.RunTimeout(name)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := runner.RunContext(ctx)
+	if ctx.Err() == nil {
+		return err
+	}
+	client.Kill()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("plugin %s timed out after %s", name, timeout)
+	}
+	return fmt.Errorf("plugin %s was interrupted", name)
+}
+
+// PrintPlugins formats the list of installed plugins as a table and prints them.
+// Disabled and quarantined plugins are listed after the loaded ones.
 func (rc *RootCommand) PrintPlugins() {
 	var buf bytes.Buffer
 	w := tabwriter.NewWriter(&buf, 0, 4, 4, ' ', 0)
-	fmt.Fprintln(w, "\nPLUGIN\tVERSION\tDESCRIPTION")
+	fmt.Fprintln(w, "\nPLUGIN\tVERSION\tSTATUS\tDESCRIPTION")
 	for _, p := range rc.Plugins {
-		fmt.Fprintf(w, "%s\t%s\t%s\n", p.Name, p.Version, p.Description)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Name, p.Version, p.Status, p.Description)
+	}
+	for _, p := range rc.Inactive {
+		desc := strings.Join(strings.Fields(p.Reason), " ")
+		if len(desc) > 80 {
+			desc = desc[:77] + "..."
+		}
+		if p.Status == plugins.StatusDisabled {
+			desc = "Run 'eiam plugins enable " + p.Name + "' to load it"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Name, "-", p.Status, desc)
 	}
 	fmt.Fprintln(w)
 	w.Flush()
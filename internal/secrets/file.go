@@ -0,0 +1,263 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	fileVersion = 1
+	keySize     = 32
+	nonceSize   = 24
+	saltSize    = 16
+)
+
+// KeyFunc returns the key that encrypts a FileStore. The salt is stored in
+// the file and is only needed by keys derived from a passphrase.
+type KeyFunc func(salt []byte) (*[keySize]byte, error)
+
+// KeyFile reads the key from a file, which is created with a random key if it
+// does not exist.
+func KeyFile(path string) KeyFunc {
+	return func([]byte) (*[keySize]byte, error) {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			var key [keySize]byte
+			if _, err := rand.Read(key[:]); err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+				return nil, err
+			}
+			encoded := base64.StdEncoding.EncodeToString(key[:])
+			if err := os.WriteFile(path, []byte(encoded+"\n"), 0o600); err != nil {
+				return nil, fmt.Errorf("failed to create key file: %v", err)
+			}
+			return &key, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %v", err)
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(decoded) != keySize {
+			return nil, fmt.Errorf("%s is not a valid key file", path)
+		}
+		var key [keySize]byte
+		copy(key[:], decoded)
+		return &key, nil
+	}
+}
+
+// Passphrase derives the key from the passphrase returned by get.
+func Passphrase(get func() (string, error)) KeyFunc {
+	return func(salt []byte) (*[keySize]byte, error) {
+		passphrase, err := get()
+		if err != nil {
+			return nil, err
+		}
+		if passphrase == "" {
+			return nil, errors.New("the passphrase is empty")
+		}
+		derived, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, keySize)
+		if err != nil {
+			return nil, err
+		}
+		var key [keySize]byte
+		copy(key[:], derived)
+		return &key, nil
+	}
+}
+
+// fileContents is the format of the encrypted file.
+type fileContents struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// FileStore keeps secrets in a file encrypted with NaCl secretbox.
+type FileStore struct {
+	path   string
+	keyFor KeyFunc
+
+	mu   sync.Mutex
+	key  *[keySize]byte
+	salt []byte
+}
+
+// NewFileStore returns a store that keeps its secrets in path.
+func NewFileStore(path string, key KeyFunc) *FileStore {
+	return &FileStore{path: path, keyFor: key}
+}
+
+// Get returns a secret.
+func (s *FileStore) Get(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	value, ok := secrets[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// Set adds or replaces a secret.
+func (s *FileStore) Set(name, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	secrets[name] = value
+	return s.save(secrets)
+}
+
+// Delete removes a secret.
+func (s *FileStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[name]; !ok {
+		return ErrNotFound
+	}
+	delete(secrets, name)
+	return s.save(secrets)
+}
+
+// List returns the names of the secrets in the store.
+func (s *FileStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secrets, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// load decrypts the file. A missing file is an empty store.
+func (s *FileStore) load() (map[string]string, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read secrets file: %v", err)
+	}
+
+	var contents fileContents
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets file %s: %v", s.path, err)
+	}
+	if contents.Version != fileVersion || len(contents.Nonce) != nonceSize {
+		return nil, fmt.Errorf("unsupported secrets file %s", s.path)
+	}
+	key, err := s.keyWithSalt(contents.Salt)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], contents.Nonce)
+	plaintext, ok := secretbox.Open(nil, contents.Data, &nonce, key)
+	if !ok {
+		// Do not keep a key that does not open the file, such as a mistyped
+		// passphrase.
+		s.key = nil
+		return nil, fmt.Errorf("failed to decrypt %s, it may have been encrypted with a different key", s.path)
+	}
+
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets file %s: %v", s.path, err)
+	}
+	return secrets, nil
+}
+
+func (s *FileStore) save(secrets map[string]string) error {
+	if s.salt == nil {
+		s.salt = make([]byte, saltSize)
+		if _, err := rand.Read(s.salt); err != nil {
+			return err
+		}
+	}
+	key, err := s.keyWithSalt(s.salt)
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	data, err := json.Marshal(fileContents{
+		Version: fileVersion,
+		Salt:    s.salt,
+		Nonce:   nonce[:],
+		Data:    secretbox.Seal(nil, plaintext, &nonce, key),
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write secrets file: %v", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// keyWithSalt returns the key for the salt of the file, deriving it only once.
+func (s *FileStore) keyWithSalt(salt []byte) (*[keySize]byte, error) {
+	if s.key != nil && string(s.salt) == string(salt) {
+		return s.key, nil
+	}
+	key, err := s.keyFor(salt)
+	if err != nil {
+		return nil, err
+	}
+	s.key, s.salt = key, salt
+	return key, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secrets.enc")
+	store := NewFileStore(path, KeyFile(filepath.Join(dir, "secrets.key")))
+
+	if _, err := store.Get("github.tokens.default"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound from an empty store, got %v", err)
+	}
+	if err := store.Set("github.tokens.default", "ghp_secret"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := store.Set("plugins.example.apikey", "api-secret"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("ghp_secret")) || bytes.Contains(data, []byte("github.tokens")) {
+		t.Errorf("expected the secrets file to be encrypted:\n%s", data)
+	}
+
+	// A new store reads the file with the same key file.
+	reopened := NewFileStore(path, KeyFile(filepath.Join(dir, "secrets.key")))
+	if got, err := reopened.Get("github.tokens.default"); err != nil || got != "ghp_secret" {
+		t.Errorf("Get = %q, %v, want ghp_secret", got, err)
+	}
+	if err := reopened.Delete("github.tokens.default"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	names, err := reopened.List()
+	if err != nil || len(names) != 1 || names[0] != "plugins.example.apikey" {
+		t.Errorf("List = %v, %v", names, err)
+	}
+
+	wrongKey := NewFileStore(path, KeyFile(filepath.Join(dir, "other.key")))
+	if _, err := wrongKey.Get("plugins.example.apikey"); err == nil {
+		t.Error("expected an error decrypting with a different key")
+	}
+}
+
+func TestFileStorePassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	passphrase := func(p string) KeyFunc {
+		return Passphrase(func() (string, error) { return p, nil })
+	}
+
+	if err := NewFileStore(path, passphrase("correct horse")).Set("token", "value"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if got, err := NewFileStore(path, passphrase("correct horse")).Get("token"); err != nil || got != "value" {
+		t.Errorf("Get = %q, %v, want value", got, err)
+	}
+	if _, err := NewFileStore(path, passphrase("battery staple")).Get("token"); err == nil {
+		t.Error("expected an error with the wrong passphrase")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "secrets.enc"), KeyFile(filepath.Join(t.TempDir(), "key")))
+	if err := store.Set("stored", "from-store"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EIAM_TEST_TOKEN", "from-env")
+
+	tests := []struct {
+		src     Source
+		want    string
+		wantErr bool
+	}{
+		{Source{}, "from-store", false},
+		{Source{Env: "EIAM_TEST_TOKEN"}, "from-env", false},
+		{Source{Env: "EIAM_TEST_UNSET_TOKEN"}, "", true},
+		{Source{Command: "echo from-command"}, "from-command", false},
+		{Source{Command: "exit 1"}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := Resolve(store, "stored", tt.src)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Resolve(%s) = %q, %v, want %q", tt.src, got, err, tt.want)
+		}
+	}
+}
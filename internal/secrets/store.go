@@ -0,0 +1,100 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package secrets keeps credentials such as Github access tokens out of the
+// eiam configuration file.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by a Store that does not have the requested secret.
+var ErrNotFound = errors.New("secret not found")
+
+// commandTimeout is how long a secret's command may run.
+const commandTimeout = 30 * time.Second
+
+// Store saves secrets by name.
+type Store interface {
+	Get(name string) (string, error)
+	Set(name, value string) error
+	Delete(name string) error
+	List() ([]string, error)
+}
+
+// Source describes where a secret is read from when it is not kept in a
+// Store. At most one of its fields is set.
+type Source struct {
+	// Command is a shell command that prints the secret, such as
+	// 'pass show github'.
+	Command string `yaml:"command,omitempty" mapstructure:"command"`
+	// Env is an environment variable that holds the secret.
+	Env string `yaml:"env,omitempty" mapstructure:"env"`
+}
+
+// IsZero reports whether the secret is kept in a Store.
+func (s Source) IsZero() bool {
+	return s.Command == "" && s.Env == ""
+}
+
+func (s Source) String() string {
+	switch {
+	case s.Command != "":
+		return "command: " + s.Command
+	case s.Env != "":
+		return "env: " + s.Env
+	}
+	return "encrypted store"
+}
+
+// Resolve returns the value of a secret from its source, or from the store if
+// it does not have one.
+func Resolve(store Store, name string, src Source) (string, error) {
+	switch {
+	case src.Command != "":
+		return runCommand(src.Command)
+	case src.Env != "":
+		value := os.Getenv(src.Env)
+		if value == "" {
+			return "", fmt.Errorf("the environment variable %s is not set", src.Env)
+		}
+		return value, nil
+	}
+	return store.Get(name)
+}
+
+func runCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("secret command %q failed: %v", command, err)
+	}
+	value := strings.TrimSpace(string(out))
+	if value == "" {
+		return "", fmt.Errorf("secret command %q did not print a value", command)
+	}
+	return value, nil
+}
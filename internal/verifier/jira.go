@@ -0,0 +1,91 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// jiraDoneCategory is the key of the status category of resolved issues.
+const jiraDoneCategory = "done"
+
+// Jira verifies that the ticket of a reason is an open issue in Jira, or in
+// a service with a compatible REST API. URL is the base URL of the site, such
+// as https://example.atlassian.net. Requests use basic authentication with
+// User and Token if User is set, and Token as a bearer token otherwise.
+type Jira struct {
+	URL    string
+	User   string
+	Token  string
+	Client *http.Client
+}
+
+type jiraIssue struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Status struct {
+			Name           string `json:"name"`
+			StatusCategory struct {
+				Key string `json:"key"`
+			} `json:"statusCategory"`
+		} `json:"status"`
+	} `json:"fields"`
+}
+
+// Verify implements ReasonVerifier.
+func (j *Jira) Verify(ctx context.Context, req Request) error {
+	if req.Ticket == "" {
+		return &RejectedError{Message: "the reason must reference a Jira issue"}
+	}
+	issueURL := fmt.Sprintf("%s/rest/api/2/issue/%s?fields=status", strings.TrimSuffix(j.URL, "/"), url.PathEscape(req.Ticket))
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, issueURL, nil)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	switch {
+	case j.User != "":
+		httpReq.SetBasicAuth(j.User, j.Token)
+	case j.Token != "":
+		httpReq.Header.Set("Authorization", "Bearer "+j.Token)
+	}
+
+	resp, err := client(j.Client).Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to reach Jira: %v", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return &RejectedError{Ticket: req.Ticket, Message: "the issue does not exist or you cannot view it"}
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("jira responded with %s", resp.Status)
+	}
+
+	var issue jiraIssue
+	if err := json.NewDecoder(resp.Body).Decode(&issue); err != nil {
+		return fmt.Errorf("jira responded with an invalid issue: %v", err)
+	}
+	status := issue.Fields.Status
+	if status.StatusCategory.Key == jiraDoneCategory {
+		return &RejectedError{Ticket: req.Ticket, Message: fmt.Sprintf("the issue is closed (%s)", status.Name)}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package verifier checks with a ticket system that the ticket referenced by
+// the reason of a command exists and is open before a token is generated.
+package verifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+)
+
+// DefaultTimeout is how long a verifier may take if reason.verifier.timeout
+// is not set.
+const DefaultTimeout = 10 * time.Second
+
+// Request describes the privileged command whose reason is verified. It is
+// the JSON body sent to webhooks.
+type Request struct {
+	Ticket         string `json:"ticket,omitempty"`
+	Reason         string `json:"reason"`
+	Project        string `json:"project,omitempty"`
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+}
+
+// ReasonVerifier approves or rejects the reason of a privileged command.
+// Verify returns a *RejectedError when the reason is rejected, and any other
+// error when the reason could not be verified.
+type ReasonVerifier interface {
+	Verify(ctx context.Context, req Request) error
+}
+
+// RejectedError is returned by a ReasonVerifier that rejected a reason.
+type RejectedError struct {
+	Ticket  string
+	Message string
+}
+
+func (e *RejectedError) Error() string {
+	msg := "the reason was rejected"
+	if e.Ticket != "" {
+		msg = fmt.Sprintf("the ticket %s was rejected", e.Ticket)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// IsRejected reports whether err is a *RejectedError.
+func IsRejected(err error) bool {
+	var rejected *RejectedError
+	return errors.As(err, &rejected)
+}
+
+// Load returns the verifier configured by the reason.verifier settings, or
+// nil if none is configured.
+func Load() (ReasonVerifier, error) {
+	kind := viper.GetString(appconfig.ReasonVerifierType)
+	if kind == "" || kind == appconfig.VerifierNone {
+		return nil, nil
+	}
+	url := viper.GetString(appconfig.ReasonVerifierURL)
+	if url == "" {
+		return nil, fmt.Errorf("the %s setting is required by the %s reason verifier", appconfig.ReasonVerifierURL, kind)
+	}
+	token, err := appconfig.SecretSetting(
+		appconfig.ReasonVerifierToken, appconfig.ReasonVerifierTokenCommand, appconfig.ReasonVerifierTokenEnv)
+	if err != nil {
+		return nil, errorsutil.New("Failed to read the reason verifier token", err)
+	}
+	timeout := viper.GetDuration(appconfig.ReasonVerifierTimeout)
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	switch kind {
+	case appconfig.VerifierWebhook:
+		return &Webhook{
+			URL:          url,
+			AuthHeader:   viper.GetString(appconfig.ReasonVerifierAuthHeader),
+			Token:        token,
+			AllowedField: viper.GetString(appconfig.ReasonVerifierAllowedField),
+			MessageField: viper.GetString(appconfig.ReasonVerifierMessageField),
+			Client:       client,
+		}, nil
+	case appconfig.VerifierJira:
+		return &Jira{
+			URL:    url,
+			User:   viper.GetString(appconfig.ReasonVerifierUser),
+			Token:  token,
+			Client: client,
+		}, nil
+	}
+	return nil, fmt.Errorf("the %s setting must be one of %v", appconfig.ReasonVerifierType, appconfig.VerifierTypes)
+}
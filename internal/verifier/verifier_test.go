@@ -0,0 +1,118 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Api-Key"); got != "secret" {
+			t.Errorf("X-Api-Key = %q, want secret", got)
+		}
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		switch req.Ticket {
+		case "INC-1":
+			w.Write([]byte(`{"result": {"approved": true}}`))
+		case "INC-2":
+			w.Write([]byte(`{"result": {"approved": false, "reason": "incident is resolved"}}`))
+		case "INC-3":
+			w.Write([]byte(`{"approved": true}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	w := &Webhook{
+		URL:          server.URL,
+		AuthHeader:   "X-Api-Key",
+		Token:        "secret",
+		AllowedField: "result.approved",
+		MessageField: "result.reason",
+	}
+	ctx := context.Background()
+	if err := w.Verify(ctx, Request{Ticket: "INC-1", Reason: "Fix INC-1"}); err != nil {
+		t.Errorf("Verify(INC-1) failed: %v", err)
+	}
+	err := w.Verify(ctx, Request{Ticket: "INC-2", Reason: "Fix INC-2"})
+	if !IsRejected(err) || !strings.Contains(err.Error(), "INC-2 was rejected: incident is resolved") {
+		t.Errorf("Verify(INC-2) = %v, want rejection", err)
+	}
+	err = w.Verify(ctx, Request{Ticket: "INC-3"})
+	if err == nil || IsRejected(err) || !strings.Contains(err.Error(), `boolean "result.approved" field`) {
+		t.Errorf("Verify(INC-3) = %v, want missing field error", err)
+	}
+	err = w.Verify(ctx, Request{Ticket: "INC-4"})
+	if err == nil || IsRejected(err) {
+		t.Errorf("Verify(INC-4) = %v, want server error", err)
+	}
+}
+
+func TestJira(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, token, ok := r.BasicAuth(); !ok || user != "me@example.com" || token != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/rest/api/2/issue/JIRA-1":
+			w.Write([]byte(`{"key": "JIRA-1", "fields": {"status": {"name": "In Progress", "statusCategory": {"key": "indeterminate"}}}}`))
+		case "/rest/api/2/issue/JIRA-2":
+			w.Write([]byte(`{"key": "JIRA-2", "fields": {"status": {"name": "Closed", "statusCategory": {"key": "done"}}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	j := &Jira{URL: server.URL + "/", User: "me@example.com", Token: "secret"}
+	ctx := context.Background()
+	tests := []struct {
+		ticket   string
+		rejected string
+	}{
+		{"JIRA-1", ""},
+		{"JIRA-2", "the issue is closed (Closed)"},
+		{"JIRA-3", "the issue does not exist"},
+		{"", "the reason must reference a Jira issue"},
+	}
+	for _, tt := range tests {
+		err := j.Verify(ctx, Request{Ticket: tt.ticket})
+		if tt.rejected == "" {
+			if err != nil {
+				t.Errorf("Verify(%q) failed: %v", tt.ticket, err)
+			}
+			continue
+		}
+		if !IsRejected(err) || !strings.Contains(err.Error(), tt.rejected) {
+			t.Errorf("Verify(%q) = %v, want rejection %q", tt.ticket, err, tt.rejected)
+		}
+	}
+
+	j.Token = "wrong"
+	if err := j.Verify(ctx, Request{Ticket: "JIRA-1"}); err == nil || IsRejected(err) {
+		t.Errorf("Verify with a wrong token = %v, want an error", err)
+	}
+}
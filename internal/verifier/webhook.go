@@ -0,0 +1,125 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// The default fields of a webhook's response.
+const (
+	DefaultAllowedField = "allowed"
+	DefaultMessageField = "message"
+)
+
+// maxResponseSize limits how much of a response is read.
+const maxResponseSize = 1 << 20
+
+// Webhook verifies reasons with an HTTP endpoint. The Request is POSTed to URL
+// as JSON, and the endpoint responds with a JSON object that has a boolean at
+// AllowedField and, optionally, an explanation at MessageField. Fields of
+// nested objects are separated by dots, such as 'result.allowed'.
+type Webhook struct {
+	URL string
+	// AuthHeader is the header that Token is sent in. It defaults to
+	// Authorization, in which case the token is sent as a bearer token.
+	AuthHeader   string
+	Token        string
+	AllowedField string
+	MessageField string
+	Client       *http.Client
+}
+
+// Verify implements ReasonVerifier.
+func (w *Webhook) Verify(ctx context.Context, req Request) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if w.Token != "" {
+		header := w.AuthHeader
+		if header == "" || strings.EqualFold(header, "Authorization") {
+			httpReq.Header.Set("Authorization", "Bearer "+w.Token)
+		} else {
+			httpReq.Header.Set(header, w.Token)
+		}
+	}
+
+	resp, err := client(w.Client).Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to reach the reason verifier: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("failed to read the response of the reason verifier: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the reason verifier responded with %s", resp.Status)
+	}
+
+	var result interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("the reason verifier responded with invalid JSON: %v", err)
+	}
+	allowedField := orDefault(w.AllowedField, DefaultAllowedField)
+	allowed, ok := lookup(result, allowedField).(bool)
+	if !ok {
+		return fmt.Errorf("the response of the reason verifier does not have a boolean %q field", allowedField)
+	}
+	if !allowed {
+		message, _ := lookup(result, orDefault(w.MessageField, DefaultMessageField)).(string)
+		return &RejectedError{Ticket: req.Ticket, Message: message}
+	}
+	return nil
+}
+
+// lookup returns the value at a dotted path of a decoded JSON object, or nil
+// if there is none.
+func lookup(value interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = obj[key]
+	}
+	return value
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+func client(c *http.Client) *http.Client {
+	if c == nil {
+		return &http.Client{Timeout: DefaultTimeout}
+	}
+	return c
+}
@@ -0,0 +1,86 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/replit/ephemeral-iam/internal/access"
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	"github.com/replit/ephemeral-iam/internal/gcpclient"
+)
+
+// CheckAccess evaluates the access policy in the access.policyfile setting,
+// if there is one, for a command run with config. It applies the limits of
+// the rule that allowed the command to config, and must be called after the
+// token duration is set and before the reason is checked. If the rule limits
+// the duration and none is set, the duration is set to the default, capped by
+// the limit.
+func CheckAccess(command string, config *CmdConfig) error {
+	file := viper.GetString(appconfig.AccessPolicyFile)
+	if file == "" {
+		return nil
+	}
+	policy, err := access.Load(file)
+	if err != nil {
+		return err
+	}
+	user, err := appconfig.Identity()
+	if err != nil {
+		return err
+	}
+
+	decision := policy.Evaluate(access.Request{
+		User:           user,
+		ServiceAccount: config.ServiceAccountEmail,
+		Project:        config.Project,
+		Command:        command,
+		Time:           time.Now(),
+		Duration:       config.TokenDuration,
+	})
+	util.Logger.Debugf("Access policy: %s", decision.Explain())
+	return applyDecision(command, file, user, decision, config)
+}
+
+// applyDecision applies the limits of an access policy decision to config.
+func applyDecision(command, file, user string, decision access.Decision, config *CmdConfig) error {
+	if !decision.Allowed {
+		return fmt.Errorf("the access policy in %s does not allow %s to use %s: %s",
+			file, user, config.ServiceAccountEmail, decision.Explain())
+	}
+	if decision.MaxDuration > 0 && config.TokenDuration == 0 {
+		// Commands without a duration flag, such as gcloud, would otherwise
+		// get tokens of the default duration or longer.
+		config.TokenDuration = min(gcpclient.DefaultTokenDuration, decision.MaxDuration)
+	}
+	if decision.MaxDuration > 0 && config.TokenDuration > decision.MaxDuration {
+		return fmt.Errorf("the token duration (%v) exceeds the maximum of %v set by the access policy, which was %s",
+			config.TokenDuration, decision.MaxDuration, decision.Explain())
+	}
+	if decision.ReadOnly && (command == access.CommandCloudSQLProxy || command == access.CommandGrant) {
+		return fmt.Errorf("the access policy only allows read-only access, which %s does not support", command)
+	}
+	if decision.ReadOnly {
+		util.Logger.Warnf("The access policy limits this command to read-only access")
+	}
+	config.ReadOnly = decision.ReadOnly
+	config.TicketPattern = decision.TicketPattern
+	config.MaxDuration = decision.MaxDuration
+	return nil
+}
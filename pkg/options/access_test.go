@@ -0,0 +1,86 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/replit/ephemeral-iam/internal/access"
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	"github.com/replit/ephemeral-iam/internal/gcpclient"
+)
+
+func TestApplyDecisionTokenDuration(t *testing.T) {
+	tests := []struct {
+		name        string
+		duration    time.Duration
+		maxDuration time.Duration
+		want        time.Duration
+		wantErr     bool
+	}{
+		{name: "no limit and no duration", want: 0},
+		{name: "no limit", duration: time.Hour, want: time.Hour},
+		{name: "limit below the default", maxDuration: 5 * time.Minute, want: 5 * time.Minute},
+		{name: "limit above the default", maxDuration: time.Hour, want: gcpclient.DefaultTokenDuration},
+		{name: "duration within the limit", duration: 5 * time.Minute, maxDuration: 15 * time.Minute, want: 5 * time.Minute},
+		{name: "duration over the limit", duration: time.Hour, maxDuration: 15 * time.Minute, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &CmdConfig{ServiceAccountEmail: "sa@project.iam.gserviceaccount.com", TokenDuration: tt.duration}
+			decision := access.Decision{Allowed: true, Index: -1, MaxDuration: tt.maxDuration}
+			err := applyDecision(access.CommandGcloud, "policy.yml", "user@example.com", decision, cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected the duration to be refused")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyDecision failed: %v", err)
+			}
+			if cfg.TokenDuration != tt.want {
+				t.Errorf("TokenDuration = %v, want %v", cfg.TokenDuration, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxTokenDuration(t *testing.T) {
+	defer viper.Set(appconfig.TokenMaxDuration, nil)
+	viper.Set(appconfig.TokenMaxDuration, "1h")
+
+	tests := []struct {
+		policyMax time.Duration
+		want      time.Duration
+	}{
+		{policyMax: 0, want: time.Hour},
+		{policyMax: 15 * time.Minute, want: 15 * time.Minute},
+		{policyMax: 2 * time.Hour, want: time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := MaxTokenDuration(&CmdConfig{MaxDuration: tt.policyMax})
+		if err != nil {
+			t.Fatalf("MaxTokenDuration failed: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("MaxTokenDuration with a policy maximum of %v = %v, want %v", tt.policyMax, got, tt.want)
+		}
+	}
+}
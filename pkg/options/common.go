@@ -17,6 +17,8 @@ package options
 import (
 	"fmt"
 	"os"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/manifoldco/promptui"
@@ -32,12 +34,20 @@ import (
 // Flag annotation strings.
 const (
 	RequiredAnnotation = "eiam_required_flag"
+
+	// RequiredUnlessAnnotation names the flags that, when one of them is set,
+	// make a required flag optional.
+	RequiredUnlessAnnotation = "eiam_required_unless_flag"
 )
 
 // YesOption designates whether to prompt for confirmation or not.
 var (
 	YesOption             = false
 	KubeConfigSetupOption = false
+
+	// ProfileOption is the profile selected with the --profile flag. The
+	// profile has already been applied by appconfig when the flag is parsed.
+	ProfileOption = ""
 )
 
 // Flag names and shorthands.
@@ -51,12 +61,24 @@ var (
 	// ReasonFlag enforces that a rationale be given for a command.
 	ReasonFlag = flagName{"reason", "R"}
 
+	// ReasonTemplateFlag builds the reason of a command from a configured template.
+	ReasonTemplateFlag = flagName{"reason-template", ""}
+
+	// TicketFlag sets the ticket that the reason of a command references.
+	TicketFlag = flagName{"ticket", ""}
+
+	// RoleFlag sets the IAM role to use for a command.
+	RoleFlag = flagName{"role", ""}
+
 	// RegionFlag sets the GCP region to use for a command.
 	RegionFlag = flagName{"region", "r"}
 
 	// ServiceAccountEmailFlag sets the service account to use for a command.
 	ServiceAccountEmailFlag = flagName{"service-account-email", "s"}
 
+	// ProfileFlag selects the configuration profile to use for a command.
+	ProfileFlag = flagName{appconfig.ProfileFlag, ""}
+
 	// YesFlag is a boolean that when set to true ignores non-required user prompts.
 	YesFlag = flagName{"yes", "y"}
 
@@ -81,11 +103,20 @@ type CmdConfig struct {
 	Project             string
 	PubSubTopic         string
 	Reason              string
+	ReasonTemplate      string
 	Region              string
+	Role                string
 	ServiceAccountEmail string
 	StorageBucket       string
+	Ticket              string
 	Zone                string
 	TokenDuration       time.Duration
+
+	// ReadOnly, TicketPattern and MaxDuration are set by CheckAccess from the
+	// rule of the access policy that allowed the command.
+	ReadOnly      bool
+	TicketPattern string
+	MaxDuration   time.Duration
 }
 
 // AddPersistentFlags add persistent flags to the root command.
@@ -98,6 +129,12 @@ func AddPersistentFlags(fs *pflag.FlagSet) {
 		KubeConfigSetupOption,
 		"Set kube config envvars")
 
+	fs.StringVar(
+		&ProfileOption,
+		ProfileFlag.Name,
+		ProfileOption,
+		"The configuration profile to use. Overrides the EIAM_PROFILE environment variable and the active profile")
+
 	currLogFmt := viper.GetString(appconfig.LoggingFormat)
 	fs.StringP(FormatFlag.Name, FormatFlag.Shorthand, currLogFmt, "Set the output of the current command")
 	if err := viper.BindPFlag(appconfig.LoggingFormat, fs.Lookup(FormatFlag.Name)); err != nil {
@@ -105,17 +142,23 @@ func AddPersistentFlags(fs *pflag.FlagSet) {
 	}
 }
 
-// AddProjectFlag adds the --project/-p flag to the command.
+// AddProjectFlag adds the --project/-p flag to the command. The default is the
+// defaults.project setting of the active profile, or the project in the active
+// gcloud config if it is not set.
 func AddProjectFlag(fs *pflag.FlagSet, project *string, required bool) {
-	defaultVal, err := gcpclient.GetCurrentProject()
-	errorsutil.CheckError(err)
+	defaultVal := viper.GetString(appconfig.DefaultProject)
+	if defaultVal == "" {
+		var err error
+		defaultVal, err = gcpclient.GetCurrentProject()
+		errorsutil.CheckError(err)
+	}
 
 	fs.StringVarP(
 		project,
 		ProjectFlag.Name,
 		ProjectFlag.Shorthand,
 		defaultVal,
-		"The GCP project. Inherits from the active gcloud config by default",
+		"The GCP project. Inherits from the active profile or gcloud config by default",
 	)
 	if defaultVal == "" || required {
 		if err := fs.SetAnnotation(ProjectFlag.Name, RequiredAnnotation, []string{"true"}); err != nil {
@@ -208,6 +251,16 @@ func AddReasonFlag(fs *pflag.FlagSet, reason *string, required bool) {
 	}
 }
 
+// AddRoleFlag adds the --role flag to the command.
+func AddRoleFlag(fs *pflag.FlagSet, role *string, required bool) {
+	fs.StringVar(role, RoleFlag.Name, "", "The IAM role, such as roles/viewer or projects/my-project/roles/myRole")
+	if required {
+		if err := fs.SetAnnotation(RoleFlag.Name, RequiredAnnotation, []string{"true"}); err != nil {
+			util.Logger.Fatalf("failed to set required annotation on flag: %v", err)
+		}
+	}
+}
+
 // AddTokenDurationFlag adds the --duration flag.
 func AddTokenDurationFlag(fs *pflag.FlagSet, tokenDuration *time.Duration, required bool) {
 	fs.DurationVarP(
@@ -215,7 +268,7 @@ func AddTokenDurationFlag(fs *pflag.FlagSet, tokenDuration *time.Duration, requi
 		TokenDurationFlag.Name,
 		TokenDurationFlag.Shorthand,
 		gcpclient.DefaultTokenDuration,
-		"The duration of the token. Defaults to 10m, cannot be longer than 1h",
+		"The duration of the token. Defaults to 10m, cannot be longer than the tokens.maxduration setting (1h by default)",
 	)
 	if required {
 		if err := fs.SetAnnotation(TokenDurationFlag.Name, RequiredAnnotation, []string{"true"}); err != nil {
@@ -224,15 +277,57 @@ func AddTokenDurationFlag(fs *pflag.FlagSet, tokenDuration *time.Duration, requi
 	}
 }
 
-// CheckTokenDuration ensures that the token duration is not excessively long.
+// CheckTokenDuration ensures that the token duration is not longer than the
+// tokens.maxduration setting.
 func CheckTokenDuration(tokenDuration time.Duration) error {
-	if tokenDuration > time.Hour {
-		return fmt.Errorf("token duration (%v) exceeds maximum (%v)", tokenDuration, time.Hour)
+	maxDuration, err := maxTokenDuration()
+	if err != nil {
+		return err
+	}
+	if tokenDuration > maxDuration {
+		return fmt.Errorf("token duration (%v) exceeds maximum (%v)", tokenDuration, maxDuration)
 	}
 
 	return nil
 }
 
+// MaxTokenDuration returns the longest token duration allowed for a command
+// run with config: the tokens.maxduration setting, or the limit of the access
+// policy if it is lower. It must be called after CheckAccess.
+func MaxTokenDuration(config *CmdConfig) (time.Duration, error) {
+	maxDuration, err := maxTokenDuration()
+	if err != nil {
+		return 0, err
+	}
+	if config.MaxDuration > 0 && config.MaxDuration < maxDuration {
+		return config.MaxDuration, nil
+	}
+	return maxDuration, nil
+}
+
+func maxTokenDuration() (time.Duration, error) {
+	maxDuration, err := time.ParseDuration(viper.GetString(appconfig.TokenMaxDuration))
+	if err != nil {
+		return 0, errorsutil.New(fmt.Sprintf("Invalid %s setting", appconfig.TokenMaxDuration), err)
+	}
+	return maxDuration, nil
+}
+
+// CheckServiceAccount ensures that the service account matches one of the
+// patterns in the access.allowedserviceaccounts setting, if any are set.
+func CheckServiceAccount(serviceAccountEmail string) error {
+	allowed := viper.GetStringSlice(appconfig.AllowedServiceAccounts)
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, pattern := range allowed {
+		if ok, _ := path.Match(pattern, serviceAccountEmail); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not one of the allowed service accounts: %s", serviceAccountEmail, strings.Join(allowed, ", "))
+}
+
 // CheckRequired ensures that a command's required flags have been set. The only
 // way to iterate over every flag in a pflag.FlagSet is with the VisitAll command.
 // VisitAll takes a function as a parameter and calls that function on each flag in the
@@ -254,7 +349,7 @@ func visitAllFlags(flags *pflag.FlagSet) <-chan error {
 	go func() {
 		flags.VisitAll(func(flag *pflag.Flag) {
 			for annot, val := range flag.Annotations {
-				if annot == RequiredAnnotation && val[0] == "true" && flag.Value.String() == "" {
+				if annot == RequiredAnnotation && val[0] == "true" && flag.Value.String() == "" && !requiredUnlessSet(flags, flag) {
 					errc <- promptForMissingFlag(flag)
 				}
 			}
@@ -264,6 +359,17 @@ func visitAllFlags(flags *pflag.FlagSet) <-chan error {
 	return errc
 }
 
+// requiredUnlessSet reports whether one of the flags that make a required
+// flag optional has been set.
+func requiredUnlessSet(flags *pflag.FlagSet, flag *pflag.Flag) bool {
+	for _, name := range flag.Annotations[RequiredUnlessAnnotation] {
+		if other := flags.Lookup(name); other != nil && other.Changed {
+			return true
+		}
+	}
+	return false
+}
+
 func promptForMissingFlag(flag *pflag.Flag) error {
 	prompt := promptui.Prompt{
 		Label:  fmt.Sprintf("Enter a value for %s", flag.Name),
@@ -0,0 +1,216 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	errorsutil "github.com/replit/ephemeral-iam/internal/errors"
+	"github.com/replit/ephemeral-iam/internal/verifier"
+)
+
+// reasonTemplateData is the data that reason templates are executed with.
+type reasonTemplateData struct {
+	Ticket         string
+	Details        string
+	Project        string
+	ServiceAccount string
+}
+
+// AddReasonTemplateFlags adds the --reason-template and --ticket flags. The
+// --reason flag is optional when a template is used, so it must be added to
+// the flag set first.
+func AddReasonTemplateFlags(fs *pflag.FlagSet, reasonTemplate, ticket *string) {
+	fs.StringVar(
+		reasonTemplate,
+		ReasonTemplateFlag.Name,
+		"",
+		"Use a reason template from the reason.templates setting. --reason adds details to it",
+	)
+	fs.StringVar(
+		ticket,
+		TicketFlag.Name,
+		"",
+		"The ticket that this work is for. It is added to the reason, or replaces {{.Ticket}} in the reason template",
+	)
+	if fs.Lookup(ReasonFlag.Name) != nil {
+		if err := fs.SetAnnotation(ReasonFlag.Name, RequiredUnlessAnnotation, []string{ReasonTemplateFlag.Name}); err != nil {
+			util.Logger.Fatalf("failed to set required annotation on flag: %v", err)
+		}
+	}
+}
+
+// ResolveReason builds the reason of a command from the --reason-template and
+// --ticket flags, then checks it against the reason settings. It must be
+// called once the project and service account are known and before the reason
+// is formatted with FormatReason.
+func ResolveReason(config *CmdConfig) error {
+	if config.ReasonTemplate != "" {
+		reason, err := renderReasonTemplate(config)
+		if err != nil {
+			return err
+		}
+		config.Reason = reason
+	} else if config.Ticket != "" && !strings.Contains(config.Reason, config.Ticket) {
+		config.Reason = strings.TrimSpace(fmt.Sprintf("%s (%s)", config.Reason, config.Ticket))
+	}
+
+	if strings.TrimSpace(config.Reason) == "" {
+		return fmt.Errorf("a reason is required, please provide one with --%s or --%s", ReasonFlag.Name, ReasonTemplateFlag.Name)
+	}
+	if err := CheckReason(config.Reason, config.Ticket, config.Project, config.ServiceAccountEmail); err != nil {
+		return err
+	}
+	if config.TicketPattern != "" {
+		if err := checkTicket(config.Reason, config.Ticket, config.TicketPattern, "access policy"); err != nil {
+			return err
+		}
+	}
+	return VerifyReason(config)
+}
+
+// VerifyReason asks the ticket system configured by the reason.verifier
+// settings, if any, to approve the reason. The command fails if the ticket
+// system cannot be reached.
+func VerifyReason(config *CmdConfig) error {
+	v, err := verifier.Load()
+	if err != nil || v == nil {
+		return err
+	}
+	req := verifier.Request{
+		Ticket:         referencedTicket(config),
+		Reason:         config.Reason,
+		Project:        config.Project,
+		ServiceAccount: config.ServiceAccountEmail,
+	}
+	util.Logger.Debugf("Verifying the reason with the %s reason verifier", viper.GetString(appconfig.ReasonVerifierType))
+	if err := v.Verify(context.Background(), req); err != nil {
+		if verifier.IsRejected(err) {
+			return err
+		}
+		return errorsutil.New("Failed to verify the reason", err)
+	}
+	return nil
+}
+
+// referencedTicket returns the --ticket flag, or else the first ticket in the
+// reason that matches the ticket pattern of the access policy or the reason
+// settings.
+func referencedTicket(config *CmdConfig) string {
+	if config.Ticket != "" {
+		return config.Ticket
+	}
+	pattern := config.TicketPattern
+	if pattern == "" {
+		var err error
+		if pattern, _, err = appconfig.TicketPattern(config.Project, config.ServiceAccountEmail); err != nil || pattern == "" {
+			return ""
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return ""
+	}
+	return re.FindString(config.Reason)
+}
+
+func renderReasonTemplate(config *CmdConfig) (string, error) {
+	text, ok := appconfig.ReasonTemplate(config.ReasonTemplate)
+	if !ok {
+		return "", fmt.Errorf("there is no reason template named %q. %s", config.ReasonTemplate, templatesHint())
+	}
+	if strings.Contains(text, ".Ticket") && config.Ticket == "" {
+		return "", fmt.Errorf("the %s reason template needs a ticket, please provide one with --%s", config.ReasonTemplate, TicketFlag.Name)
+	}
+
+	tmpl, err := template.New(config.ReasonTemplate).Parse(text)
+	if err != nil {
+		return "", errorsutil.New(fmt.Sprintf("Invalid %s.%s setting", appconfig.ReasonTemplates, config.ReasonTemplate), err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, reasonTemplateData{
+		Ticket:         config.Ticket,
+		Details:        config.Reason,
+		Project:        config.Project,
+		ServiceAccount: config.ServiceAccountEmail,
+	}); err != nil {
+		return "", errorsutil.New(fmt.Sprintf("Failed to execute the %s reason template", config.ReasonTemplate), err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// CheckReason ensures that the reason matches the reason.pattern setting and
+// references a ticket when the reason settings require one for the project
+// and service account. ticket is the value of the --ticket flag, if any. It
+// must be called before the reason is formatted with FormatReason.
+func CheckReason(reason, ticket, project, serviceAccountEmail string) error {
+	if pattern := viper.GetString(appconfig.ReasonPattern); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return errorsutil.New(fmt.Sprintf("Invalid %s setting", appconfig.ReasonPattern), err)
+		}
+		if !re.MatchString(reason) {
+			return fmt.Errorf("the reason must match the pattern %s", pattern)
+		}
+	}
+
+	pattern, source, err := appconfig.TicketPattern(project, serviceAccountEmail)
+	if err != nil || pattern == "" {
+		return err
+	}
+	return checkTicket(reason, ticket, pattern, source+" setting")
+}
+
+// checkTicket ensures that the reason references a ticket that matches the
+// pattern required by source.
+func checkTicket(reason, ticket, pattern, source string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return errorsutil.New(fmt.Sprintf("Invalid ticket pattern in the %s", source), err)
+	}
+	if ticket != "" && !regexp.MustCompile(`^(?:`+pattern+`)$`).MatchString(ticket) {
+		return fmt.Errorf("the ticket %q does not match the pattern %s required by the %s", ticket, pattern, source)
+	}
+	if !re.MatchString(reason) {
+		msg := fmt.Sprintf(
+			"the reason must reference a ticket that matches the pattern %s, which is required by the %s. "+
+				"Please add the ticket to the reason or provide it with --%s",
+			pattern, source, TicketFlag.Name)
+		if len(appconfig.ReasonTemplateNames()) > 0 {
+			msg += ". " + templatesHint()
+		}
+		return errors.New(msg)
+	}
+	return nil
+}
+
+func templatesHint() string {
+	names := appconfig.ReasonTemplateNames()
+	if len(names) == 0 {
+		return "No reason templates are configured"
+	}
+	return fmt.Sprintf("The reason templates are: %s", strings.Join(names, ", "))
+}
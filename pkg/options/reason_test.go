@@ -0,0 +1,168 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/replit/ephemeral-iam/internal/appconfig"
+	util "github.com/replit/ephemeral-iam/internal/eiamutil"
+	"github.com/replit/ephemeral-iam/internal/verifier"
+)
+
+const reasonConfig = `
+reason:
+  ticketpattern: 'JIRA-[0-9]+'
+  rules:
+    - project: "prod-*"
+      ticketpattern: 'INC-[0-9]+'
+    - serviceaccount: "*@sandbox.iam.gserviceaccount.com"
+      ticketpattern: ""
+  templates:
+    incident: "Responding to {{.Ticket}} in {{.Project}} {{.Details}}"
+    maintenance: "Scheduled maintenance"
+`
+
+func TestResolveReason(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.SetConfigType("yml")
+	if err := viper.ReadConfig(strings.NewReader(reasonConfig)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  CmdConfig
+		reason  string
+		wantErr string
+	}{
+		{
+			name:   "reason references ticket",
+			config: CmdConfig{Reason: "Fix JIRA-12", Project: "dev"},
+			reason: "Fix JIRA-12",
+		},
+		{
+			name:   "ticket flag is added to reason",
+			config: CmdConfig{Reason: "Fix", Ticket: "JIRA-12", Project: "dev"},
+			reason: "Fix (JIRA-12)",
+		},
+		{
+			name:    "missing ticket",
+			config:  CmdConfig{Reason: "Fix", Project: "dev"},
+			wantErr: "required by the reason.ticketpattern setting",
+		},
+		{
+			name:    "project rule",
+			config:  CmdConfig{Reason: "Fix JIRA-12", Project: "prod-db"},
+			wantErr: "INC-[0-9]+, which is required by the reason.rules[0] setting",
+		},
+		{
+			name:    "ticket must match the whole pattern",
+			config:  CmdConfig{Reason: "Fix", Ticket: "INC-1x", Project: "prod-db"},
+			wantErr: `the ticket "INC-1x" does not match`,
+		},
+		{
+			name:   "service account rule without ticket",
+			config: CmdConfig{Reason: "Testing", Project: "dev", ServiceAccountEmail: "a@sandbox.iam.gserviceaccount.com"},
+			reason: "Testing",
+		},
+		{
+			name:   "template",
+			config: CmdConfig{ReasonTemplate: "Incident", Ticket: "INC-42", Project: "prod-db", Reason: "(db failover)"},
+			reason: "Responding to INC-42 in prod-db (db failover)",
+		},
+		{
+			name:    "template needs ticket",
+			config:  CmdConfig{ReasonTemplate: "incident", Project: "prod-db"},
+			wantErr: "the incident reason template needs a ticket",
+		},
+		{
+			name:    "template does not reference ticket",
+			config:  CmdConfig{ReasonTemplate: "maintenance", Project: "dev"},
+			wantErr: "The reason templates are: incident, maintenance",
+		},
+		{
+			name:    "unknown template",
+			config:  CmdConfig{ReasonTemplate: "other", Project: "dev"},
+			wantErr: `there is no reason template named "other"`,
+		},
+		{
+			name:    "empty reason",
+			config:  CmdConfig{Project: "dev"},
+			wantErr: "a reason is required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			err := ResolveReason(&config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolveReason() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveReason() error = %v", err)
+			}
+			if config.Reason != tt.reason {
+				t.Errorf("reason = %q, want %q", config.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestVerifyReason(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q, want the bearer token", got)
+		}
+		var req verifier.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Ticket == "JIRA-1" {
+			w.Write([]byte(`{"allowed": true}`))
+			return
+		}
+		w.Write([]byte(`{"allowed": false, "message": "ticket is closed"}`))
+	}))
+	defer server.Close()
+
+	util.Logger = logrus.New()
+	viper.Reset()
+	defer viper.Reset()
+	t.Setenv("VERIFIER_TOKEN", "secret")
+	viper.Set(appconfig.ReasonTicketPattern, "JIRA-[0-9]+")
+	viper.Set(appconfig.ReasonVerifierType, appconfig.VerifierWebhook)
+	viper.Set(appconfig.ReasonVerifierURL, server.URL)
+	viper.Set(appconfig.ReasonVerifierTokenEnv, "VERIFIER_TOKEN")
+
+	if err := ResolveReason(&CmdConfig{Reason: "Fix JIRA-1"}); err != nil {
+		t.Errorf("ResolveReason(JIRA-1) failed: %v", err)
+	}
+	err := ResolveReason(&CmdConfig{Reason: "Fix", Ticket: "JIRA-2"})
+	if !verifier.IsRejected(err) || !strings.Contains(err.Error(), "ticket is closed") {
+		t.Errorf("ResolveReason(JIRA-2) = %v, want rejection", err)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eiamplugin
+
+import (
+	"context"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// CobraPlugin is a plugin built from a cobra command. The command's name and
+// short description are reported to eiam, and the command is executed when
+// the user runs the plugin.
+type CobraPlugin struct {
+	Command *cobra.Command
+	Version string
+
+	// Config describes the settings that the plugin stores in the
+	// plugins.<name> section of the eiam config.
+	Config []ConfigField
+
+	// Args are the arguments the command is executed with. If it is nil, the
+	// arguments that eiam started the plugin process with are used.
+	Args []string
+
+	host Host
+}
+
+// NewCobraPlugin returns a plugin that runs cmd. Errors returned from the
+// command are passed back to eiam to be reported, so like eiam's own commands,
+// cobra is told not to print them or the usage itself.
+func NewCobraPlugin(cmd *cobra.Command, version string) *CobraPlugin {
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	return &CobraPlugin{Command: cmd, Version: version}
+}
+
+// GetInfo returns the name, description, and version of the plugin.
+func (p *CobraPlugin) GetInfo() (name, desc, version string, err error) {
+	return p.Command.Name(), p.Command.Short, p.Version, nil
+}
+
+// ConfigSchema returns the settings of the plugin.
+func (p *CobraPlugin) ConfigSchema() []ConfigField {
+	return p.Config
+}
+
+// SetHost is called by eiam before Run with the services it provides.
+func (p *CobraPlugin) SetHost(host Host) {
+	p.host = host
+}
+
+// Host returns the services eiam provides while the plugin runs. It is nil
+// when the plugin runs under a version of eiam without them.
+func (p *CobraPlugin) Host() Host {
+	return p.host
+}
+
+// Run executes the command.
+func (p *CobraPlugin) Run() error {
+	return p.RunContext(context.Background())
+}
+
+// RunContext executes the command with ctx, which commands can get with
+// cmd.Context(). eiam cancels it when the user interrupts eiam or the
+// plugin's timeout expires.
+func (p *CobraPlugin) RunContext(ctx context.Context) error {
+	args := p.Args
+	if args == nil {
+		args = os.Args[1:]
+	}
+	p.Command.SetArgs(args)
+	return p.Command.ExecuteContext(ctx)
+}
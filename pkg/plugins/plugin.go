@@ -20,16 +20,58 @@ import (
 	"github.com/hashicorp/go-plugin"
 	"google.golang.org/grpc"
 
+	"github.com/replit/ephemeral-iam/internal/hooks"
 	"github.com/replit/ephemeral-iam/internal/plugins"
 	pb "github.com/replit/ephemeral-iam/internal/plugins/proto"
 )
 
+// PluginName is the name that the plugin's command is served under.
+const PluginName = "run-command"
+
+// Handshake is the go-plugin handshake that eiam and its plugins agree on.
 var Handshake = plugin.HandshakeConfig{
 	ProtocolVersion:  1,
 	MagicCookieKey:   "EIAM_PLUGIN",
 	MagicCookieValue: "dab75867-cde1-41fc-8416-818b718e4d62",
 }
 
+// ConfigField describes a setting that a plugin stores in the plugins.<name>
+// section of the eiam configuration. Plugins report their settings by
+// implementing ConfigSchema() []ConfigField.
+type ConfigField = plugins.ConfigField
+
+// Host is the set of services eiam provides to a running plugin. Plugins
+// receive it by implementing SetHost(Host), which is called before Run.
+type Host = plugins.Host
+
+// ContextPlugin is implemented by plugins whose command stops when eiam
+// cancels it. RunContext is called instead of Run when it is implemented.
+type ContextPlugin = plugins.ContextPlugin
+
+// Event is a lifecycle event. Plugins subscribe to events by implementing
+// Events() []EventType and HandleEvent(Event) error.
+type Event = hooks.Event
+
+// EventType is the kind of lifecycle event.
+type EventType = hooks.EventType
+
+// The lifecycle events that plugins can subscribe to.
+const (
+	SessionStarted  = hooks.SessionStarted
+	TokenMinted     = hooks.TokenMinted
+	CommandStarted  = hooks.CommandStarted
+	CommandFinished = hooks.CommandFinished
+	SessionEnded    = hooks.SessionEnded
+)
+
+// Types of plugin configuration fields.
+const (
+	ConfigString   = plugins.ConfigString
+	ConfigBool     = plugins.ConfigBool
+	ConfigInt      = plugins.ConfigInt
+	ConfigDuration = plugins.ConfigDuration
+)
+
 // Command is the implementation of plugin.GRPCPlugin that allows it to be
 // served and consumed.
 type Command struct {
@@ -38,7 +80,7 @@ type Command struct {
 }
 
 func (p *Command) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
-	pb.RegisterEIAMPluginServer(s, &plugins.GRPCServer{Impl: p.Impl})
+	pb.RegisterEIAMPluginServer(s, &plugins.GRPCServer{Impl: p.Impl, Broker: broker})
 	return nil
 }
 
@@ -47,5 +89,5 @@ func (p *Command) GRPCClient(
 	broker *plugin.GRPCBroker,
 	c *grpc.ClientConn,
 ) (interface{}, error) {
-	return &plugins.GRPCClient{Client: pb.NewEIAMPluginClient(c)}, nil
+	return &plugins.GRPCClient{Client: pb.NewEIAMPluginClient(c), Broker: broker}, nil
 }
@@ -0,0 +1,208 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package plugintest runs eiam plugins in-process, through the same gRPC
+// interface that eiam uses, so that they can be unit tested.
+//
+// The harness replaces os.Args, os.Stdout, and os.Stderr while a plugin runs,
+// so tests that use it must not run in parallel.
+package plugintest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	hcplugin "github.com/hashicorp/go-plugin"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/status"
+
+	"github.com/replit/ephemeral-iam/internal/plugins"
+	eiamplugin "github.com/replit/ephemeral-iam/pkg/plugins"
+)
+
+// Harness runs a plugin through a real gRPC connection.
+type Harness struct {
+	Name        string
+	Description string
+	Version     string
+
+	t      testing.TB
+	client *plugins.GRPCClient
+}
+
+// Result is the outcome of running the plugin's command.
+type Result struct {
+	// Stdout is everything the plugin wrote to standard output.
+	Stdout string
+
+	// Stderr is everything the plugin wrote to standard error. Log entries
+	// written with eiamplugin.NewLogger are in Logs instead.
+	Stderr string
+
+	// Logs are the entries written with a logger from eiamplugin.NewLogger.
+	Logs []LogEntry
+
+	// Err is the error returned to eiam, as the user would see it.
+	Err error
+}
+
+// LogEntry is a log entry that the plugin sent to eiam.
+type LogEntry struct {
+	Level   string
+	Message string
+	Fields  map[string]interface{}
+}
+
+// New starts a plugin and fetches its metadata. The connection is closed
+// when the test finishes.
+func New(t testing.TB, impl eiamplugin.Plugin) *Harness {
+	t.Helper()
+	client, _ := hcplugin.TestPluginGRPCConn(t, false, map[string]hcplugin.Plugin{
+		eiamplugin.PluginName: &eiamplugin.Command{Impl: impl},
+	})
+	t.Cleanup(func() { client.Close() })
+
+	raw, err := client.Dispense(eiamplugin.PluginName)
+	if err != nil {
+		t.Fatalf("failed to dispense plugin: %v", err)
+	}
+	h := &Harness{t: t, client: raw.(*plugins.GRPCClient)}
+	h.Name, h.Description, h.Version, err = h.client.GetInfo()
+	if err != nil {
+		t.Fatalf("failed to get plugin info: %v", err)
+	}
+	return h
+}
+
+// ConfigSchema returns the settings the plugin reported to eiam.
+func (h *Harness) ConfigSchema() []eiamplugin.ConfigField {
+	return h.client.ConfigSchema()
+}
+
+// Events returns the lifecycle events the plugin subscribed to.
+func (h *Harness) Events() []eiamplugin.EventType {
+	return h.client.Events()
+}
+
+// SetConfig sets a plugin setting as if the user had run
+// 'eiam config set plugins.<name>.<key> <value>'. It is reset when the test
+// finishes.
+func (h *Harness) SetConfig(key, value string) {
+	configKey := plugins.ConfigKey(h.Name, key)
+	viper.Set(configKey, value)
+	h.t.Cleanup(func() { viper.Set(configKey, nil) })
+}
+
+// Run runs the plugin's command as if the user had run 'eiam <name> args...'.
+func (h *Harness) Run(args ...string) Result {
+	h.t.Helper()
+	return h.RunContext(context.Background(), args...)
+}
+
+// RunContext runs the plugin like Run, and cancels it when ctx is done the way
+// eiam does when the user interrupts it or the plugin's timeout expires.
+func (h *Harness) RunContext(ctx context.Context, args ...string) Result {
+	h.t.Helper()
+
+	origArgs, origStdout, origStderr := os.Args, os.Stdout, os.Stderr
+	stdout, stdoutDone := capture(h.t)
+	stderr, stderrDone := capture(h.t)
+	var logs bytes.Buffer
+	restoreLogs := eiamplugin.SetLogOutput(&logs)
+	os.Args = append([]string{h.Name}, args...)
+	os.Stdout, os.Stderr = stdout, stderr
+
+	err := h.client.RunContext(ctx)
+
+	os.Args, os.Stdout, os.Stderr = origArgs, origStdout, origStderr
+	restoreLogs()
+	stdout.Close()
+	stderr.Close()
+
+	res := Result{Stdout: <-stdoutDone, Stderr: <-stderrDone, Logs: parseLogs(logs.String()), Err: err}
+	if serr, ok := status.FromError(err); ok && err != nil {
+		res.Err = errors.New(serr.Message())
+	}
+	return res
+}
+
+// Emit delivers a lifecycle event to the plugin. It fails if the plugin did
+// not subscribe to the event.
+func (h *Harness) Emit(e eiamplugin.Event) error {
+	subscribed := false
+	for _, t := range h.client.Events() {
+		if t == e.Type {
+			subscribed = true
+		}
+	}
+	if !subscribed {
+		return fmt.Errorf("plugin %s is not subscribed to %s", h.Name, e.Type)
+	}
+	err := h.client.HandleEvent(context.Background(), e)
+	if serr, ok := status.FromError(err); ok && err != nil {
+		return errors.New(serr.Message())
+	}
+	return err
+}
+
+// capture returns a file that collects everything written to it. The
+// collected output is sent on the channel once the file is closed.
+func capture(t testing.TB) (*os.File, <-chan string) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	done := make(chan string, 1)
+	go func() {
+		var buf bytes.Buffer
+		_, _ = io.Copy(&buf, r)
+		r.Close()
+		done <- buf.String()
+	}()
+	return w, done
+}
+
+// parseLogs parses the JSON log entries written by eiamplugin.NewLogger.
+func parseLogs(output string) []LogEntry {
+	var logs []LogEntry
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		var raw map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil || raw["@message"] == nil {
+			continue
+		}
+		entry := LogEntry{Fields: map[string]interface{}{}}
+		for k, v := range raw {
+			switch k {
+			case "@level":
+				entry.Level = fmt.Sprint(v)
+			case "@message":
+				entry.Message = fmt.Sprint(v)
+			case "@timestamp", "@module":
+			default:
+				entry.Fields[k] = v
+			}
+		}
+		logs = append(logs, entry)
+	}
+	return logs
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugintest_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	eiamplugin "github.com/replit/ephemeral-iam/pkg/plugins"
+	"github.com/replit/ephemeral-iam/pkg/plugins/plugintest"
+)
+
+func newGreeter() *eiamplugin.CobraPlugin {
+	var (
+		fail   bool
+		plugin *eiamplugin.CobraPlugin
+	)
+	logger := eiamplugin.NewLogger("greeter")
+	cmd := &cobra.Command{
+		Use:   "greeter",
+		Short: "Greets the user",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if fail {
+				return errors.New("greeting failed")
+			}
+			config, err := plugin.Host().GetConfig()
+			if err != nil {
+				return err
+			}
+			logger.Info("Greeting user", "greeting", config["greeting"])
+			fmt.Fprintf(cmd.OutOrStdout(), "%s, %v\n", config["greeting"], args)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&fail, "fail", false, "Return an error")
+
+	plugin = eiamplugin.NewCobraPlugin(cmd, "v1.0.0")
+	plugin.Config = []eiamplugin.ConfigField{
+		{Name: "greeting", Description: "The greeting to use", Default: "Hello"},
+	}
+	return plugin
+}
+
+func TestHarnessRun(t *testing.T) {
+	h := plugintest.New(t, newGreeter())
+	if h.Name != "greeter" || h.Description != "Greets the user" || h.Version != "v1.0.0" {
+		t.Errorf("unexpected plugin info: %s %s %s", h.Name, h.Description, h.Version)
+	}
+	if len(h.ConfigSchema()) != 1 {
+		t.Errorf("expected one config field, got %v", h.ConfigSchema())
+	}
+
+	res := h.Run("world")
+	if res.Err != nil {
+		t.Fatalf("unexpected error: %v", res.Err)
+	}
+	if res.Stdout != "Hello, [world]\n" {
+		t.Errorf("unexpected output: %q", res.Stdout)
+	}
+	if len(res.Logs) != 1 || res.Logs[0].Message != "Greeting user" || res.Logs[0].Level != "info" {
+		t.Fatalf("unexpected logs: %+v", res.Logs)
+	}
+	if res.Logs[0].Fields["greeting"] != "Hello" {
+		t.Errorf("unexpected log fields: %v", res.Logs[0].Fields)
+	}
+
+	h.SetConfig("greeting", "Howdy")
+	if res := h.Run("partner"); res.Stdout != "Howdy, [partner]\n" {
+		t.Errorf("expected the configured greeting, got %q (error: %v)", res.Stdout, res.Err)
+	}
+
+	res = h.Run("--fail")
+	if res.Err == nil || res.Err.Error() != "greeting failed" {
+		t.Errorf("expected the command's error, got %v", res.Err)
+	}
+}
+
+func TestHarnessEmitUnsubscribed(t *testing.T) {
+	h := plugintest.New(t, newGreeter())
+	if err := h.Emit(eiamplugin.Event{Type: eiamplugin.SessionStarted}); err == nil {
+		t.Error("expected an error for an event the plugin did not subscribe to")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 Workrise Technologies Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eiamplugin
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+
+	"github.com/replit/ephemeral-iam/internal/plugins"
+)
+
+// Plugin is the interface that plugins implement. See CobraPlugin for an
+// implementation built from a cobra command.
+type Plugin = plugins.EIAMPlugin
+
+// Serve serves a plugin to eiam. It is called from the main function of the
+// plugin binary and does not return.
+func Serve(impl Plugin) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins: map[string]plugin.Plugin{
+			PluginName: &Command{Impl: impl},
+		},
+		GRPCServer: plugin.DefaultGRPCServer,
+		Logger:     NewLogger("go-plugin"),
+	})
+}
+
+// logOutput is where loggers from NewLogger write to. Serve replaces
+// os.Stderr with a pipe that is copied to the user's terminal as is, so the
+// original stderr is kept here for eiam to parse the log entries from.
+var (
+	logMu     sync.Mutex
+	logOutput io.Writer = os.Stderr
+)
+
+// NewLogger returns a logger whose entries are sent to eiam to be formatted
+// and output like its own logs.
+func NewLogger(name string) hclog.Logger {
+	return hclog.New(&hclog.LoggerOptions{
+		Name:       name,
+		Level:      hclog.Trace,
+		Output:     logWriter{},
+		JSONFormat: true,
+	})
+}
+
+// SetLogOutput redirects the loggers returned by NewLogger and returns a
+// function that restores the previous output. It is used by the plugintest
+// harness to capture log entries.
+func SetLogOutput(w io.Writer) (restore func()) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	prev := logOutput
+	logOutput = w
+	return func() {
+		logMu.Lock()
+		defer logMu.Unlock()
+		logOutput = prev
+	}
+}
+
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return logOutput.Write(p)
+}